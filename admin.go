@@ -0,0 +1,858 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"harbor/database"
+	"harbor/dedup"
+	"harbor/fsck"
+	"harbor/gc"
+	"harbor/models"
+	"harbor/utils/logger"
+	"harbor/utils/storages"
+	"harbor/utils/storages/encrypt"
+	"harbor/utils/storages/multidisk"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// envSuperUserPassword environment variable of password for command "createsuperuser"
+const envSuperUserPassword = "HARBOR_SUPERUSER_PASSWORD"
+
+// timeLayout layout of time in tables
+const timeLayout = "2006-01-02 15:04:05"
+
+// newFlagSet return flag set of subcommand, exit with status 2 on error
+func newFlagSet(name, args string) *flag.FlagSet {
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parse flags of subcommand and check the number of positional args
+func parseArgs(fs *flag.FlagSet, args []string, nArgs int) []string {
+
+	fs.Parse(args)
+	if fs.NArg() != nArgs {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Args()
+}
+
+// initDatabase connect to databases and migrate them as the server does on start
+func initDatabase() {
+
+	database.InitDatabase()
+	if err := migrateOnStart(); err != nil {
+		fatalf("%s\n", err)
+	}
+}
+
+// printJSON print v as indented json
+func printJSON(v interface{}) {
+
+	b, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+	fmt.Println(string(b))
+}
+
+// printTable print rows aligned in columns under header
+func printTable(header []string, rows [][]string) {
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+// formatTime return time in timeLayout, "-" if it is not set
+func formatTime(t models.TypeJSONTime) string {
+
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(timeLayout)
+}
+
+// getUser return user of username, exit if not found
+func getUser(username string) *models.UserProfile {
+
+	user, err := models.NewUserManager().GetUserByName(username)
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+	if user == nil {
+		fatalf("user '%s' does not exist\n", username)
+	}
+	return user
+}
+
+// readPassword return password of environment variable HARBOR_SUPERUSER_PASSWORD or the first line of stdin,
+// it is not given by flag, as arguments are visible to other users by ps
+func readPassword() (string, error) {
+
+	if pw := os.Getenv(envSuperUserPassword); pw != "" {
+		return pw, nil
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if pw := strings.TrimRight(line, "\r\n"); pw != "" {
+		return pw, nil
+	}
+	return "", fmt.Errorf("password is required, give it by environment variable %s or stdin", envSuperUserPassword)
+}
+
+// createSuperUserCommand handle command "createsuperuser"
+func createSuperUserCommand(args []string) {
+
+	fs := newFlagSet("createsuperuser", "")
+	username := fs.String("username", "", "username of the superuser, required")
+	email := fs.String("email", "", "email of the superuser, default is username")
+	parseArgs(fs, args, 0)
+	if *username == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	pw, err := readPassword()
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+
+	initDatabase()
+	defer database.CloseAll()
+	um := models.NewUserManager()
+	if user, err := um.GetUserByName(*username); err != nil {
+		fatalf("%s\n", err)
+	} else if user != nil {
+		fatalf("user '%s' already exists\n", *username)
+	}
+
+	user := models.NewUserProfile()
+	user.Username = *username
+	user.Email = *email
+	if user.Email == "" {
+		user.Email = *username
+	}
+	user.IsActive = true
+	user.EmailVerified = true
+	user.SetRole(models.RoleStaffSuperUser)
+	user.SetPassword(pw)
+	if err := um.SaveUser(user); err != nil {
+		fatalf("%s\n", err)
+	}
+	fmt.Printf("superuser '%s' created, id %d\n", user.Username, user.ID)
+}
+
+// userJSON user in output of command "user list"
+type userJSON struct {
+	ID         uint                 `json:"id"`
+	Username   string               `json:"username"`
+	Email      string               `json:"email"`
+	Roles      []string             `json:"roles"`
+	IsActive   bool                 `json:"is_active"`
+	DateJoined models.TypeJSONTime  `json:"date_joined"`
+	LastLogin  *models.TypeJSONTime `json:"last_login"`
+}
+
+// userCommand handle "user" subcommands
+func userCommand(args []string) {
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: user list|set-role USERNAME ROLES|activate USERNAME|deactivate USERNAME")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "list":
+		fs := newFlagSet("user list", "")
+		asJSON := fs.Bool("json", false, "print as json")
+		parseArgs(fs, args[1:], 0)
+		initDatabase()
+		defer database.CloseAll()
+		users, err := models.NewUserManager().ListUsers()
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+		if *asJSON {
+			list := make([]userJSON, 0, len(users))
+			for _, u := range users {
+				uj := userJSON{ID: u.ID, Username: u.Username, Email: u.Email, Roles: u.RoleNames(),
+					IsActive: u.IsActive, DateJoined: u.DateJoined}
+				if !u.LastLogin.IsZero() {
+					lastLogin := u.LastLogin
+					uj.LastLogin = &lastLogin
+				}
+				list = append(list, uj)
+			}
+			printJSON(list)
+			return
+		}
+		rows := make([][]string, 0, len(users))
+		for _, u := range users {
+			rows = append(rows, []string{strconv.FormatUint(uint64(u.ID), 10), u.Username, u.Email,
+				strings.Join(u.RoleNames(), ","), strconv.FormatBool(u.IsActive), formatTime(u.DateJoined), formatTime(u.LastLogin)})
+		}
+		printTable([]string{"ID", "USERNAME", "EMAIL", "ROLES", "ACTIVE", "DATE JOINED", "LAST LOGIN"}, rows)
+	case "set-role":
+		fs := newFlagSet("user set-role", "USERNAME ROLES\n\nROLES is comma separated list of superuser, staff, app_superuser, or normal")
+		a := parseArgs(fs, args[1:], 2)
+		role, err := models.ParseRoles(a[1])
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+		initDatabase()
+		defer database.CloseAll()
+		user := getUser(a[0])
+		user.SetRole(role)
+		if err := models.NewUserManager().SaveUser(user); err != nil {
+			fatalf("%s\n", err)
+		}
+		fmt.Printf("roles of user '%s' are set to %s\n", user.Username, strings.Join(user.RoleNames(), ","))
+	case "activate", "deactivate":
+		fs := newFlagSet("user "+args[0], "USERNAME")
+		a := parseArgs(fs, args[1:], 1)
+		initDatabase()
+		defer database.CloseAll()
+		user := getUser(a[0])
+		user.IsActive = args[0] == "activate"
+		// activated by admin, it is not activated again by email verification after deactivated
+		if user.IsActive {
+			user.EmailVerified = true
+		}
+		if err := models.NewUserManager().SaveUser(user); err != nil {
+			fatalf("%s\n", err)
+		}
+		fmt.Printf("user '%s' %sd\n", user.Username, args[0])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown user command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// bucketJSON bucket in output of command "bucket list" and "bucket stats"
+type bucketJSON struct {
+	ID           uint64                  `json:"id"`
+	Name         string                  `json:"name"`
+	Owner        string                  `json:"owner"`
+	Public       bool                    `json:"public"`
+	Deleted      bool                    `json:"deleted"`
+	ObjsCount    uint32                  `json:"objs_count"`
+	Size         uint64                  `json:"size"`
+	CreatedTime  models.TypeJSONTime     `json:"created_time"`
+	ModifiedTime models.TypeJSONTime     `json:"modified_time"`
+	Table        string                  `json:"table,omitempty"`
+	Actual       *models.BucketObjsStats `json:"actual,omitempty"`
+}
+
+func newBucketJSON(b *models.Bucket, owner string) *bucketJSON {
+
+	return &bucketJSON{
+		ID:           b.ID,
+		Name:         b.Name,
+		Owner:        owner,
+		Public:       b.IsPublic(),
+		Deleted:      b.IsSoftDelete(),
+		ObjsCount:    b.ObjsCount,
+		Size:         b.Size,
+		CreatedTime:  b.CreatedTime,
+		ModifiedTime: b.ModifiedTime,
+	}
+}
+
+// getBucket return bucket of name, soft deleted bucket is found by its name after deletion, exit if not found
+func getBucket(name string) *models.Bucket {
+
+	bucket, err := models.NewBucketManager("", nil).GetBucketByNameWithDeleted(name)
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+	if bucket == nil {
+		fatalf("bucket '%s' does not exist\n", name)
+	}
+	return bucket
+}
+
+// usernames return map of user id to username
+func usernames() map[uint]string {
+
+	users, err := models.NewUserManager().ListUsers()
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+	names := make(map[uint]string, len(users))
+	for _, u := range users {
+		names[u.ID] = u.Username
+	}
+	return names
+}
+
+// bucketCommand handle "bucket" subcommands
+func bucketCommand(args []string) {
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: bucket list|stats NAME|purge NAME")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "list":
+		fs := newFlagSet("bucket list", "")
+		asJSON := fs.Bool("json", false, "print as json")
+		owner := fs.String("user", "", "only list buckets of user")
+		deleted := fs.Bool("deleted", false, "include soft deleted buckets")
+		parseArgs(fs, args[1:], 0)
+		initDatabase()
+		defer database.CloseAll()
+		var user *models.UserProfile
+		if *owner != "" {
+			user = getUser(*owner)
+		}
+		buckets, err := models.NewBucketManager("", nil).ListBuckets(user, *deleted)
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+		names := usernames()
+		if *asJSON {
+			list := make([]*bucketJSON, 0, len(buckets))
+			for i := range buckets {
+				list = append(list, newBucketJSON(&buckets[i], names[buckets[i].UserID]))
+			}
+			printJSON(list)
+			return
+		}
+		rows := make([][]string, 0, len(buckets))
+		for _, b := range buckets {
+			rows = append(rows, []string{strconv.FormatUint(b.ID, 10), b.Name, names[b.UserID], strconv.FormatBool(b.IsPublic()),
+				strconv.FormatBool(b.IsSoftDelete()), strconv.FormatUint(uint64(b.ObjsCount), 10), strconv.FormatUint(b.Size, 10), formatTime(b.CreatedTime)})
+		}
+		printTable([]string{"ID", "NAME", "OWNER", "PUBLIC", "DELETED", "OBJECTS", "SIZE", "CREATED"}, rows)
+	case "stats":
+		fs := newFlagSet("bucket stats", "NAME")
+		asJSON := fs.Bool("json", false, "print as json")
+		a := parseArgs(fs, args[1:], 1)
+		initDatabase()
+		defer database.CloseAll()
+		bucket := getBucket(a[0])
+		stats, err := models.NewBucketManager("", nil).GetObjsStats(bucket)
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+		owner := ""
+		if user, err := models.NewUserManager().GetUserByID(bucket.UserID); err != nil {
+			fatalf("%s\n", err)
+		} else if user != nil {
+			owner = user.Username
+		}
+		bj := newBucketJSON(bucket, owner)
+		bj.Table = bucket.GetObjsTableName()
+		bj.Actual = stats
+		if *asJSON {
+			printJSON(bj)
+			return
+		}
+		printTable([]string{"FIELD", "VALUE"}, [][]string{
+			{"id", strconv.FormatUint(bj.ID, 10)},
+			{"name", bj.Name},
+			{"owner", bj.Owner},
+			{"public", strconv.FormatBool(bj.Public)},
+			{"deleted", strconv.FormatBool(bj.Deleted)},
+			{"created", formatTime(bj.CreatedTime)},
+			{"modified", formatTime(bj.ModifiedTime)},
+			{"table", bj.Table},
+			{"objects (recorded)", strconv.FormatUint(uint64(bj.ObjsCount), 10)},
+			{"size (recorded)", strconv.FormatUint(bj.Size, 10)},
+			{"objects", strconv.FormatUint(stats.Objects, 10)},
+			{"dirs", strconv.FormatUint(stats.Dirs, 10)},
+			{"size", strconv.FormatUint(stats.Size, 10)},
+			{"stored size", strconv.FormatUint(stats.StoredSize, 10)},
+		})
+	case "purge":
+		fs := newFlagSet("bucket purge", "NAME")
+		yes := fs.Bool("yes", false, "confirm to delete the bucket and data of all its objects permanently")
+		a := parseArgs(fs, args[1:], 1)
+		initDatabase()
+		defer database.CloseAll()
+		bucket := getBucket(a[0])
+		if !*yes {
+			fatalf("bucket '%s' and data of all its objects will be deleted permanently, run again with flag -yes to confirm\n", bucket.Name)
+		}
+		// data of objects is deleted by garbage collector, references to blobs are released
+		gm := models.NewGCManager()
+		n, err := models.NewBucketManager("", nil).PurgeBucket(bucket, func(obj *models.HarborObject) error {
+			return gm.EnqueueObject(bucket, obj, models.GCBucketPurged)
+		})
+		if err != nil {
+			fatalf("purge bucket '%s' failed after %d objects deleted: %s\n", bucket.Name, n, err)
+		}
+		fmt.Printf("bucket '%s' purged, data of %d objects is queued for garbage collection\n", bucket.Name, n)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown bucket command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// tokenCommand handle "token" subcommands
+func tokenCommand(args []string) {
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: token issue USERNAME|revoke USERNAME")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "issue":
+		fs := newFlagSet("token issue", "USERNAME")
+		asJSON := fs.Bool("json", false, "print as json")
+		a := parseArgs(fs, args[1:], 1)
+		initDatabase()
+		defer database.CloseAll()
+		user := getUser(a[0])
+		// a user has only one token, the old one is replaced
+		tm := models.NewTokenManager(user)
+		tm.BeginTransaction()
+		if _, err := tm.DeleteUserTokens(); err != nil {
+			tm.RollbackTransaction()
+			fatalf("%s\n", err)
+		}
+		token := models.NewToken(user)
+		if err := tm.CreateToken(token); err != nil {
+			tm.RollbackTransaction()
+			fatalf("%s\n", err)
+		}
+		if err := tm.CommitTransaction(); err != nil {
+			fatalf("%s\n", err)
+		}
+		if *asJSON {
+			printJSON(map[string]interface{}{"username": user.Username, "key": token.Key, "created": token.Created})
+			return
+		}
+		fmt.Println(token.Key)
+	case "revoke":
+		fs := newFlagSet("token revoke", "USERNAME")
+		a := parseArgs(fs, args[1:], 1)
+		initDatabase()
+		defer database.CloseAll()
+		user := getUser(a[0])
+		n, err := models.NewTokenManager(user).DeleteUserTokens()
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+		fmt.Printf("%d tokens of user '%s' revoked\n", n, user.Username)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown token command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// fsckCommand handle command "fsck"
+func fsckCommand(args []string) {
+
+	fs := newFlagSet("fsck", "")
+	asJSON := fs.Bool("json", false, "print report as json")
+	var opts fsck.Options
+	fs.StringVar(&opts.Bucket, "bucket", "", "only check bucket of the name")
+	fs.BoolVar(&opts.FixSizes, "fix-sizes", false, "set size of objects to size of their data")
+	fs.BoolVar(&opts.DeleteMissing, "delete-missing", false, "delete objects which have no data")
+	fs.BoolVar(&opts.DeleteOrphanData, "delete-orphan-data", false, "delete data whose object does not exist")
+	fs.BoolVar(&opts.DropOrphanTables, "drop-orphan-tables", false, "drop tables bucket_N whose bucket does not exist")
+	repairAll := fs.Bool("repair", false, "run all repair actions")
+	parseArgs(fs, args, 0)
+	if *repairAll {
+		opts.FixSizes, opts.DeleteMissing, opts.DeleteOrphanData, opts.DropOrphanTables = true, true, true, true
+	}
+
+	initDatabase()
+	defer database.CloseAll()
+	store, err := storages.NewStore()
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+	defer store.Close()
+	report, err := fsck.Run(store, opts)
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+
+	if *asJSON {
+		printJSON(report)
+	} else {
+		rows := make([][]string, 0, len(report.Problems))
+		for _, p := range report.Problems {
+			repaired := strconv.FormatBool(p.Repaired)
+			if p.RepairError != "" {
+				repaired = "error: " + p.RepairError
+			}
+			rows = append(rows, []string{p.Kind, p.Bucket, p.Table, p.Path, p.Key,
+				strconv.FormatUint(p.Size, 10), strconv.FormatUint(p.DataSize, 10), repaired})
+		}
+		printTable([]string{"PROBLEM", "BUCKET", "TABLE", "PATH", "KEY", "SIZE", "DATA SIZE", "REPAIRED"}, rows)
+		fmt.Printf("\n%d buckets, %d objects, %d keys checked, %d problems found, %d not repaired\n",
+			report.Buckets, report.Objects, report.Keys, len(report.Problems), report.Unrepaired())
+	}
+	if report.Unrepaired() > 0 {
+		os.Exit(1)
+	}
+}
+
+// gcCommand handle "gc" subcommands
+func gcCommand(args []string) {
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: gc status|run|scan")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "status":
+		fs := newFlagSet("gc status", "")
+		asJSON := fs.Bool("json", false, "print as json")
+		parseArgs(fs, args[1:], 0)
+		initDatabase()
+		defer database.CloseAll()
+		stats, err := models.NewGCManager().GetQueueStats()
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+		if *asJSON {
+			printJSON(stats)
+			return
+		}
+		fmt.Printf("%d keys queued, %d retrying, %d bytes\n", stats.Pending, stats.Retrying, stats.Bytes)
+	case "run", "scan":
+		fs := newFlagSet("gc "+args[0], "")
+		parseArgs(fs, args[1:], 0)
+		initDatabase()
+		defer database.CloseAll()
+		c := gc.NewConfigured(logger.Std().WithField("component", "gc"))
+		if args[0] == "scan" {
+			p, err := c.Scan()
+			if err != nil {
+				fatalf("%s\n", err)
+			}
+			fmt.Printf("%d buckets, %d objects, %d keys scanned, %d orphaned keys of %d bytes queued\n",
+				p.Buckets, p.Objects, p.Keys, p.Orphans, p.Bytes)
+			return
+		}
+		if _, err := c.Collect(); err != nil {
+			fatalf("%s\n", err)
+		}
+		st := c.Stats()
+		fmt.Printf("%d keys deleted, %d skipped, %d failed, %d bytes reclaimed\n", st.Deleted, st.Skipped, st.Failed, st.ReclaimedBytes)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown gc command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// dedupCommand handle "dedup" subcommands
+func dedupCommand(args []string) {
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: dedup status|run|recount")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "status":
+		fs := newFlagSet("dedup status", "")
+		asJSON := fs.Bool("json", false, "print as json")
+		parseArgs(fs, args[1:], 0)
+		initDatabase()
+		defer database.CloseAll()
+		stats, err := models.NewBlobManager().GetBlobStats()
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+		if *asJSON {
+			printJSON(stats)
+			return
+		}
+		fmt.Printf("%d blobs referenced by %d objects, %d bytes stored in %d bytes, %d bytes saved\n",
+			stats.Blobs, stats.Refs, stats.Size, stats.StoredSize, stats.SavedBytes)
+	case "run":
+		fs := newFlagSet("dedup run", "")
+		parseArgs(fs, args[1:], 0)
+		initDatabase()
+		defer database.CloseAll()
+		d := dedup.NewConfigured(logger.Std().WithField("component", "dedup"))
+		if _, err := d.Run(); err != nil {
+			fatalf("%s\n", err)
+		}
+		st := d.Stats()
+		fmt.Printf("%d objects share existing blobs, %d blobs created, %d skipped, %d failed, %d bytes saved\n",
+			st.Shared, st.Created, st.Skipped, st.Failed, st.SavedBytes)
+	case "recount":
+		fs := newFlagSet("dedup recount", "")
+		fix := fs.Bool("fix", false, "correct references of blobs, blobs not referenced are deleted")
+		parseArgs(fs, args[1:], 0)
+		initDatabase()
+		defer database.CloseAll()
+		r, err := dedup.Recount(*fix)
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+		fmt.Printf("%d blobs, %d with wrong references, %d fixed, %d deleted\n", r.Blobs, r.Wrong, r.Fixed, r.Deleted)
+		if r.Wrong > r.Fixed {
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown dedup command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// multidiskCommand handle "multidisk" subcommands
+func multidiskCommand(args []string) {
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: multidisk status|repair")
+		os.Exit(2)
+	}
+	if b := storages.Backend(); b != storages.BackendMultiDisk {
+		fatalf("storage backend is %s, not %s\n", b, storages.BackendMultiDisk)
+	}
+	s, err := storages.MultiDisk()
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+
+	switch args[0] {
+	case "status":
+		fs := newFlagSet("multidisk status", "")
+		asJSON := fs.Bool("json", false, "print as json")
+		parseArgs(fs, args[1:], 0)
+		d := storages.GetDiagnostics().MultiDisk
+		available := 0
+		rows := make([][]string, 0, len(d.Dirs))
+		for _, dir := range d.Dirs {
+			if dir.Available {
+				available++
+			}
+			var used, free string
+			if dir.Usage != nil {
+				used, free = strconv.FormatUint(dir.Usage.Used, 10), strconv.FormatUint(dir.Usage.Available, 10)
+			}
+			rows = append(rows, []string{dir.Path, strconv.FormatBool(dir.Available), used, free, dir.Error})
+		}
+		if *asJSON {
+			printJSON(d)
+		} else {
+			printTable([]string{"DIR", "AVAILABLE", "USED", "FREE", "ERROR"}, rows)
+			fmt.Printf("\n%s of %d shards, %d of %d dirs available, %d shards needed for writes\n",
+				d.Mode, d.Shards, available, len(d.Dirs), d.WriteQuorum)
+		}
+		if available < d.WriteQuorum {
+			os.Exit(1)
+		}
+	case "repair":
+		fs := newFlagSet("multidisk repair", "")
+		asJSON := fs.Bool("json", false, "print results of damaged keys as json")
+		key := fs.String("key", "", "only check data of the key")
+		dryRun := fs.Bool("dry-run", false, "only check shards, do not rewrite them")
+		parseArgs(fs, args[1:], 0)
+		keys := []string{*key}
+		if *key == "" {
+			keys = nil
+			if err := s.Keys(func(k string) error {
+				keys = append(keys, k)
+				return nil
+			}); err != nil {
+				fatalf("%s\n", err)
+			}
+		}
+		var damaged []*multidisk.RepairResult
+		total := multidisk.RepairResult{}
+		for _, k := range keys {
+			r, err := s.Repair(k, !*dryRun)
+			if err != nil {
+				fatalf("%s: %s\n", k, err)
+			}
+			total.Stripes += r.Stripes
+			total.Damaged += r.Damaged
+			total.Repaired += r.Repaired
+			total.Lost += r.Lost
+			if r.Damaged > 0 || r.Lost > 0 {
+				damaged = append(damaged, r)
+			}
+		}
+		if *asJSON {
+			printJSON(damaged)
+		} else {
+			rows := make([][]string, 0, len(damaged))
+			for _, r := range damaged {
+				rows = append(rows, []string{r.Key, strconv.Itoa(r.Stripes), strconv.Itoa(r.Damaged),
+					strconv.Itoa(r.Repaired), strconv.Itoa(r.Lost), r.Error})
+			}
+			printTable([]string{"KEY", "STRIPES", "DAMAGED", "REPAIRED", "LOST", "ERROR"}, rows)
+			fmt.Printf("\n%d keys, %d stripes checked, %d damaged, %d repaired, %d lost\n",
+				len(keys), total.Stripes, total.Damaged, total.Repaired, total.Lost)
+		}
+		if total.Damaged > total.Repaired || total.Lost > 0 {
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown multidisk command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// walkObjs call fn with each object of buckets including soft deleted ones, only bucket of the name if it is not empty
+func walkObjs(bucketName string, fn func(bucket *models.Bucket, obj *models.HarborObject) error) error {
+
+	bm := models.NewBucketManager("", nil)
+	var buckets []models.Bucket
+	if bucketName != "" {
+		buckets = []models.Bucket{*getBucket(bucketName)}
+	} else {
+		var err error
+		if buckets, err = bm.ListBuckets(nil, true); err != nil {
+			return err
+		}
+	}
+	for i := range buckets {
+		bucket := &buckets[i]
+		if !database.GetDB("objs").HasTable(bucket.GetObjsTableName()) {
+			continue
+		}
+		err := bm.WalkObjs(bucket, func(objs []*models.HarborObject) error {
+			for _, obj := range objs {
+				if err := fn(bucket, obj); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptionCommand handle "encryption" subcommands
+func encryptionCommand(args []string) {
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: encryption status|rotate")
+		os.Exit(2)
+	}
+	keyring, err := encrypt.ConfiguredKeyring()
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+
+	switch args[0] {
+	case "status":
+		fs := newFlagSet("encryption status", "")
+		asJSON := fs.Bool("json", false, "print as json")
+		bucketName := fs.String("bucket", "", "only count objects of bucket")
+		parseArgs(fs, args[1:], 0)
+		initDatabase()
+		defer database.CloseAll()
+		// key id of master key, or "SSE-C" -> number of objects
+		counts := map[string]int64{}
+		err := walkObjs(*bucketName, func(bucket *models.Bucket, obj *models.HarborObject) error {
+			switch obj.Encryption {
+			case models.EncryptionAES256:
+				counts[obj.KeyID]++
+			case models.EncryptionCustomer:
+				counts[models.EncryptionCustomer]++
+			}
+			return nil
+		})
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+		if *asJSON {
+			printJSON(map[string]interface{}{"active_key": keyring.ActiveID(), "objects": counts})
+			return
+		}
+		rows := [][]string{}
+		for kid, n := range counts {
+			note := ""
+			switch {
+			case kid == models.EncryptionCustomer:
+				note = "customer-provided keys"
+			case kid == keyring.ActiveID():
+				note = "active"
+			case !keyring.Has(kid):
+				note = "not configured"
+			}
+			rows = append(rows, []string{kid, strconv.FormatInt(n, 10), note})
+		}
+		printTable([]string{"KEY", "OBJECTS", ""}, rows)
+	case "rotate":
+		fs := newFlagSet("encryption rotate", "")
+		bucketName := fs.String("bucket", "", "only rotate data keys of objects of bucket")
+		dryRun := fs.Bool("dry-run", false, "only count objects whose data keys are not wrapped by the active key")
+		parseArgs(fs, args[1:], 0)
+		if !keyring.Enabled() {
+			fatalf("no encryption key is configured\n")
+		}
+		initDatabase()
+		defer database.CloseAll()
+		var rotated, skipped, failed int64
+		err := walkObjs(*bucketName, func(bucket *models.Bucket, obj *models.HarborObject) error {
+			if obj.Encryption != models.EncryptionAES256 || obj.KeyID == keyring.ActiveID() {
+				return nil
+			}
+			if *dryRun {
+				rotated++
+				return nil
+			}
+			// only data keys are wrapped again, data is not encrypted again
+			oldDataKey := obj.DataKey
+			dataKey, err := keyring.Unwrap(obj.KeyID, obj.DataKey)
+			if err == nil {
+				obj.KeyID, obj.DataKey, err = keyring.Wrap(dataKey)
+			}
+			replaced := false
+			if err == nil {
+				// the object may be overwritten or deleted by the server since it was read
+				replaced, err = models.NewHarborObjectManager(bucket.GetObjsTableName(), "", "").ReplaceObjectDataKey(obj, oldDataKey)
+			}
+			if err == nil && !replaced {
+				skipped++
+				return nil
+			}
+			if err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "bucket '%s' object '%s': %s\n", bucket.Name, obj.PathName, err)
+				return nil
+			}
+			rotated++
+			return nil
+		})
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+		if *dryRun {
+			fmt.Printf("%d objects to rotate to key '%s'\n", rotated, keyring.ActiveID())
+			return
+		}
+		fmt.Printf("%d objects rotated to key '%s', %d skipped as changed during rotation, %d failed\n",
+			rotated, keyring.ActiveID(), skipped, failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown encryption command %q\n", args[0])
+		os.Exit(2)
+	}
+}
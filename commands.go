@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"harbor/config"
+	"harbor/database"
+	"harbor/models"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// usage print usage of command line
+func usage() {
+
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, `Usage: %s [flags] [command]
+
+Commands:
+  serve           run http server, the default if no command is given
+  createsuperuser -username NAME [-email EMAIL]
+                  create an active superuser, password is read from HARBOR_SUPERUSER_PASSWORD
+                  or the first line of stdin
+  user list [-json]
+                  list users
+  user set-role USERNAME ROLES
+                  set roles of user, ROLES is comma separated list of superuser, staff,
+                  app_superuser, or normal
+  user activate|deactivate USERNAME
+                  allow or forbid user to log in
+  bucket list [-user USERNAME] [-deleted] [-json]
+                  list buckets
+  bucket stats [-json] NAME
+                  show recorded and actual object count and size of bucket
+  bucket purge -yes NAME
+                  delete bucket and its objects permanently, their data is queued for garbage collection
+  token issue [-json] USERNAME
+                  issue a new auth token for user, the old one is replaced
+  token revoke USERNAME
+                  revoke auth token of user
+  fsck [-bucket NAME] [-json] [-fix-sizes] [-delete-missing] [-delete-orphan-data] [-drop-orphan-tables] [-repair]
+                  check objects of buckets against data in storage, report objects without data,
+                  size mismatches, orphaned data and orphaned bucket tables, and repair them by flags;
+                  stop uploads and deletions before repairing, exit status is 1 if problems are left
+  gc status [-json]
+                  show keys queued for garbage collection
+  gc run          delete data of due keys in queue now
+  gc scan         queue data in storage whose objects do not exist, it is deleted after gc.scan_grace
+  dedup status [-json]
+                  show blobs of deduplicated data and the storage saved by them
+  dedup run       deduplicate objects not modified within dedup.delay now
+  dedup recount [-fix]
+                  count objects referencing each blob, and correct references of blobs by flag;
+                  stop uploads, deletions and deduplication before fixing
+  encryption status [-bucket NAME] [-json]
+                  count encrypted objects by the master key wrapping their data keys
+  encryption rotate [-bucket NAME] [-dry-run]
+                  wrap data keys of objects again by the active master key, the first one of
+                  encryption.keys, old keys can be removed from config after it
+  multidisk status [-json]
+                  show dirs of storage backend multidisk and whether they are available
+  multidisk repair [-key KEY] [-dry-run] [-json]
+                  check shards of data in dirs of storage backend multidisk, and rewrite shards
+                  missing, corrupted or stale from the others, exit status is 1 if damage is left
+  config print    print the effective config with secrets redacted
+  migrate status  list migrations and whether they are applied
+  migrate up [VERSION]
+                  apply pending migrations up to VERSION, all if VERSION is omitted
+  migrate down [N]
+                  revert the last N applied migrations, default 1; the initial one can not be reverted
+
+Configs are read from the config file and overridden by HARBOR_* environment variables,
+e.g. HARBOR_DATABASES_0_PASSWORD, HARBOR_DATABASES_0_PASSWORD_FILE=/run/secrets/db-password.
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+// configCommand handle "config" subcommands
+func configCommand(args []string) {
+
+	if len(args) != 1 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: config print")
+		os.Exit(2)
+	}
+	b, err := json.MarshalIndent(config.GetConfigs().Redacted(), "", "    ")
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+	fmt.Println(string(b))
+}
+
+// migrateOnStart apply pending migrations before serving, or fail if config "no_auto_migrate" is set
+func migrateOnStart() error {
+
+	m, err := models.NewMigrator()
+	if err != nil {
+		return err
+	}
+	pending, err := m.Pending()
+	if err != nil {
+		return fmt.Errorf("check migrations: %s", err)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if config.GetConfigs().NoAutoMigrate {
+		return fmt.Errorf("database schema is not up to date, %d migrations are pending, run \"migrate up\" first", len(pending))
+	}
+	_, err = m.Up(0)
+	return err
+}
+
+// migrateCommand handle "migrate" subcommands
+func migrateCommand(args []string) {
+
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "usage: migrate status|up [VERSION]|down [N]")
+		os.Exit(2)
+	}
+	var n uint64
+	if len(args) == 2 {
+		var err error
+		if n, err = strconv.ParseUint(args[1], 10, 32); err != nil {
+			fatalf("invalid number %q\n", args[1])
+		}
+	}
+
+	database.InitDatabase()
+	defer database.CloseAll()
+	m, err := models.NewMigrator()
+	if err != nil {
+		fatalf("%s\n", err)
+	}
+
+	switch args[0] {
+	case "status":
+		ss, err := m.Status()
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range ss {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		w.Flush()
+	case "up":
+		done, err := m.Up(uint(n))
+		printMigrated("applied", done)
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		done, err := m.Down(int(n))
+		printMigrated("reverted", done)
+		if err != nil {
+			fatalf("%s\n", err)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+func printMigrated(action string, versions []uint) {
+
+	if len(versions) == 0 {
+		fmt.Printf("no migrations %s\n", action)
+		return
+	}
+	for _, v := range versions {
+		fmt.Printf("%s migration %d\n", action, v)
+	}
+}
@@ -0,0 +1,155 @@
+package config_test
+
+import (
+	"harbor/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "harbor-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "db-password")
+	ioutil.WriteFile(secret, []byte("s3cret\n"), 0600)
+
+	c := config.Config{Databases: []config.DBConfig{{Alias: "default", Host: "old", Port: 3306}}}
+	err = config.ApplyEnv(&c, []string{
+		"HARBOR_DEBUG=true",
+		"HARBOR_DATABASES_0_HOST=db.local",
+		"HARBOR_DATABASES_0_PASSWORD_FILE=" + secret,
+		"HARBOR_DATABASES_1_ALIAS=objs",
+		"HARBOR_DATABASES_1_PORT=3307",
+		"HARBOR_CEPH_RADOS_POOL_NAME=harbor",
+		"HARBOR_JWT_TIMEOUT=2h",
+		"HARBOR_AUTH_BACKENDS=local, ldap",
+		"HARBOR_OIDC_GROUP_ROLES={\"admins\": \"superuser\"}",
+		"HARBOR_RATE_LIMIT_USERS={\"bob\": {\"request_rate\": 1.5}}",
+		"OTHER_DEBUG=false",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Debug || c.CephRados.PoolName != "harbor" || c.JWT.Timeout != 2*time.Hour {
+		t.Errorf("scalar values are not overridden: %+v", c)
+	}
+	if len(c.Databases) != 2 {
+		t.Fatalf("database should be appended, got %+v", c.Databases)
+	}
+	if db := c.Databases[0]; db.Host != "db.local" || db.Port != 3306 || db.Password != "s3cret" {
+		t.Errorf("database 0 got %+v", db)
+	}
+	if db := c.Databases[1]; db.Alias != "objs" || db.Port != 3307 {
+		t.Errorf("database 1 got %+v", db)
+	}
+	if len(c.AuthBackends) != 2 || c.AuthBackends[1] != "ldap" {
+		t.Errorf("auth backends got %v", c.AuthBackends)
+	}
+	if c.OIDC.GroupRoles["admins"] != "superuser" || c.RateLimit.Users["bob"].RequestRate != 1.5 {
+		t.Errorf("maps are not overridden: %v %v", c.OIDC.GroupRoles, c.RateLimit.Users)
+	}
+
+	bad := [][]string{
+		{"HARBOR_DATABASES_0_PORT=abc"},
+		{"HARBOR_SECRET_KEY=x", "HARBOR_SECRET_KEY_FILE=" + secret},
+		{"HARBOR_SECRET_KEY_FILE=" + filepath.Join(dir, "missing")},
+		{"HARBOR_DATABASES=not json"},
+	}
+	for _, environ := range bad {
+		if err := config.ApplyEnv(&config.Config{}, environ); err == nil || !strings.HasPrefix(err.Error(), "HARBOR_") {
+			t.Errorf("%v should fail with the variable name, got %v", environ, err)
+		}
+	}
+}
+
+func validConfig() config.Config {
+
+	return config.Config{
+		SecretKey: "key",
+		Databases: []config.DBConfig{{Alias: "default", Engine: "mysql", Host: "localhost", Port: 3306, Name: "harbor", Password: "pass"}},
+		CephRados: config.CephConfig{ConfFile: "/etc/ceph/ceph.conf", PoolName: "harbor"},
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	c := validConfig()
+	if err := c.Validate(); err != nil {
+		t.Fatalf("valid config got %v", err)
+	}
+
+	c.SecretKey = ""
+	c.Databases = append(c.Databases, config.DBConfig{Alias: "default", Engine: "oracle"})
+	c.Storage.Backend = "tape"
+	c.Server.TLSCertFile = "cert.pem"
+	err := c.Validate()
+	verr, ok := err.(*config.ValidationError)
+	if !ok {
+		t.Fatalf("should return *ValidationError, got %v", err)
+	}
+	for _, want := range []string{"secret_key", "duplicated", "engine 'oracle'", "storage.backend", "tls_key_file"} {
+		if !strings.Contains(verr.Error(), want) {
+			t.Errorf("error should mention %q: %s", want, verr)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+
+	c := validConfig()
+	c.JWT.Timeout = time.Hour
+	m := c.Redacted()
+	if m["secret_key"] != config.RedactedValue {
+		t.Errorf("secret key should be redacted, got %v", m["secret_key"])
+	}
+	db := m["databases"].([]interface{})[0].(map[string]interface{})
+	if db["password"] != config.RedactedValue || db["host"] != "localhost" {
+		t.Errorf("database got %v", db)
+	}
+	if m["jwt"].(map[string]interface{})["timeout"] != "1h0m0s" {
+		t.Errorf("duration should be printed as string, got %v", m["jwt"])
+	}
+	if m["oidc"].(map[string]interface{})["client_secret"] != "" {
+		t.Errorf("empty secret should be kept empty")
+	}
+}
+
+func TestLoad(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "harbor-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "harbor.json")
+	ioutil.WriteFile(file, []byte(`{
+		"secret_key": "key",
+		"databases": [{"alias": "default", "engine": "mysql", "host": "localhost", "port": "3306", "name": "harbor"}],
+		"ceph_rados": {"conf_file": "/etc/ceph/ceph.conf", "pool_name": "harbor"}
+	}`), 0600)
+
+	os.Setenv("HARBOR_DATABASES_0_NAME", "fromenv")
+	defer os.Unsetenv("HARBOR_DATABASES_0_NAME")
+	if err := config.Load(file, dir); err != nil {
+		t.Fatal(err)
+	}
+	c := config.GetConfigs()
+	if c.Databases[0].Name != "fromenv" || c.Databases[0].Port != 3306 || c.BaseDir != dir {
+		t.Errorf("got %+v", c)
+	}
+
+	if err := config.Load(filepath.Join(dir, "missing.json"), dir); err == nil {
+		t.Errorf("missing config file given explicitly should fail")
+	}
+	if err := config.Load("", dir); err == nil {
+		t.Errorf("config of environment variables only should be validated")
+	}
+}
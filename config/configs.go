@@ -2,7 +2,10 @@ package config
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -10,13 +13,18 @@ import (
 // DBConfig database config struct
 type DBConfig struct {
 	Alias    string `mapstructure:"alias"`
-	Engine   string `mapstructure:"engine"`
+	Engine   string `mapstructure:"engine"` // mysql, postgres or sqlite3
 	Host     string `mapstructure:"host"`
 	Port     int    `mapstructure:"port"`
-	Name     string `mapstructure:"name"`
+	Name     string `mapstructure:"name"` // database name, or file path for sqlite3(":memory:" for in-memory database)
 	User     string `mapstructure:"user"`
-	Password string `mapstructure:"password"`
+	Password string `mapstructure:"password" secret:"true"`
 	Charset  string `mapstructure:"charset"`
+	SSLMode  string `mapstructure:"sslmode"` // postgres only, default "disable"
+
+	MaxOpenConns    int           `mapstructure:"max_open_conns"`    // 0 for unlimited, sqlite is limited to 1 by default
+	MaxIdleConns    int           `mapstructure:"max_idle_conns"`    // 0 for default 2, negative for no idle connections
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"` // 0 for no limit
 }
 
 // CephConfig ceph rados configs
@@ -28,13 +36,253 @@ type CephConfig struct {
 	PoolName    string `mapstructure:"pool_name"`
 }
 
+// MultiDiskConfig multidisk storage backend config, data of each object is spread across directories on different disks
+type MultiDiskConfig struct {
+	Dirs         []string `mapstructure:"dirs"`          // e.g. mount points of disks, they are not created; order should not be changed
+	Mode         string   `mapstructure:"mode"`          // "erasure"(default) or "replica"
+	DataShards   int      `mapstructure:"data_shards"`   // erasure only, default number of dirs - parity_shards
+	ParityShards int      `mapstructure:"parity_shards"` // erasure only, shards can be lost, default 2, or 1 for less than 4 dirs
+	Replicas     int      `mapstructure:"replicas"`      // replica only, copies of data, default number of dirs
+}
+
+// S3Config s3 storage backend config, data of each object is stored in parts in a bucket of an S3-compatible service
+type S3Config struct {
+	Endpoint    string        `mapstructure:"endpoint"`                 // e.g. https://s3.us-east-1.amazonaws.com or http://minio:9000
+	Region      string        `mapstructure:"region"`                   // default "us-east-1"
+	Bucket      string        `mapstructure:"bucket"`                   // should exist
+	AccessKey   string        `mapstructure:"access_key"`               // requests are not signed if empty
+	SecretKey   string        `mapstructure:"secret_key" secret:"true"` //
+	Prefix      string        `mapstructure:"prefix"`                   // prefix of keys of data in bucket, e.g. "harbor/"
+	VirtualHost bool          `mapstructure:"virtual_host"`             // address bucket by host name, default by path
+	PartSize    uint64        `mapstructure:"part_size"`                // bytes of data in each s3 object, default 8MiB; should not be changed
+	Timeout     time.Duration `mapstructure:"timeout"`                  // timeout of a request, default 2m
+}
+
+// StorageConfig object data storage config
+type StorageConfig struct {
+	Backend   string          `mapstructure:"backend"` // "ceph"(default), "filesystem"(files in {base dir}/upload), "multidisk" or "s3"
+	MultiDisk MultiDiskConfig `mapstructure:"multidisk"`
+	S3        S3Config        `mapstructure:"s3"`
+}
+
+// JWTKeyConfig jwt signing key configs
+type JWTKeyConfig struct {
+	KeyID       string `mapstructure:"kid"`
+	Algorithm   string `mapstructure:"algorithm"` // default the same as JWTConfig.Algorithm
+	PrivKeyFile string `mapstructure:"priv_key_file"`
+	PubKeyFile  string `mapstructure:"pub_key_file"`
+	ActiveFrom  string `mapstructure:"active_from"` // RFC3339 time, the key is used to sign new token since it
+	ExpireAt    string `mapstructure:"expire_at"`   // RFC3339 time, the key is not accepted since it
+}
+
+// JWTConfig jwt configs
+type JWTConfig struct {
+	Algorithm   string         `mapstructure:"algorithm"` // HS256(default), HS384, HS512, RS256, RS384, RS512, ES256, ES384, ES512
+	PrivKeyFile string         `mapstructure:"priv_key_file"`
+	PubKeyFile  string         `mapstructure:"pub_key_file"`
+	Keys        []JWTKeyConfig `mapstructure:"keys"` // multiple keys for key rotation, asymmetric algorithm only
+	Timeout     time.Duration  `mapstructure:"timeout"`
+	MaxRefresh  time.Duration  `mapstructure:"max_refresh"`
+}
+
+// OIDCConfig OpenID Connect login configs
+type OIDCConfig struct {
+	Enabled       bool              `mapstructure:"enabled"`
+	Issuer        string            `mapstructure:"issuer"`
+	ClientID      string            `mapstructure:"client_id"`
+	ClientSecret  string            `mapstructure:"client_secret" secret:"true"`
+	RedirectURL   string            `mapstructure:"redirect_url"`   // e.g. https://harbor.example.com/oidc/callback/
+	Scopes        []string          `mapstructure:"scopes"`         // default ["openid", "email", "profile"]
+	UsernameClaim string            `mapstructure:"username_claim"` // default "email"
+	GroupsClaim   string            `mapstructure:"groups_claim"`   // optional, e.g. "groups"
+	GroupRoles    map[string]string `mapstructure:"group_roles"`    // group -> role, role is one of "superuser", "staff", "app_superuser"
+	AutoCreate    bool              `mapstructure:"auto_create"`    // create user at first login(just-in-time provisioning)
+	LinkExisting  bool              `mapstructure:"link_existing"`  // link existing users with local password or of LDAP at first login
+}
+
+// LDAPConfig LDAP authentication backend configs
+type LDAPConfig struct {
+	URL                string            `mapstructure:"url"` // ldap://host:389 or ldaps://host:636
+	StartTLS           bool              `mapstructure:"start_tls"`
+	InsecureSkipVerify bool              `mapstructure:"insecure_skip_verify"`
+	Timeout            time.Duration     `mapstructure:"timeout"`                     // default 10s
+	BindDN             string            `mapstructure:"bind_dn"`                     // account used to search user, empty for anonymous search
+	BindPassword       string            `mapstructure:"bind_password" secret:"true"` //
+	UserSearchBase     string            `mapstructure:"user_search_base"`            // e.g. ou=people,dc=example,dc=com
+	UserFilter         string            `mapstructure:"user_filter"`                 // default (uid=%s), %s is replaced by escaped username
+	UserDNTemplate     string            `mapstructure:"user_dn_template"`            // bind directly without search if set, e.g. uid=%s,ou=people,dc=example,dc=com
+	Attributes         map[string]string `mapstructure:"attributes"`                  // UserProfile field -> LDAP attribute, fields: email, first_name, last_name, company, telephone
+	AutoCreate         bool              `mapstructure:"auto_create"`                 // create local user at first login
+	BindCacheTTL       time.Duration     `mapstructure:"bind_cache_ttl"`              // successful binds are reused for the same password, default 1m, negative to disable
+}
+
+// PasswordHasherConfig password hasher configs, zero value means default
+type PasswordHasherConfig struct {
+	Algorithm         string `mapstructure:"algorithm"`          // pbkdf2_sha256(default), argon2, bcrypt_sha256
+	PBKDF2Iterations  int    `mapstructure:"pbkdf2_iterations"`  // default 150000
+	Argon2Time        uint32 `mapstructure:"argon2_time"`        // default 3
+	Argon2Memory      uint32 `mapstructure:"argon2_memory"`      // KiB, default 65536
+	Argon2Parallelism uint8  `mapstructure:"argon2_parallelism"` // default 4
+	BcryptCost        int    `mapstructure:"bcrypt_cost"`        // default 12
+}
+
+// TwoFactorConfig TOTP two-factor authentication configs
+type TwoFactorConfig struct {
+	Issuer              string `mapstructure:"issuer"`                // shown in authenticator apps, default "EVHarbor"
+	Skew                int    `mapstructure:"skew"`                  // time steps allowed before and after for clock drift, default 1
+	RequireForPrivilege bool   `mapstructure:"require_for_privilege"` // staff and super users must enroll before using other APIs
+}
+
+// EmailConfig email delivery configs
+type EmailConfig struct {
+	Backend      string        `mapstructure:"backend"` // smtp, file or log(development only); if empty, emails are not delivered, only headers are logged
+	Host         string        `mapstructure:"host"`
+	Port         int           `mapstructure:"port"`
+	Username     string        `mapstructure:"username"`
+	Password     string        `mapstructure:"password" secret:"true"`
+	Security     string        `mapstructure:"security"`      // starttls(default), tls, none
+	FilePath     string        `mapstructure:"file_path"`     // directory of file backend
+	From         string        `mapstructure:"from"`          // e.g. EVHarbor <noreply@example.com>
+	SiteURL      string        `mapstructure:"site_url"`      // used to build links in email, e.g. https://harbor.example.com
+	VerifyExpire time.Duration `mapstructure:"verify_expire"` // email verification link expire, default 72h
+	ResetExpire  time.Duration `mapstructure:"reset_expire"`  // password reset link expire, default 1h
+}
+
+// LockoutConfig login brute-force protection config
+type LockoutConfig struct {
+	Disabled       bool          `mapstructure:"disabled"`
+	FreeAttempts   int           `mapstructure:"free_attempts"`    // failures allowed per username before lockout, default 5
+	IPFreeAttempts int           `mapstructure:"ip_free_attempts"` // failures allowed per client ip before lockout, default 20
+	BaseDelay      time.Duration `mapstructure:"base_delay"`       // lockout duration, doubled for each failure after, default 1s
+	MaxDelay       time.Duration `mapstructure:"max_delay"`        // default 15m
+	ResetAfter     time.Duration `mapstructure:"reset_after"`      // failures are forgotten if no failure in this duration, default 1h
+}
+
+// RateLimitRule rate limits of a user, role or client ip, 0 means unlimited
+type RateLimitRule struct {
+	RequestRate  float64 `mapstructure:"request_rate"`  // requests per second
+	RequestBurst int64   `mapstructure:"request_burst"` // default request_rate
+	UploadRate   int64   `mapstructure:"upload_rate"`   // bytes per second
+	DownloadRate int64   `mapstructure:"download_rate"` // bytes per second
+}
+
+// RateLimitConfig request rate limiting and bandwidth throttling config
+type RateLimitConfig struct {
+	Enabled bool                     `mapstructure:"enabled"`
+	KeyBy   string                   `mapstructure:"key_by"`  // "user"(default), "token" or "ip"; anonymous requests are keyed by client ip
+	Global  RateLimitRule            `mapstructure:"global"`  // limits of all requests in total
+	Default RateLimitRule            `mapstructure:"default"` // limits of each key
+	Roles   map[string]RateLimitRule `mapstructure:"roles"`   // limits of each key by user role: superuser, staff, app_superuser, normal, anonymous
+	Users   map[string]RateLimitRule `mapstructure:"users"`   // limits of each key by username, override roles
+}
+
+// MetricsConfig prometheus metrics endpoint config
+type MetricsConfig struct {
+	Disabled     bool     `mapstructure:"disabled"`
+	Path         string   `mapstructure:"path"`                // default "/metrics"
+	Token        string   `mapstructure:"token" secret:"true"` // if not empty, scrape requests must have header "Authorization: Bearer {token}"
+	BucketLabels []string `mapstructure:"bucket_labels"`       // buckets labeled by name in metrics, others are labeled "other"
+}
+
+// AuditConfig audit log config
+type AuditConfig struct {
+	Disabled bool   `mapstructure:"disabled"`
+	File     string `mapstructure:"file"` // if not empty, events are also appended to this file in JSON lines
+}
+
+// LogConfig logging config
+type LogConfig struct {
+	Level    string `mapstructure:"level"`     // debug, info(default), warn, error
+	Format   string `mapstructure:"format"`    // text(default) or json
+	File     string `mapstructure:"file"`      // empty for stderr
+	NoAccess bool   `mapstructure:"no_access"` // do not log requests
+	SQL      bool   `mapstructure:"sql"`       // log sql statements at debug level
+}
+
+// WebhookConfig bucket event notification config
+type WebhookConfig struct {
+	Disabled             bool          `mapstructure:"disabled"`
+	Workers              int           `mapstructure:"workers"`                // delivery goroutines, default 4
+	QueueSize            int           `mapstructure:"queue_size"`             // default 1000
+	MaxAttempts          int           `mapstructure:"max_attempts"`           // attempts before a delivery is dead, default 8
+	BaseDelay            time.Duration `mapstructure:"base_delay"`             // delay before first retry, doubled for each retry after, default 30s
+	MaxDelay             time.Duration `mapstructure:"max_delay"`              // default 1h
+	Timeout              time.Duration `mapstructure:"timeout"`                // timeout of a attempt, default 10s
+	MaxPerBucket         int           `mapstructure:"max_per_bucket"`         // default 10
+	AllowPrivateNetworks bool          `mapstructure:"allow_private_networks"` // allow endpoints in private and loopback networks
+}
+
+// EncryptionKeyConfig master key of encryption at rest
+type EncryptionKeyConfig struct {
+	KeyID string `mapstructure:"kid"`               // stored with objects to find the key wrapping their data keys
+	Key   string `mapstructure:"key" secret:"true"` // base64 encoded 32 bytes, e.g. output of "openssl rand -base64 32"
+}
+
+// EncryptionConfig encryption at rest config, data keys of objects in encrypted buckets are wrapped by master keys
+type EncryptionConfig struct {
+	Keys []EncryptionKeyConfig `mapstructure:"keys"` // the first key wraps new data keys, others are kept to unwrap old ones until "encryption rotate"
+}
+
+// GCConfig garbage collector of storage data config
+type GCConfig struct {
+	Disabled     bool          `mapstructure:"disabled"`      // do not run garbage collector in server, keys are kept in queue
+	Interval     time.Duration `mapstructure:"interval"`      // interval of collecting due keys in queue, default 1m
+	BatchSize    int           `mapstructure:"batch_size"`    // keys read from queue at a time, default 100
+	BaseDelay    time.Duration `mapstructure:"base_delay"`    // delay before first retry, doubled for each retry after, default 1m
+	MaxDelay     time.Duration `mapstructure:"max_delay"`     // default 6h
+	ScanInterval time.Duration `mapstructure:"scan_interval"` // interval of orphan scan, no periodic scan if 0
+	ScanGrace    time.Duration `mapstructure:"scan_grace"`    // delay before deleting orphans found by scan, default 1h
+}
+
+// DedupConfig deduplication of object data config, objects of the same content share data stored once
+type DedupConfig struct {
+	Enabled   bool          `mapstructure:"enabled"`    // deduplicate data of objects in background in server
+	MinSize   uint64        `mapstructure:"min_size"`   // smaller objects are not deduplicated, default 1MiB
+	Delay     time.Duration `mapstructure:"delay"`      // objects modified within delay are not deduplicated, default 10m
+	Interval  time.Duration `mapstructure:"interval"`   // interval of deduplication, default 10m
+	BatchSize int           `mapstructure:"batch_size"` // objects read from a table at a time, default 100
+}
+
+// ServerConfig http server config, zero timeout means no timeout
+type ServerConfig struct {
+	Address           string        `mapstructure:"address"`             // listen address, default ":9999"
+	TLSCertFile       string        `mapstructure:"tls_cert_file"`       // serve https if both cert and key are set, reloaded on SIGHUP
+	TLSKeyFile        string        `mapstructure:"tls_key_file"`        //
+	ReadHeaderTimeout time.Duration `mapstructure:"read_header_timeout"` // default 10s
+	ReadTimeout       time.Duration `mapstructure:"read_timeout"`        // whole request including body, keep 0 for long uploads
+	WriteTimeout      time.Duration `mapstructure:"write_timeout"`       // whole response, keep 0 for long downloads
+	IdleTimeout       time.Duration `mapstructure:"idle_timeout"`        // keep-alive connections, default 120s
+	DrainDelay        time.Duration `mapstructure:"drain_delay"`         // delay after readiness fails before stop accepting connections at shutdown
+	ShutdownTimeout   time.Duration `mapstructure:"shutdown_timeout"`    // wait for in-flight requests at shutdown, default 5m
+	TrustedProxies    []string      `mapstructure:"trusted_proxies"`     // IPs or CIDRs of reverse proxies, X-Forwarded-For and X-Real-IP are used only from them
+}
+
 // Config struct
 type Config struct {
-	Debug     bool       `mapstructure:"debug"`
-	SecretKey string     `mapstructure:"secret_key"`
-	Databases []DBConfig `mapstructure:"databases"` //database configs
-	CephRados CephConfig `mapstructure:"ceph_rados"`
-	BaseDir   string
+	Debug         bool                 `mapstructure:"debug"`
+	SecretKey     string               `mapstructure:"secret_key" secret:"true"`
+	Databases     []DBConfig           `mapstructure:"databases"` //database configs
+	CephRados     CephConfig           `mapstructure:"ceph_rados"`
+	Storage       StorageConfig        `mapstructure:"storage"`
+	JWT           JWTConfig            `mapstructure:"jwt"`
+	OIDC          OIDCConfig           `mapstructure:"oidc"`
+	AuthBackends  []string             `mapstructure:"auth_backends"` // authentication backends in order, "local" and "ldap", default ["local"]
+	LDAP          LDAPConfig           `mapstructure:"ldap"`
+	Hasher        PasswordHasherConfig `mapstructure:"password_hasher"` // passwords are rehashed at login if algorithm changed or cost raised
+	TwoFactor     TwoFactorConfig      `mapstructure:"two_factor"`
+	Email         EmailConfig          `mapstructure:"email"`
+	Lockout       LockoutConfig        `mapstructure:"lockout"`
+	RateLimit     RateLimitConfig      `mapstructure:"rate_limit"`
+	Metrics       MetricsConfig        `mapstructure:"metrics"`
+	Audit         AuditConfig          `mapstructure:"audit"`
+	Webhook       WebhookConfig        `mapstructure:"webhook"`
+	GC            GCConfig             `mapstructure:"gc"`
+	Dedup         DedupConfig          `mapstructure:"dedup"`
+	Encryption    EncryptionConfig     `mapstructure:"encryption"`
+	Log           LogConfig            `mapstructure:"log"`
+	Server        ServerConfig         `mapstructure:"server"`
+	NoAutoMigrate bool                 `mapstructure:"no_auto_migrate"` // do not apply pending migrations at start, run "migrate up" instead
+	BaseDir       string
 }
 
 var configs Config
@@ -44,19 +292,59 @@ func GetConfigs() *Config {
 	return &configs
 }
 
-// LoadConfigFile load config file
-func LoadConfigFile(basDir string) {
-	path := filepath.Join(basDir, "config")
-	v := viper.New()
-	v.SetConfigName("config") // name of config file (without extension)
-	v.AddConfigPath(path)     // optionally look for config in the working directory
-	v.SetConfigType("json")
-	// Find and read the config file
-	if err := v.ReadInConfig(); err != nil {
-		panic(fmt.Errorf("fatal error config file: %s ", err))
+// AbsPath return absolute path, relative path is relative to BaseDir
+func (c *Config) AbsPath(path string) string {
+
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.BaseDir, path)
+}
+
+// DefaultConfigFile return path of config file used if no path is given
+func DefaultConfigFile(baseDir string) string {
+
+	return filepath.Join(baseDir, "config", "config.json")
+}
+
+// Load load configs from file and override them with HARBOR_* environment variables, then validate them.
+// path is a config file or a directory containing config.json, if path is empty,
+// DefaultConfigFile is used and configs can be given only by environment variables when it does not exist.
+// Relative paths in configs are relative to baseDir.
+func Load(path, baseDir string) error {
+
+	c := Config{}
+	explicit := path != ""
+	if !explicit {
+		path = DefaultConfigFile(baseDir)
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, "config.json")
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		v := viper.New()
+		v.SetConfigFile(path)
+		if ext := strings.TrimPrefix(filepath.Ext(path), "."); ext == "" {
+			v.SetConfigType("json")
+		}
+		if err := v.ReadInConfig(); err != nil {
+			return fmt.Errorf("read config file '%s': %s", path, err)
+		}
+		if err := v.Unmarshal(&c); err != nil {
+			return fmt.Errorf("parse config file '%s': %s", path, err)
+		}
+	} else if explicit || !os.IsNotExist(err) {
+		return fmt.Errorf("config file: %s", err)
+	}
+
+	if err := ApplyEnv(&c, os.Environ()); err != nil {
+		return fmt.Errorf("environment variable %s", err)
 	}
-	if err := v.Unmarshal(&configs); err != nil {
-		panic(fmt.Errorf("fatal error config file: %s ", err))
+	c.BaseDir = baseDir
+	if err := c.Validate(); err != nil {
+		return err
 	}
-	configs.BaseDir = basDir
+	configs = c
+	return nil
 }
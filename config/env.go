@@ -0,0 +1,174 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// EnvPrefix prefix of environment variables overriding configs
+const EnvPrefix = "HARBOR"
+
+// EnvFileSuffix suffix of environment variables naming a file that the value is read from, used for secrets
+const EnvFileSuffix = "_FILE"
+
+// ApplyEnv override configs with environment variables, environ is in the form of os.Environ().
+//
+// Name of the variable is EnvPrefix and the mapstructure keys joined by "_" in upper case,
+// e.g. HARBOR_SECRET_KEY, HARBOR_CEPH_RADOS_POOL_NAME, HARBOR_DATABASES_0_PASSWORD.
+// Items of list of structs are selected by index, the index equal to length of the list appends an item.
+// Lists and maps can be set in JSON, e.g. HARBOR_DATABASES='[{"alias":"default", ...}]',
+// list of strings can also be set comma separated, e.g. HARBOR_AUTH_BACKENDS=local,ldap.
+// String values can be read from a file by the name with suffix "_FILE", e.g. HARBOR_DATABASES_0_PASSWORD_FILE=/run/secrets/db.
+func ApplyEnv(c *Config, environ []string) error {
+
+	env := map[string]string{}
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 && strings.HasPrefix(kv, EnvPrefix+"_") {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	if len(env) == 0 {
+		return nil
+	}
+	return applyEnv(reflect.ValueOf(c).Elem(), EnvPrefix, env)
+}
+
+// fieldKey return mapstructure key of struct field, "" if no tag
+func fieldKey(f reflect.StructField) string {
+
+	tag := f.Tag.Get("mapstructure")
+	if i := strings.Index(tag, ","); i >= 0 {
+		tag = tag[:i]
+	}
+	return tag
+}
+
+func applyEnv(v reflect.Value, name string, env map[string]string) error {
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			key := fieldKey(t.Field(i))
+			if key == "" {
+				continue
+			}
+			if err := applyEnv(v.Field(i), name+"_"+strings.ToUpper(key), env); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice:
+		if s, ok := env[name]; ok {
+			var raw interface{}
+			if strings.HasPrefix(strings.TrimSpace(s), "[") {
+				if err := json.Unmarshal([]byte(s), &raw); err != nil {
+					return fmt.Errorf("%s: invalid JSON: %s", name, err)
+				}
+			} else if v.Type().Elem().Kind() == reflect.String {
+				raw = splitList(s)
+			} else {
+				return fmt.Errorf("%s: JSON list is required", name)
+			}
+			v.Set(reflect.Zero(v.Type()))
+			if err := decodeValue(v, raw); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+		}
+		if v.Type().Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		for i := 0; ; i++ {
+			prefix := fmt.Sprintf("%s_%d", name, i)
+			if i >= v.Len() {
+				if !hasPrefix(env, prefix+"_") {
+					return nil
+				}
+				v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+			}
+			if err := applyEnv(v.Index(i), prefix, env); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		s, ok := env[name]
+		if !ok {
+			return nil
+		}
+		var raw interface{}
+		if err := json.Unmarshal([]byte(s), &raw); err != nil {
+			return fmt.Errorf("%s: invalid JSON: %s", name, err)
+		}
+		v.Set(reflect.Zero(v.Type()))
+		if err := decodeValue(v, raw); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		return nil
+	default:
+		s, ok := env[name]
+		file, fromFile := env[name+EnvFileSuffix]
+		if ok && fromFile {
+			return fmt.Errorf("%s and %s%s are both set", name, name, EnvFileSuffix)
+		}
+		if fromFile {
+			if v.Kind() != reflect.String {
+				return fmt.Errorf("%s%s: only string value can be read from file", name, EnvFileSuffix)
+			}
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("%s%s: %s", name, EnvFileSuffix, err)
+			}
+			s, ok = strings.TrimRight(string(b), "\r\n"), true
+		}
+		if !ok {
+			return nil
+		}
+		if err := decodeValue(v, s); err != nil {
+			return fmt.Errorf("%s: invalid %s value %q", name, v.Type(), s)
+		}
+		return nil
+	}
+}
+
+// decodeValue decode raw into v in the same way as viper decoding config file
+func decodeValue(v reflect.Value, raw interface{}) error {
+
+	d, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		WeaklyTypedInput: true,
+		Result:           v.Addr().Interface(),
+	})
+	if err != nil {
+		return err
+	}
+	return d.Decode(raw)
+}
+
+func splitList(s string) []string {
+
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func hasPrefix(env map[string]string, prefix string) bool {
+
+	for name := range env {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"reflect"
+	"time"
+)
+
+// RedactedValue replace non-empty secret in printed configs
+const RedactedValue = "******"
+
+// Redacted return configs as map keyed by config keys, values of fields tagged `secret:"true"` are redacted
+func (c *Config) Redacted() map[string]interface{} {
+
+	return toMap(reflect.ValueOf(*c)).(map[string]interface{})
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func toMap(v reflect.Value) interface{} {
+
+	if v.Type() == durationType {
+		return v.Interface().(time.Duration).String()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		m := map[string]interface{}{}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			key := fieldKey(f)
+			if key == "" {
+				continue
+			}
+			if f.Tag.Get("secret") == "true" && v.Field(i).String() != "" {
+				m[key] = RedactedValue
+				continue
+			}
+			m[key] = toMap(v.Field(i))
+		}
+		return m
+	case reflect.Slice:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = toMap(v.Index(i))
+		}
+		return items
+	case reflect.Map:
+		m := map[string]interface{}{}
+		for _, k := range v.MapKeys() {
+			m[k.String()] = toMap(v.MapIndex(k))
+		}
+		return m
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Engines supported database engines
+var Engines = []string{"mysql", "postgres", "sqlite3"}
+
+// ValidationError problems found in configs
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+
+	return "invalid config:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+func (e *ValidationError) addf(format string, a ...interface{}) {
+
+	e.Problems = append(e.Problems, fmt.Sprintf(format, a...))
+}
+
+func oneOf(value string, choices ...string) bool {
+
+	for _, c := range choices {
+		if value == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate return *ValidationError listing all the problems, nil if configs are valid
+func (c *Config) Validate() error {
+
+	e := &ValidationError{}
+
+	if c.SecretKey == "" {
+		e.addf("secret_key is required")
+	}
+
+	if len(c.Databases) == 0 {
+		e.addf("databases: at least one database is required")
+	}
+	aliases := map[string]bool{}
+	for i, db := range c.Databases {
+		name := fmt.Sprintf("databases[%d]", i)
+		if db.Alias == "" {
+			e.addf("%s.alias is required", name)
+		} else if aliases[db.Alias] {
+			e.addf("%s.alias '%s' is duplicated", name, db.Alias)
+		}
+		aliases[db.Alias] = true
+		if !oneOf(db.Engine, Engines...) {
+			e.addf("%s.engine '%s' is not supported, should be one of %s", name, db.Engine, strings.Join(Engines, ", "))
+		}
+		switch db.Engine {
+		case "mysql", "postgres":
+			if db.Host == "" {
+				e.addf("%s.host is required", name)
+			}
+			if db.Port < 0 || db.Port > 65535 {
+				e.addf("%s.port %d is invalid", name, db.Port)
+			}
+			if db.Name == "" {
+				e.addf("%s.name is required", name)
+			}
+		case "sqlite3":
+			if db.Name == "" {
+				e.addf("%s.name is required, it is the path of database file", name)
+			}
+		}
+	}
+	if len(c.Databases) > 0 && !aliases["default"] {
+		e.addf("databases: a database with alias 'default' is required")
+	}
+
+	switch strings.ToLower(c.Storage.Backend) {
+	case "", "ceph":
+		if c.CephRados.ConfFile == "" {
+			e.addf("ceph_rados.conf_file is required for storage backend ceph")
+		}
+		if c.CephRados.PoolName == "" {
+			e.addf("ceph_rados.pool_name is required for storage backend ceph")
+		}
+	case "filesystem":
+	case "multidisk":
+		md := c.Storage.MultiDisk
+		if len(md.Dirs) < 2 {
+			e.addf("storage.multidisk.dirs: at least 2 dirs are required for storage backend multidisk")
+		}
+		dirs := map[string]bool{}
+		for i, dir := range md.Dirs {
+			if dir == "" {
+				e.addf("storage.multidisk.dirs[%d] is empty", i)
+			} else if dirs[dir] {
+				e.addf("storage.multidisk.dirs[%d] '%s' is duplicated", i, dir)
+			}
+			dirs[dir] = true
+		}
+		if !oneOf(strings.ToLower(md.Mode), "", "erasure", "replica") {
+			e.addf("storage.multidisk.mode '%s' should be erasure or replica", md.Mode)
+		}
+		if md.DataShards < 0 || md.ParityShards < 0 || md.Replicas < 0 {
+			e.addf("storage.multidisk.data_shards, parity_shards and replicas should not be negative")
+		} else if md.DataShards+md.ParityShards > len(md.Dirs) || md.Replicas > len(md.Dirs) {
+			e.addf("storage.multidisk: shards or replicas should not be more than %d dirs", len(md.Dirs))
+		}
+	case "s3":
+		s3 := c.Storage.S3
+		if u, err := url.Parse(s3.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			e.addf("storage.s3.endpoint '%s' should be http(s)://host[:port]", s3.Endpoint)
+		}
+		if s3.Bucket == "" {
+			e.addf("storage.s3.bucket is required for storage backend s3")
+		}
+		if (s3.AccessKey == "") != (s3.SecretKey == "") {
+			e.addf("storage.s3.access_key and storage.s3.secret_key should be set together")
+		}
+	default:
+		e.addf("storage.backend '%s' should be ceph, filesystem, multidisk or s3", c.Storage.Backend)
+	}
+
+	kids := map[string]bool{}
+	for i, k := range c.Encryption.Keys {
+		name := fmt.Sprintf("encryption.keys[%d]", i)
+		if k.KeyID == "" {
+			e.addf("%s.kid is required", name)
+		} else if kids[k.KeyID] {
+			e.addf("%s.kid '%s' is duplicated", name, k.KeyID)
+		}
+		kids[k.KeyID] = true
+		if key, err := base64.StdEncoding.DecodeString(k.Key); err != nil || len(key) != 32 {
+			e.addf("%s.key should be 32 bytes encoded in base64", name)
+		}
+	}
+
+	if c.Server.Address != "" {
+		if _, _, err := net.SplitHostPort(c.Server.Address); err != nil {
+			e.addf("server.address '%s' is invalid: %s", c.Server.Address, err)
+		}
+	}
+	for _, p := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			e.addf("server.trusted_proxies: '%s' should be an IP or CIDR", p)
+		}
+	}
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		e.addf("server.tls_cert_file and server.tls_key_file should be set together")
+	}
+
+	if a := c.JWT.Algorithm; a != "" && !oneOf(a, "HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "ES256", "ES384", "ES512") {
+		e.addf("jwt.algorithm '%s' is not supported", a)
+	}
+
+	for _, b := range c.AuthBackends {
+		if !oneOf(b, "local", "ldap") {
+			e.addf("auth_backends: '%s' should be local or ldap", b)
+		}
+		if b == "ldap" && c.LDAP.URL == "" {
+			e.addf("ldap.url is required for auth backend ldap")
+		}
+	}
+	if c.OIDC.Enabled {
+		if c.OIDC.Issuer == "" {
+			e.addf("oidc.issuer is required when oidc is enabled")
+		}
+		if c.OIDC.ClientID == "" {
+			e.addf("oidc.client_id is required when oidc is enabled")
+		}
+		if c.OIDC.RedirectURL == "" {
+			e.addf("oidc.redirect_url is required when oidc is enabled")
+		}
+	}
+
+	if !oneOf(strings.ToLower(c.Email.Backend), "", "smtp", "file", "log") {
+		e.addf("email.backend '%s' should be smtp, file or log", c.Email.Backend)
+	}
+	if !oneOf(strings.ToLower(c.Email.Security), "", "starttls", "tls", "none") {
+		e.addf("email.security '%s' should be starttls, tls or none", c.Email.Security)
+	}
+	if !oneOf(c.RateLimit.KeyBy, "", "user", "token", "ip") {
+		e.addf("rate_limit.key_by '%s' should be user, token or ip", c.RateLimit.KeyBy)
+	}
+	if !oneOf(strings.ToLower(c.Log.Level), "", "trace", "debug", "info", "warn", "warning", "error", "fatal", "panic") {
+		e.addf("log.level '%s' should be debug, info, warn or error", c.Log.Level)
+	}
+	if !oneOf(strings.ToLower(c.Log.Format), "", "text", "json") {
+		e.addf("log.format '%s' should be text or json", c.Log.Format)
+	}
+
+	if len(e.Problems) > 0 {
+		return e
+	}
+	return nil
+}
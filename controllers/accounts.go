@@ -0,0 +1,501 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"harbor/config"
+	"harbor/models"
+	"harbor/utils/mail"
+	"harbor/utils/signing"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	verifyEmailSalt   = "users.verify_email"
+	passwordResetSalt = "users.password_reset"
+)
+
+var (
+	mailSender     mail.Sender
+	mailSenderOnce sync.Once
+	mailSenderErr  error
+)
+
+// newMailSender return sender by config "email.backend"
+func newMailSender(c *config.Config) (mail.Sender, error) {
+
+	ec := c.Email
+	switch strings.ToLower(ec.Backend) {
+	case "smtp":
+		return &mail.SMTPSender{
+			Host:     ec.Host,
+			Port:     ec.Port,
+			Username: ec.Username,
+			Password: ec.Password,
+			Security: strings.ToLower(ec.Security),
+		}, nil
+	case "file":
+		dir := c.AbsPath(ec.FilePath)
+		if dir == "" {
+			dir = filepath.Join(c.BaseDir, "mails")
+		}
+		return &mail.FileSender{Dir: dir}, nil
+	case "":
+		// links with tokens in body are not logged
+		return &mail.LogSender{OmitBody: true}, nil
+	case "log":
+		return &mail.LogSender{}, nil
+	}
+	return nil, fmt.Errorf("unknown email backend '%s'", ec.Backend)
+}
+
+// SetMailSender replace the sender used to send email
+func SetMailSender(s mail.Sender) {
+
+	mailSenderOnce.Do(func() {})
+	mailSender = s
+	mailSenderErr = nil
+}
+
+// sendEmail render template "views/users/{name}" and send it to user
+func sendEmail(user *models.UserProfile, subject, name string, data gin.H) error {
+
+	mailSenderOnce.Do(func() {
+		mailSender, mailSenderErr = newMailSender(config.GetConfigs())
+	})
+	if mailSenderErr != nil {
+		return mailSenderErr
+	}
+
+	configs := config.GetConfigs()
+	t, err := template.ParseFiles(filepath.Join(configs.BaseDir, "views", "users", name))
+	if err != nil {
+		return err
+	}
+	buf := bytes.Buffer{}
+	if err := t.ExecuteTemplate(&buf, "users/"+name, data); err != nil {
+		return err
+	}
+
+	to := user.Email
+	if to == "" {
+		to = user.Username
+	}
+	from := configs.Email.From
+	if from == "" {
+		from = "EVHarbor <noreply@localhost>"
+	}
+	return mailSender.Send(&mail.Message{
+		From:    from,
+		To:      []string{to},
+		Subject: subject,
+		Body:    buf.String(),
+		HTML:    true,
+	})
+}
+
+// siteURL return "email.site_url", or the url of current request in debug mode;
+// site url should be configured, the Host header of request can be forged
+func siteURL(ctx *gin.Context) (string, error) {
+
+	configs := config.GetConfigs()
+	if configs.Email.SiteURL != "" {
+		return strings.TrimRight(configs.Email.SiteURL, "/"), nil
+	}
+	if !configs.Debug {
+		return "", errors.New("email config 'site_url' is required")
+	}
+	scheme := "http"
+	if ctx.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + ctx.Request.Host, nil
+}
+
+// MakeVerifyEmailToken return a signed expiring token to verify user's email
+func MakeVerifyEmailToken(user *models.UserProfile) string {
+
+	configs := config.GetConfigs()
+	expire := configs.Email.VerifyExpire
+	if expire <= 0 {
+		expire = 72 * time.Hour
+	}
+	value := strconv.FormatUint(uint64(user.ID), 10) + ":" + user.Email
+	return signing.NewSigner(configs.SecretKey, verifyEmailSalt).Sign([]byte(value), time.Now().Add(expire))
+}
+
+// CheckVerifyEmailToken return user of token, the email must not be changed after the token made,
+// and the user must not be verified, so that users deactivated can not be activated by a link
+func CheckVerifyEmailToken(token string) (*models.UserProfile, error) {
+
+	value, err := signing.NewSigner(config.GetConfigs().SecretKey, verifyEmailSalt).Unsign(token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	a := strings.SplitN(string(value), ":", 2)
+	if len(a) != 2 {
+		return nil, signing.ErrBadSignature
+	}
+	id, err := strconv.ParseUint(a[0], 10, 64)
+	if err != nil {
+		return nil, signing.ErrBadSignature
+	}
+	user, err := models.NewUserManager().GetUserByID(uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.Email != a[1] || user.EmailVerified {
+		return nil, signing.ErrBadSignature
+	}
+	return user, nil
+}
+
+// passwordResetState a value changes after password or last login changed, so reset token is used only once
+func passwordResetState(user *models.UserProfile) string {
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%d", user.ID, user.Password, user.LastLogin.Unix())))
+	return hex.EncodeToString(sum[:16])
+}
+
+// MakePasswordResetToken return a signed expiring token to reset user's password,
+// it is invalid after the password is changed
+func MakePasswordResetToken(user *models.UserProfile) string {
+
+	configs := config.GetConfigs()
+	expire := configs.Email.ResetExpire
+	if expire <= 0 {
+		expire = time.Hour
+	}
+	value := strconv.FormatUint(uint64(user.ID), 10) + ":" + passwordResetState(user)
+	return signing.NewSigner(configs.SecretKey, passwordResetSalt).Sign([]byte(value), time.Now().Add(expire))
+}
+
+// CheckPasswordResetToken return user of token
+func CheckPasswordResetToken(token string) (*models.UserProfile, error) {
+
+	value, err := signing.NewSigner(config.GetConfigs().SecretKey, passwordResetSalt).Unsign(token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	a := strings.SplitN(string(value), ":", 2)
+	if len(a) != 2 {
+		return nil, signing.ErrBadSignature
+	}
+	id, err := strconv.ParseUint(a[0], 10, 64)
+	if err != nil {
+		return nil, signing.ErrBadSignature
+	}
+	user, err := models.NewUserManager().GetUserByID(uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !user.IsActived() || passwordResetState(user) != a[1] {
+		return nil, errors.New("invalid or used token")
+	}
+	return user, nil
+}
+
+// SendVerifyEmail send email verification link to user
+func SendVerifyEmail(ctx *gin.Context, user *models.UserProfile) error {
+
+	site, err := siteURL(ctx)
+	if err != nil {
+		return err
+	}
+	link := site + "/user/verify-email/?token=" + url.QueryEscape(MakeVerifyEmailToken(user))
+	return sendEmail(user, "EVHarbor 邮箱验证", "email_verify.tmpl", gin.H{
+		"user": user,
+		"link": link,
+	})
+}
+
+// SendPasswordResetEmail send password reset link to user
+func SendPasswordResetEmail(ctx *gin.Context, user *models.UserProfile) error {
+
+	site, err := siteURL(ctx)
+	if err != nil {
+		return err
+	}
+	link := site + "/user/password-reset/?token=" + url.QueryEscape(MakePasswordResetToken(user))
+	return sendEmail(user, "EVHarbor 重置密码", "email_password_reset.tmpl", gin.H{
+		"user": user,
+		"link": link,
+	})
+}
+
+// UserVerifyEmail 邮箱验证链接
+// @Summary 邮箱验证
+// @Description 用户点击验证邮件中的链接，验证通过后激活用户；邮箱已验证的用户(包括被管理员停用的用户)链接无效
+// @Tags Register 注册
+// @Produce html
+// @Param   token query string true "token in the link"
+// @Success 200 {string} string "html"
+// @Router /user/verify-email/ [get]
+func UserVerifyEmail(ctx *gin.Context) {
+
+	user, err := CheckVerifyEmailToken(ctx.Query("token"))
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "users/message.tmpl", gin.H{
+			"title":   "邮箱验证失败",
+			"message": "验证链接无效或已过期，请重新发送验证邮件",
+		})
+		return
+	}
+
+	user.EmailVerified = true
+	user.IsActive = true
+	if err := models.NewUserManager().SaveUser(user); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "users/message.tmpl", gin.H{
+			"title":   "邮箱验证失败",
+			"message": err.Error(),
+		})
+		return
+	}
+	ctx.HTML(http.StatusOK, "users/message.tmpl", gin.H{
+		"title":   "邮箱验证成功",
+		"message": "您的账户已激活，现在可以登录了",
+	})
+}
+
+// UserPasswordReset 重置密码页面
+// @Summary 重置密码页面
+// @Description GET显示设置新密码表单，POST提交新密码；链接只能使用一次
+// @Tags Register 注册
+// @Accept  x-www-form-urlencoded
+// @Produce html
+// @Param   token query string true "token in the link"
+// @Success 200 {string} string "html"
+// @Router /user/password-reset/ [get]
+func UserPasswordReset(ctx *gin.Context) {
+
+	token := ctx.Query("token")
+	if token == "" {
+		token = ctx.PostForm("token")
+	}
+	user, err := CheckPasswordResetToken(token)
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "users/message.tmpl", gin.H{
+			"title":   "重置密码失败",
+			"message": "重置密码链接无效、已过期或已使用，请重新申请",
+		})
+		return
+	}
+
+	if strings.ToUpper(ctx.Request.Method) == "GET" {
+		ctx.HTML(http.StatusOK, "users/password_reset.tmpl", gin.H{"token": token})
+		return
+	}
+
+	form := PasswordResetConfirmForm{}
+	if err := ctx.ShouldBind(&form); err != nil || form.Password != ctx.PostForm("confirm_password") {
+		ctx.HTML(http.StatusBadRequest, "users/password_reset.tmpl", gin.H{
+			"token": token,
+			"error": "密码长度8-128位，且两次输入必须一致",
+		})
+		return
+	}
+	if err := resetPassword(user, form.Password); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "users/message.tmpl", gin.H{
+			"title":   "重置密码失败",
+			"message": err.Error(),
+		})
+		return
+	}
+	ctx.HTML(http.StatusOK, "users/message.tmpl", gin.H{
+		"title":   "重置密码成功",
+		"message": "密码已修改，请使用新密码登录",
+	})
+}
+
+// resetPassword set new password
+func resetPassword(user *models.UserProfile, password string) error {
+
+	user.SetPassword(password)
+	return models.NewUserManager().UpdatePassword(user)
+}
+
+// EmailForm username form
+type EmailForm struct {
+	Username string `json:"username" form:"username" binding:"required"`
+}
+
+// VerifyEmailController 重发验证邮件控制器结构
+type VerifyEmailController struct {
+	Controller
+}
+
+// NewVerifyEmailController new controller
+func NewVerifyEmailController() *VerifyEmailController {
+	return &VerifyEmailController{}
+}
+
+// Init 初始化this，子类要重写此方法
+func (ctl *VerifyEmailController) Init() ControllerInterface {
+
+	ctl.this = ctl
+	return ctl
+}
+
+// GetPermissions return permission
+func (ctl VerifyEmailController) GetPermissions(ctx *gin.Context) []PermissionFunc {
+
+	return []PermissionFunc{}
+}
+
+// Post controller
+// @Summary 重新发送邮箱验证邮件
+// @Description 用户邮箱未验证时发送验证邮件，被管理员停用的用户不能通过验证邮件重新激活；为防止探测用户是否存在，总是返回成功
+// @Tags Register 注册
+// @Accept  json
+// @Produce  json
+// @Param   data body controllers.EmailForm true "username"
+// @Success 200 {object} controllers.BaseJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Router /api/v1/verify-email/ [post]
+func (ctl VerifyEmailController) Post(ctx *gin.Context) {
+
+	form := EmailForm{}
+	if err := ctx.ShouldBind(&form); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+
+	user, err := models.NewUserManager().GetUserByName(form.Username)
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	if user != nil && !user.EmailVerified {
+		if err := SendVerifyEmail(ctx, user); err != nil {
+			ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+			return
+		}
+	}
+	ctx.JSON(200, BaseJSONResponse(200, "如果账户存在且邮箱未验证，验证邮件已发送"))
+}
+
+// PasswordResetController 忘记密码控制器结构
+type PasswordResetController struct {
+	Controller
+}
+
+// NewPasswordResetController new controller
+func NewPasswordResetController() *PasswordResetController {
+	return &PasswordResetController{}
+}
+
+// Init 初始化this，子类要重写此方法
+func (ctl *PasswordResetController) Init() ControllerInterface {
+
+	ctl.this = ctl
+	return ctl
+}
+
+// GetPermissions return permission
+func (ctl PasswordResetController) GetPermissions(ctx *gin.Context) []PermissionFunc {
+
+	return []PermissionFunc{}
+}
+
+// Post controller
+// @Summary 忘记密码，发送重置密码邮件
+// @Description 向已激活用户的邮箱发送重置密码链接，链接有效期内只能使用一次；为防止探测用户是否存在，总是返回成功
+// @Tags Register 注册
+// @Accept  json
+// @Produce  json
+// @Param   data body controllers.EmailForm true "username"
+// @Success 200 {object} controllers.BaseJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Router /api/v1/password-reset/ [post]
+func (ctl PasswordResetController) Post(ctx *gin.Context) {
+
+	form := EmailForm{}
+	if err := ctx.ShouldBind(&form); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+
+	user, err := models.NewUserManager().GetUserByName(form.Username)
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	if user != nil && user.IsActive {
+		if err := SendPasswordResetEmail(ctx, user); err != nil {
+			ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+			return
+		}
+	}
+	ctx.JSON(200, BaseJSONResponse(200, "如果账户存在，重置密码邮件已发送"))
+}
+
+// PasswordResetConfirmForm new password form
+type PasswordResetConfirmForm struct {
+	Token    string `json:"token" form:"token" binding:"required"`
+	Password string `json:"password" form:"password" binding:"min=8,max=128,required"`
+}
+
+// PasswordResetConfirmController 重置密码控制器结构
+type PasswordResetConfirmController struct {
+	Controller
+}
+
+// NewPasswordResetConfirmController new controller
+func NewPasswordResetConfirmController() *PasswordResetConfirmController {
+	return &PasswordResetConfirmController{}
+}
+
+// Init 初始化this，子类要重写此方法
+func (ctl *PasswordResetConfirmController) Init() ControllerInterface {
+
+	ctl.this = ctl
+	return ctl
+}
+
+// GetPermissions return permission
+func (ctl PasswordResetConfirmController) GetPermissions(ctx *gin.Context) []PermissionFunc {
+
+	return []PermissionFunc{}
+}
+
+// Post controller
+// @Summary 重置密码
+// @Description 提交重置密码邮件中的token和新密码，token只能使用一次
+// @Tags Register 注册
+// @Accept  json
+// @Produce  json
+// @Param   data body controllers.PasswordResetConfirmForm true "token and new password"
+// @Success 200 {object} controllers.BaseJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Router /api/v1/password-reset/confirm/ [post]
+func (ctl PasswordResetConfirmController) Post(ctx *gin.Context) {
+
+	form := PasswordResetConfirmForm{}
+	if err := ctx.ShouldBind(&form); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+
+	user, err := CheckPasswordResetToken(form.Token)
+	if err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+	if err := resetPassword(user, form.Password); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, BaseJSONResponse(200, "ok"))
+}
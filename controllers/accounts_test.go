@@ -0,0 +1,103 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"harbor/controllers"
+	"harbor/internal/testdb"
+	"harbor/models"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func verifyEmailRouter() *gin.Engine {
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.SetHTMLTemplate(template.Must(template.New("users/message.tmpl").Parse("{{.title}}")))
+	r.GET("/user/verify-email/", controllers.UserVerifyEmail)
+	r.POST("/api/v1/verify-email/", controllers.NewVerifyEmailController().Post)
+	return r
+}
+
+func request(r *gin.Engine, method, target, body string) int {
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestVerifyEmail(t *testing.T) {
+
+	testdb.Setup(t)
+	r := verifyEmailRouter()
+	um := models.NewUserManager()
+	user := models.NewUserProfile()
+	user.Username, user.Email = "verify@example.com", "verify@example.com"
+	if err := um.SaveUser(user); err != nil {
+		t.Fatal(err)
+	}
+	link := "/user/verify-email/?token=" + url.QueryEscape(controllers.MakeVerifyEmailToken(user))
+
+	// verification email is sent to user not verified, it fails as email is not configured
+	if code := request(r, "POST", "/api/v1/verify-email/", `{"username": "verify@example.com"}`); code != 500 {
+		t.Errorf("got status %d, verification email should be sent", code)
+	}
+	if code := request(r, "GET", link, ""); code != http.StatusOK {
+		t.Fatalf("got status %d, user should be verified", code)
+	}
+	got, _ := um.GetUserByID(user.ID)
+	if !got.IsActive || !got.EmailVerified {
+		t.Fatal("verified user should be active")
+	}
+
+	// deactivated by admin
+	got.IsActive = false
+	if err := um.SaveUser(got); err != nil {
+		t.Fatal(err)
+	}
+	if code := request(r, "POST", "/api/v1/verify-email/", `{"username": "verify@example.com"}`); code != 200 {
+		t.Errorf("got status %d, verification email should not be sent to verified user", code)
+	}
+	if code := request(r, "GET", link, ""); code != http.StatusBadRequest {
+		t.Errorf("got status %d, link should be invalid after verified", code)
+	}
+	if got, _ = um.GetUserByID(user.ID); got.IsActive {
+		t.Error("deactivated user should not be activated by email verification")
+	}
+}
+
+func TestCreateUserEmailFailed(t *testing.T) {
+
+	testdb.Setup(t)
+	r := verifyEmailRouter()
+	r.POST("/api/v1/users/", controllers.NewUserController().Post)
+
+	// user is created though email is not configured
+	req := httptest.NewRequest("POST", "/api/v1/users/", strings.NewReader(`{"username": "new@example.com", "password": "password1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var body struct {
+		EmailSent *bool `json:"email_sent"`
+	}
+	if w.Code != 201 || json.Unmarshal(w.Body.Bytes(), &body) != nil || body.EmailSent == nil || *body.EmailSent {
+		t.Fatalf("got status %d, body %s, email should be reported not sent", w.Code, w.Body)
+	}
+	user, err := models.NewUserManager().GetUserByName("new@example.com")
+	if err != nil || user == nil || user.IsActive {
+		t.Fatalf("inactive user should be created, got %v, %v", user, err)
+	}
+
+	// created again before verified
+	if code := request(r, "POST", "/api/v1/users/", `{"username": "new@example.com", "password": "password2"}`); code != 201 {
+		t.Errorf("got status %d, user not verified should be overwritten", code)
+	}
+}
@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"encoding/json"
+	"harbor/config"
+	"harbor/middlewares"
+	"harbor/models"
+	"harbor/utils/paginations"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditEventKey context key of audit event of request
+const auditEventKey = "audit_event"
+
+// audit set the audit event of request, the event is saved by Dispatch after the handler returned,
+// the result is decided by response status code
+func audit(ctx *gin.Context, action, targetType, target string) *models.AuditEvent {
+
+	e := models.NewAuditEvent(action, targetType, target)
+	ctx.Set(auditEventKey, e)
+	return e
+}
+
+// auditObjectTarget return audit target of object or directory
+func auditObjectTarget(bucketName, path string) string {
+
+	return bucketName + "/" + ClearPath(path)
+}
+
+var auditFileMu sync.Mutex
+
+// saveAuditEvent complete and save the audit event of request if it is set
+func saveAuditEvent(ctx *gin.Context, user *models.UserProfile) {
+
+	v, ok := ctx.Get(auditEventKey)
+	if !ok {
+		return
+	}
+	e, ok := v.(*models.AuditEvent)
+	if !ok {
+		return
+	}
+	configs := config.GetConfigs()
+	if configs.Audit.Disabled {
+		return
+	}
+
+	if e.ActorID == 0 && user != nil {
+		e.SetActor(user)
+	}
+	e.SourceIP = middlewares.ClientIP(ctx)
+	e.RequestID = middlewares.GetRequestID(ctx)
+	e.StatusCode = ctx.Writer.Status()
+	e.Result = models.AuditResultSuccess
+	if e.StatusCode >= 400 {
+		e.Result = models.AuditResultFailure
+	}
+
+	// the request is done, failure of audit log can only be logged
+	if err := models.NewAuditManager().CreateEvent(e); err != nil {
+		middlewares.GetLogger(ctx).WithError(err).Error("audit: save event failed")
+	}
+	if configs.Audit.File != "" {
+		if err := appendAuditFile(configs.AbsPath(configs.Audit.File), e); err != nil {
+			middlewares.GetLogger(ctx).WithError(err).Error("audit: write file failed")
+		}
+	}
+}
+
+// appendAuditFile append event to file in JSON lines
+func appendAuditFile(path string, e *models.AuditEvent) error {
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	auditFileMu.Lock()
+	defer auditFileMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// AuditEventController 审计日志控制器结构
+type AuditEventController struct {
+	Controller
+}
+
+// NewAuditEventController new controller
+func NewAuditEventController() *AuditEventController {
+	return &AuditEventController{}
+}
+
+// Init 初始化this，子类要重写此方法
+func (ctl *AuditEventController) Init() ControllerInterface {
+
+	ctl.this = ctl
+	return ctl
+}
+
+// GetPermissions return permission
+func (ctl AuditEventController) GetPermissions(ctx *gin.Context) []PermissionFunc {
+
+	return []PermissionFunc{IsSuperUser}
+}
+
+// AuditEventListJSON audit events list
+type AuditEventListJSON struct {
+	BaseJSON
+	Count   uint                `json:"count"`
+	Next    string              `json:"next"`
+	Privous string              `json:"previous"`
+	Results []models.AuditEvent `json:"results"`
+}
+
+// parseAuditTime parse time in RFC3339 or "2006-01-02 15:04:05"(local time)
+func parseAuditTime(s string) (time.Time, error) {
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+}
+
+// Get handler for get method
+// @Summary 查询审计日志
+// @Description 查询谁在何时对哪个对象、目录、存储桶、用户或token做了什么操作，需要超级用户权限；
+// @Description 通过query参数“offset”和“limit”分页，结果按时间倒序；
+// @Description action以“.”结尾时按前缀过滤，例如“object.”；target按前缀过滤，例如“bucketname/dir/”
+// @Tags audit 审计日志
+// @Produce json
+// @Param   actor       query string false "username"
+// @Param   actor_id    query int    false "user id"
+// @Param   action      query string false "e.g. object.delete, object."
+// @Param   target_type query string false "object, dir, bucket, user, token"
+// @Param   target      query string false "target prefix"
+// @Param   ip          query string false "source ip"
+// @Param   result      query string false "success or failure"
+// @Param   request_id  query string false "request id"
+// @Param   since       query string false "RFC3339 time or '2006-01-02 15:04:05'"
+// @Param   until       query string false "RFC3339 time or '2006-01-02 15:04:05'"
+// @Param   offset      query int    false "The initial index from which to return the results"
+// @Param   limit       query int    false "Number of results to return per page"
+// @Success 200 {object} controllers.AuditEventListJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Failure 403 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/audit-events/ [get]
+func (ctl AuditEventController) Get(ctx *gin.Context) {
+
+	filter := models.AuditEventFilter{
+		Actor:        ctx.Query("actor"),
+		Action:       ctx.Query("action"),
+		TargetType:   ctx.Query("target_type"),
+		TargetPrefix: ctx.Query("target"),
+		SourceIP:     ctx.Query("ip"),
+		Result:       ctx.Query("result"),
+		RequestID:    ctx.Query("request_id"),
+	}
+	if s := ctx.Query("actor_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			ctx.JSON(400, BaseJSONResponse(400, "invalid query param actor_id"))
+			return
+		}
+		filter.ActorID = uint(id)
+	}
+	for key, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if s := ctx.Query(key); s != "" {
+			v, err := parseAuditTime(s)
+			if err != nil {
+				ctx.JSON(400, BaseJSONResponse(400, "invalid query param "+key))
+				return
+			}
+			*t = v
+		}
+	}
+
+	paginater := paginations.NewOptimizedLimitOffsetPagination()
+	if err := paginater.PrePaginate(ctx); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+	var events []models.AuditEvent
+	dbQuery := models.NewAuditManager().GetEventsQuery(filter)
+	if err := paginater.PaginateDBQuery(&events, dbQuery); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+
+	ctx.JSON(200, AuditEventListJSON{
+		BaseJSON: *BaseJSONResponse(200, "ok"),
+		Count:    uint(paginater.GetCount()),
+		Results:  events,
+		Next:     paginater.GetNextURL(),
+		Privous:  paginater.GetPreviousURL(),
+	})
+}
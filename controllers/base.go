@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"fmt"
+	"harbor/middlewares"
 	"harbor/models"
+	"harbor/utils/metrics"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -118,9 +120,11 @@ func (ctl Controller) HasPermission(ctx *gin.Context) bool {
 func (ctl *Controller) Dispatch(ctx *gin.Context) {
 	if ctl.this == nil {
 		ctl.Init()
-		fmt.Println("You must overrite Init method.")
+		middlewares.GetLogger(ctx).Warnf("%T: You must overrite Init method.", ctl)
 	}
 
+	ctx.Set(metrics.HandlerKey, strings.TrimPrefix(fmt.Sprintf("%T", ctl.this), "*"))
+
 	// try to get user
 	ctl.user = AuthUserOrNil(ctx)
 
@@ -155,6 +159,7 @@ func (ctl *Controller) Dispatch(ctx *gin.Context) {
 	default:
 		MethodNotAllowedJSON(ctx)
 	}
+	saveAuditEvent(ctx, ctl.user)
 }
 
 func (ctl *Controller) buildAbsoluteURI(ctx *gin.Context, path string, querys map[string]string) string {
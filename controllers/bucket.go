@@ -3,8 +3,11 @@ package controllers
 import (
 	"errors"
 	"fmt"
+	"harbor/middlewares"
 	"harbor/models"
 	"harbor/utils/paginations"
+	"harbor/utils/storages/encrypt"
+	"harbor/utils/webhook"
 	"regexp"
 	"strconv"
 	"strings"
@@ -73,7 +76,7 @@ func (ctl BucketController) Get(ctx *gin.Context) {
 		return
 	}
 	var buckets = make([]models.Bucket, 0)
-	bManager := models.NewBucketManager("", user)
+	bManager := models.NewBucketManager("", user).WithLogger(middlewares.GetLogger(ctx))
 	dbQuery := bManager.GetUserBucketsQuery()
 	if err := paginater.PaginateDBQuery(&buckets, dbQuery); err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -98,7 +101,9 @@ func (ctl BucketController) Get(ctx *gin.Context) {
 
 // BucketPostForm create bucket post form struct
 type BucketPostForm struct {
-	Name string `json:"name" form:"name" binding:"required"`
+	Name        string `json:"name" form:"name" binding:"required"`
+	Encryption  string `json:"encryption" form:"encryption"`   // "AES256"加密新对象的数据，默认不加密
+	Compression string `json:"compression" form:"compression"` // "gzip"压缩新对象的数据，默认不压缩，不能和加密同时设置
 }
 
 func (f *BucketPostForm) isValid(ctx *gin.Context) error {
@@ -106,7 +111,59 @@ func (f *BucketPostForm) isValid(ctx *gin.Context) error {
 	if err := ctx.ShouldBind(f); err != nil {
 		return err
 	}
-	return f.validate()
+	if err := f.validate(); err != nil {
+		return err
+	}
+	enc, err := parseBucketEncryption(f.Encryption)
+	if err != nil {
+		return err
+	}
+	f.Encryption = enc
+	cmp, err := parseBucketCompression(f.Compression)
+	if err != nil {
+		return err
+	}
+	f.Compression = cmp
+	if enc != "" && cmp != "" {
+		return errBucketCompressionEncryption
+	}
+	return nil
+}
+
+// errBucketCompressionEncryption returned if both compression and encryption of bucket are set, encrypted data is not compressed
+var errBucketCompressionEncryption = errors.New("compression and encryption of bucket can not be both set")
+
+// parseBucketCompression return compression of bucket, "gzip" or "" for none
+func parseBucketCompression(s string) (string, error) {
+
+	switch strings.ToLower(s) {
+	case "", "none":
+		return "", nil
+	case models.CompressionGzip:
+		return models.CompressionGzip, nil
+	default:
+		return "", errors.New("compression should be gzip or none")
+	}
+}
+
+// parseBucketEncryption return encryption of bucket, "AES256" or "" for none
+func parseBucketEncryption(s string) (string, error) {
+
+	switch strings.ToUpper(s) {
+	case "", "NONE":
+		return "", nil
+	case models.EncryptionAES256:
+		keyring, err := encrypt.ConfiguredKeyring()
+		if err != nil {
+			return "", err
+		}
+		if !keyring.Enabled() {
+			return "", errors.New("encryption is not available, no encryption key is configured")
+		}
+		return models.EncryptionAES256, nil
+	default:
+		return "", errors.New("encryption should be AES256 or none")
+	}
 }
 
 func (f *BucketPostForm) validate() error {
@@ -154,6 +211,8 @@ type bucketPostJSON struct {
 // Post controller
 // @Summary 创建存储桶
 // @Description 存储桶名称只能由字母、数字和“-”组成，且不能以“-”开头和结尾，长度3-64字符，符合DNS标准。
+// @Description encryption为AES256时，存储桶中新对象的数据加密存储，需要服务器配置了加密主密钥。
+// @Description compression为gzip时，存储桶中新对象的数据分块压缩存储，不能和encryption同时设置。
 // @Tags Bucket 存储桶
 // @Accept  json
 // @Produce  json
@@ -182,7 +241,7 @@ func (ctl BucketController) Post(ctx *gin.Context) {
 		return
 	}
 
-	bManager := models.NewBucketManager(bucketName, user)
+	bManager := models.NewBucketManager(bucketName, user).WithLogger(middlewares.GetLogger(ctx))
 	bucket, err := bManager.GetBucket()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -203,6 +262,22 @@ func (ctl BucketController) Post(ctx *gin.Context) {
 		ctx.JSON(500, BaseJSONResponse(500, s))
 		return
 	}
+	if form.Encryption != "" {
+		if err := bManager.SetBucketEncryption(bucket, form.Encryption); err != nil {
+			bManager.DeleteBucket(bucket)
+			s := fmt.Sprintf("Create bucket error:'%s'", err.Error())
+			ctx.JSON(500, BaseJSONResponse(500, s))
+			return
+		}
+	}
+	if form.Compression != "" {
+		if err := bManager.SetBucketCompression(bucket, form.Compression); err != nil {
+			bManager.DeleteBucket(bucket)
+			s := fmt.Sprintf("Create bucket error:'%s'", err.Error())
+			ctx.JSON(500, BaseJSONResponse(500, s))
+			return
+		}
+	}
 	if err := bManager.CreateObjsTable(bucket); err != nil {
 		bManager.DeleteBucket(bucket)
 		s := fmt.Sprintf("Create bucket error:'%s'", err.Error())
@@ -316,7 +391,7 @@ func (ctl BucketDetailController) Get(ctx *gin.Context) {
 		return
 	}
 
-	bManager := models.NewBucketManager("", user)
+	bManager := models.NewBucketManager("", user).WithLogger(middlewares.GetLogger(ctx))
 	bucket, err := bManager.GetUserBucketByID(id)
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -348,6 +423,7 @@ func (ctl BucketDetailController) Get(ctx *gin.Context) {
 // @Router /api/v1/buckets/{id}/ [delete]
 func (ctl BucketDetailController) Delete(ctx *gin.Context) {
 
+	ae := audit(ctx, "bucket.delete", "bucket", ctx.Param("id"))
 	form := bucketIdsPramsStruct{}
 	if err := form.isValid(ctx); err != nil {
 		bj := BaseJSONResponse(400, err.Error())
@@ -364,19 +440,30 @@ func (ctl BucketDetailController) Delete(ctx *gin.Context) {
 	}
 
 	ids := form.IDs
-	bManager := models.NewBucketManager("", user)
+	ae.AddDetail("ids", ids)
+	bManager := models.NewBucketManager("", user).WithLogger(middlewares.GetLogger(ctx))
+	var buckets []*models.Bucket
+	if err := bManager.GetUserBucketsQuery().Where("id IN (?)", ids).Find(&buckets).Error; err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
 	if err := bManager.SoftDeleteUserBucketsByIDs(ids); err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
 		return
 	}
+	for _, b := range buckets {
+		notifyWebhooks(b, webhook.EventBucketDeleted, "", user, nil)
+	}
 
 	ctx.JSON(204, nil)
 }
 
 type bucketPatchJSON struct {
 	BaseJSON
-	Public bool   `json:"public,omitempty"`
-	Rename string `json:"rename,omitempty"`
+	Public      bool    `json:"public,omitempty"`
+	Rename      string  `json:"rename,omitempty"`
+	Encryption  *string `json:"encryption,omitempty"`
+	Compression *string `json:"compression,omitempty"`
 }
 
 // Patch controller
@@ -385,6 +472,8 @@ type bucketPatchJSON struct {
 // @Description	#重命名存储桶，提交query参数“rename”,其值为新名称;
 // @Description	#可以一次设置多个存储桶访问权限，其余存储桶id通过form ids传递, 重命名时ids无效。
 // @Description	#同时提交“public”和“rename”参数,忽略“rename”参数
+// @Description	#设置存储桶加密，提交query参数“encryption”, AES256(加密)，none(不加密)，只影响之后新上传的对象，已有对象保持原有的加密方式;
+// @Description	#设置存储桶压缩，提交query参数“compression”, gzip(压缩)，none(不压缩)，只影响之后新上传的对象，加密的对象不压缩，压缩和加密不能同时设置;
 // @Tags Bucket 存储桶
 // @Accept  json
 // @Produce  json
@@ -392,6 +481,8 @@ type bucketPatchJSON struct {
 // @Param   public query bool false "设置对象公有或私有, true(公有)，false(私有)"
 // @Param   rename query string false "重命名桶,值为存储桶新名称"
 // @Param   ids query []string false "bucket id array,一次设置多个桶的权限时使用，命重名桶时无效"
+// @Param   encryption query string false "新对象的加密方式, AES256或none"
+// @Param   compression query string false "新对象的压缩方式, gzip或none"
 // @Success 200 {object} controllers.bucketPatchJSON
 // @Failure 400 {object} controllers.BaseJSON
 // @Failure 404 {object} controllers.BaseJSON
@@ -411,12 +502,113 @@ func (ctl BucketDetailController) Patch(ctx *gin.Context) {
 		return
 	}
 
+	if enc, exists := ctx.GetQuery("encryption"); exists {
+		ctl.patchEncryption(ctx, enc)
+		return
+	}
+
+	if cmp, exists := ctx.GetQuery("compression"); exists {
+		ctl.patchCompression(ctx, cmp)
+		return
+	}
+
 	ctx.JSON(400, BaseJSONResponse(400, "invalid request"))
 	return
 }
 
+func (ctl BucketDetailController) patchEncryption(ctx *gin.Context, enc string) {
+
+	ae := audit(ctx, "bucket.set_encryption", "bucket", ctx.Param("id")).AddDetail("encryption", enc)
+	enc, err := parseBucketEncryption(enc)
+	if err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, "invalid id"))
+		return
+	}
+	user := AuthUserOrAbort(ctx)
+	if user == nil {
+		return
+	}
+
+	bManager := models.NewBucketManager("", user).WithLogger(middlewares.GetLogger(ctx))
+	bucket, err := bManager.GetUserBucketByID(id)
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	} else if bucket == nil {
+		ctx.JSON(404, BaseJSONResponse(404, "bucket is not found"))
+		return
+	}
+	ae.AddDetail("name", bucket.Name)
+	if enc != "" && bucket.Compression != "" {
+		ctx.JSON(400, BaseJSONResponse(400, errBucketCompressionEncryption.Error()))
+		return
+	}
+
+	if err := bManager.SetBucketEncryption(bucket, enc); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+
+	ctx.JSON(200, &bucketPatchJSON{
+		BaseJSON:   *BaseJSONResponse(200, "success to set bucket encryption"),
+		Encryption: &enc,
+	})
+}
+
+func (ctl BucketDetailController) patchCompression(ctx *gin.Context, cmp string) {
+
+	ae := audit(ctx, "bucket.set_compression", "bucket", ctx.Param("id")).AddDetail("compression", cmp)
+	cmp, err := parseBucketCompression(cmp)
+	if err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, "invalid id"))
+		return
+	}
+	user := AuthUserOrAbort(ctx)
+	if user == nil {
+		return
+	}
+
+	bManager := models.NewBucketManager("", user).WithLogger(middlewares.GetLogger(ctx))
+	bucket, err := bManager.GetUserBucketByID(id)
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	} else if bucket == nil {
+		ctx.JSON(404, BaseJSONResponse(404, "bucket is not found"))
+		return
+	}
+	ae.AddDetail("name", bucket.Name)
+	if cmp != "" && bucket.Encryption != "" {
+		ctx.JSON(400, BaseJSONResponse(400, errBucketCompressionEncryption.Error()))
+		return
+	}
+
+	if err := bManager.SetBucketCompression(bucket, cmp); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+
+	ctx.JSON(200, &bucketPatchJSON{
+		BaseJSON:    *BaseJSONResponse(200, "success to set bucket compression"),
+		Compression: &cmp,
+	})
+}
+
 func (ctl BucketDetailController) patchRename(ctx *gin.Context, rename string) {
 
+	ae := audit(ctx, "bucket.rename", "bucket", ctx.Param("id")).AddDetail("rename", rename)
 	form := BucketPostForm{Name: rename}
 	if err := form.validate(); err != nil {
 		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
@@ -437,7 +629,7 @@ func (ctl BucketDetailController) patchRename(ctx *gin.Context, rename string) {
 		return
 	}
 
-	bManager := models.NewBucketManager("", user)
+	bManager := models.NewBucketManager("", user).WithLogger(middlewares.GetLogger(ctx))
 	bucket, err := bManager.GetUserBucketByID(id)
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -446,6 +638,7 @@ func (ctl BucketDetailController) patchRename(ctx *gin.Context, rename string) {
 		ctx.JSON(404, BaseJSONResponse(404, "bucket is not found"))
 		return
 	}
+	ae.AddDetail("name", bucket.Name)
 
 	//check new bucket name exists
 	if b, err := bManager.GetBucketByName(rename); err != nil {
@@ -470,6 +663,7 @@ func (ctl BucketDetailController) patchRename(ctx *gin.Context, rename string) {
 
 func (ctl BucketDetailController) patchPublic(ctx *gin.Context, pub string) {
 
+	ae := audit(ctx, "bucket.set_public", "bucket", ctx.Param("id"))
 	var public bool
 	if pub == "true" {
 		public = true
@@ -485,12 +679,13 @@ func (ctl BucketDetailController) patchPublic(ctx *gin.Context, pub string) {
 	}
 
 	ids := form.IDs
+	ae.AddDetail("public", public).AddDetail("ids", ids)
 	user := AuthUserOrAbort(ctx)
 	if user == nil {
 		return
 	}
 
-	bManager := models.NewBucketManager("", user)
+	bManager := models.NewBucketManager("", user).WithLogger(middlewares.GetLogger(ctx))
 	if err := bManager.SetUserBucketsAccessByIDs(ids, public); err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
 		return
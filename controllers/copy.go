@@ -0,0 +1,297 @@
+package controllers
+
+import (
+	"harbor/middlewares"
+	"harbor/models"
+	"harbor/utils/storages"
+	"harbor/utils/webhook"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CopyController 对象复制控制器
+type CopyController struct {
+	Controller
+}
+
+// NewCopyController new controller
+func NewCopyController() *CopyController {
+	return &CopyController{}
+}
+
+// Init 初始化this，子类要重写此方法
+func (ctl *CopyController) Init() ControllerInterface {
+
+	ctl.this = ctl
+	return ctl
+}
+
+// GetPermissions return permission
+func (ctl CopyController) GetPermissions(ctx *gin.Context) []PermissionFunc {
+
+	method := strings.ToUpper(ctx.Request.Method)
+	switch method {
+	case "POST":
+		return []PermissionFunc{IsAuthenticatedUser}
+	default:
+		return []PermissionFunc{}
+	}
+}
+
+// ObjCopyJSON copied object json struct
+type ObjCopyJSON struct {
+	BaseJSON
+	BucketName string               `json:"bucket_name"`
+	Obj        *models.HarborObject `json:"obj"`
+	Shared     bool                 `json:"shared"` // the new object shares deduplicated data of the source object
+}
+
+// Post handler for post method
+// @Summary 对象复制
+// @Description 复制一个对象到同一个或另一个存储桶；参数to_path指定新对象的全路径，所在目录必须已存在，已存在同名的对象或目录时失败；
+// @Description 参数to_bucket指定目标存储桶，默认为源对象所在的桶
+// @Description ## 去重：
+// @Description 源对象的数据已去重时，新对象共享相同的数据，不占用额外的存储；开启数据去重时，不小于dedup.min_size且超过dedup.delay未修改的源对象先去重再复制；
+// @Description 其他情况复制数据，新对象按目标存储桶的加密和压缩设置存储
+// @Description ## 加密：
+// @Description 源对象使用客户提供的密钥加密时需要提交X-Harbor-SSE-Customer-*标头，新对象使用相同的密钥加密
+// @Tags copy复制
+// @Produce json
+// @Param   bucketname 	path string true "bucketname"
+// @Param   objpath 	path string true "objpath"
+// @Param   to_path 	query string true "path of new object"
+// @Param   to_bucket 	query string false "bucket of new object"
+// @Param   X-Harbor-SSE-Customer-Algorithm header string false "源对象使用客户提供的密钥加密时提交，值为AES256"
+// @Param   X-Harbor-SSE-Customer-Key header string false "客户提供的密钥，32字节的base64编码"
+// @Success 201 {object} controllers.ObjCopyJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Failure 403 {object} controllers.BaseJSON
+// @Failure 404 {object} controllers.BaseJSON
+// @Failure 500 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/copy/{bucketname}/{objpath} [post]
+func (ctl CopyController) Post(ctx *gin.Context) {
+
+	objPath := ClearPath(ctx.Param("objpath"))
+	dirPath, objName := SplitPathAndFilename(objPath)
+	if objName == "" {
+		ctx.JSON(400, BaseJSONResponse(400, "objpath is invalid"))
+		return
+	}
+	toPath := ClearPath(ctx.Query("to_path"))
+	toDir, toName := SplitPathAndFilename(toPath)
+	if toName == "" {
+		ctx.JSON(400, BaseJSONResponse(400, "to_path is invalid"))
+		return
+	}
+	if len(toName) > 255 {
+		ctx.JSON(400, BaseJSONResponse(400, "对象名称不能大于255个字符长度"))
+		return
+	}
+	bucketName := ctx.Param("bucketname")
+	toBucketName := ctx.DefaultQuery("to_bucket", bucketName)
+	ae := audit(ctx, "object.copy", "object", auditObjectTarget(bucketName, objPath))
+	ae.AddDetail("to_bucket", toBucketName).AddDetail("to_path", toPath)
+
+	custKey, err := customerKey(ctx)
+	if err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+
+	// buckets
+	bucket := ctl.getUserBucketOrResponse(ctx, bucketName)
+	if bucket == nil {
+		return
+	}
+	toBucket := bucket
+	if toBucketName != bucketName {
+		if toBucket = ctl.getUserBucketOrResponse(ctx, toBucketName); toBucket == nil {
+			return
+		}
+	}
+
+	// source object
+	manager := models.NewHarborObjectManager(bucket.GetObjsTableName(), dirPath, objName).WithLogger(middlewares.GetLogger(ctx))
+	src, err := manager.GetObjExists()
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	} else if src == nil {
+		ctx.JSON(404, BaseJSONResponse(404, "object not found"))
+		return
+	}
+
+	// target
+	toManager := models.NewHarborObjectManager(toBucket.GetObjsTableName(), toDir, toName).WithLogger(middlewares.GetLogger(ctx))
+	dir, err := toManager.GetCurDir()
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	} else if dir == nil {
+		ctx.JSON(400, BaseJSONResponse(400, "目标目录不存在"))
+		return
+	}
+	target, err := toManager.GetObjOrDirByDidName(dir.ID, toName)
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	} else if target != nil {
+		ctx.JSON(400, BaseJSONResponse(400, "无法完成对象的复制操作，指定的目标路径下已存在同名的对象或目录"))
+		return
+	}
+
+	var obj *models.HarborObject
+	shared := false
+	if ctl.canShare(ctx, bucket, toBucket, src, custKey) {
+		if obj, err = ctl.copyShared(ctx, toManager, src, dir.ID); err != nil {
+			ctx.JSON(500, BaseJSONResponse(500, "复制对象时发生错误:"+err.Error()))
+			return
+		}
+		shared = obj != nil
+	}
+	if obj == nil {
+		if obj = ctl.copyData(ctx, bucket, toBucket, toManager, src, dir.ID, custKey); obj == nil {
+			return
+		}
+	}
+
+	notifyWebhooks(toBucket, webhook.EventObjectCreated, obj.PathName, ctl.user,
+		map[string]interface{}{"size": obj.Size, "copied_from": auditObjectTarget(bucket.Name, src.PathName)})
+	dPath := URLPathJoin([]string{"obs", toBucket.Name, obj.PathName})
+	obj.DownloadURL = ctl.buildAbsoluteURI(ctx, dPath, nil)
+	ctx.JSON(201, &ObjCopyJSON{
+		BaseJSON:   *BaseJSONResponse(201, "复制对象成功"),
+		BucketName: toBucket.Name,
+		Obj:        obj,
+		Shared:     shared,
+	})
+}
+
+// canShare return true if the new object can share the data of src: data of src is deduplicated, or it is deduplicated
+// now by the rules of deduplicator, and data of the new object is not encrypted
+func (ctl CopyController) canShare(ctx *gin.Context, bucket, toBucket *models.Bucket, src *models.HarborObject, custKey []byte) bool {
+
+	if toBucket.Encryption != "" || custKey != nil || src.IsEncrypted() {
+		return false
+	}
+	if src.IsDeduplicated() {
+		return true
+	}
+	if deduplicator == nil {
+		return false
+	}
+	log := middlewares.GetLogger(ctx)
+	if _, err := deduplicator.Object(bucket, src, log); err != nil {
+		log.WithError(err).Warn("deduplicate object failed, its data is copied")
+	}
+	return src.IsDeduplicated()
+}
+
+// copyShared create object referencing the blob of deduplicated src in dir did,
+// nil is returned if the blob is being released
+func (ctl CopyController) copyShared(ctx *gin.Context, manager *models.HarborObjectManager, src *models.HarborObject, did uint64) (*models.HarborObject, error) {
+
+	bm := models.NewBlobManager()
+	blob := &models.Blob{ID: src.BlobID}
+	if ok, err := bm.AddBlobRef(blob); err != nil || !ok {
+		return nil, err
+	}
+
+	manager.BeginTransaction()
+	obj, err := manager.CreatObject()
+	if err == nil {
+		obj.ParentID = did
+		obj.Size = src.Size
+		obj.Compression = src.Compression
+		obj.StoredSize = src.StoredSize
+		obj.BlobID = src.BlobID
+		err = manager.SaveObject(obj)
+	}
+	if err == nil {
+		err = manager.CommitTransaction()
+	}
+	if err != nil {
+		manager.RollbackTransaction()
+		if err := bm.ReleaseBlob(blob.ID); err != nil {
+			middlewares.GetLogger(ctx).WithError(err).WithField("blob", blob.ID).Error("release blob failed")
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+// copyData create object in dir did with data copied from src, encrypted and compressed by settings of toBucket;
+// response is sent and nil returned on error
+func (ctl CopyController) copyData(ctx *gin.Context, bucket, toBucket *models.Bucket, manager *models.HarborObjectManager,
+	src *models.HarborObject, did uint64, custKey []byte) *models.HarborObject {
+
+	srcIO := objectIOOrResponse(ctx, bucket, src)
+	if srcIO == nil {
+		return nil
+	}
+	defer srcIO.Close()
+
+	manager.BeginTransaction()
+	obj, err := manager.CreatObject()
+	if err != nil {
+		manager.RollbackTransaction()
+		ctx.JSON(500, BaseJSONResponse(500, "复制对象时发生错误:"+err.Error()))
+		return nil
+	}
+	dataKey, status, err := newObjectDataKey(toBucket, obj, custKey)
+	if err != nil {
+		manager.RollbackTransaction()
+		ctx.JSON(status, BaseJSONResponse(uint(status), "复制对象时发生错误:"+err.Error()))
+		return nil
+	}
+	// encrypted data is not compressed
+	if !obj.IsEncrypted() {
+		obj.Compression = toBucket.Compression
+	}
+
+	dst, err := storages.NewObjectIO(obj.GetObjKey(toBucket), 0, dataKey, obj.Compression, middlewares.GetLogger(ctx))
+	if err == nil {
+		err = storages.CopyObjectData(dst, srcIO, src.Size, nil)
+		dst.Close()
+	}
+	if err == nil {
+		obj.ParentID = did
+		obj.Size = src.Size
+		obj.StoredSize = uint64(storages.StoredSizeDelta(dst, 0, src.Size))
+		err = manager.SaveObject(obj)
+	}
+	if err == nil {
+		err = manager.CommitTransaction()
+	}
+	if err != nil {
+		manager.RollbackTransaction()
+		enqueueGC(ctx, toBucket, obj, models.GCUploadFailed)
+		ctx.JSON(500, BaseJSONResponse(500, "复制对象时发生错误:"+err.Error()))
+		return nil
+	}
+	return obj
+}
+
+// getUserBucketOrResponse get user own bucket of name
+// return:
+//
+//	nil: error
+//	bucket: success
+func (ctl CopyController) getUserBucketOrResponse(ctx *gin.Context, bucketName string) *models.Bucket {
+
+	bm := models.NewBucketManager(bucketName, ctl.user).WithLogger(middlewares.GetLogger(ctx))
+	bucket, err := bm.GetUserBucket()
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return nil
+	}
+	if bucket == nil {
+		ctx.JSON(404, BaseJSONResponse(404, "bucket not found"))
+		return nil
+	}
+
+	return bucket
+}
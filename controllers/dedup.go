@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"errors"
+	"harbor/config"
+	"harbor/dedup"
+	"harbor/middlewares"
+	"harbor/models"
+	"harbor/utils/logger"
+	"harbor/utils/storages"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// deduplicator deduplicator of object data, nil if it is disabled
+var deduplicator *dedup.Deduplicator
+
+func dedupLog() *logrus.Entry {
+
+	return logger.Or(nil).WithField("component", "dedup")
+}
+
+// StartDedup start deduplication of object data in background if it is enabled
+func StartDedup() {
+
+	if !config.GetConfigs().Dedup.Enabled {
+		return
+	}
+	deduplicator = dedup.NewConfigured(dedupLog())
+	deduplicator.Start()
+}
+
+// StopDedup stop deduplication after the object in progress is done
+func StopDedup() {
+
+	if deduplicator != nil {
+		deduplicator.Stop()
+	}
+}
+
+// detachObjectBlob copy data of blob shared by deduplicated obj to its own key, and release the blob,
+// so that the data can be written; obj is updated in database
+func detachObjectBlob(ctx *gin.Context, manager *models.HarborObjectManager, bucket *models.Bucket, obj *models.HarborObject) error {
+
+	// old data of own key may be queued, it must not be deleted after copied
+	if err := models.NewGCManager().DeleteTaskByKey(obj.GetOwnObjKey(bucket)); err != nil {
+		return err
+	}
+	log := middlewares.GetLogger(ctx)
+	src, err := storages.NewObjectIO(obj.GetObjKey(bucket), obj.Size, nil, obj.Compression, log)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := storages.NewObjectIO(obj.GetOwnObjKey(bucket), 0, nil, obj.Compression, log)
+	if err != nil {
+		return err
+	}
+	err = storages.CopyObjectData(dst, src, obj.Size, nil)
+	dst.Close()
+	if err != nil {
+		return err
+	}
+
+	blobID := obj.BlobID
+	obj.BlobID = 0
+	obj.StoredSize = uint64(storages.StoredSizeDelta(dst, 0, obj.Size))
+	if err := manager.SetObjectBlob(obj); err != nil {
+		return err
+	}
+	if err := models.NewBlobManager().ReleaseBlob(blobID); err != nil {
+		log.WithError(err).WithField("blob", blobID).Error("release blob failed")
+	}
+	return nil
+}
+
+// updateUnsharedObjectSize update size of obj in the transaction of manager; if obj is deduplicated since it
+// is read, the transaction is rolled back to copy shared data to the object, and begun again. The update locks
+// the object until the transaction ends, so that it is not deduplicated while its data is written.
+func updateUnsharedObjectSize(ctx *gin.Context, manager *models.HarborObjectManager, bucket *models.Bucket, obj *models.HarborObject) error {
+
+	for {
+		ok, err := manager.UpdateUnsharedObjectSize(obj)
+		if err != nil || ok {
+			return err
+		}
+		cur, err := manager.GetObjectByID(obj.ID)
+		if err != nil {
+			return err
+		}
+		if cur == nil {
+			return errors.New("object is deleted")
+		}
+		// metadata not changed
+		if !cur.IsDeduplicated() {
+			return nil
+		}
+		manager.RollbackTransaction()
+		err = detachObjectBlob(ctx, manager, bucket, cur)
+		manager.BeginTransaction()
+		if err != nil {
+			return err
+		}
+		obj.BlobID, obj.StoredSize, obj.Compression = cur.BlobID, cur.StoredSize, cur.Compression
+	}
+}
+
+// DedupController 数据去重控制器结构
+type DedupController struct {
+	Controller
+}
+
+// NewDedupController new controller
+func NewDedupController() *DedupController {
+	return &DedupController{}
+}
+
+// Init 初始化this，子类要重写此方法
+func (ctl *DedupController) Init() ControllerInterface {
+
+	ctl.this = ctl
+	return ctl
+}
+
+// GetPermissions return permission
+func (ctl DedupController) GetPermissions(ctx *gin.Context) []PermissionFunc {
+
+	return []PermissionFunc{IsSuperUser}
+}
+
+// DedupJSON deduplication status
+type DedupJSON struct {
+	BaseJSON
+	Enabled      bool              `json:"enabled"`
+	Blobs        *models.BlobStats `json:"blobs"`
+	Deduplicator *dedup.Stats      `json:"deduplicator,omitempty"` // since the server is started
+}
+
+// Get handler for get method
+// @Summary 获取数据去重状态
+// @Description 返回去重共享数据块(blob)的统计（数量、引用对象数、数据大小、存储大小、节省的存储大小），
+// @Description 以及服务启动以来后台去重的进度；需要超级用户权限
+// @Tags dedup 数据去重
+// @Produce json
+// @Success 200 {object} controllers.DedupJSON
+// @Failure 401 {object} controllers.BaseJSON
+// @Failure 403 {object} controllers.BaseJSON
+// @Failure 500 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/dedup/ [get]
+func (ctl DedupController) Get(ctx *gin.Context) {
+
+	blobs, err := models.NewBlobManager().GetBlobStats()
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	r := DedupJSON{
+		BaseJSON: *BaseJSONResponse(200, "ok"),
+		Enabled:  deduplicator != nil,
+		Blobs:    blobs,
+	}
+	if deduplicator != nil {
+		stats := deduplicator.Stats()
+		r.Deduplicator = &stats
+	}
+	ctx.JSON(200, r)
+}
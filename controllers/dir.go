@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"harbor/middlewares"
 	"harbor/models"
 	"harbor/utils/paginations"
 	"strings"
@@ -72,7 +73,7 @@ func (ctl DirController) Get(ctx *gin.Context) {
 	dirPath = ClearPath(dirPath)
 
 	user := ctl.user
-	bm := models.NewBucketManager(bucketName, user)
+	bm := models.NewBucketManager(bucketName, user).WithLogger(middlewares.GetLogger(ctx))
 	bucket, err := bm.GetUserBucket()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -84,7 +85,7 @@ func (ctl DirController) Get(ctx *gin.Context) {
 	}
 
 	tableName := bucket.GetObjsTableName()
-	manager := models.NewHarborObjectManager(tableName, dirPath, "")
+	manager := models.NewHarborObjectManager(tableName, dirPath, "").WithLogger(middlewares.GetLogger(ctx))
 	dbQuery, err := manager.GetObjectsQuery()
 	if err != nil {
 		ctx.JSON(400, BaseJSONResponse(400, "directory not found"))
@@ -168,10 +169,11 @@ func (ctl DirController) Post(ctx *gin.Context) {
 
 	bucketName := ctx.Param("bucketname")
 	dirPath := ctx.Param("dirpath")
+	audit(ctx, "dir.create", "dir", auditObjectTarget(bucketName, dirPath))
 	dirPath, dirName := SplitPathAndFilename(dirPath)
 
 	user := ctl.user
-	bm := models.NewBucketManager(bucketName, user)
+	bm := models.NewBucketManager(bucketName, user).WithLogger(middlewares.GetLogger(ctx))
 	bucket, err := bm.GetUserBucket()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -183,7 +185,7 @@ func (ctl DirController) Post(ctx *gin.Context) {
 	}
 
 	tableName := bucket.GetObjsTableName()
-	manager := models.NewHarborObjectManager(tableName, dirPath, "")
+	manager := models.NewHarborObjectManager(tableName, dirPath, "").WithLogger(middlewares.GetLogger(ctx))
 	dir, created, err := manager.GetDirOrCreateUnderCurrent(dirName)
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -230,9 +232,10 @@ func (ctl DirController) Delete(ctx *gin.Context) {
 
 	bucketName := ctx.Param("bucketname")
 	dirPath := ClearPath(ctx.Param("dirpath"))
+	audit(ctx, "dir.delete", "dir", auditObjectTarget(bucketName, dirPath))
 
 	user := ctl.user
-	bm := models.NewBucketManager(bucketName, user)
+	bm := models.NewBucketManager(bucketName, user).WithLogger(middlewares.GetLogger(ctx))
 	bucket, err := bm.GetUserBucket()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -244,7 +247,7 @@ func (ctl DirController) Delete(ctx *gin.Context) {
 	}
 
 	tableName := bucket.GetObjsTableName()
-	manager := models.NewHarborObjectManager(tableName, dirPath, "")
+	manager := models.NewHarborObjectManager(tableName, dirPath, "").WithLogger(middlewares.GetLogger(ctx))
 	dir, err := manager.GetCurDir()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -3,9 +3,9 @@ package controllers
 import (
 	"errors"
 	"fmt"
+	"harbor/middlewares"
 	"harbor/models"
 	"harbor/utils/convert"
-	"harbor/utils/storages"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -51,14 +51,19 @@ func (ctl DownloadController) GetPermissions(ctx *gin.Context) []PermissionFunc
 // @Description       let url = $(this).attr('href') + '?jwt=' + token;   // jwt token
 // @Description       window.location.href = url;
 // @Description    }
+// @Description * 使用客户提供的密钥加密的对象，需通过X-Harbor-SSE-Customer-*标头提交密钥
 // @Tags 对象下载
 // @Accept  json
 // @Produce application/octet-stream
 // @Param   bucketname path string true "bucketname"
 // @Param   objpath path string true "objpath"
+// @Param   X-Harbor-SSE-Customer-Algorithm header string false "对象使用客户提供的密钥加密时必须提交，值为AES256"
+// @Param   X-Harbor-SSE-Customer-Key header string false "客户提供的密钥，32字节的base64编码"
+// @Param   X-Harbor-SSE-Customer-Key-MD5 header string false "密钥md5的base64编码，可选"
 // @Success 200 {string} string "file"
 // @Failure 206 {object} controllers.BaseJSON
 // @Failure 400 {object} controllers.BaseJSON
+// @Failure 403 {object} controllers.BaseJSON
 // @Failure 404 {object} controllers.BaseJSON
 // @Failure 416 {object} controllers.BaseJSON
 // @Security BasicAuth
@@ -84,7 +89,7 @@ func (ctl DownloadController) Get(ctx *gin.Context) {
 	}
 
 	tableName := bucket.GetObjsTableName()
-	manager := models.NewHarborObjectManager(tableName, dirPath, objName)
+	manager := models.NewHarborObjectManager(tableName, dirPath, objName).WithLogger(middlewares.GetLogger(ctx))
 	hobj, err := manager.GetObjExists()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -111,7 +116,7 @@ func (ctl DownloadController) getBucketOrResponse(ctx *gin.Context) *models.Buck
 
 	bucketName := ctx.Param("bucketname")
 	user := ctl.user
-	bm := models.NewBucketManager(bucketName, user)
+	bm := models.NewBucketManager(bucketName, user).WithLogger(middlewares.GetLogger(ctx))
 	bucket, err := bm.GetBucket()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -169,8 +174,10 @@ func (ctl DownloadController) totalFileResponse(ctx *gin.Context,
 	bucket *models.Bucket, obj *models.HarborObject) {
 
 	filesize := obj.Size
-	objkey := obj.GetObjKey(bucket)
-	cho := storages.NewCephHarborObject(objkey, obj.Size)
+	cho := objectIOOrResponse(ctx, bucket, obj)
+	if cho == nil {
+		return
+	}
 
 	stepFunc, err := cho.StepWriteFunc(0, filesize-1)
 	if err != nil {
@@ -185,10 +192,10 @@ func (ctl DownloadController) totalFileResponse(ctx *gin.Context,
 	ctx.Header("evob_obj_size", strFilesize)
 	ctx.Header("Content-Disposition", fmt.Sprintf("attachment;filename*=utf-8''%s", filename)) // 注意filename 这个是下载后的名字
 	ctx.Status(http.StatusOK)
-	ctx.Stream(stepFunc)
+	streamObject(ctx, bucket.Name, stepFunc)
 
 	tableName := bucket.GetObjsTableName()
-	manager := models.NewHarborObjectManager(tableName, "", "")
+	manager := models.NewHarborObjectManager(tableName, "", "").WithLogger(middlewares.GetLogger(ctx))
 	manager.IncreaseDownloadCount(obj) // 下载次数+1
 	return
 }
@@ -200,8 +207,10 @@ func (ctl DownloadController) rangeFileResponse(ctx *gin.Context,
 	var offset int64
 
 	filesize := obj.Size
-	objkey := obj.GetObjKey(bucket)
-	cho := storages.NewCephHarborObject(objkey, obj.Size)
+	cho := objectIOOrResponse(ctx, bucket, obj)
+	if cho == nil {
+		return
+	}
 
 	start, end, err := ctl.parseHeaderRange(hRange)
 	if err != nil {
@@ -235,11 +244,11 @@ func (ctl DownloadController) rangeFileResponse(ctx *gin.Context,
 	ctx.Header("Content-Type", "application/octet-stream")                                     // 注意格式
 	ctx.Header("Content-Disposition", fmt.Sprintf("attachment;filename*=utf-8''%s", filename)) // 注意filename 这个是下载后的名字
 	ctx.Status(http.StatusPartialContent)
-	ctx.Stream(stepFunc)
+	streamObject(ctx, bucket.Name, stepFunc)
 
 	if offset == 0 {
 		tableName := bucket.GetObjsTableName()
-		manager := models.NewHarborObjectManager(tableName, "", "")
+		manager := models.NewHarborObjectManager(tableName, "", "").WithLogger(middlewares.GetLogger(ctx))
 		manager.IncreaseDownloadCount(obj) // 下载次数+1
 	}
 	return
@@ -261,7 +270,6 @@ func (ctl DownloadController) parseHeaderRange(s string) (start, end int64, err
 	if r == nil {
 		return
 	}
-	fmt.Println(r)
 	if r[1] != "" {
 		val, err = strconv.ParseUint(r[1], 10, 64)
 		start = int64(val)
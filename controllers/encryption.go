@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"harbor/middlewares"
+	"harbor/models"
+	"harbor/utils/storages"
+	"harbor/utils/storages/encrypt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// headers of customer-provided key encrypting object data, required in each request reading or writing the object
+const (
+	HeaderSSECAlgorithm = "X-Harbor-SSE-Customer-Algorithm" // "AES256"
+	HeaderSSECKey       = "X-Harbor-SSE-Customer-Key"       // base64 encoded 32 bytes
+	HeaderSSECKeyMD5    = "X-Harbor-SSE-Customer-Key-MD5"   // optional, base64 encoded md5 of the key
+)
+
+// customerKey return key in request headers, nil if it is not provided
+func customerKey(ctx *gin.Context) ([]byte, error) {
+
+	alg := ctx.GetHeader(HeaderSSECAlgorithm)
+	b64 := ctx.GetHeader(HeaderSSECKey)
+	if alg == "" && b64 == "" {
+		return nil, nil
+	}
+	if alg != "AES256" {
+		return nil, fmt.Errorf("header %s should be AES256", HeaderSSECAlgorithm)
+	}
+	key, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil || len(key) != encrypt.KeySize {
+		return nil, fmt.Errorf("header %s should be %d bytes encoded in base64", HeaderSSECKey, encrypt.KeySize)
+	}
+	if h := ctx.GetHeader(HeaderSSECKeyMD5); h != "" {
+		sum := md5.Sum(key)
+		if h != base64.StdEncoding.EncodeToString(sum[:]) {
+			return nil, fmt.Errorf("header %s does not match the key", HeaderSSECKeyMD5)
+		}
+	}
+	return key, nil
+}
+
+// newObjectDataKey set encryption of object without data by the customer key, or by encryption of bucket,
+// return the new data key, nil if it is not encrypted; status code of response is returned with error
+func newObjectDataKey(bucket *models.Bucket, obj *models.HarborObject, custKey []byte) ([]byte, int, error) {
+
+	obj.Encryption, obj.KeyID, obj.DataKey = "", "", ""
+	if custKey == nil && bucket.Encryption == "" {
+		return nil, 0, nil
+	}
+	dataKey, err := encrypt.NewDataKey()
+	if err != nil {
+		return nil, 500, err
+	}
+	if custKey != nil {
+		wrapped, err := encrypt.WrapKey(custKey, dataKey)
+		if err != nil {
+			return nil, 500, err
+		}
+		obj.Encryption, obj.DataKey = models.EncryptionCustomer, wrapped
+		return dataKey, 0, nil
+	}
+
+	keyring, err := encrypt.ConfiguredKeyring()
+	if err != nil {
+		return nil, 500, err
+	}
+	kid, wrapped, err := keyring.Wrap(dataKey)
+	if err != nil {
+		return nil, 500, err
+	}
+	obj.Encryption, obj.KeyID, obj.DataKey = models.EncryptionAES256, kid, wrapped
+	return dataKey, 0, nil
+}
+
+// objectDataKey return data key of object, nil if it is not encrypted; status code of response is returned with error
+func objectDataKey(obj *models.HarborObject, custKey []byte) ([]byte, int, error) {
+
+	switch obj.Encryption {
+	case "", models.EncryptionAES256:
+		if custKey != nil {
+			return nil, 400, errors.New("object is not encrypted with a customer key")
+		}
+		if obj.Encryption == "" {
+			return nil, 0, nil
+		}
+		keyring, err := encrypt.ConfiguredKeyring()
+		if err != nil {
+			return nil, 500, err
+		}
+		dataKey, err := keyring.Unwrap(obj.KeyID, obj.DataKey)
+		if err != nil {
+			return nil, 500, err
+		}
+		return dataKey, 0, nil
+	case models.EncryptionCustomer:
+		if custKey == nil {
+			return nil, 400, fmt.Errorf("object is encrypted with a customer key, headers %s and %s are required",
+				HeaderSSECAlgorithm, HeaderSSECKey)
+		}
+		dataKey, err := encrypt.UnwrapKey(custKey, obj.DataKey)
+		if err == encrypt.ErrKeyMismatch {
+			return nil, 403, err
+		} else if err != nil {
+			return nil, 500, err
+		}
+		return dataKey, 0, nil
+	default:
+		return nil, 500, fmt.Errorf("unknown encryption '%s' of object", obj.Encryption)
+	}
+}
+
+// storedSize return size of stored data of object with size
+func storedSize(obj *models.HarborObject, size uint64) uint64 {
+
+	if obj.IsEncrypted() {
+		return encrypt.StoredSize(size)
+	}
+	return size
+}
+
+// objectIOOrResponse return ObjectIO of object data, the customer key is read from request headers if required;
+// response is sent and nil returned on error
+func objectIOOrResponse(ctx *gin.Context, bucket *models.Bucket, obj *models.HarborObject) storages.ObjectIO {
+
+	custKey, err := customerKey(ctx)
+	if err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return nil
+	}
+	dataKey, status, err := objectDataKey(obj, custKey)
+	if err != nil {
+		ctx.JSON(status, BaseJSONResponse(uint(status), err.Error()))
+		return nil
+	}
+	oio, err := storages.NewObjectIO(obj.GetObjKey(bucket), obj.Size, dataKey, obj.Compression, middlewares.GetLogger(ctx))
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return nil
+	}
+	return oio
+}
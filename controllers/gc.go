@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"harbor/config"
+	"harbor/gc"
+	"harbor/middlewares"
+	"harbor/models"
+	"harbor/utils/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// gcCollector garbage collector of storage data, nil if it is disabled
+var gcCollector *gc.Collector
+
+func gcLog() *logrus.Entry {
+
+	return logger.Or(nil).WithField("component", "gc")
+}
+
+// StartGC start garbage collector of storage data in background
+func StartGC() {
+
+	if config.GetConfigs().GC.Disabled {
+		return
+	}
+	gcCollector = gc.NewConfigured(gcLog())
+	gcCollector.Start()
+}
+
+// StopGC stop garbage collector after the key in progress is done, keys left are collected at next start
+func StopGC() {
+
+	if gcCollector != nil {
+		gcCollector.Stop()
+	}
+}
+
+// enqueueGC queue data of object to be deleted by garbage collector, or release its blob if it is deduplicated;
+// the error is logged only, as the data can still be found by orphan scan, and references by "dedup recount"
+func enqueueGC(ctx *gin.Context, bucket *models.Bucket, obj *models.HarborObject, reason string) {
+
+	key := obj.GetObjKey(bucket)
+	if err := models.NewGCManager().EnqueueObject(bucket, obj, reason); err != nil {
+		middlewares.GetLogger(ctx).WithError(err).WithField("key", key).Error("queue data for garbage collection failed")
+	}
+}
+
+// GCController 存储垃圾回收控制器结构
+type GCController struct {
+	Controller
+}
+
+// NewGCController new controller
+func NewGCController() *GCController {
+	return &GCController{}
+}
+
+// Init 初始化this，子类要重写此方法
+func (ctl *GCController) Init() ControllerInterface {
+
+	ctl.this = ctl
+	return ctl
+}
+
+// GetPermissions return permission
+func (ctl GCController) GetPermissions(ctx *gin.Context) []PermissionFunc {
+
+	return []PermissionFunc{IsSuperUser}
+}
+
+// GCJSON garbage collector status
+type GCJSON struct {
+	BaseJSON
+	Enabled   bool                 `json:"enabled"`
+	Queue     *models.GCQueueStats `json:"queue"`
+	Collector *gc.Stats            `json:"collector,omitempty"` // since the server is started
+}
+
+// Get handler for get method
+// @Summary 获取存储垃圾回收状态
+// @Description 返回待删除存储数据队列的统计（键数量、重试中的数量、字节数），以及服务启动以来垃圾回收的进度：
+// @Description 已删除键数量、跳过数量、失败次数、回收的字节数，和最近一次孤立数据扫描的结果；需要超级用户权限
+// @Tags gc 垃圾回收
+// @Produce json
+// @Success 200 {object} controllers.GCJSON
+// @Failure 401 {object} controllers.BaseJSON
+// @Failure 403 {object} controllers.BaseJSON
+// @Failure 500 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/gc/ [get]
+func (ctl GCController) Get(ctx *gin.Context) {
+
+	queue, err := models.NewGCManager().GetQueueStats()
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	r := GCJSON{
+		BaseJSON: *BaseJSONResponse(200, "ok"),
+		Enabled:  gcCollector != nil,
+		Queue:    queue,
+	}
+	if gcCollector != nil {
+		stats := gcCollector.Stats()
+		r.Collector = &stats
+	}
+	ctx.JSON(200, r)
+}
+
+// Post handler for post method
+// @Summary 开始孤立数据扫描
+// @Description 在后台对比存储后端的所有键和数据库中的对象元数据，没有对象的存储数据加入垃圾回收队列，
+// @Description 在宽限时间(gc.scan_grace)后删除，删除前会再次确认对象不存在；扫描进度通过GET获取；需要超级用户权限
+// @Tags gc 垃圾回收
+// @Produce json
+// @Success 202 {object} controllers.BaseJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Failure 401 {object} controllers.BaseJSON
+// @Failure 403 {object} controllers.BaseJSON
+// @Failure 409 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/gc/ [post]
+func (ctl GCController) Post(ctx *gin.Context) {
+
+	if gcCollector == nil {
+		ctx.JSON(400, BaseJSONResponse(400, "garbage collector is disabled"))
+		return
+	}
+	if gcCollector.Stats().Scan.Running {
+		ctx.JSON(409, BaseJSONResponse(409, gc.ErrScanRunning.Error()))
+		return
+	}
+	audit(ctx, "gc.scan", "gc", "")
+	go func() {
+		if _, err := gcCollector.Scan(); err != nil && err != gc.ErrScanRunning {
+			gcLog().WithError(err).Error("scan orphans failed")
+		}
+	}()
+	ctx.JSON(202, BaseJSONResponse(202, "orphan scan started"))
+}
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"context"
+	"harbor/database"
+	"harbor/utils/storages"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readyTimeout timeout of readiness checks
+const readyTimeout = 5 * time.Second
+
+var startTime = time.Now()
+
+// shuttingDown is set to 1 when the server is shutting down
+var shuttingDown int32
+
+// SetShuttingDown make readiness probe fail, so that no new requests are routed to this instance
+func SetShuttingDown() {
+
+	atomic.StoreInt32(&shuttingDown, 1)
+}
+
+// CheckJSON result of a readiness check
+type CheckJSON struct {
+	OK        bool    `json:"ok"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ReadyJSON readiness
+type ReadyJSON struct {
+	Status string                `json:"status"` // "ok", "unavailable" or "shutting_down"
+	Checks map[string]*CheckJSON `json:"checks"`
+}
+
+// Healthz handler of liveness probe
+// @Summary 存活探针
+// @Description 进程存活即返回200
+// @Tags health 健康检查
+// @Produce json
+// @Success 200 {object} controllers.ReadyJSON
+// @Router /healthz [get]
+func Healthz(ctx *gin.Context) {
+
+	ctx.JSON(200, ReadyJSON{Status: "ok", Checks: map[string]*CheckJSON{}})
+}
+
+// runningChecks names of checks still running, checks not observing context may outlive the timeout
+var runningChecks = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: map[string]bool{}}
+
+// runCheck run check with timeout, the check is not started again until the last run of it returns,
+// so that checks blocked in calls without context do not pile up
+func runCheck(name string, check func(context.Context) error) *CheckJSON {
+
+	runningChecks.Lock()
+	if runningChecks.names[name] {
+		runningChecks.Unlock()
+		return &CheckJSON{Error: "last check has not finished"}
+	}
+	runningChecks.names[name] = true
+	runningChecks.Unlock()
+
+	c, cancel := context.WithTimeout(context.Background(), readyTimeout)
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer cancel()
+		err := check(c)
+		runningChecks.Lock()
+		delete(runningChecks.names, name)
+		runningChecks.Unlock()
+		done <- err
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-c.Done():
+		// the context is also canceled after the check returned
+		select {
+		case err = <-done:
+		default:
+			err = c.Err()
+		}
+	}
+	r := &CheckJSON{OK: err == nil, LatencyMs: float64(time.Since(start)) / float64(time.Millisecond)}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// Readyz handler of readiness probe
+// @Summary 就绪探针
+// @Description 检查所有数据库连接和存储后端，全部可用返回200，否则返回503；服务正在关闭时返回503
+// @Tags health 健康检查
+// @Produce json
+// @Success 200 {object} controllers.ReadyJSON
+// @Failure 503 {object} controllers.ReadyJSON
+// @Router /readyz [get]
+func Readyz(ctx *gin.Context) {
+
+	if atomic.LoadInt32(&shuttingDown) == 1 {
+		ctx.JSON(503, ReadyJSON{Status: "shutting_down", Checks: map[string]*CheckJSON{}})
+		return
+	}
+
+	checks := map[string]func(context.Context) error{
+		"storage": func(context.Context) error { return storages.CheckHealth() },
+	}
+	for _, alias := range database.Aliases() {
+		alias := alias
+		checks["db:"+alias] = func(c context.Context) error { return database.Ping(c, alias) }
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ready := ReadyJSON{Status: "ok", Checks: map[string]*CheckJSON{}}
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+			r := runCheck(name, check)
+			mu.Lock()
+			ready.Checks[name] = r
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	for _, r := range ready.Checks {
+		if !r.OK {
+			ready.Status = "unavailable"
+			ctx.JSON(503, ready)
+			return
+		}
+	}
+	ctx.JSON(200, ready)
+}
+
+// DiagnosticsController 诊断信息控制器结构
+type DiagnosticsController struct {
+	Controller
+}
+
+// NewDiagnosticsController new controller
+func NewDiagnosticsController() *DiagnosticsController {
+	return &DiagnosticsController{}
+}
+
+// Init 初始化this，子类要重写此方法
+func (ctl *DiagnosticsController) Init() ControllerInterface {
+
+	ctl.this = ctl
+	return ctl
+}
+
+// GetPermissions return permission
+func (ctl DiagnosticsController) GetPermissions(ctx *gin.Context) []PermissionFunc {
+
+	return []PermissionFunc{IsSuperUser}
+}
+
+// DBPoolJSON connection pool stats of a database
+type DBPoolJSON struct {
+	MaxOpenConnections int   `json:"max_open_connections"`
+	OpenConnections    int   `json:"open_connections"`
+	InUse              int   `json:"in_use"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"wait_count"`
+	WaitDurationMs     int64 `json:"wait_duration_ms"`
+}
+
+// DiagnosticsJSON diagnostics
+type DiagnosticsJSON struct {
+	BaseJSON
+	Uptime     string                 `json:"uptime"`
+	Goroutines int                    `json:"goroutines"`
+	Databases  map[string]*DBPoolJSON `json:"databases"`
+	Storage    *storages.Diagnostics  `json:"storage"`
+}
+
+// Get handler for get method
+// @Summary 获取存储后端和数据库诊断信息
+// @Description ceph后端返回集群容量统计和io状态(iostat, 带宽KiB/s, 操作数op/s)，filesystem后端返回上传目录所在磁盘的容量统计，
+// @Description multidisk后端返回纠删码或副本设置，以及各目录是否可用和所在磁盘的容量统计；
+// @Description s3后端返回服务地址、存储桶和对象分块大小，以及存储桶是否可访问；
+// @Description 以及各数据库连接池状态，需要超级用户权限
+// @Tags health 健康检查
+// @Produce json
+// @Success 200 {object} controllers.DiagnosticsJSON
+// @Failure 401 {object} controllers.BaseJSON
+// @Failure 403 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/diagnostics/ [get]
+func (ctl DiagnosticsController) Get(ctx *gin.Context) {
+
+	dbs := map[string]*DBPoolJSON{}
+	for _, alias := range database.Aliases() {
+		st := database.Stats(alias)
+		dbs[alias] = &DBPoolJSON{
+			MaxOpenConnections: st.MaxOpenConnections,
+			OpenConnections:    st.OpenConnections,
+			InUse:              st.InUse,
+			Idle:               st.Idle,
+			WaitCount:          st.WaitCount,
+			WaitDurationMs:     int64(st.WaitDuration / time.Millisecond),
+		}
+	}
+
+	ctx.JSON(200, DiagnosticsJSON{
+		BaseJSON:   *BaseJSONResponse(200, "ok"),
+		Uptime:     time.Since(startTime).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		Databases:  dbs,
+		Storage:    storages.GetDiagnostics(),
+	})
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"harbor/middlewares"
+	"harbor/utils/lockout"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LockoutController 登录锁定管理控制器结构
+type LockoutController struct {
+	Controller
+}
+
+// NewLockoutController new controller
+func NewLockoutController() *LockoutController {
+	return &LockoutController{}
+}
+
+// Init 初始化this，子类要重写此方法
+func (ctl *LockoutController) Init() ControllerInterface {
+
+	ctl.this = ctl
+	return ctl
+}
+
+// GetPermissions return permission
+func (ctl LockoutController) GetPermissions(ctx *gin.Context) []PermissionFunc {
+
+	return []PermissionFunc{IsSuperUser}
+}
+
+// LockoutListJSON locked usernames and client ips
+type LockoutListJSON struct {
+	BaseJSON
+	Users []lockout.Record `json:"users"`
+	IPs   []lockout.Record `json:"ips"`
+}
+
+// LockoutDeleteForm unlock form, username or ip is required
+type LockoutDeleteForm struct {
+	Username string `json:"username" form:"username"`
+	IP       string `json:"ip" form:"ip"`
+}
+
+// Get handler for get method
+// @Summary 获取登录锁定列表
+// @Description 因连续登录失败被临时锁定的用户名和客户端IP，需要超级用户权限
+// @Tags lockout 登录锁定
+// @Produce json
+// @Success 200 {object} controllers.LockoutListJSON
+// @Failure 401 {object} controllers.BaseJSON
+// @Failure 403 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/lockouts/ [get]
+func (ctl LockoutController) Get(ctx *gin.Context) {
+
+	users, ips := middlewares.LoginLockouts()
+	ctx.JSON(200, LockoutListJSON{
+		BaseJSON: *BaseJSONResponse(200, "ok"),
+		Users:    users,
+		IPs:      ips,
+	})
+}
+
+// Delete handler for delete method
+// @Summary 解除登录锁定
+// @Description 清除用户名或客户端IP的登录失败记录，需要超级用户权限
+// @Tags lockout 登录锁定
+// @Accept json
+// @Produce json
+// @Param   data body controllers.LockoutDeleteForm true "username or ip"
+// @Success 204 {string} string "No content"
+// @Failure 400 {object} controllers.BaseJSON
+// @Failure 404 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/lockouts/ [delete]
+func (ctl LockoutController) Delete(ctx *gin.Context) {
+
+	form := LockoutDeleteForm{}
+	if err := ctx.ShouldBind(&form); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+	if form.Username == "" && form.IP == "" {
+		ctx.JSON(400, BaseJSONResponse(400, "username or ip is required"))
+		return
+	}
+	if !middlewares.UnlockLogin(form.Username, form.IP) {
+		ctx.JSON(404, BaseJSONResponse(404, "no failed login attempts found"))
+		return
+	}
+	ctx.JSON(204, nil)
+}
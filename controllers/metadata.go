@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"harbor/middlewares"
 	"harbor/models"
 	"strings"
 
@@ -78,7 +79,7 @@ func (ctl MetadataController) Get(ctx *gin.Context) {
 	}
 
 	tableName := bucket.GetObjsTableName()
-	manager := models.NewHarborObjectManager(tableName, dirPath, objName)
+	manager := models.NewHarborObjectManager(tableName, dirPath, objName).WithLogger(middlewares.GetLogger(ctx))
 	hobj, err := manager.GetObjOrDirExists()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -110,7 +111,7 @@ func (ctl MetadataController) getUserBucketOrResponse(ctx *gin.Context) *models.
 
 	bucketName := ctx.Param("bucketname")
 	user := ctl.user
-	bm := models.NewBucketManager(bucketName, user)
+	bm := models.NewBucketManager(bucketName, user).WithLogger(middlewares.GetLogger(ctx))
 	bucket, err := bm.GetUserBucket()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
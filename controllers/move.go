@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"errors"
+	"harbor/middlewares"
 	"harbor/models"
+	"harbor/utils/webhook"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -76,10 +78,17 @@ func (ctl MoveController) Post(ctx *gin.Context) {
 		return
 	}
 
+	ae := audit(ctx, "object.move", "object", auditObjectTarget(ctx.Param("bucketname"), objPath))
 	moveTo, rename, err := ctl.postQueryParamOrResponse(ctx)
 	if err != nil {
 		return
 	}
+	if moveTo != "" {
+		ae.AddDetail("move_to", moveTo)
+	}
+	if rename != "" {
+		ae.AddDetail("rename", rename)
+	}
 
 	// bucket
 	bucket := ctl.getUserBucketOrResponse(ctx)
@@ -88,7 +97,7 @@ func (ctl MoveController) Post(ctx *gin.Context) {
 	}
 
 	tableName := bucket.GetObjsTableName()
-	manager := models.NewHarborObjectManager(tableName, dirPath, objName)
+	manager := models.NewHarborObjectManager(tableName, dirPath, objName).WithLogger(middlewares.GetLogger(ctx))
 	hobj, err := manager.GetObjExists()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -97,7 +106,11 @@ func (ctl MoveController) Post(ctx *gin.Context) {
 		ctx.JSON(404, BaseJSONResponse(404, "object not found"))
 		return
 	}
+	oldPath := hobj.PathName
 	ctl.moveRenameObj(ctx, bucket, hobj, moveTo, rename)
+	if ctx.Writer.Status() == 201 {
+		notifyWebhooks(bucket, webhook.EventObjectMoved, hobj.PathName, ctl.user, map[string]interface{}{"from": oldPath})
+	}
 
 	// dPath := URLPathJoin([]string{"obs", bucket.Name, objPath})
 	// dURL := ctl.buildAbsoluteURI(ctx, dPath, nil)
@@ -113,7 +126,7 @@ func (ctl MoveController) renameObj(ctx *gin.Context, bucket *models.Bucket,
 
 	dirPath, _ := SplitPathAndFilename(obj.PathName)
 	tableName := bucket.GetObjsTableName()
-	manager := models.NewHarborObjectManager(tableName, dirPath, rename)
+	manager := models.NewHarborObjectManager(tableName, dirPath, rename).WithLogger(middlewares.GetLogger(ctx))
 	targetObj, err := manager.GetObjOrDirByDidName(obj.ParentID, rename)
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, "重命名对象时发生错误"))
@@ -170,7 +183,7 @@ func (ctl MoveController) moveRenameObj(ctx *gin.Context, bucket *models.Bucket,
 
 	// 检查是否符合移动或重命名条件，目标路径下是否已存在同名对象或子目录
 	tableName := bucket.GetObjsTableName()
-	manager := models.NewHarborObjectManager(tableName, moveTo, newObjName)
+	manager := models.NewHarborObjectManager(tableName, moveTo, newObjName).WithLogger(middlewares.GetLogger(ctx))
 	targetObj, err := manager.GetObjOrDirExists()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, "无法完成对象的移动操作:"+err.Error()))
@@ -212,7 +225,7 @@ func (ctl MoveController) getUserBucketOrResponse(ctx *gin.Context) *models.Buck
 
 	bucketName := ctx.Param("bucketname")
 	user := ctl.user
-	bm := models.NewBucketManager(bucketName, user)
+	bm := models.NewBucketManager(bucketName, user).WithLogger(middlewares.GetLogger(ctx))
 	bucket, err := bm.GetUserBucket()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
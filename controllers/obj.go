@@ -2,8 +2,11 @@ package controllers
 
 import (
 	"fmt"
+	"harbor/middlewares"
 	"harbor/models"
+	"harbor/utils/metrics"
 	"harbor/utils/storages"
+	"harbor/utils/webhook"
 	"mime/multipart"
 	"net/url"
 	"strconv"
@@ -64,8 +67,12 @@ func (ctl ObjController) GetPermissions(ctx *gin.Context) []PermissionFunc {
 // @Param   objpath path string true "objpath"
 // @Param   offset     query    int     false        "The byte offset of object to read"
 // @Param   size       query    int     false        "Byte size to read"
+// @Param   X-Harbor-SSE-Customer-Algorithm header string false "对象使用客户提供的密钥加密时必须提交，值为AES256"
+// @Param   X-Harbor-SSE-Customer-Key header string false "客户提供的密钥，32字节的base64编码"
+// @Param   X-Harbor-SSE-Customer-Key-MD5 header string false "密钥md5的base64编码，可选"
 // @Success 200 {string} string "file"
 // @Failure 400 {object} controllers.BaseJSON
+// @Failure 403 {object} controllers.BaseJSON
 // @Failure 404 {object} controllers.BaseJSON
 // @Security BasicAuth
 // @Security ApiKeyAuth
@@ -98,7 +105,7 @@ func (ctl ObjController) Get(ctx *gin.Context) {
 	}
 
 	tableName := bucket.GetObjsTableName()
-	manager := models.NewHarborObjectManager(tableName, dirPath, objName)
+	manager := models.NewHarborObjectManager(tableName, dirPath, objName).WithLogger(middlewares.GetLogger(ctx))
 	hobj, err := manager.GetObjExists()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -108,9 +115,11 @@ func (ctl ObjController) Get(ctx *gin.Context) {
 		return
 	}
 
-	objkey := hobj.GetObjKey(bucket)
 	objSize := hobj.Size
-	cho := storages.NewCephHarborObject(objkey, objSize)
+	cho := objectIOOrResponse(ctx, bucket, hobj)
+	if cho == nil {
+		return
+	}
 	filesize := strconv.FormatUint(objSize, 10)
 	if size > 0 {
 		data, err := cho.Read(offset, uint(size))
@@ -122,7 +131,9 @@ func (ctl ObjController) Get(ctx *gin.Context) {
 		ctx.Header("Content-Type", "application/octet-stream") // 注意格式
 		ctx.Header("evob_obj_size", filesize)
 		ctx.Header("Content-Length", chunksize)
+		middlewares.WaitDownload(ctx, int64(len(data)))
 		ctx.Data(200, "application/octet-stream", data)
+		metrics.TransferBytes.WithLabelValues("download", metrics.BucketLabel(bucket.Name)).Add(float64(len(data)))
 		if offset == 0 {
 			manager.IncreaseDownloadCount(hobj) // 下载次数+1
 		}
@@ -139,7 +150,7 @@ func (ctl ObjController) Get(ctx *gin.Context) {
 	ctx.Header("Content-Length", filesize)
 	ctx.Header("Content-Disposition", fmt.Sprintf("attachment;filename*=utf-8''%s", filename)) // 注意filename 这个是下载后的名字
 	ctx.Header("evob_obj_size", filesize)
-	ctx.Stream(stepFunc)
+	streamObject(ctx, bucket.Name, stepFunc)
 
 	manager.IncreaseDownloadCount(hobj) // 下载次数+1
 	return
@@ -167,6 +178,16 @@ type objPostJSON struct {
 // @Description
 // @Description ## 注意：
 // @Description 	分片上传现不支持并发上传，并发上传可能造成脏数据，上传分片顺序没有要求，请一个分片上传成功后再上传另一个分片
+// @Description ## 加密：
+// @Description 新对象的数据按存储桶的加密设置加密存储；提交X-Harbor-SSE-Customer-*标头时，使用客户提供的密钥加密新对象，
+// @Description 服务器不保存此密钥，之后上传分片和下载对象时都必须提交相同的密钥，密钥丢失则数据无法恢复。
+// @Description 对象已存在时，沿用对象原有的加密方式，reset=true时按本次请求重新确定。
+// @Description ## 压缩：
+// @Description 新对象的数据按存储桶的压缩设置分块压缩存储，加密的对象不压缩；对象已存在时沿用对象原有的压缩方式，reset=true时重新确定。
+// @Description ## 去重：
+// @Description 开启数据去重时，内容相同的对象共享存储的数据；上传到共享数据的对象时，先复制共享数据为对象独有，reset=true时直接释放共享数据。
+// @Description ## 通知：
+// @Description 对象创建或重置时的分片上传后发送webhook事件object.created；每个分片上传后发送object.written，size为写入后的对象大小，最后一个分片的size即对象最终大小。
 // @Tags object对象
 // @Accept  multipart/form-data
 // @Produce  json
@@ -175,8 +196,12 @@ type objPostJSON struct {
 // @Param   chunk formData file true "chunk"
 // @Param   chunk_offset formData int64 true "chunk_offset"
 // @Param   chunk_size formData int64 true "chunk_size"
+// @Param   X-Harbor-SSE-Customer-Algorithm header string false "使用客户提供的密钥加密时提交，值为AES256"
+// @Param   X-Harbor-SSE-Customer-Key header string false "客户提供的密钥，32字节的base64编码"
+// @Param   X-Harbor-SSE-Customer-Key-MD5 header string false "密钥md5的base64编码，可选"
 // @Success 200 {object} controllers.objPostJSON
 // @Failure 400 {object} controllers.BaseJSON
+// @Failure 403 {object} controllers.BaseJSON
 // @Failure 404 {object} controllers.BaseJSON
 // @Security BasicAuth
 // @Security ApiKeyAuth
@@ -194,11 +219,18 @@ func (ctl ObjController) Post(ctx *gin.Context) {
 		return
 	}
 
+	audit(ctx, "object.upload", "object", auditObjectTarget(ctx.Param("bucketname"), objPath))
 	if reset, err = GetBoolParamOrDefault(ctx, "reset", false); err != nil {
 		ctx.JSON(400, BaseJSONResponse(400, "reset param is invalid"))
 		return
 	}
 
+	custKey, err := customerKey(ctx)
+	if err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+
 	form := FormUploadChunk{}
 	if err = ctx.ShouldBind(&form); err != nil {
 		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
@@ -220,34 +252,63 @@ func (ctl ObjController) Post(ctx *gin.Context) {
 	}
 
 	tableName := bucket.GetObjsTableName()
-	manager := models.NewHarborObjectManager(tableName, dirPath, objName)
+	manager := models.NewHarborObjectManager(tableName, dirPath, objName).WithLogger(middlewares.GetLogger(ctx))
 	hobj, err = manager.GetObjExists()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, "Get harbor object metadata error"))
 		return
 	}
+	// data of existing object is written with its data key
+	var dataKey []byte
+	var oldSize uint64
+	if hobj != nil && !reset {
+		var status int
+		if dataKey, status, err = objectDataKey(hobj, custKey); err != nil {
+			ctx.JSON(status, BaseJSONResponse(uint(status), err.Error()))
+			return
+		}
+		oldSize = hobj.Size
+		// shared data of deduplicated object is copied to the object before writing
+		if hobj.IsDeduplicated() {
+			if err := detachObjectBlob(ctx, manager, bucket, hobj); err != nil {
+				ctx.JSON(500, BaseJSONResponse(500, "upload fialed:"+err.Error()))
+				return
+			}
+		}
+	}
 	// object exists and param reset == true; reset object size
 	if (hobj != nil) && reset {
 		oldSize := hobj.Size
+		oldStoredSize := hobj.StoredSize
 		oldTime := hobj.UpdateTime
-		objkey := hobj.GetObjKey(bucket)
-		cho := storages.NewCephHarborObject(objkey, oldSize)
+		oldBlobID := hobj.BlobID
+		objkey := hobj.GetOwnObjKey(bucket)
 
 		// modify metadata
 		hobj.Size = uint64(size)
+		hobj.StoredSize = 0
+		hobj.BlobID = 0
 		hobj.UpdateModyfiedTime()
 		if err := manager.SaveObject(hobj); err != nil {
 			ctx.JSON(500, BaseJSONResponse(500, "reset object size failed"))
 			return
 		}
 		// delete object data
-		if err := cho.Delete(); err != nil {
+		if err := storages.DeleteObjectData(objkey, storedSize(hobj, oldSize), hobj.IsCompressed(), middlewares.GetLogger(ctx)); err != nil {
 			hobj.Size = oldSize
+			hobj.StoredSize = oldStoredSize
 			hobj.UpdateTime = oldTime
+			hobj.BlobID = oldBlobID
 			manager.SaveObject(hobj)
 			ctx.JSON(500, BaseJSONResponse(500, "reset object size failed"))
 			return
 		}
+		// shared data of deduplicated object is released
+		if oldBlobID != 0 {
+			if err := models.NewBlobManager().ReleaseBlob(oldBlobID); err != nil {
+				middlewares.GetLogger(ctx).WithError(err).WithField("blob", oldBlobID).Error("release blob failed")
+			}
+		}
 	}
 
 	manager.BeginTransaction()
@@ -260,10 +321,35 @@ func (ctl ObjController) Post(ctx *gin.Context) {
 		}
 		created = true
 	}
+	// object without data, its encryption is set by this request
+	if created || reset {
+		var status int
+		if dataKey, status, err = newObjectDataKey(bucket, hobj, custKey); err != nil {
+			manager.RollbackTransaction()
+			ctx.JSON(status, BaseJSONResponse(uint(status), "upload fialed:"+err.Error()))
+			return
+		}
+		if err := manager.SetObjectEncryption(hobj); err != nil {
+			manager.RollbackTransaction()
+			ctx.JSON(500, BaseJSONResponse(500, "upload fialed:"+err.Error()))
+			return
+		}
+		// encrypted data is not compressed
+		hobj.Compression = ""
+		if !hobj.IsEncrypted() {
+			hobj.Compression = bucket.Compression
+		}
+		if err := manager.SetObjectCompression(hobj); err != nil {
+			manager.RollbackTransaction()
+			ctx.JSON(500, BaseJSONResponse(500, "upload fialed:"+err.Error()))
+			return
+		}
+	}
 
 	hobj.SetSizeOnlyIncrease(uint64(offset + size))
 	hobj.UpdateModyfiedTime()
-	if err := manager.UpdateObjectSize(hobj); err != nil {
+	// the object may be deduplicated since it is read
+	if err := updateUnsharedObjectSize(ctx, manager, bucket, hobj); err != nil {
 		manager.RollbackTransaction()
 		ctx.JSON(500, BaseJSONResponse(500, "upload fialed:"+err.Error()))
 		return
@@ -271,19 +357,38 @@ func (ctl ObjController) Post(ctx *gin.Context) {
 
 	// storage object data
 	objkey := hobj.GetObjKey(bucket)
-	cho := storages.NewCephHarborObject(objkey, hobj.Size)
-	err = cho.WriteFile(offset, chunk)
+	cho, err := storages.NewObjectIO(objkey, oldSize, dataKey, hobj.Compression, middlewares.GetLogger(ctx))
+	if err == nil {
+		err = cho.WriteFile(offset, chunk)
+		cho.Close()
+	}
+	if err == nil {
+		err = manager.AddObjectStoredSize(hobj, storages.StoredSizeDelta(cho, oldSize, hobj.Size))
+	}
 	if err != nil {
 		manager.RollbackTransaction()
+		if created {
+			enqueueGC(ctx, bucket, hobj, models.GCUploadFailed)
+		}
 		ctx.JSON(500, BaseJSONResponse(500, "upload fialed:"+err.Error()))
 		return
 	}
 
 	if err := manager.CommitTransaction(); err != nil {
 		manager.RollbackTransaction()
+		if created {
+			enqueueGC(ctx, bucket, hobj, models.GCUploadFailed)
+		}
 		ctx.JSON(500, BaseJSONResponse(500, "upload fialed:"+err.Error()))
 		return
 	}
+	metrics.TransferBytes.WithLabelValues("upload", metrics.BucketLabel(bucket.Name)).Add(float64(size))
+	// uploads have no completion request, "object.written" of the last chunk has the final size
+	written := map[string]interface{}{"size": hobj.Size, "chunk_offset": offset, "chunk_size": size}
+	if created || reset {
+		notifyWebhooks(bucket, webhook.EventObjectCreated, objPath, ctl.user, written)
+	}
+	notifyWebhooks(bucket, webhook.EventObjectWritten, objPath, ctl.user, written)
 	ctx.JSON(200, &objPostJSON{
 		BaseJSON: *BaseJSONResponse(200, "success to upload"),
 		Created:  created,
@@ -314,6 +419,7 @@ func (ctl ObjController) Patch(ctx *gin.Context) {
 		ctx.JSON(400, BaseJSONResponse(400, "objpath is invalid"))
 		return
 	}
+	ae := audit(ctx, "object.share", "object", auditObjectTarget(ctx.Param("bucketname"), ctx.Param("objpath")))
 
 	// query param
 	days, err := GetIntParamOrDefault(ctx, "days", 0)
@@ -326,6 +432,7 @@ func (ctl ObjController) Patch(ctx *gin.Context) {
 		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
 		return
 	}
+	ae.AddDetail("share", share).AddDetail("days", days)
 
 	// bucket
 	bucket := ctl.getUserBucketOrResponse(ctx)
@@ -335,7 +442,7 @@ func (ctl ObjController) Patch(ctx *gin.Context) {
 
 	// object
 	tableName := bucket.GetObjsTableName()
-	manager := models.NewHarborObjectManager(tableName, dirPath, objName)
+	manager := models.NewHarborObjectManager(tableName, dirPath, objName).WithLogger(middlewares.GetLogger(ctx))
 	hobj, err := manager.GetObjExists()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -351,6 +458,7 @@ func (ctl ObjController) Patch(ctx *gin.Context) {
 		ctx.JSON(500, BaseJSONResponse(500, "share object fialed:"+err.Error()))
 		return
 	}
+	notifyWebhooks(bucket, webhook.EventObjectShared, hobj.PathName, ctl.user, map[string]interface{}{"share": share, "days": days})
 
 	ctx.JSON(200, BaseJSONResponse(200, "success to share object"))
 }
@@ -377,6 +485,7 @@ func (ctl ObjController) Delete(ctx *gin.Context) {
 		ctx.JSON(400, BaseJSONResponse(400, "objpath is invalid"))
 		return
 	}
+	audit(ctx, "object.delete", "object", auditObjectTarget(ctx.Param("bucketname"), ctx.Param("objpath")))
 
 	// bucket
 	bucket := ctl.getUserBucketOrResponse(ctx)
@@ -386,7 +495,7 @@ func (ctl ObjController) Delete(ctx *gin.Context) {
 
 	// object
 	tableName := bucket.GetObjsTableName()
-	manager := models.NewHarborObjectManager(tableName, dirPath, objName)
+	manager := models.NewHarborObjectManager(tableName, dirPath, objName).WithLogger(middlewares.GetLogger(ctx))
 	hobj, err := manager.GetObjExists()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
@@ -402,16 +511,9 @@ func (ctl ObjController) Delete(ctx *gin.Context) {
 		return
 	}
 
-	// delete object data
-	objkey := hobj.GetObjKey(bucket)
-	fs := storages.NewFileStorage(objkey)
-	if err := fs.Delete(); err != nil {
-		// restore object metadata
-		if err := manager.InsertObject(hobj); err == nil {
-			ctx.JSON(500, BaseJSONResponse(500, "delete object fialed，can not remove object data"))
-			return
-		}
-	}
+	// object data is deleted by garbage collector
+	enqueueGC(ctx, bucket, hobj, models.GCObjectDeleted)
+	notifyWebhooks(bucket, webhook.EventObjectDeleted, hobj.PathName, ctl.user, map[string]interface{}{"size": hobj.Size})
 
 	ctx.JSON(200, BaseJSONResponse(200, "success to delete object"))
 }
@@ -424,7 +526,7 @@ func (ctl ObjController) getUserBucketOrResponse(ctx *gin.Context) *models.Bucke
 
 	bucketName := ctx.Param("bucketname")
 	user := ctl.user
-	bm := models.NewBucketManager(bucketName, user)
+	bm := models.NewBucketManager(bucketName, user).WithLogger(middlewares.GetLogger(ctx))
 	bucket, err := bm.GetUserBucket()
 	if err != nil {
 		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
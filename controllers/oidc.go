@@ -0,0 +1,414 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"harbor/config"
+	"harbor/middlewares"
+	"harbor/models"
+	"harbor/utils/oidc"
+	"harbor/utils/signing"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	oidcStateCookie  = "oidc_state"
+	oidcStateMaxAge  = 10 * time.Minute
+	oidcTicketMaxAge = 5 * time.Minute
+)
+
+// oidcRoles role names can be used in config "group_roles"
+var oidcRoles = map[string]models.TypeRole{
+	"superuser":     models.RoleSuperUser,
+	"staff":         models.RoleStaff,
+	"app_superuser": models.RoleAppSuperUser,
+}
+
+// TokenGeneratorFunc generate a jwt for user, return token and expire time
+type TokenGeneratorFunc func(data interface{}) (string, time.Time, error)
+
+// OIDCController OpenID Connect login
+type OIDCController struct {
+	client         *oidc.Client
+	signer         *signing.Signer
+	ticketSigner   *signing.Signer // ticket of user who passed IdP authentication, exchanged for jwt with second factor
+	tokenGenerator TokenGeneratorFunc
+}
+
+// oidcState state saved in cookie between login and callback
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Next     string `json:"next,omitempty"`
+}
+
+// OIDCLoginJSON jwt json struct returned after login, or ticket if the user enrolled two-factor authentication
+type OIDCLoginJSON struct {
+	BaseJSON
+	Token     string `json:"token,omitempty"`
+	Expire    string `json:"expire,omitempty"`
+	OTPTicket string `json:"otp_ticket,omitempty"` // post it with code to "/oidc/2fa/" for jwt
+}
+
+// OIDCSecondFactorForm form of second step of OIDC login
+type OIDCSecondFactorForm struct {
+	Ticket  string `json:"ticket" form:"ticket" binding:"required"`
+	OTPCode string `json:"otp_code" form:"otp_code" binding:"required"` // TOTP code or recovery code
+}
+
+// NewOIDCController new controller
+func NewOIDCController(tokenGenerator TokenGeneratorFunc) *OIDCController {
+
+	configs := config.GetConfigs()
+	c := configs.OIDC
+	return &OIDCController{
+		client:         oidc.NewClient(c.Issuer, c.ClientID, c.ClientSecret, c.RedirectURL, c.Scopes),
+		signer:         signing.NewSigner(configs.SecretKey, "oidc.state"),
+		ticketSigner:   signing.NewSigner(configs.SecretKey, "oidc.2fa"),
+		tokenGenerator: tokenGenerator,
+	}
+}
+
+// isSafeNext only allow to redirect to a path of this site after login
+func isSafeNext(next string) bool {
+
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return false
+	}
+	u, err := url.Parse(next)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+// Login handler for get method
+// @Summary OIDC登录
+// @Description 重定向到身份提供者(IdP)进行认证，认证成功后IdP回调"/oidc/callback/"
+// @Description 可选query参数“next”，登录成功后重定向到此站内路径，jwt在url片段中，例如 /web/#jwt=xxx
+// @Tags jwt
+// @Param   next query string false "redirect to after login"
+// @Success 302 {string} string "redirect to IdP"
+// @Failure 400 {object} controllers.BaseJSON
+// @Failure 502 {object} controllers.BaseJSON
+// @Router /oidc/login/ [get]
+func (ctl *OIDCController) Login(ctx *gin.Context) {
+
+	next := ctx.Query("next")
+	if next != "" && !isSafeNext(next) {
+		ctx.JSON(400, BaseJSONResponse(400, "invalid query param next"))
+		return
+	}
+
+	verifier, challenge := oidc.NewPKCE()
+	st := oidcState{
+		State:    oidc.RandomString(16),
+		Nonce:    oidc.RandomString(16),
+		Verifier: verifier,
+		Next:     next,
+	}
+	authURL, err := ctl.client.AuthCodeURL(st.State, st.Nonce, challenge)
+	if err != nil {
+		ctx.JSON(502, BaseJSONResponse(502, err.Error()))
+		return
+	}
+
+	value, _ := json.Marshal(st)
+	signed := ctl.signer.Sign(value, time.Now().Add(oidcStateMaxAge))
+	ctx.SetCookie(oidcStateCookie, signed, int(oidcStateMaxAge/time.Second), "/oidc/", "", ctx.Request.TLS != nil, true)
+	ctx.Redirect(http.StatusFound, authURL)
+}
+
+// Callback handler for get method
+// @Summary OIDC登录回调
+// @Description IdP认证后回调，验证ID token，用户不存在时自动创建(auto_create)，返回jwt；
+// @Description 用户已启用两步验证时不返回jwt，返回otp_ticket，需提交到"/oidc/2fa/"并附带验证码以获取jwt；
+// @Description 登录时有“next”参数时，重定向到next，jwt或otp_ticket在url片段中
+// @Tags jwt
+// @Produce json
+// @Param   code query string true "authorization code"
+// @Param   state query string true "state"
+// @Success 200 {object} controllers.OIDCLoginJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Failure 401 {object} controllers.BaseJSON
+// @Failure 502 {object} controllers.BaseJSON
+// @Router /oidc/callback/ [get]
+func (ctl *OIDCController) Callback(ctx *gin.Context) {
+
+	if e := ctx.Query("error"); e != "" {
+		ctx.JSON(401, BaseJSONResponse(401, e+" "+ctx.Query("error_description")))
+		return
+	}
+
+	cookie, err := ctx.Cookie(oidcStateCookie)
+	if err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, "missing oidc state, please login again"))
+		return
+	}
+	// state is used only once
+	ctx.SetCookie(oidcStateCookie, "", -1, "/oidc/", "", ctx.Request.TLS != nil, true)
+
+	value, err := ctl.signer.Unsign(cookie, time.Now())
+	if err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, "invalid oidc state, "+err.Error()))
+		return
+	}
+	st := oidcState{}
+	if err := json.Unmarshal(value, &st); err != nil || st.State == "" || st.State != ctx.Query("state") {
+		ctx.JSON(400, BaseJSONResponse(400, "oidc state mismatch"))
+		return
+	}
+
+	code := ctx.Query("code")
+	if code == "" {
+		ctx.JSON(400, BaseJSONResponse(400, "missing query param code"))
+		return
+	}
+	token, err := ctl.client.Exchange(code, st.Verifier)
+	if err != nil {
+		ctx.JSON(502, BaseJSONResponse(502, err.Error()))
+		return
+	}
+	claims, err := ctl.client.VerifyIDToken(token.IDToken, st.Nonce)
+	if err != nil {
+		ctx.JSON(401, BaseJSONResponse(401, err.Error()))
+		return
+	}
+
+	user, err := OIDCUserFromClaims(claims, &config.GetConfigs().OIDC)
+	if err != nil {
+		ctx.JSON(401, BaseJSONResponse(401, err.Error()))
+		return
+	}
+
+	// the second factor is required as password login
+	t, err := middlewares.GetConfirmedTOTP(user)
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	if t != nil {
+		ticket := ctl.ticketSigner.Sign([]byte(strconv.FormatUint(uint64(user.ID), 10)), time.Now().Add(oidcTicketMaxAge))
+		if st.Next != "" {
+			ctx.Redirect(http.StatusFound, st.Next+"#otp_ticket="+url.QueryEscape(ticket))
+			return
+		}
+		ctx.JSON(200, &OIDCLoginJSON{
+			BaseJSON:  *BaseJSONResponse(200, middlewares.ErrOTPRequired.Error()),
+			OTPTicket: ticket,
+		})
+		return
+	}
+	ctl.loginSucceeded(ctx, user, middlewares.TwoFactorEnrollRequired(user, false), st.Next)
+}
+
+// SecondFactor handler for post method
+// @Summary OIDC登录两步验证
+// @Description 已启用两步验证的用户通过IdP认证后，提交回调返回的otp_ticket和TOTP验证码或恢复码，返回jwt
+// @Tags jwt
+// @Accept json
+// @Produce json
+// @Param   data body controllers.OIDCSecondFactorForm true "ticket and code"
+// @Success 200 {object} controllers.OIDCLoginJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Failure 401 {object} controllers.BaseJSON
+// @Failure 429 {object} controllers.BaseJSON
+// @Router /oidc/2fa/ [post]
+func (ctl *OIDCController) SecondFactor(ctx *gin.Context) {
+
+	form := OIDCSecondFactorForm{}
+	if err := ctx.ShouldBind(&form); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+	value, err := ctl.ticketSigner.Unsign(form.Ticket, time.Now())
+	if err != nil {
+		ctx.JSON(401, BaseJSONResponse(401, "invalid otp ticket, please login again"))
+		return
+	}
+	id, err := strconv.ParseUint(string(value), 10, 64)
+	if err != nil {
+		ctx.JSON(401, BaseJSONResponse(401, "invalid otp ticket, please login again"))
+		return
+	}
+	user, err := models.NewUserManager().GetUserByID(uint(id))
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	if user == nil || !user.IsActived() {
+		ctx.JSON(401, BaseJSONResponse(401, "user does not exist or is not active"))
+		return
+	}
+	if err := middlewares.CheckLoginThrottle(ctx, user.Username); err != nil {
+		ctx.JSON(429, BaseJSONResponse(429, err.Error()))
+		return
+	}
+	enrollOnly, err := middlewares.LoginSecondFactor(ctx, user, form.OTPCode)
+	if err != nil {
+		ctx.JSON(401, BaseJSONResponse(401, err.Error()))
+		return
+	}
+	ctl.loginSucceeded(ctx, user, enrollOnly, "")
+}
+
+// loginSucceeded respond jwt of user, which only allows enrollment of two-factor authentication if enrollOnly,
+// redirect to next with jwt in url fragment if next is not empty
+func (ctl *OIDCController) loginSucceeded(ctx *gin.Context, user *models.UserProfile, enrollOnly bool, next string) {
+
+	var identity interface{} = user
+	if enrollOnly {
+		identity = middlewares.TwoFactorEnrollIdentity(user)
+	}
+	jwtToken, expire, err := ctl.tokenGenerator(identity)
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+
+	if next != "" {
+		ctx.Redirect(http.StatusFound, next+"#jwt="+url.QueryEscape(jwtToken))
+		return
+	}
+	ctx.JSON(200, &OIDCLoginJSON{
+		BaseJSON: *BaseJSONResponse(200, "ok"),
+		Token:    jwtToken,
+		Expire:   expire.Format(time.RFC3339),
+	})
+}
+
+// claimString return string claim or ""
+func claimString(claims jwt.MapClaims, name string) string {
+
+	s, _ := claims[name].(string)
+	return s
+}
+
+// claimStrings return string array claim, a single string is treated as an array of one element
+func claimStrings(claims jwt.MapClaims, name string) []string {
+
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		a := []string{}
+		for _, i := range v {
+			if s, ok := i.(string); ok {
+				a = append(a, s)
+			}
+		}
+		return a
+	}
+	return nil
+}
+
+// OIDCUserFromClaims map id token claims to user, the user is matched by claim "sub", or by username for users
+// not logged in by OIDC before, who are linked to the subject if checkOIDCLink allows; the user is created
+// if not exists and "auto_create" is true. If "groups_claim" is configured, roles mapped by "group_roles" are
+// synced from groups at every login, other roles of user are kept
+func OIDCUserFromClaims(claims jwt.MapClaims, c *config.OIDCConfig) (*models.UserProfile, error) {
+
+	usernameClaim := c.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "email"
+	}
+	username := claimString(claims, usernameClaim)
+	if username == "" {
+		return nil, errors.New("id token has no claim " + usernameClaim)
+	}
+	// email is taken as username only if the IdP verified it
+	if verified, _ := claims["email_verified"].(bool); !verified && usernameClaim == "email" {
+		return nil, errors.New("email is not verified")
+	}
+	sub := claimString(claims, "sub")
+	if sub == "" {
+		return nil, errors.New("id token has no claim sub")
+	}
+
+	um := models.NewUserManager()
+	user, err := um.GetUserByOIDCSubject(sub)
+	if err == nil && user == nil {
+		if user, err = um.GetUserByName(username); err == nil && user != nil {
+			if err := checkOIDCLink(user, c); err != nil {
+				return nil, err
+			}
+			user.OIDCSubject = sub
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		if !c.AutoCreate {
+			return nil, errors.New("user does not exist")
+		}
+		user = models.NewUserProfile()
+		user.Username = username
+		user.Email = claimString(claims, "email")
+		user.FirstName = truncateString(claimString(claims, "given_name"), 30)
+		user.LastName = truncateString(claimString(claims, "family_name"), 150)
+		user.IsActive = true
+		user.EmailVerified = true
+		user.OIDCSubject = sub
+		user.SetPassword("") // login by IdP only
+	} else if !user.IsActived() {
+		return nil, errors.New("user is not active")
+	}
+
+	if c.GroupsClaim != "" {
+		managed, granted := models.RoleNormal, models.RoleNormal
+		for _, name := range c.GroupRoles {
+			managed |= oidcRoles[name]
+		}
+		for _, group := range claimStrings(claims, c.GroupsClaim) {
+			// viper lowercases map keys
+			if name, ok := c.GroupRoles[strings.ToLower(group)]; ok {
+				granted |= oidcRoles[name]
+			}
+		}
+		user.SetRole(models.TypeRole(user.Role)&^managed | granted)
+	}
+
+	user.LastLogin = models.JSONTimeNow()
+	if err := um.SaveUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// checkOIDCLink return error if existing user of the same username can not be linked to a subject of IdP:
+// it is linked to another subject, or unless "link_existing" is true, it has a local password, or it may be
+// a LDAP user while LDAP backend is enabled, so that an IdP user is not given an account of someone else
+func checkOIDCLink(user *models.UserProfile, c *config.OIDCConfig) error {
+
+	if user.OIDCSubject != "" {
+		return errors.New("user is linked to another OIDC subject")
+	}
+	if c.LinkExisting {
+		return nil
+	}
+	if user.HasUsablePassword() {
+		return errors.New("user has a local password, it is not linked to OIDC unless \"link_existing\" is true")
+	}
+	for _, backend := range config.GetConfigs().AuthBackends {
+		if backend == "ldap" {
+			return errors.New("user may be a LDAP user, it is not linked to OIDC unless \"link_existing\" is true")
+		}
+	}
+	return nil
+}
+
+// truncateString truncate s to at most n characters
+func truncateString(s string, n int) string {
+
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
@@ -0,0 +1,50 @@
+package controllers_test
+
+import (
+	"harbor/config"
+	"harbor/controllers"
+	"harbor/internal/testdb"
+	"harbor/models"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestOIDCUserFromClaims(t *testing.T) {
+
+	testdb.Setup(t)
+	c := &config.OIDCConfig{AutoCreate: true}
+	if _, err := controllers.OIDCUserFromClaims(jwt.MapClaims{"sub": "s1", "email": "new@example.com"}, c); err == nil {
+		t.Error("email without claim email_verified should not be username")
+	}
+	claims := jwt.MapClaims{"sub": "s1", "email": "new@example.com", "email_verified": true}
+	user, err := controllers.OIDCUserFromClaims(claims, c)
+	if err != nil || user.Username != "new@example.com" || user.OIDCSubject != "s1" {
+		t.Fatalf("user should be created, got %+v %v", user, err)
+	}
+	// matched by subject after email changed
+	claims["email"] = "renamed@example.com"
+	if got, err := controllers.OIDCUserFromClaims(claims, c); err != nil || got.ID != user.ID {
+		t.Errorf("user should be matched by subject, got %+v %v", got, err)
+	}
+
+	admin := models.NewUserProfile()
+	admin.Username, admin.IsActive = "admin@example.com", true
+	admin.SetRole(models.RoleStaffSuperUser)
+	admin.SetPassword("local-password")
+	if err := models.NewUserManager().SaveUser(admin); err != nil {
+		t.Fatal(err)
+	}
+	claims = jwt.MapClaims{"sub": "s2", "email": "admin@example.com", "email_verified": true}
+	if _, err := controllers.OIDCUserFromClaims(claims, c); err == nil {
+		t.Error("user with local password should not be linked")
+	}
+	c.LinkExisting = true
+	if got, err := controllers.OIDCUserFromClaims(claims, c); err != nil || got.ID != admin.ID {
+		t.Fatalf("user should be linked by \"link_existing\", got %+v %v", got, err)
+	}
+	claims["sub"] = "s3"
+	if _, err := controllers.OIDCUserFromClaims(claims, c); err == nil {
+		t.Error("user linked to another subject should not be linked again")
+	}
+}
@@ -0,0 +1,310 @@
+package controllers
+
+import (
+	"harbor/config"
+	"harbor/middlewares"
+	"harbor/models"
+	"harbor/utils/totp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TwoFactorController TOTP两步验证控制器结构
+type TwoFactorController struct {
+	Controller
+}
+
+// NewTwoFactorController new controller
+func NewTwoFactorController() *TwoFactorController {
+	return &TwoFactorController{}
+}
+
+// Init 初始化this，子类要重写此方法
+func (ctl *TwoFactorController) Init() ControllerInterface {
+
+	ctl.this = ctl
+	return ctl
+}
+
+// GetPermissions return permission
+func (ctl TwoFactorController) GetPermissions(ctx *gin.Context) []PermissionFunc {
+
+	return []PermissionFunc{IsAuthenticatedUser}
+}
+
+// TwoFactorStatusJSON two-factor authentication status
+type TwoFactorStatusJSON struct {
+	BaseJSON
+	Enabled           bool `json:"enabled"`
+	Required          bool `json:"required"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// TwoFactorEnrollJSON enrollment secret and provisioning uri
+type TwoFactorEnrollJSON struct {
+	BaseJSON
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// TwoFactorRecoveryCodesJSON recovery codes, only shown once
+type TwoFactorRecoveryCodesJSON struct {
+	BaseJSON
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TwoFactorCodeForm otp code form
+type TwoFactorCodeForm struct {
+	OTPCode string `json:"otp_code" form:"otp_code" binding:"required"`
+}
+
+// currentUser return current user from db, user in jwt has only a few fields
+func (ctl TwoFactorController) currentUser(ctx *gin.Context) *models.UserProfile {
+
+	user, err := models.NewUserManager().GetUserByID(ctl.user.ID)
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return nil
+	}
+	if user == nil {
+		ctx.JSON(404, BaseJSONResponse(404, "user not found"))
+		return nil
+	}
+	return user
+}
+
+// Get handler for get method
+// @Summary 获取当前用户两步验证状态
+// @Description 是否已开启两步验证，是否被要求开启，剩余恢复码数量
+// @Tags 2fa 两步验证
+// @Produce json
+// @Success 200 {object} controllers.TwoFactorStatusJSON
+// @Failure 401 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/2fa/ [get]
+func (ctl TwoFactorController) Get(ctx *gin.Context) {
+
+	user := ctl.currentUser(ctx)
+	if user == nil {
+		return
+	}
+	t, err := middlewares.GetConfirmedTOTP(user)
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+
+	data := TwoFactorStatusJSON{
+		BaseJSON: *BaseJSONResponse(200, "ok"),
+		Enabled:  t != nil,
+		Required: middlewares.TwoFactorEnrollRequired(user, false),
+	}
+	if t != nil {
+		data.RecoveryCodesLeft = t.RecoveryCodesLeft()
+	}
+	ctx.JSON(200, data)
+}
+
+// Post handler for post method
+// @Summary 开始绑定两步验证
+// @Description 生成新的TOTP密钥，返回密钥和otpauth URI，用身份验证器APP扫描URI的二维码添加账户；
+// @Description 然后用PUT方法提交验证码完成绑定，未完成绑定前重复请求会生成新的密钥
+// @Tags 2fa 两步验证
+// @Produce json
+// @Success 201 {object} controllers.TwoFactorEnrollJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/2fa/ [post]
+func (ctl TwoFactorController) Post(ctx *gin.Context) {
+
+	user := ctl.currentUser(ctx)
+	if user == nil {
+		return
+	}
+	m := models.NewTOTPManager(user)
+	t, err := m.GetTOTP()
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	if t != nil && t.Confirmed {
+		ctx.JSON(400, BaseJSONResponse(400, "two-factor authentication is already enabled"))
+		return
+	}
+
+	secret := totp.GenerateSecret()
+	if t == nil {
+		t = models.NewUserTOTP(user, secret)
+	} else {
+		t.Secret = secret
+		t.Created = models.JSONTimeNow()
+	}
+	if err := m.SaveTOTP(t); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+
+	issuer := config.GetConfigs().TwoFactor.Issuer
+	if issuer == "" {
+		issuer = "EVHarbor"
+	}
+	ctx.JSON(201, TwoFactorEnrollJSON{
+		BaseJSON:        *BaseJSONResponse(201, "ok"),
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(secret, issuer, user.Username),
+	})
+}
+
+// Put handler for put method
+// @Summary 完成绑定两步验证
+// @Description 提交身份验证器APP显示的验证码完成绑定，返回一次性恢复码(只显示这一次，请妥善保存)；
+// @Description 被要求绑定两步验证的用户，完成绑定后需要重新登录
+// @Tags 2fa 两步验证
+// @Accept json
+// @Produce json
+// @Param   data body controllers.TwoFactorCodeForm true "otp code"
+// @Success 200 {object} controllers.TwoFactorRecoveryCodesJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/2fa/ [put]
+func (ctl TwoFactorController) Put(ctx *gin.Context) {
+
+	form := TwoFactorCodeForm{}
+	if err := ctx.ShouldBind(&form); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+	user := ctl.currentUser(ctx)
+	if user == nil {
+		return
+	}
+	m := models.NewTOTPManager(user)
+	t, err := m.GetTOTP()
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	if t == nil {
+		ctx.JSON(400, BaseJSONResponse(400, "two-factor authentication enrollment is not started"))
+		return
+	}
+	if t.Confirmed {
+		ctx.JSON(400, BaseJSONResponse(400, "two-factor authentication is already enabled"))
+		return
+	}
+	if err := middlewares.VerifyOTP(t, form.OTPCode); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+
+	t.Confirmed = true
+	codes := t.GenerateRecoveryCodes()
+	if err := m.SaveRecoveryCodes(t); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, TwoFactorRecoveryCodesJSON{
+		BaseJSON:      *BaseJSONResponse(200, "ok"),
+		RecoveryCodes: codes,
+	})
+}
+
+// Patch handler for patch method
+// @Summary 重新生成恢复码
+// @Description 需要提交验证码，旧的恢复码全部失效
+// @Tags 2fa 两步验证
+// @Accept json
+// @Produce json
+// @Param   data body controllers.TwoFactorCodeForm true "otp code"
+// @Success 200 {object} controllers.TwoFactorRecoveryCodesJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/2fa/ [patch]
+func (ctl TwoFactorController) Patch(ctx *gin.Context) {
+
+	form := TwoFactorCodeForm{}
+	if err := ctx.ShouldBind(&form); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+	user := ctl.currentUser(ctx)
+	if user == nil {
+		return
+	}
+	t, err := middlewares.GetConfirmedTOTP(user)
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	if t == nil {
+		ctx.JSON(400, BaseJSONResponse(400, "two-factor authentication is not enabled"))
+		return
+	}
+	if err := middlewares.VerifyOTP(t, form.OTPCode); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+
+	codes := t.GenerateRecoveryCodes()
+	if err := models.NewTOTPManager(user).SaveRecoveryCodes(t); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, TwoFactorRecoveryCodesJSON{
+		BaseJSON:      *BaseJSONResponse(200, "ok"),
+		RecoveryCodes: codes,
+	})
+}
+
+// Delete handler for delete method
+// @Summary 关闭两步验证
+// @Description 需要提交验证码或恢复码；被要求两步验证的职员或超级用户不能关闭
+// @Tags 2fa 两步验证
+// @Accept json
+// @Produce json
+// @Param   data body controllers.TwoFactorCodeForm true "otp code"
+// @Success 204 {string} string "No content"
+// @Failure 400 {object} controllers.BaseJSON
+// @Failure 403 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/2fa/ [delete]
+func (ctl TwoFactorController) Delete(ctx *gin.Context) {
+
+	form := TwoFactorCodeForm{}
+	if err := ctx.ShouldBind(&form); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+	user := ctl.currentUser(ctx)
+	if user == nil {
+		return
+	}
+	if middlewares.TwoFactorEnrollRequired(user, false) {
+		ctx.JSON(403, BaseJSONResponse(403, "two-factor authentication is required for staff and super users"))
+		return
+	}
+	t, err := middlewares.GetConfirmedTOTP(user)
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	if t == nil {
+		ctx.JSON(400, BaseJSONResponse(400, "two-factor authentication is not enabled"))
+		return
+	}
+	if err := middlewares.VerifyOTP(t, form.OTPCode); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+
+	if err := models.NewTOTPManager(user).DeleteTOTP(t); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	ctx.JSON(204, nil)
+}
@@ -1,7 +1,9 @@
 package controllers
 
 import (
+	"errors"
 	"harbor/database"
+	"harbor/middlewares"
 	"harbor/models"
 	"harbor/utils/paginations"
 	"net/http"
@@ -112,6 +114,11 @@ func (f UserPostForm) validate() error {
 	return nil
 }
 
+type userPostJSON struct {
+	BaseJSON
+	EmailSent bool `json:"email_sent"` // false if sending verification email failed, it can be sent again later
+}
+
 // Post controller
 // @Summary 创建用户
 // @Description 用户名必须是邮箱
@@ -120,7 +127,7 @@ func (f UserPostForm) validate() error {
 // @Accept  json
 // @Produce  json
 // @Param   user     body    controllers.UserPostForm     true        "用户名"
-// @Success 201 {object} controllers.BaseJSON
+// @Success 201 {object} controllers.userPostJSON
 // @Failure 400 {object} controllers.BaseJSON
 // @Failure 404 {object} controllers.BaseJSON
 // @Security BasicAuth
@@ -134,16 +141,31 @@ func (ctl UserController) Post(ctx *gin.Context) {
 		return
 	}
 
-	user := models.UserProfile{}
+	user := &models.UserProfile{}
+	if code, err := createUser(&form, user); err != nil {
+		ctx.JSON(code, BaseJSONResponse(uint(code), err.Error()))
+		return
+	}
+	if !sendUserVerifyEmail(ctx, user) {
+		ctx.JSON(201, userPostJSON{BaseJSON: *BaseJSONResponse(201, "用户创建成功，但发送验证邮件失败，请稍后重新发送验证邮件")})
+		return
+	}
+	ctx.JSON(201, userPostJSON{BaseJSON: *BaseJSONResponse(201, "用户创建成功，请查收邮件激活账户"), EmailSent: true})
+}
+
+// createUser save a inactive user of form into user, a user with the same username not verified is overwritten
+func createUser(form *UserPostForm, user *models.UserProfile) (code int, err error) {
+
 	db := database.GetDBDefault()
-	r := db.Where("username = ?", form.Username).First(&user)
+	r := db.Where("username = ?", form.Username).First(user)
 	if r.Error == nil {
-		if user.IsActived() {
-			ctx.JSON(400, BaseJSONResponse(400, "user already exists"))
-			return
+		if user.IsActived() || user.EmailVerified {
+			return 400, errors.New("user already exists")
 		}
 	} else if r.RecordNotFound() {
 		user.IsActive = false
+	} else {
+		return 500, r.Error
 	}
 
 	user.Username = form.Username
@@ -154,36 +176,65 @@ func (ctl UserController) Post(ctx *gin.Context) {
 	user.Telephone = form.Telephone
 	user.DateJoined = models.JSONTimeNow()
 	user.SetPassword(form.Password)
-	if r := db.Save(&user); r.RowsAffected == 1 && r.Error == nil {
-		ctx.JSON(201, BaseJSONResponse(201, "用户创建成功"))
-		return
+	if r := db.Save(user); r.RowsAffected != 1 || r.Error != nil {
+		return 500, errors.New("用户创建失败")
+	}
+	return 201, nil
+}
+
+// sendUserVerifyEmail send email verification link to user created, return false if sending failed;
+// the user is kept and the email can be sent again, the error is logged
+func sendUserVerifyEmail(ctx *gin.Context, user *models.UserProfile) bool {
+
+	if err := SendVerifyEmail(ctx, user); err != nil {
+		middlewares.GetLogger(ctx).WithError(err).WithField("user", user.Username).Error("send verification email failed")
+		return false
 	}
-	ctx.JSON(500, BaseJSONResponse(500, "用户创建失败"))
+	return true
 }
 
 // UserRegister 注册用户
-// @Description 注册用户
+// @Summary 注册用户
+// @Description GET显示注册页面，POST提交注册表单；注册后需要点击验证邮件中的链接激活账户
 // @Tags Register 注册
-// @Accept  json
-// @Produce  json
-// @Param   some_id     path    string     true        "Some ID"
-// @Param   offset     query    int     true        "The initial index from which to return the results"
-// @Param   limit      query    int     true        "Number of results to return per page"
-// @Success 200 {object} controllers.BaseJSON "ok"
-// @Failure 400 {object} controllers.BaseJSON
-// @Failure 404 {object} controllers.BaseJSON
+// @Accept  x-www-form-urlencoded
+// @Produce  html
+// @Param   user     body    controllers.UserPostForm     true        "用户信息"
+// @Success 200 {string} string "html"
+// @Failure 400 {string} string "html"
 // @Router /user/register/ [post]
 func UserRegister(ctx *gin.Context) {
 
 	if strings.ToUpper(ctx.Request.Method) == "GET" {
 		ctx.HTML(http.StatusOK, "users/register.tmpl", gin.H{})
+		return
 	}
 
-	username := ctx.PostForm("username")
-	password := ctx.PostForm("password")
-	ctx.JSON(200, gin.H{
-		"username": username,
-		"password": password,
+	form := UserPostForm{}
+	err := form.isValid(ctx)
+	if err == nil && form.Password != ctx.PostForm("confirm_password") {
+		err = errors.New("两次输入的密码不一致")
+	}
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "users/register.tmpl", gin.H{"error": err.Error()})
+		return
+	}
+
+	user := &models.UserProfile{}
+	if code, err := createUser(&form, user); err != nil {
+		ctx.HTML(code, "users/register.tmpl", gin.H{"error": err.Error()})
+		return
+	}
+	if !sendUserVerifyEmail(ctx, user) {
+		ctx.HTML(http.StatusOK, "users/message.tmpl", gin.H{
+			"title":   "注册成功",
+			"message": "发送验证邮件失败，请稍后重新发送验证邮件激活账户",
+		})
+		return
+	}
+	ctx.HTML(http.StatusOK, "users/message.tmpl", gin.H{
+		"title":   "注册成功",
+		"message": "验证邮件已发送到 " + form.Username + "，请点击邮件中的链接激活账户",
 	})
 }
 
@@ -293,30 +344,53 @@ func (f UserPatchForm) validate() error {
 	return nil
 }
 
-func (f UserPatchForm) updateUser(user *models.UserProfile) error {
+// updateUser update user, return changed fields with old and new values, the password is not included
+func (f UserPatchForm) updateUser(user *models.UserProfile) (map[string]interface{}, error) {
 
-	if f.Company != "" {
-		user.Company = f.Company
-	}
-	if f.FirstName != "" {
-		user.FirstName = f.FirstName
-	}
-	if f.LastName != "" {
-		user.LastName = f.LastName
-	}
-	if f.Telephone != "" {
-		user.Telephone = f.Telephone
+	changes := map[string]interface{}{}
+	set := func(name string, field *string, value string) {
+		if value != "" && value != *field {
+			changes[name] = gin.H{"old": *field, "new": value}
+			*field = value
+		}
 	}
+	set("company", &user.Company, f.Company)
+	set("first_name", &user.FirstName, f.FirstName)
+	set("last_name", &user.LastName, f.LastName)
+	set("telephone", &user.Telephone, f.Telephone)
 	if f.Password != "" {
 		user.SetPassword(f.Password)
+		changes["password"] = gin.H{"changed": true}
 	}
 
 	db := database.GetDBDefault()
 	if r := db.Save(user); r.Error != nil {
-		return r.Error
+		return nil, r.Error
 	}
 
-	return nil
+	return changes, nil
+}
+
+// roleNames return names of roles for audit events
+func roleNames(role int16) []string {
+
+	names := []string{}
+	for _, r := range []struct {
+		role models.TypeRole
+		name string
+	}{
+		{models.RoleSuperUser, "superuser"},
+		{models.RoleAppSuperUser, "app_superuser"},
+		{models.RoleStaff, "staff"},
+	} {
+		if role&r.role.Value() != 0 {
+			names = append(names, r.name)
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, "normal")
+	}
+	return names
 }
 
 // Patch handler for get method
@@ -337,6 +411,7 @@ func (f UserPatchForm) updateUser(user *models.UserProfile) error {
 // @Router /api/v1/users/{id} [patch]
 func (ctl UserDetailController) Patch(ctx *gin.Context) {
 
+	ae := audit(ctx, "user.update", "user", ctx.Param("id"))
 	form := UserPatchForm{}
 	if err := form.isValid(ctx); err != nil {
 		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
@@ -366,10 +441,13 @@ func (ctl UserDetailController) Patch(ctx *gin.Context) {
 		(IsSuperUser(user) && u.IsNormalUser()) ||
 		// 修改当前用户自己
 		(user.ID == u.ID) {
-		if err := form.updateUser(&u); err != nil {
+		ae.AddDetail("username", u.Username).AddDetail("old_role", roleNames(u.Role))
+		changes, err := form.updateUser(&u)
+		if err != nil {
 			ctx.JSON(500, BaseJSONResponse(500, err.Error()))
 			return
 		}
+		ae.AddDetail("changes", changes).AddDetail("new_role", roleNames(u.Role))
 		ctx.JSON(200, BaseJSONResponse(200, "ok"))
 		return
 	}
@@ -393,6 +471,7 @@ func (ctl UserDetailController) Patch(ctx *gin.Context) {
 // @Router /api/v1/users/{id} [delete]
 func (ctl UserDetailController) Delete(ctx *gin.Context) {
 
+	ae := audit(ctx, "user.delete", "user", ctx.Param("id"))
 	id := ctl.GetParamID(ctx)
 	if id == 0 {
 		ctx.JSON(400, BaseJSONResponse(400, "invalid param id"))
@@ -409,10 +488,13 @@ func (ctl UserDetailController) Delete(ctx *gin.Context) {
 		ctx.JSON(500, BaseJSONResponse(500, r.Error.Error()))
 		return
 	}
-	// 改为非激活用户
+	ae.AddDetail("username", u.Username)
+	// 改为非激活用户，不能再通过邮箱验证激活
 	if u.IsActived() {
 		// u.IsActive = false
-		if err := db.Table(u.TableName()).Where("id = ?", u.ID).Update("is_active", "false").Error; err != nil {
+		if err := db.Table(u.TableName()).Where("id = ?", u.ID).Updates(map[string]interface{}{
+			"is_active": false, "email_verified": true,
+		}).Error; err != nil {
 			ctx.JSON(500, BaseJSONResponse(500, err.Error()))
 			return
 		}
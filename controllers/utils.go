@@ -3,7 +3,10 @@ package controllers
 import (
 	"errors"
 	"fmt"
+	"harbor/middlewares"
 	"harbor/models"
+	"harbor/utils/metrics"
+	"io"
 	"strconv"
 	"strings"
 
@@ -234,3 +237,33 @@ func URLPathJoin(slice []string) string {
 	}
 	return strings.Join(a, "/")
 }
+
+// countWriter count bytes written
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// streamObject stream object data of bucket to response by step write function,
+// limited by the download rate limits and counted in metrics
+func streamObject(ctx *gin.Context, bucketName string, step func(io.Writer) bool) {
+
+	metrics.ActiveDownloads.Inc()
+	defer metrics.ActiveDownloads.Dec()
+
+	throttled := middlewares.ThrottleStepWrite(ctx, step)
+	counter := metrics.TransferBytes.WithLabelValues("download", metrics.BucketLabel(bucketName))
+	ctx.Stream(func(w io.Writer) bool {
+		cw := &countWriter{w: w}
+		more := throttled(cw)
+		counter.Add(float64(cw.n))
+		return more
+	})
+}
@@ -0,0 +1,685 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"harbor/config"
+	"harbor/middlewares"
+	"harbor/models"
+	"harbor/utils/logger"
+	"harbor/utils/paginations"
+	"harbor/utils/webhook"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// webhookDispatcher delivers events of webhooks, nil if webhooks are disabled
+var webhookDispatcher *webhook.Dispatcher
+
+// StartWebhooks start webhook delivery workers and resume the unfinished deliveries of last run
+func StartWebhooks() {
+
+	c := config.GetConfigs().Webhook
+	if c.Disabled {
+		return
+	}
+	if c.Workers <= 0 {
+		c.Workers = 4
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 1000
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 8
+	}
+	if c.BaseDelay <= 0 {
+		c.BaseDelay = 30 * time.Second
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = time.Hour
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	policy := webhook.RetryPolicy{MaxAttempts: c.MaxAttempts, BaseDelay: c.BaseDelay, MaxDelay: c.MaxDelay}
+	d := webhook.NewDispatcher(webhook.NewClient(c.Timeout, c.AllowPrivateNetworks), policy, c.QueueSize, saveDeliveryResult)
+	d.Start(c.Workers)
+	webhookDispatcher = d
+
+	if err := resumeDeliveries(d); err != nil {
+		webhookLog().WithError(err).Error("resume deliveries failed")
+	}
+}
+
+// StopWebhooks stop webhook delivery workers after the attempts in progress are done,
+// deliveries not finished are resumed at next start
+func StopWebhooks() {
+
+	if webhookDispatcher != nil {
+		webhookDispatcher.Stop()
+	}
+}
+
+// resumeDeliveries schedule deliveries which are not finished
+func resumeDeliveries(d *webhook.Dispatcher) error {
+
+	m := models.NewWebhookManager()
+	deliveries, err := m.GetUnfinishedDeliveries()
+	if err != nil {
+		return err
+	}
+	hooks := map[uint64]*models.Webhook{}
+	for _, dl := range deliveries {
+		hook, ok := hooks[dl.WebhookID]
+		if !ok {
+			if hook, err = m.GetWebhookByID(dl.WebhookID); err != nil {
+				return err
+			}
+			hooks[dl.WebhookID] = hook
+		}
+		if hook == nil || !hook.IsActive {
+			dl.Status = models.DeliveryDead
+			dl.Error = "webhook is deleted or inactive"
+			m.SaveDelivery(dl)
+			continue
+		}
+		d.Schedule(newDelivery(hook, dl), time.Until(dl.NextAttempt.Time))
+	}
+	return nil
+}
+
+func newDelivery(hook *models.Webhook, dl *models.WebhookDelivery) *webhook.Delivery {
+
+	return &webhook.Delivery{
+		ID:        dl.ID,
+		URL:       hook.URL,
+		Secret:    hook.Secret,
+		EventType: dl.EventType,
+		Body:      []byte(dl.Payload),
+		Attempts:  dl.Attempts,
+	}
+}
+
+// saveDeliveryResult update delivery record after a attempt
+func saveDeliveryResult(dl *webhook.Delivery, r webhook.Result) {
+
+	fields := map[string]interface{}{
+		"attempts":      dl.Attempts,
+		"response_code": r.StatusCode,
+		"duration_ms":   int64(r.Duration / time.Millisecond),
+		"last_attempt":  time.Now(),
+		"error":         "",
+	}
+	switch {
+	case r.Err == nil:
+		fields["status"] = models.DeliverySuccess
+	case r.Dead:
+		fields["status"] = models.DeliveryDead
+	default:
+		fields["status"] = models.DeliveryRetrying
+		fields["next_attempt"] = r.NextRetry
+	}
+	if r.Err != nil {
+		s := r.Err.Error()
+		if len(s) > 1024 {
+			s = s[:1024]
+		}
+		fields["error"] = s
+	}
+	if err := models.NewWebhookManager().UpdateDelivery(dl.ID, fields); err != nil {
+		webhookLog().WithError(err).WithField("delivery_id", dl.ID).Error("save delivery failed")
+	}
+}
+
+func webhookLog() *logrus.Entry {
+
+	return logger.Or(nil).WithField("component", "webhook")
+}
+
+// randomHex return n random bytes in hex
+func randomHex(n int) string {
+
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// notifyWebhooks send event to the webhooks of bucket subscribing it, path is object path in bucket;
+// it is called after the change is done, errors are only logged
+func notifyWebhooks(bucket *models.Bucket, eventType, path string, actor *models.UserProfile, detail map[string]interface{}) {
+
+	if webhookDispatcher == nil {
+		return
+	}
+	m := models.NewWebhookManager()
+	hooks, err := m.GetBucketWebhooks(bucket.ID)
+	if err != nil {
+		webhookLog().WithError(err).WithField("bucket_id", bucket.ID).Error("get webhooks of bucket failed")
+		return
+	}
+
+	path = ClearPath(path)
+	var payload []byte
+	event := webhook.Event{
+		ID:     randomHex(16),
+		Type:   eventType,
+		Time:   time.Now(),
+		Bucket: bucket.Name,
+		Path:   path,
+		Detail: detail,
+	}
+	if actor != nil {
+		event.Actor = actor.Username
+	}
+	for _, hook := range hooks {
+		if !hook.Match(eventType, path) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(event); err != nil {
+				webhookLog().WithError(err).Error("encode event failed")
+				return
+			}
+		}
+		now := models.JSONTimeNow()
+		dl := &models.WebhookDelivery{
+			WebhookID:   hook.ID,
+			EventID:     event.ID,
+			EventType:   eventType,
+			Payload:     string(payload),
+			Status:      models.DeliveryPending,
+			CreatedTime: now,
+			NextAttempt: now,
+		}
+		if err := m.CreateDelivery(dl); err != nil {
+			webhookLog().WithError(err).WithField("webhook_id", hook.ID).Error("save delivery failed")
+			continue
+		}
+		webhookDispatcher.Enqueue(newDelivery(hook, dl))
+	}
+}
+
+// WebhookController 事件通知webhook控制器结构
+type WebhookController struct {
+	Controller
+}
+
+// NewWebhookController new controller
+func NewWebhookController() *WebhookController {
+	return &WebhookController{}
+}
+
+// Init 初始化this，子类要重写此方法
+func (ctl *WebhookController) Init() ControllerInterface {
+
+	ctl.this = ctl
+	return ctl
+}
+
+// WebhookForm create or update webhook form, fields of nil are not changed when update
+type WebhookForm struct {
+	BucketID uint64    `json:"bucket_id" form:"bucket_id"`
+	URL      *string   `json:"url" form:"url"`
+	Events   *[]string `json:"events" form:"events"` // empty for all events
+	Prefix   *string   `json:"prefix" form:"prefix"` // object path prefix, empty for all objects
+	Secret   *string   `json:"secret" form:"secret"` // generated if empty when create
+	IsActive *bool     `json:"is_active" form:"is_active"`
+}
+
+func (f *WebhookForm) isValid(ctx *gin.Context) error {
+
+	if err := ctx.ShouldBind(f); err != nil {
+		return err
+	}
+	return f.validate()
+}
+
+func (f *WebhookForm) validate() error {
+
+	if f.URL != nil {
+		if err := webhook.CheckURL(*f.URL, config.GetConfigs().Webhook.AllowPrivateNetworks); err != nil {
+			return err
+		}
+		if len(*f.URL) > 2048 {
+			return errors.New("webhook url is too long")
+		}
+	}
+	if f.Events != nil {
+		for _, e := range *f.Events {
+			if !webhook.IsEventType(e) {
+				return fmt.Errorf("invalid event type '%s', should be one of %s", e, strings.Join(webhook.EventTypes, ", "))
+			}
+		}
+	}
+	if f.Prefix != nil {
+		p := ClearPath(*f.Prefix)
+		if len(p) > 1024 {
+			return errors.New("prefix is too long")
+		}
+		f.Prefix = &p
+	}
+	if f.Secret != nil && *f.Secret != "" && (len(*f.Secret) < 16 || len(*f.Secret) > 128) {
+		return errors.New("the length of secret should be 16-128")
+	}
+	return nil
+}
+
+// update set fields of webhook
+func (f *WebhookForm) update(hook *models.Webhook) {
+
+	if f.URL != nil {
+		hook.URL = *f.URL
+	}
+	if f.Events != nil {
+		hook.Events = *f.Events
+	}
+	if f.Prefix != nil {
+		hook.Prefix = *f.Prefix
+	}
+	if f.Secret != nil && *f.Secret != "" {
+		hook.Secret = *f.Secret
+	}
+	if f.IsActive != nil {
+		hook.IsActive = *f.IsActive
+	}
+}
+
+// WebhookListJSON webhooks list
+type WebhookListJSON struct {
+	BaseJSON
+	Count   uint              `json:"count"`
+	Next    string            `json:"next"`
+	Privous string            `json:"previous"`
+	Results []*models.Webhook `json:"results"`
+}
+
+// WebhookJSON webhook
+type WebhookJSON struct {
+	BaseJSON
+	Webhook *models.Webhook `json:"webhook"`
+	Secret  string          `json:"secret,omitempty"` // only returned when created
+}
+
+// Get handler for get method
+// @Summary 获取当前用户存储桶的webhook列表
+// @Description 通过query参数“offset”和“limit”分页，可以通过query参数“bucket_id”过滤
+// @Tags webhook 事件通知
+// @Produce json
+// @Param   bucket_id query int false "bucket id"
+// @Param   offset    query int false "The initial index from which to return the results"
+// @Param   limit     query int false "Number of results to return per page"
+// @Success 200 {object} controllers.WebhookListJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/webhooks/ [get]
+func (ctl WebhookController) Get(ctx *gin.Context) {
+
+	bucketID, err := GetIntParamOrDefault(ctx, "bucket_id", 0)
+	if err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, "invalid query param bucket_id"))
+		return
+	}
+
+	paginater := paginations.NewOptimizedLimitOffsetPagination()
+	if err := paginater.PrePaginate(ctx); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+	var hooks []*models.Webhook
+	dbQuery := models.NewWebhookManager().GetUserWebhooksQuery(ctl.user, uint64(bucketID))
+	if err := paginater.PaginateDBQuery(&hooks, dbQuery); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+
+	ctx.JSON(200, WebhookListJSON{
+		BaseJSON: *BaseJSONResponse(200, "ok"),
+		Count:    uint(paginater.GetCount()),
+		Results:  hooks,
+		Next:     paginater.GetNextURL(),
+		Privous:  paginater.GetPreviousURL(),
+	})
+}
+
+// Post handler for post method
+// @Summary 为存储桶创建一个webhook
+// @Description 存储桶内发生订阅的事件时，以POST方式异步发送JSON格式的事件到url，失败会以指数退避重试，多次失败后放弃；
+// @Description 事件类型：object.created, object.written, object.deleted, object.moved, object.shared, bucket.deleted，events为空时订阅所有事件；
+// @Description prefix为对象路径前缀，为空时订阅所有对象，不影响存储桶事件；
+// @Description 请求头“X-Harbor-Signature”为签名“sha256=hex(HMAC-SHA256(secret, X-Harbor-Timestamp + "." + body))”，
+// @Description secret未提交时自动生成，只在创建时返回一次
+// @Tags webhook 事件通知
+// @Accept json
+// @Produce json
+// @Param   data body controllers.WebhookForm true "bucket_id and url are required"
+// @Success 201 {object} controllers.WebhookJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Failure 404 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/webhooks/ [post]
+func (ctl WebhookController) Post(ctx *gin.Context) {
+
+	ae := audit(ctx, "webhook.create", "webhook", "")
+	form := WebhookForm{}
+	if err := form.isValid(ctx); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+	if form.BucketID == 0 || form.URL == nil {
+		ctx.JSON(400, BaseJSONResponse(400, "bucket_id and url are required"))
+		return
+	}
+	ae.AddDetail("bucket_id", form.BucketID).AddDetail("url", *form.URL)
+
+	bucket, err := models.NewBucketManager("", ctl.user).WithLogger(middlewares.GetLogger(ctx)).GetUserBucketByID(form.BucketID)
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	} else if bucket == nil {
+		ctx.JSON(404, BaseJSONResponse(404, "bucket not found"))
+		return
+	}
+
+	m := models.NewWebhookManager()
+	max := config.GetConfigs().Webhook.MaxPerBucket
+	if max <= 0 {
+		max = 10
+	}
+	if n, err := m.CountBucketWebhooks(bucket.ID); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	} else if n >= max {
+		ctx.JSON(400, BaseJSONResponse(400, fmt.Sprintf("a bucket can have at most %d webhooks", max)))
+		return
+	}
+
+	hook := &models.Webhook{
+		BucketID:    bucket.ID,
+		UserID:      ctl.user.ID,
+		Secret:      randomHex(20),
+		Events:      []string{},
+		IsActive:    true,
+		CreatedTime: models.JSONTimeNow(),
+	}
+	form.update(hook)
+	if err := m.SaveWebhook(hook); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	ae.Target = fmt.Sprint(hook.ID)
+
+	ctx.JSON(201, WebhookJSON{
+		BaseJSON: *BaseJSONResponse(201, "ok"),
+		Webhook:  hook,
+		Secret:   hook.Secret,
+	})
+}
+
+// getUserWebhookOrResponse return webhook of param id if it belongs to user's bucket
+// return:
+//		nil: error
+//		webhook: success
+func getUserWebhookOrResponse(ctx *gin.Context, id uint64, user *models.UserProfile) *models.Webhook {
+
+	if id == 0 {
+		ctx.JSON(400, BaseJSONResponse(400, "invalid param id"))
+		return nil
+	}
+	hook, err := models.NewWebhookManager().GetWebhookByID(id)
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return nil
+	}
+	if hook != nil {
+		bucket, err := models.NewBucketManager("", user).WithLogger(middlewares.GetLogger(ctx)).GetUserBucketByID(hook.BucketID)
+		if err != nil {
+			ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+			return nil
+		}
+		if bucket != nil {
+			return hook
+		}
+	}
+	ctx.JSON(404, BaseJSONResponse(404, "webhook not found"))
+	return nil
+}
+
+// WebhookDetailController 事件通知webhook详情控制器结构
+type WebhookDetailController struct {
+	Controller
+}
+
+// NewWebhookDetailController new controller
+func NewWebhookDetailController() *WebhookDetailController {
+	return &WebhookDetailController{}
+}
+
+// Init 初始化this，子类要重写此方法
+func (ctl *WebhookDetailController) Init() ControllerInterface {
+
+	ctl.this = ctl
+	return ctl
+}
+
+// Get handler for get method
+// @Summary 获取一个webhook
+// @Tags webhook 事件通知
+// @Produce json
+// @Param   id path int true "webhook id"
+// @Success 200 {object} controllers.WebhookJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Failure 404 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/webhooks/{id}/ [get]
+func (ctl WebhookDetailController) Get(ctx *gin.Context) {
+
+	hook := getUserWebhookOrResponse(ctx, ctl.GetParamID(ctx), ctl.user)
+	if hook == nil {
+		return
+	}
+	ctx.JSON(200, WebhookJSON{
+		BaseJSON: *BaseJSONResponse(200, "ok"),
+		Webhook:  hook,
+	})
+}
+
+// Patch handler for patch method
+// @Summary 修改一个webhook
+// @Description 只修改提交的字段，bucket_id无效；提交secret可以更换签名密钥
+// @Tags webhook 事件通知
+// @Accept json
+// @Produce json
+// @Param   id path int true "webhook id"
+// @Param   data body controllers.WebhookForm true "fields to update"
+// @Success 200 {object} controllers.WebhookJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Failure 404 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/webhooks/{id}/ [patch]
+func (ctl WebhookDetailController) Patch(ctx *gin.Context) {
+
+	ae := audit(ctx, "webhook.update", "webhook", ctx.Param("id"))
+	hook := getUserWebhookOrResponse(ctx, ctl.GetParamID(ctx), ctl.user)
+	if hook == nil {
+		return
+	}
+	form := WebhookForm{}
+	if err := form.isValid(ctx); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+	if form.URL != nil {
+		ae.AddDetail("url", *form.URL)
+	}
+	if form.IsActive != nil {
+		ae.AddDetail("is_active", *form.IsActive)
+	}
+	form.update(hook)
+	if err := models.NewWebhookManager().SaveWebhook(hook); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	ctx.JSON(200, WebhookJSON{
+		BaseJSON: *BaseJSONResponse(200, "ok"),
+		Webhook:  hook,
+	})
+}
+
+// Delete handler for delete method
+// @Summary 删除一个webhook
+// @Description 删除webhook及其发送记录
+// @Tags webhook 事件通知
+// @Param   id path int true "webhook id"
+// @Success 204 {string} string "No content"
+// @Failure 400 {object} controllers.BaseJSON
+// @Failure 404 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/webhooks/{id}/ [delete]
+func (ctl WebhookDetailController) Delete(ctx *gin.Context) {
+
+	audit(ctx, "webhook.delete", "webhook", ctx.Param("id"))
+	hook := getUserWebhookOrResponse(ctx, ctl.GetParamID(ctx), ctl.user)
+	if hook == nil {
+		return
+	}
+	if err := models.NewWebhookManager().DeleteWebhook(hook); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	ctx.JSON(204, nil)
+}
+
+// WebhookDeliveryController webhook发送记录控制器结构
+type WebhookDeliveryController struct {
+	Controller
+}
+
+// NewWebhookDeliveryController new controller
+func NewWebhookDeliveryController() *WebhookDeliveryController {
+	return &WebhookDeliveryController{}
+}
+
+// Init 初始化this，子类要重写此方法
+func (ctl *WebhookDeliveryController) Init() ControllerInterface {
+
+	ctl.this = ctl
+	return ctl
+}
+
+// WebhookDeliveryListJSON deliveries list
+type WebhookDeliveryListJSON struct {
+	BaseJSON
+	Count   uint                     `json:"count"`
+	Next    string                   `json:"next"`
+	Privous string                   `json:"previous"`
+	Results []models.WebhookDelivery `json:"results"`
+}
+
+// Get handler for get method
+// @Summary 获取webhook的发送记录
+// @Description 结果按时间倒序，通过query参数“offset”和“limit”分页；
+// @Description status: pending(等待发送), retrying(失败等待重试), success(成功), dead(多次失败已放弃)
+// @Tags webhook 事件通知
+// @Produce json
+// @Param   id     path  int    true  "webhook id"
+// @Param   status query string false "pending, retrying, success or dead"
+// @Param   offset query int    false "The initial index from which to return the results"
+// @Param   limit  query int    false "Number of results to return per page"
+// @Success 200 {object} controllers.WebhookDeliveryListJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Failure 404 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/webhooks/{id}/deliveries/ [get]
+func (ctl WebhookDeliveryController) Get(ctx *gin.Context) {
+
+	hook := getUserWebhookOrResponse(ctx, ctl.GetParamID(ctx), ctl.user)
+	if hook == nil {
+		return
+	}
+
+	paginater := paginations.NewOptimizedLimitOffsetPagination()
+	if err := paginater.PrePaginate(ctx); err != nil {
+		ctx.JSON(400, BaseJSONResponse(400, err.Error()))
+		return
+	}
+	var deliveries []models.WebhookDelivery
+	dbQuery := models.NewWebhookManager().GetDeliveriesQuery(hook.ID, ctx.Query("status"))
+	if err := paginater.PaginateDBQuery(&deliveries, dbQuery); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+
+	ctx.JSON(200, WebhookDeliveryListJSON{
+		BaseJSON: *BaseJSONResponse(200, "ok"),
+		Count:    uint(paginater.GetCount()),
+		Results:  deliveries,
+		Next:     paginater.GetNextURL(),
+		Privous:  paginater.GetPreviousURL(),
+	})
+}
+
+// Post handler for post method
+// @Summary 重新发送一个发送记录
+// @Description 重新发送已成功或已放弃(dead)的事件，使用webhook当前的url和secret，重试次数重新计算
+// @Tags webhook 事件通知
+// @Produce json
+// @Param   id          path  int true "webhook id"
+// @Param   delivery_id query int true "delivery id"
+// @Success 202 {object} controllers.BaseJSON
+// @Failure 400 {object} controllers.BaseJSON
+// @Failure 404 {object} controllers.BaseJSON
+// @Security BasicAuth
+// @Security ApiKeyAuth
+// @Router /api/v1/webhooks/{id}/deliveries/ [post]
+func (ctl WebhookDeliveryController) Post(ctx *gin.Context) {
+
+	hook := getUserWebhookOrResponse(ctx, ctl.GetParamID(ctx), ctl.user)
+	if hook == nil {
+		return
+	}
+	id, err := GetIntParamOrDefault(ctx, "delivery_id", 0)
+	if err != nil || id <= 0 {
+		ctx.JSON(400, BaseJSONResponse(400, "invalid query param delivery_id"))
+		return
+	}
+	if webhookDispatcher == nil {
+		ctx.JSON(400, BaseJSONResponse(400, "webhooks are disabled"))
+		return
+	}
+
+	m := models.NewWebhookManager()
+	dl, err := m.GetDelivery(hook.ID, uint64(id))
+	if err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	} else if dl == nil {
+		ctx.JSON(404, BaseJSONResponse(404, "delivery not found"))
+		return
+	}
+	if dl.Status == models.DeliveryPending || dl.Status == models.DeliveryRetrying {
+		ctx.JSON(400, BaseJSONResponse(400, "the delivery is in progress"))
+		return
+	}
+
+	dl.Status = models.DeliveryPending
+	dl.Attempts = 0
+	dl.NextAttempt = models.JSONTimeNow()
+	if err := m.SaveDelivery(dl); err != nil {
+		ctx.JSON(500, BaseJSONResponse(500, err.Error()))
+		return
+	}
+	webhookDispatcher.Enqueue(newDelivery(hook, dl))
+	ctx.JSON(202, BaseJSONResponse(202, "redelivery is queued"))
+}
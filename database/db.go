@@ -1,15 +1,31 @@
 package database
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"harbor/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/mysql"    // mysql driver
+	_ "github.com/jinzhu/gorm/dialects/postgres" // postgres driver
+	_ "github.com/jinzhu/gorm/dialects/sqlite"   // sqlite3 driver
 )
 
 var dbConnMap map[string]*gorm.DB
 
+// engines supported, names are the same as gorm dialects
+const (
+	EngineMySQL    = "mysql"
+	EnginePostgres = "postgres"
+	EngineSQLite   = "sqlite3"
+)
+
 // InitDatabase connect to database
 func InitDatabase() {
 	dbConnMap = make(map[string]*gorm.DB)
@@ -17,20 +33,204 @@ func InitDatabase() {
 	debug := configs.Debug
 	dbs := configs.Databases
 	for _, db := range dbs {
-		engine := db.Engine
-		var url string
-		if engine == "mysql" {
-			url = fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%s&parseTime=True&loc=Local", db.User, db.Password, db.Host, db.Port, db.Name, db.Charset)
-		}
-		dbConn, errConn := gorm.Open(engine, url)
+		dbConn, errConn := Open(db)
 		if errConn != nil {
 			panic("连接数据库失败:" + errConn.Error())
 		}
 		dbConn.LogMode(debug)
+		registerMetricsCallbacks(db.Alias, dbConn)
+		registerLogCallbacks(db.Alias, dbConn, configs.Log.SQL)
 		dbConnMap[db.Alias] = dbConn
 	}
 }
 
+// Open connect to database of config and apply connection pool settings
+func Open(c config.DBConfig) (*gorm.DB, error) {
+
+	url, err := DataSourceName(c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Engine == EngineSQLite && !isSQLiteMemory(c.Name) {
+		if err := os.MkdirAll(filepath.Dir(sqlitePath(c.Name)), 0755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := gorm.Open(c.Engine, url)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := db.DB()
+	maxOpen, maxIdle, lifetime := c.MaxOpenConns, c.MaxIdleConns, c.ConnMaxLifetime
+	if c.Engine == EngineSQLite {
+		// sqlite allows only one writer at a time, and each connection to ":memory:" opens a new database
+		if maxOpen <= 0 || isSQLiteMemory(c.Name) {
+			maxOpen = 1
+		}
+		if isSQLiteMemory(c.Name) {
+			maxIdle, lifetime = 1, 0
+		}
+	}
+	if maxOpen > 0 {
+		pool.SetMaxOpenConns(maxOpen)
+	}
+	if maxIdle != 0 {
+		pool.SetMaxIdleConns(maxIdle)
+	}
+	if lifetime > 0 {
+		pool.SetConnMaxLifetime(lifetime)
+	}
+	return db, nil
+}
+
+// DataSourceName return url for gorm.Open of database config
+func DataSourceName(c config.DBConfig) (string, error) {
+
+	switch c.Engine {
+	case EngineMySQL:
+		port, charset := c.Port, c.Charset
+		if port == 0 {
+			port = 3306
+		}
+		if charset == "" {
+			charset = "utf8"
+		}
+		return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%s&parseTime=True&loc=Local", c.User, c.Password, c.Host, port, c.Name, charset), nil
+	case EnginePostgres:
+		port, sslMode := c.Port, c.SSLMode
+		if port == 0 {
+			port = 5432
+		}
+		if sslMode == "" {
+			sslMode = "disable"
+		}
+		params := [][2]string{{"host", c.Host}, {"port", strconv.Itoa(port)}, {"user", c.User},
+			{"password", c.Password}, {"dbname", c.Name}, {"sslmode", sslMode}}
+		if c.Charset != "" {
+			params = append(params, [2]string{"client_encoding", c.Charset})
+		}
+		kvs := make([]string, 0, len(params))
+		for _, p := range params {
+			if p[1] != "" {
+				kvs = append(kvs, p[0]+"="+quotePostgresValue(p[1]))
+			}
+		}
+		return strings.Join(kvs, " "), nil
+	case EngineSQLite:
+		if isSQLiteMemory(c.Name) {
+			return ":memory:", nil
+		}
+		return "file:" + sqlitePath(c.Name) + "?_busy_timeout=5000", nil
+	default:
+		return "", fmt.Errorf("unsupported database engine '%s'", c.Engine)
+	}
+}
+
+// quotePostgresValue quote value of keyword/value connection string if needed
+func quotePostgresValue(v string) string {
+
+	if !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+func isSQLiteMemory(name string) bool {
+
+	return name == ":memory:"
+}
+
+// sqlitePath return path of sqlite database file, relative path is relative to BaseDir
+func sqlitePath(name string) string {
+
+	return config.GetConfigs().AbsPath(name)
+}
+
+// IsMySQL return true if db is a mysql database
+func IsMySQL(db *gorm.DB) bool {
+
+	return db.Dialect().GetName() == EngineMySQL
+}
+
+// IndexName return name of index on table, index names are unique per database in postgres and sqlite,
+// so the name is prefixed with table name, but not in mysql to keep the names of existing tables
+func IndexName(db *gorm.DB, table, name string) string {
+
+	if IsMySQL(db) {
+		return name
+	}
+	return table + "_" + name
+}
+
+// AddColumns add columns of model to table if they do not exist, types of the columns are the ones of model's fields
+func AddColumns(db *gorm.DB, table string, model interface{}, columns ...string) error {
+
+	scope := db.NewScope(model)
+	for _, name := range columns {
+		if db.Dialect().HasColumn(table, name) {
+			continue
+		}
+		field, ok := scope.FieldByName(name)
+		if !ok {
+			return fmt.Errorf("column '%s' is not a field of %T", name, model)
+		}
+		sql := fmt.Sprintf("ALTER TABLE %s ADD %s %s", scope.Quote(table), scope.Quote(name), db.Dialect().DataTypeOf(field.StructField))
+		if err := db.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropColumns drop columns of table if they exist, columns are kept in sqlite which can not drop columns
+func DropColumns(db *gorm.DB, table string, columns ...string) error {
+
+	if db.Dialect().GetName() == EngineSQLite {
+		return nil
+	}
+	for _, name := range columns {
+		if !db.Dialect().HasColumn(table, name) {
+			continue
+		}
+		if err := db.Table(table).DropColumn(name).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tables return names of tables in the current database or schema of db
+func Tables(db *gorm.DB) ([]string, error) {
+
+	var query string
+	switch name := db.Dialect().GetName(); name {
+	case EngineMySQL:
+		query = "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE'"
+	case EnginePostgres:
+		query = "SELECT tablename FROM pg_tables WHERE schemaname = CURRENT_SCHEMA()"
+	case EngineSQLite:
+		query = "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'"
+	default:
+		return nil, fmt.Errorf("unsupported database engine '%s'", name)
+	}
+
+	rows, err := db.Raw(query).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
 // GetDBDefault get database connect
 func GetDBDefault() *gorm.DB {
 	return dbConnMap["default"]
@@ -47,3 +247,46 @@ func GetDB(alias string) *gorm.DB {
 	}
 	return db
 }
+
+// Aliases return aliases of connected databases in order
+func Aliases() []string {
+
+	aliases := make([]string, 0, len(dbConnMap))
+	for alias := range dbConnMap {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// Ping check the connection of database alias
+func Ping(ctx context.Context, alias string) error {
+
+	db, ok := dbConnMap[alias]
+	if !ok {
+		return fmt.Errorf("DB with alias ‘%s’ does not exist", alias)
+	}
+	return db.DB().PingContext(ctx)
+}
+
+// Stats return connection pool stats of database alias
+func Stats(alias string) sql.DBStats {
+
+	db, ok := dbConnMap[alias]
+	if !ok {
+		return sql.DBStats{}
+	}
+	return db.DB().Stats()
+}
+
+// CloseAll close connection pools of all databases
+func CloseAll() error {
+
+	var firstErr error
+	for _, alias := range Aliases() {
+		if err := dbConnMap[alias].Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("close DB '%s': %s", alias, err)
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,34 @@
+package database_test
+
+import (
+	"harbor/config"
+	"harbor/database"
+	"testing"
+)
+
+func TestDataSourceName(t *testing.T) {
+
+	cases := []struct {
+		c    config.DBConfig
+		want string
+	}{
+		{config.DBConfig{Engine: "mysql", Host: "db", Name: "harbor", User: "u", Password: "p"},
+			"u:p@tcp(db:3306)/harbor?charset=utf8&parseTime=True&loc=Local"},
+		{config.DBConfig{Engine: "postgres", Host: "db", Name: "harbor", User: "u", Password: `it's a \secret`},
+			`host=db port=5432 user=u password='it\'s a \\secret' dbname=harbor sslmode=disable`},
+		{config.DBConfig{Engine: "postgres", Host: "db", Port: 6432, Name: "harbor", SSLMode: "require"},
+			"host=db port=6432 dbname=harbor sslmode=require"},
+		{config.DBConfig{Engine: "sqlite3", Name: ":memory:"}, ":memory:"},
+		{config.DBConfig{Engine: "sqlite3", Name: "/var/lib/harbor/harbor.db"}, "file:/var/lib/harbor/harbor.db?_busy_timeout=5000"},
+	}
+	for _, c := range cases {
+		got, err := database.DataSourceName(c.c)
+		if err != nil || got != c.want {
+			t.Errorf("%s: got %q %v, want %q", c.c.Engine, got, err, c.want)
+		}
+	}
+
+	if _, err := database.DataSourceName(config.DBConfig{Engine: "oracle"}); err == nil {
+		t.Errorf("unsupported engine should fail")
+	}
+}
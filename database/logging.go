@@ -0,0 +1,53 @@
+package database
+
+import (
+	"harbor/utils/logger"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// LoggerKey gorm setting key of logger, set by db.Set(LoggerKey, *logrus.Entry) so that queries are logged with its fields
+const LoggerKey = "harbor:logger"
+
+// registerLogCallbacks log sql statements at debug level if logSQL, and failed ones at error level,
+// with the fields of the logger set on db
+func registerLogCallbacks(alias string, db *gorm.DB, logSQL bool) {
+
+	after := func(operation string) func(*gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			err := scope.DB().Error
+			if err != nil && gorm.IsRecordNotFoundError(err) {
+				err = nil
+			}
+			if err == nil && !logSQL {
+				return
+			}
+
+			var l *logrus.Entry
+			if v, ok := scope.Get(LoggerKey); ok {
+				l, _ = v.(*logrus.Entry)
+			}
+			start := time.Now()
+			if v, ok := scope.Get(metricsStartKey); ok {
+				if t, ok := v.(time.Time); ok {
+					start = t
+				}
+			}
+			logger.Operation(l, "db", operation, start, err, logrus.Fields{
+				"database": alias,
+				"table":    scope.TableName(),
+				"sql":      scope.SQL,
+				"rows":     scope.DB().RowsAffected,
+			})
+		}
+	}
+
+	cb := db.Callback()
+	cb.Create().After("metrics:after_create").Register("log:after_create", after("create"))
+	cb.Update().After("metrics:after_update").Register("log:after_update", after("update"))
+	cb.Delete().After("metrics:after_delete").Register("log:after_delete", after("delete"))
+	cb.Query().After("metrics:after_query").Register("log:after_query", after("query"))
+	cb.RowQuery().After("metrics:after_row_query").Register("log:after_row_query", after("row_query"))
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"harbor/utils/metrics"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const metricsStartKey = "metrics:start_time"
+
+// registerMetricsCallbacks observe query latency and errors of gorm operations on db
+func registerMetricsCallbacks(alias string, db *gorm.DB) {
+
+	before := func(scope *gorm.Scope) {
+		scope.Set(metricsStartKey, time.Now())
+	}
+	after := func(operation string) func(*gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			v, ok := scope.Get(metricsStartKey)
+			if !ok {
+				return
+			}
+			start, ok := v.(time.Time)
+			if !ok {
+				return
+			}
+			metrics.DBQueryDuration.WithLabelValues(alias, operation).Observe(time.Since(start).Seconds())
+			if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+				metrics.DBQueryErrors.WithLabelValues(alias, operation).Inc()
+			}
+		}
+	}
+
+	cb := db.Callback()
+	cb.Create().Before("gorm:begin_transaction").Register("metrics:before_create", before)
+	cb.Create().After("gorm:commit_or_rollback_transaction").Register("metrics:after_create", after("create"))
+	cb.Update().Before("gorm:begin_transaction").Register("metrics:before_update", before)
+	cb.Update().After("gorm:commit_or_rollback_transaction").Register("metrics:after_update", after("update"))
+	cb.Delete().Before("gorm:begin_transaction").Register("metrics:before_delete", before)
+	cb.Delete().After("gorm:commit_or_rollback_transaction").Register("metrics:after_delete", after("delete"))
+	cb.Query().Before("gorm:query").Register("metrics:before_query", before)
+	cb.Query().After("gorm:after_query").Register("metrics:after_query", after("query"))
+	cb.RowQuery().Before("gorm:row_query").Register("metrics:before_row_query", before)
+	cb.RowQuery().After("gorm:row_query").Register("metrics:after_row_query", after("row_query"))
+}
@@ -0,0 +1,276 @@
+// Package migrate numbered schema migrations recorded in table schema_version.
+//
+// A migration changes database "default" by Up/Down, and each per-bucket object table in database "objs"
+// by UpTable/DownTable. The changes of a database are done in a transaction, then the version is recorded,
+// so a migration must be idempotent(e.g. check the column or index exists before adding it),
+// and it is safe to run again if it fails halfway.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrIrreversible returned by Down of a migration can not be reverted
+var ErrIrreversible = errors.New("migration is irreversible")
+
+// Migration a numbered schema change
+type Migration struct {
+	Version uint
+	Name    string
+
+	Up   func(db *gorm.DB) error // change database "default"
+	Down func(db *gorm.DB) error
+
+	UpTable   func(db *gorm.DB, table string) error // change a per-bucket object table in database "objs"
+	DownTable func(db *gorm.DB, table string) error
+}
+
+// SchemaVersion applied migration
+type SchemaVersion struct {
+	Version   uint      `gorm:"PRIMARY_KEY;AUTO_INCREMENT:false" json:"version"`
+	Name      string    `gorm:"type:varchar(255);not null" json:"name"`
+	AppliedAt time.Time `gorm:"column:applied_at;not null" json:"applied_at"`
+}
+
+// TableName return table name
+func (SchemaVersion) TableName() string {
+
+	return "schema_version"
+}
+
+// Status status of a migration
+type Status struct {
+	Version   uint
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator apply and revert migrations
+type Migrator struct {
+	db         *gorm.DB // database "default", schema_version is in it
+	objsDB     *gorm.DB // database "objs"
+	tables     func() ([]string, error)
+	migrations []Migration
+	log        *logrus.Entry
+}
+
+// NewMigrator return a migrator, tables return names of per-bucket object tables,
+// error if versions of migrations are not unique and positive
+func NewMigrator(db, objsDB *gorm.DB, tables func() ([]string, error), migrations []Migration, log *logrus.Entry) (*Migrator, error) {
+
+	ms := append([]Migration{}, migrations...)
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	for i, m := range ms {
+		if m.Version == 0 {
+			return nil, fmt.Errorf("migration '%s' has no version", m.Name)
+		}
+		if i > 0 && ms[i-1].Version == m.Version {
+			return nil, fmt.Errorf("migration version %d is duplicated", m.Version)
+		}
+	}
+	if log == nil {
+		log = logrus.NewEntry(logrus.StandardLogger())
+	}
+	return &Migrator{db: db, objsDB: objsDB, tables: tables, migrations: ms, log: log}, nil
+}
+
+// applied return applied versions
+func (m *Migrator) applied() (map[uint]SchemaVersion, error) {
+
+	if err := m.db.AutoMigrate(&SchemaVersion{}).Error; err != nil {
+		return nil, fmt.Errorf("create table schema_version: %s", err)
+	}
+	var vs []SchemaVersion
+	if err := m.db.Order("version").Find(&vs).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[uint]SchemaVersion, len(vs))
+	for _, v := range vs {
+		applied[v.Version] = v
+	}
+	return applied, nil
+}
+
+// Status return status of all migrations in order of version
+func (m *Migrator) Status() ([]Status, error) {
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	ss := make([]Status, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		s := Status{Version: mg.Version, Name: mg.Name}
+		if v, ok := applied[mg.Version]; ok {
+			s.Applied, s.AppliedAt = true, v.AppliedAt
+		}
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+// Pending return migrations not applied in order of version
+func (m *Migrator) Pending() ([]Migration, error) {
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, mg := range m.migrations {
+		if _, ok := applied[mg.Version]; !ok {
+			pending = append(pending, mg)
+		}
+	}
+	return pending, nil
+}
+
+// Up apply pending migrations whose version <= to in order, all pending ones if to is 0,
+// return versions applied
+func (m *Migrator) Up(to uint) ([]uint, error) {
+
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	var done []uint
+	for _, mg := range pending {
+		if to > 0 && mg.Version > to {
+			break
+		}
+		if err := m.up(mg); err != nil {
+			return done, fmt.Errorf("migration %d '%s': %s", mg.Version, mg.Name, err)
+		}
+		done = append(done, mg.Version)
+	}
+	return done, nil
+}
+
+// Down revert the last n applied migrations in reverse order of version, return versions reverted
+func (m *Migrator) Down(n int) ([]uint, error) {
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var done []uint
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < n; i-- {
+		mg := m.migrations[i]
+		if _, ok := applied[mg.Version]; !ok {
+			continue
+		}
+		if err := m.down(mg); err != nil {
+			return done, fmt.Errorf("migration %d '%s': %s", mg.Version, mg.Name, err)
+		}
+		done = append(done, mg.Version)
+	}
+	return done, nil
+}
+
+// PrepareTable apply UpTable of applied migrations to a new per-bucket object table,
+// so that it has the same schema as the existing ones
+func (m *Migrator) PrepareTable(table string) error {
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	return transaction(m.objsDB, func(tx *gorm.DB) error {
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Version]; ok && mg.UpTable != nil {
+				if err := mg.UpTable(tx, table); err != nil {
+					return fmt.Errorf("migration %d '%s' of table %s: %s", mg.Version, mg.Name, table, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (m *Migrator) up(mg Migration) error {
+
+	start := time.Now()
+	if mg.Up != nil {
+		if err := transaction(m.db, mg.Up); err != nil {
+			return err
+		}
+	}
+	n, err := m.eachTable(mg.UpTable)
+	if err != nil {
+		return err
+	}
+	v := SchemaVersion{Version: mg.Version, Name: mg.Name, AppliedAt: time.Now()}
+	if err := m.db.Create(&v).Error; err != nil {
+		return err
+	}
+	m.log.WithFields(logrus.Fields{"version": mg.Version, "name": mg.Name, "tables": n,
+		"duration": time.Since(start).String()}).Info("migration applied")
+	return nil
+}
+
+func (m *Migrator) down(mg Migration) error {
+
+	start := time.Now()
+	n, err := m.eachTable(mg.DownTable)
+	if err != nil {
+		return err
+	}
+	if mg.Down != nil {
+		if err := transaction(m.db, mg.Down); err != nil {
+			return err
+		}
+	}
+	if err := m.db.Where("version = ?", mg.Version).Delete(&SchemaVersion{}).Error; err != nil {
+		return err
+	}
+	m.log.WithFields(logrus.Fields{"version": mg.Version, "name": mg.Name, "tables": n,
+		"duration": time.Since(start).String()}).Info("migration reverted")
+	return nil
+}
+
+// eachTable call f with each existing per-bucket object table in a transaction, return number of tables
+func (m *Migrator) eachTable(f func(db *gorm.DB, table string) error) (int, error) {
+
+	if f == nil {
+		return 0, nil
+	}
+	tables, err := m.tables()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = transaction(m.objsDB, func(tx *gorm.DB) error {
+		for _, table := range tables {
+			if !tx.HasTable(table) {
+				continue
+			}
+			if err := f(tx, table); err != nil {
+				return fmt.Errorf("table %s: %s", table, err)
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// transaction call f in a transaction, commit if f return nil, otherwise rollback
+func transaction(db *gorm.DB, f func(tx *gorm.DB) error) error {
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,120 @@
+package migrate_test
+
+import (
+	"errors"
+	"harbor/database/migrate"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/sirupsen/logrus"
+)
+
+type item struct {
+	ID   uint
+	Name string
+}
+
+func openSQLite(t *testing.T) *gorm.DB {
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB().SetMaxOpenConns(1)
+	return db
+}
+
+func TestMigrator(t *testing.T) {
+
+	db, objsDB := openSQLite(t), openSQLite(t)
+	defer db.Close()
+	defer objsDB.Close()
+	objsDB.Exec("CREATE TABLE bucket_1 (id integer primary key)")
+	objsDB.Exec("CREATE TABLE bucket_2 (id integer primary key)")
+	tables := func() ([]string, error) { return []string{"bucket_1", "bucket_2", "bucket_missing"}, nil }
+
+	failing := true
+	migrations := []migrate.Migration{
+		{
+			Version: 2,
+			Name:    "add column",
+			UpTable: func(db *gorm.DB, table string) error {
+				if db.Dialect().HasColumn(table, "size") {
+					return nil
+				}
+				return db.Exec("ALTER TABLE " + table + " ADD COLUMN size integer").Error
+			},
+		},
+		{
+			Version: 1,
+			Name:    "items",
+			Up:      func(db *gorm.DB) error { return db.CreateTable(&item{}).Error },
+			Down:    func(db *gorm.DB) error { return db.DropTable(&item{}).Error },
+		},
+		{
+			Version: 3,
+			Name:    "failing",
+			Up: func(db *gorm.DB) error {
+				db.Create(&item{Name: "rolled back"})
+				if failing {
+					return errors.New("failed")
+				}
+				return nil
+			},
+		},
+	}
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	m, err := migrate.NewMigrator(db, objsDB, tables, migrations, logrus.NewEntry(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := m.Up(2)
+	if err != nil || len(done) != 2 || done[0] != 1 || done[1] != 2 {
+		t.Fatalf("migrations should be applied in order of version, got %v %v", done, err)
+	}
+	if !objsDB.Dialect().HasColumn("bucket_1", "size") || !objsDB.Dialect().HasColumn("bucket_2", "size") {
+		t.Errorf("table migration should be applied to all tables")
+	}
+
+	if _, err := m.Up(0); err == nil {
+		t.Fatal("failing migration should return error")
+	}
+	var count int
+	db.Model(&item{}).Count(&count)
+	if count != 0 {
+		t.Errorf("changes of failing migration should be rolled back")
+	}
+	pending, _ := m.Pending()
+	if len(pending) != 1 || pending[0].Version != 3 {
+		t.Errorf("failing migration should be pending, got %v", pending)
+	}
+
+	failing = false
+	if done, err := m.Up(0); err != nil || len(done) != 1 {
+		t.Fatalf("got %v %v", done, err)
+	}
+	ss, err := m.Status()
+	if err != nil || len(ss) != 3 || !ss[2].Applied {
+		t.Fatalf("all migrations should be applied, got %+v %v", ss, err)
+	}
+
+	objsDB.Exec("CREATE TABLE bucket_3 (id integer primary key)")
+	if err := m.PrepareTable("bucket_3"); err != nil || !objsDB.Dialect().HasColumn("bucket_3", "size") {
+		t.Errorf("applied table migrations should be applied to new table, %v", err)
+	}
+
+	if done, err := m.Down(3); err != nil || len(done) != 3 || done[0] != 3 {
+		t.Fatalf("migrations should be reverted in reverse order, got %v %v", done, err)
+	}
+	if db.HasTable(&item{}) {
+		t.Errorf("down migration should be applied")
+	}
+
+	if _, err := migrate.NewMigrator(db, objsDB, tables, append(migrations, migrate.Migration{Version: 1}), nil); err == nil {
+		t.Errorf("duplicated versions should fail")
+	}
+}
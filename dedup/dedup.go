@@ -0,0 +1,411 @@
+// Package dedup deduplication of object data by content. Data of objects not modified for a while is hashed,
+// and objects of the same content reference a blob of the data stored once by key "blob_{id}".
+// Blobs count their references, a blob is deleted and its data queued for garbage collection when the last
+// object referencing it is deleted or written.
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"harbor/config"
+	"harbor/database"
+	"harbor/models"
+	"harbor/utils/storages"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Result of deduplicating an object
+type Result int
+
+// results of Object
+const (
+	Skipped Result = iota // object is not deduplicated, it is modified, or the blob of its content is not complete
+	Shared                // object references an existing blob
+	Created               // a new blob is created from data of object
+)
+
+// Options of deduplicator, zero values are replaced by defaults
+type Options struct {
+	Interval  time.Duration // interval of deduplication, default 10m
+	Delay     time.Duration // objects modified within delay are not deduplicated, default 10m
+	MinSize   uint64        // smaller objects are not deduplicated, default 1MiB
+	BatchSize int           // objects read from a table at a time, default 100
+}
+
+func (o *Options) setDefaults() {
+
+	if o.Interval <= 0 {
+		o.Interval = 10 * time.Minute
+	}
+	if o.Delay <= 0 {
+		o.Delay = 10 * time.Minute
+	}
+	if o.MinSize == 0 {
+		o.MinSize = 1024 * 1024
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+}
+
+// Stats progress of deduplicator since it is created
+type Stats struct {
+	Running    bool       `json:"running"`
+	Shared     int64      `json:"shared"`      // objects referencing existing blobs
+	Created    int64      `json:"created"`     // blobs created
+	Skipped    int64      `json:"skipped"`     // objects modified during deduplication
+	Failed     int64      `json:"failed"`      // objects failed
+	SavedBytes uint64     `json:"saved_bytes"` // stored data of objects referencing existing blobs
+	LastRun    *time.Time `json:"last_run,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+// Deduplicator deduplicate objects of all buckets, run in background by Start or once by Run
+type Deduplicator struct {
+	opts Options
+	log  *logrus.Entry
+
+	mu    sync.Mutex // protect stats
+	stats Stats
+
+	runMu sync.Mutex // one run at a time
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// New return a deduplicator
+func New(opts Options, log *logrus.Entry) *Deduplicator {
+
+	opts.setDefaults()
+	return &Deduplicator{
+		opts: opts,
+		log:  log,
+		stop: make(chan struct{}),
+	}
+}
+
+// NewConfigured return a deduplicator of config "dedup"
+func NewConfigured(log *logrus.Entry) *Deduplicator {
+
+	c := config.GetConfigs().Dedup
+	return New(Options{
+		Interval:  c.Interval,
+		Delay:     c.Delay,
+		MinSize:   c.MinSize,
+		BatchSize: c.BatchSize,
+	}, log)
+}
+
+// Stats return progress of deduplicator
+func (d *Deduplicator) Stats() Stats {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
+
+// Start deduplicate objects every Interval in background
+func (d *Deduplicator) Start() {
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		t := time.NewTicker(d.opts.Interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-t.C:
+				if _, err := d.Run(); err != nil {
+					d.log.WithError(err).Error("deduplicate objects failed")
+				}
+			}
+		}
+	}()
+}
+
+// Stop background deduplication after the object in progress is done
+func (d *Deduplicator) Stop() {
+
+	close(d.stop)
+	d.wg.Wait()
+}
+
+func (d *Deduplicator) stopped() bool {
+
+	select {
+	case <-d.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run deduplicate objects of all buckets not modified within Delay, return the number of objects deduplicated;
+// failed objects are logged and tried again at next run
+func (d *Deduplicator) Run() (int, error) {
+
+	d.runMu.Lock()
+	defer d.runMu.Unlock()
+	d.mu.Lock()
+	d.stats.Running = true
+	d.mu.Unlock()
+
+	n, err := d.run()
+
+	now := time.Now()
+	d.mu.Lock()
+	d.stats.Running = false
+	d.stats.LastRun = &now
+	d.stats.LastError = ""
+	if err != nil {
+		d.stats.LastError = err.Error()
+	}
+	d.mu.Unlock()
+	return n, err
+}
+
+func (d *Deduplicator) run() (int, error) {
+
+	buckets, err := models.NewBucketManager("", nil).ListBuckets(nil, false)
+	if err != nil {
+		return 0, err
+	}
+	before := time.Now().Add(-d.opts.Delay)
+	db := database.GetDB("objs")
+	n := 0
+	for i := range buckets {
+		bucket := &buckets[i]
+		table := bucket.GetObjsTableName()
+		if !db.HasTable(table) {
+			continue
+		}
+		om := models.NewHarborObjectManager(table, "", "")
+		var lastID uint64
+		for {
+			objs, err := om.GetDedupCandidates(lastID, d.opts.MinSize, before, d.opts.BatchSize)
+			if err != nil {
+				return n, err
+			}
+			if len(objs) == 0 {
+				break
+			}
+			for _, obj := range objs {
+				if d.stopped() {
+					return n, nil
+				}
+				if d.object(bucket, obj, before) {
+					n++
+				}
+			}
+			lastID = objs[len(objs)-1].ID
+		}
+	}
+	return n, nil
+}
+
+// Object deduplicate obj in bucket now if it is not smaller than MinSize and not modified within Delay,
+// the result is not recorded in stats
+func (d *Deduplicator) Object(bucket *models.Bucket, obj *models.HarborObject, log *logrus.Entry) (Result, error) {
+
+	before := time.Now().Add(-d.opts.Delay)
+	if obj.Size < d.opts.MinSize || !obj.UpdateTime.Before(before) {
+		return Skipped, nil
+	}
+	return Object(bucket, obj, before, log)
+}
+
+// object deduplicate obj and record the result, return true if it is deduplicated
+func (d *Deduplicator) object(bucket *models.Bucket, obj *models.HarborObject, before time.Time) bool {
+
+	log := d.log.WithFields(logrus.Fields{"bucket": bucket.Name, "object": obj.ID})
+	storedSize := obj.StoredSize
+	r, err := Object(bucket, obj, before, log)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		d.stats.Failed++
+		log.WithError(err).Warn("deduplicate object failed")
+		return false
+	}
+	switch r {
+	case Shared:
+		d.stats.Shared++
+		d.stats.SavedBytes += storedSize
+		log.WithField("blob", obj.BlobID).Debug("object shares data of blob")
+	case Created:
+		d.stats.Created++
+		log.WithField("blob", obj.BlobID).Debug("blob created from object")
+	default:
+		d.stats.Skipped++
+	}
+	return r != Skipped
+}
+
+// Object deduplicate data of obj in bucket if the object is not modified since before: obj references the blob
+// of the same content, or a new blob created from its data, and its own data is deleted; obj is updated
+// if it is deduplicated. Encrypted objects are not deduplicated, as their data is unique.
+func Object(bucket *models.Bucket, obj *models.HarborObject, before time.Time, log *logrus.Entry) (Result, error) {
+
+	if !obj.IsFile() || obj.IsEncrypted() || obj.IsDeduplicated() || obj.Size == 0 {
+		return Skipped, nil
+	}
+	ownKey, compressed := obj.GetOwnObjKey(bucket), obj.IsCompressed()
+	hash, err := hashData(ownKey, obj, nil, log)
+	if err != nil {
+		return Skipped, err
+	}
+
+	bm := models.NewBlobManager()
+	blob, err := bm.GetBlobByHash(hash)
+	if err != nil {
+		return Skipped, err
+	}
+	result := Shared
+	if blob != nil {
+		ok, err := bm.AddBlobRef(blob)
+		if err != nil || !ok {
+			return Skipped, err
+		}
+	} else {
+		if blob, err = createBlob(ownKey, obj, hash, log); err != nil || blob == nil {
+			return Skipped, err
+		}
+		result = Created
+	}
+
+	om := models.NewHarborObjectManager(bucket.GetObjsTableName(), "", "")
+	ok, err := om.AttachObjectBlob(obj, blob, before)
+	if err != nil || !ok {
+		if err := bm.ReleaseBlob(blob.ID); err != nil {
+			log.WithError(err).WithField("blob", blob.ID).Error("release blob failed")
+		}
+		return Skipped, err
+	}
+	// own data is no longer read, it is queued if it can not be deleted now
+	if err := storages.DeleteObjectData(ownKey, obj.Size, compressed, log); err != nil {
+		log.WithError(err).WithField("key", ownKey).Warn("delete data of deduplicated object failed")
+		if err := models.NewGCManager().Enqueue(ownKey, obj.Size, models.GCDeduplicated, 0); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// hashData return sha256 in hex of data of object key, the data is also copied to dst if it is not nil
+func hashData(key string, obj *models.HarborObject, dst storages.ObjectIO, log *logrus.Entry) (string, error) {
+
+	src, err := storages.NewObjectIO(key, obj.Size, nil, obj.Compression, log)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	h := sha256.New()
+	if err := storages.CopyObjectData(dst, src, obj.Size, h); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// createBlob create a blob of hash from data of obj by key, nil is returned if the blob exists,
+// or the data is changed since it is hashed
+func createBlob(key string, obj *models.HarborObject, hash string, log *logrus.Entry) (*models.Blob, error) {
+
+	bm := models.NewBlobManager()
+	blob := &models.Blob{Hash: hash, Size: obj.Size, Compression: obj.Compression}
+	if created, err := bm.CreateBlob(blob); err != nil || !created {
+		return nil, err
+	}
+
+	// blob is incomplete until its data is written, no object can reference it before
+	dst, err := storages.NewObjectIO(models.BlobKey(blob.ID), 0, nil, blob.Compression, log)
+	var copied string
+	if err == nil {
+		copied, err = hashData(key, obj, dst, log)
+		if err == nil {
+			blob.StoredSize = uint64(storages.StoredSizeDelta(dst, 0, blob.Size))
+		}
+		dst.Close()
+	}
+	if err == nil && copied == hash {
+		err = bm.CompleteBlob(blob)
+		if err == nil {
+			return blob, nil
+		}
+	}
+	if err := bm.ReleaseBlob(blob.ID); err != nil {
+		log.WithError(err).WithField("blob", blob.ID).Error("release blob failed")
+	}
+	return nil, err
+}
+
+// RecountResult result of Recount
+type RecountResult struct {
+	Blobs   int `json:"blobs"`
+	Wrong   int `json:"wrong"`   // blobs whose references are not equal to the objects referencing them
+	Fixed   int `json:"fixed"`   // blobs whose references are corrected
+	Deleted int `json:"deleted"` // blobs not referenced, deleted and their data queued for garbage collection
+}
+
+// Recount count objects referencing each blob in all buckets, and correct references of blobs if fix is true,
+// blobs not referenced by any object are deleted; references changed during the count are corrected falsely,
+// so it should be run while writes and deduplication are stopped
+func Recount(fix bool) (*RecountResult, error) {
+
+	bm := models.NewBucketManager("", nil)
+	buckets, err := bm.ListBuckets(nil, true)
+	if err != nil {
+		return nil, err
+	}
+	counted := map[uint64]int64{}
+	db := database.GetDB("objs")
+	for i := range buckets {
+		if !db.HasTable(buckets[i].GetObjsTableName()) {
+			continue
+		}
+		refs, err := bm.GetObjsBlobRefs(&buckets[i])
+		if err != nil {
+			return nil, err
+		}
+		for id, n := range refs {
+			counted[id] += n
+		}
+	}
+
+	r := &RecountResult{}
+	m := models.NewBlobManager()
+	var lastID uint64
+	for {
+		blobs, err := m.ListBlobs(lastID, 1000)
+		if err != nil {
+			return r, err
+		}
+		if len(blobs) == 0 {
+			return r, nil
+		}
+		for _, blob := range blobs {
+			r.Blobs++
+			refs := counted[blob.ID]
+			if blob.Refs == refs {
+				continue
+			}
+			r.Wrong++
+			if !fix {
+				continue
+			}
+			if err := m.SetBlobRefs(blob, refs); err != nil {
+				return r, err
+			}
+			r.Fixed++
+			if refs == 0 {
+				r.Deleted++
+			}
+		}
+		lastID = blobs[len(blobs)-1].ID
+	}
+}
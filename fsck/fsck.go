@@ -0,0 +1,315 @@
+// Package fsck check consistency between metadata of objects in database and their data in storage backend,
+// and optionally repair the problems found
+package fsck
+
+import (
+	"errors"
+	"fmt"
+	"harbor/database"
+	"harbor/models"
+	"harbor/utils/storages/compress"
+	"harbor/utils/storages/encrypt"
+	"regexp"
+	"strconv"
+)
+
+// kinds of problems
+const (
+	MissingData  = "missing_data"  // object has no data in storage, or compressed data has no index
+	SizeMismatch = "size_mismatch" // size of data is not equal to size of object
+	OrphanData   = "orphan_data"   // data in storage whose object or blob does not exist
+	OrphanTable  = "orphan_table"  // table "bucket_N" whose bucket does not exist
+	MissingTable = "missing_table" // table of bucket does not exist, can not be repaired
+)
+
+var (
+	keyRegexp     = regexp.MustCompile(`^(\d+)_(\d+)(` + regexp.QuoteMeta(compress.IndexSuffix) + `)?$`)
+	blobKeyRegexp = regexp.MustCompile(`^blob_(\d+)(` + regexp.QuoteMeta(compress.IndexSuffix) + `)?$`)
+	tableRegexp   = regexp.MustCompile(`^bucket_\d+$`)
+)
+
+// Store data of objects by object key "{bucket id}_{object id}" and blobs by "blob_{id}", implemented by storages.Store
+type Store interface {
+	// Stat return size of data of key, exists is false if there is no data
+	Stat(key string) (size uint64, exists bool, err error)
+	// Keys call fn with each key in storage, keys not in format of object or blob key are ignored
+	Keys(fn func(key string) error) error
+	// Remove delete data of key with size
+	Remove(key string, size uint64) error
+}
+
+// ParseKey return bucket id and object id of object key "{bucket id}_{object id}", or key of index of its compressed data,
+// ok is false if key is not in the format
+func ParseKey(key string) (bucketID, objID uint64, ok bool) {
+
+	m := keyRegexp.FindStringSubmatch(key)
+	if m == nil {
+		return 0, 0, false
+	}
+	bucketID, err1 := strconv.ParseUint(m[1], 10, 64)
+	objID, err2 := strconv.ParseUint(m[2], 10, 64)
+	return bucketID, objID, err1 == nil && err2 == nil
+}
+
+// ParseBlobKey return blob id of blob key "blob_{id}", or key of index of its compressed data,
+// ok is false if key is not in the format
+func ParseBlobKey(key string) (id uint64, ok bool) {
+
+	m := blobKeyRegexp.FindStringSubmatch(key)
+	if m == nil {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(m[1], 10, 64)
+	return id, err == nil
+}
+
+// Options of check and repair actions
+type Options struct {
+	Bucket           string // only check bucket of the name, orphan tables are not checked if set
+	FixSizes         bool   // set size of object to size of its data
+	DeleteMissing    bool   // delete objects which have no data
+	DeleteOrphanData bool   // delete data whose object does not exist
+	DropOrphanTables bool   // drop tables whose bucket does not exist
+	OrphansOnly      bool   // only check orphaned data and tables, data of objects is not stated
+}
+
+// Problem an inconsistency found
+type Problem struct {
+	Kind        string `json:"kind"`
+	BucketID    uint64 `json:"bucket_id,omitempty"`
+	Bucket      string `json:"bucket,omitempty"`
+	Table       string `json:"table,omitempty"`
+	ObjectID    uint64 `json:"object_id,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Key         string `json:"key,omitempty"`
+	Size        uint64 `json:"size"`      // size of object in metadata
+	DataSize    uint64 `json:"data_size"` // size of data in storage, including overhead of encryption, or extent of compressed data
+	Repaired    bool   `json:"repaired"`
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+// Report result of a check
+type Report struct {
+	Buckets  int        `json:"buckets"`
+	Objects  int64      `json:"objects"`
+	Keys     int64      `json:"keys"` // object and blob keys found in storage, including keys of indexes of compressed data
+	Problems []*Problem `json:"problems"`
+}
+
+// Unrepaired return the number of problems not repaired
+func (r *Report) Unrepaired() int {
+
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// repair run action of problem p if enabled, and record the result in p
+func repair(p *Problem, enabled bool, action func() error) {
+
+	if !enabled {
+		return
+	}
+	if err := action(); err != nil {
+		p.RepairError = err.Error()
+		return
+	}
+	p.Repaired = true
+}
+
+// Run walk objects tables of buckets and keys in store, report the problems found and repair them by opts;
+// objects uploaded or deleted during the check may be reported falsely, so repairs should be
+// run while writes are stopped
+func Run(store Store, opts Options) (*Report, error) {
+
+	bm := models.NewBucketManager("", nil)
+	var buckets []models.Bucket
+	if opts.Bucket != "" {
+		b, err := bm.GetBucketByNameWithDeleted(opts.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			return nil, fmt.Errorf("bucket '%s' does not exist", opts.Bucket)
+		}
+		buckets = []models.Bucket{*b}
+	} else {
+		var err error
+		if buckets, err = bm.ListBuckets(nil, true); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &Report{Buckets: len(buckets), Problems: []*Problem{}}
+	db := database.GetDB("objs")
+	known := map[uint64]map[uint64]bool{} // bucket id -> object ids
+	for i := range buckets {
+		bucket := &buckets[i]
+		table := bucket.GetObjsTableName()
+		ids := map[uint64]bool{}
+		known[bucket.ID] = ids
+		if !db.HasTable(table) {
+			report.Problems = append(report.Problems, &Problem{Kind: MissingTable, BucketID: bucket.ID, Bucket: bucket.Name, Table: table})
+			continue
+		}
+
+		om := models.NewHarborObjectManager(table, "", "")
+		err := bm.WalkObjs(bucket, func(objs []*models.HarborObject) error {
+			for _, obj := range objs {
+				report.Objects++
+				if opts.OrphansOnly {
+					ids[obj.ID] = true
+					continue
+				}
+				key := obj.GetObjKey(bucket)
+				size, exists, err := store.Stat(key)
+				if err != nil {
+					return fmt.Errorf("stat data of object %d in bucket '%s': %s", obj.ID, bucket.Name, err)
+				}
+				p := &Problem{BucketID: bucket.ID, Bucket: bucket.Name, Table: table, ObjectID: obj.ID,
+					Path: obj.PathName, Key: key, Size: obj.Size, DataSize: size}
+				// size of encrypted data includes overhead of segments
+				wantSize, plainSize := obj.Size, size
+				if obj.IsEncrypted() {
+					wantSize, plainSize = encrypt.StoredSize(obj.Size), encrypt.PlainSize(size)
+				}
+				indexExists := true
+				if exists && obj.IsCompressed() {
+					if _, indexExists, err = store.Stat(compress.IndexKey(key)); err != nil {
+						return fmt.Errorf("stat index of object %d in bucket '%s': %s", obj.ID, bucket.Name, err)
+					}
+				}
+				switch {
+				case (!exists || !indexExists) && obj.Size > 0:
+					// empty objects may have no data
+					p.Kind = MissingData
+					repair(p, opts.DeleteMissing, func() error {
+						if err := om.DeleteObject(obj); err != nil || !obj.IsDeduplicated() {
+							return err
+						}
+						return models.NewBlobManager().ReleaseBlob(obj.BlobID)
+					})
+				case exists && obj.IsCompressed():
+					// compressed data is sparse, it does not extend beyond the object
+					if size > obj.Size {
+						p.Kind = SizeMismatch
+						repair(p, opts.FixSizes, func() error {
+							return errors.New("size of compressed object can not be recovered from its data")
+						})
+					}
+				case exists && size != wantSize:
+					p.Kind = SizeMismatch
+					repair(p, opts.FixSizes, func() error {
+						obj.Size = plainSize
+						return om.SetObjectSize(obj)
+					})
+				}
+				if p.Kind != "" {
+					report.Problems = append(report.Problems, p)
+				}
+				if p.Kind != MissingData || !p.Repaired {
+					ids[obj.ID] = true
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+
+	// blobs are shared by buckets, their keys are only checked with all buckets
+	var blobs map[uint64]bool
+	if opts.Bucket == "" {
+		var err error
+		if blobs, err = blobIDs(); err != nil {
+			return report, err
+		}
+	}
+
+	// keys are collected first, as removing data while listing may skip keys
+	var orphans []*Problem
+	err := store.Keys(func(key string) error {
+		if id, ok := ParseBlobKey(key); ok {
+			if blobs == nil {
+				return nil
+			}
+			report.Keys++
+			if !blobs[id] {
+				orphans = append(orphans, &Problem{Kind: OrphanData, Key: key})
+			}
+			return nil
+		}
+		bucketID, objID, ok := ParseKey(key)
+		if !ok {
+			return nil
+		}
+		ids, ok := known[bucketID]
+		if opts.Bucket != "" && !ok {
+			return nil
+		}
+		report.Keys++
+		if !ids[objID] {
+			orphans = append(orphans, &Problem{Kind: OrphanData, BucketID: bucketID, ObjectID: objID, Key: key})
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("list keys in storage: %s", err)
+	}
+	for _, p := range orphans {
+		size, _, err := store.Stat(p.Key)
+		if err != nil {
+			return report, fmt.Errorf("stat data of key %s: %s", p.Key, err)
+		}
+		p.DataSize = size
+		repair(p, opts.DeleteOrphanData, func() error { return store.Remove(p.Key, size) })
+		report.Problems = append(report.Problems, p)
+	}
+
+	if opts.Bucket != "" {
+		return report, nil
+	}
+	tables, err := database.Tables(db)
+	if err != nil {
+		return report, fmt.Errorf("list tables: %s", err)
+	}
+	knownTables := map[string]bool{}
+	for i := range buckets {
+		knownTables[buckets[i].GetObjsTableName()] = true
+	}
+	for _, table := range tables {
+		if !tableRegexp.MatchString(table) || knownTables[table] {
+			continue
+		}
+		p := &Problem{Kind: OrphanTable, Table: table}
+		repair(p, opts.DropOrphanTables, func() error { return db.DropTable(table).Error })
+		report.Problems = append(report.Problems, p)
+	}
+	return report, nil
+}
+
+// blobIDs return ids of all blobs
+func blobIDs() (map[uint64]bool, error) {
+
+	m := models.NewBlobManager()
+	ids := map[uint64]bool{}
+	var lastID uint64
+	for {
+		blobs, err := m.ListBlobs(lastID, 1000)
+		if err != nil {
+			return nil, fmt.Errorf("list blobs: %s", err)
+		}
+		if len(blobs) == 0 {
+			return ids, nil
+		}
+		for _, b := range blobs {
+			ids[b.ID] = true
+		}
+		lastID = blobs[len(blobs)-1].ID
+	}
+}
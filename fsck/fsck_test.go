@@ -0,0 +1,117 @@
+package fsck_test
+
+import (
+	"harbor/database"
+	"harbor/fsck"
+	"harbor/internal/testdb"
+	"harbor/models"
+	"testing"
+)
+
+func kinds(r *fsck.Report) map[string]int {
+
+	n := map[string]int{}
+	for _, p := range r.Problems {
+		n[p.Kind]++
+	}
+	return n
+}
+
+func TestRun(t *testing.T) {
+
+	testdb.Setup(t)
+	user := &models.UserProfile{ID: 1}
+	bm := models.NewBucketManager("", user)
+	bucket, err := bm.CreateBucketByName("fsck", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bm.CreateObjsTable(bucket); err != nil {
+		t.Fatal(err)
+	}
+	// bucket without table
+	if _, err := bm.CreateBucketByName("notable", user); err != nil {
+		t.Fatal(err)
+	}
+	// table without bucket
+	if err := database.GetDB("objs").Table("bucket_999").CreateTable(models.NewHarborObject()).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	store := testdb.NewStore(map[string]uint64{"foreign": 1})
+	om := models.NewHarborObjectManager(bucket.GetObjsTableName(), "", "")
+	sizes := map[string][2]uint64{ // object size, data size; 0 data size means no data
+		"ok":       {10, 10},
+		"empty":    {0, 0},
+		"missing":  {10, 0},
+		"mismatch": {10, 4},
+	}
+	for name, s := range sizes {
+		om.ResetObjName(name)
+		obj, created := om.GetObjOrCreat()
+		if !created {
+			t.Fatalf("object %s should be created", name)
+		}
+		obj.Size = s[0]
+		if err := om.SetObjectSize(obj); err != nil {
+			t.Fatal(err)
+		}
+		if s[1] > 0 {
+			store.Data[obj.GetObjKey(bucket)] = s[1]
+		}
+	}
+	// compressed data is sparse and has an index
+	om.ResetObjName("compressed")
+	obj, _ := om.GetObjOrCreat()
+	obj.Size, obj.Compression = 10, models.CompressionGzip
+	if err := om.SetObjectSize(obj); err != nil {
+		t.Fatal(err)
+	}
+	if err := om.SetObjectCompression(obj); err != nil {
+		t.Fatal(err)
+	}
+	store.Data[obj.GetObjKey(bucket)] = 6
+	store.Data[obj.GetObjKey(bucket)+".idx"] = 8
+	store.Data[bucket.GetObjsTableName()[len("bucket_"):]+"_1000"] = 3
+	store.Data[bucket.GetObjsTableName()[len("bucket_"):]+"_1000.idx"] = 8
+
+	report, err := fsck.Run(store, fsck.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{fsck.MissingData: 1, fsck.SizeMismatch: 1, fsck.OrphanData: 2, fsck.OrphanTable: 1, fsck.MissingTable: 1}
+	if got := kinds(report); len(got) != len(want) || report.Unrepaired() != 6 {
+		t.Fatalf("got problems %v, want %v", got, want)
+	} else {
+		for k, n := range want {
+			if got[k] != n {
+				t.Errorf("got %d problems of %s, want %d", got[k], k, n)
+			}
+		}
+	}
+	if report.Objects != 5 || report.Keys != 6 {
+		t.Errorf("got %d objects and %d keys, want 5 and 6", report.Objects, report.Keys)
+	}
+
+	report, err = fsck.Run(store, fsck.Options{FixSizes: true, DeleteMissing: true, DeleteOrphanData: true, DropOrphanTables: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := report.Unrepaired(); n != 1 {
+		t.Errorf("only missing table should not be repaired, got %d unrepaired", n)
+	}
+
+	report, err = fsck.Run(store, fsck.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := kinds(report); len(got) != 1 || got[fsck.MissingTable] != 1 {
+		t.Errorf("problems should be repaired, got %v", got)
+	}
+	if report.Objects != 4 {
+		t.Errorf("object without data should be deleted, got %d objects", report.Objects)
+	}
+	if _, ok := store.Data["foreign"]; !ok {
+		t.Errorf("keys of other data should be kept")
+	}
+}
@@ -0,0 +1,405 @@
+// Package gc garbage collector of storage data, deletes data of keys in queue table gc_queue with retries,
+// and finds orphaned data by comparing keys in storage with objects in database
+package gc
+
+import (
+	"errors"
+	"harbor/config"
+	"harbor/database"
+	"harbor/fsck"
+	"harbor/models"
+	"harbor/utils/metrics"
+	"harbor/utils/storages"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrScanRunning returned by Scan if a scan is in progress
+var ErrScanRunning = errors.New("orphan scan is in progress")
+
+// Store storage backend, implemented by storages.Store
+type Store interface {
+	fsck.Store
+	Close() error
+}
+
+// claimLease time a task is claimed for, the claim of a collector stopped abnormally expires after it
+const claimLease = time.Hour
+
+// Options of collector, zero values are replaced by defaults
+type Options struct {
+	Interval     time.Duration // interval of collecting, default 1m
+	BatchSize    int           // keys read from queue at a time, default 100
+	BaseDelay    time.Duration // delay before first retry, doubled for each retry after, default 1m
+	MaxDelay     time.Duration // default 6h
+	ScanInterval time.Duration // interval of orphan scan, no periodic scan if 0
+	ScanGrace    time.Duration // delay before deleting orphans found by scan, default 1h
+}
+
+func (o *Options) setDefaults() {
+
+	if o.Interval <= 0 {
+		o.Interval = time.Minute
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	if o.BaseDelay <= 0 {
+		o.BaseDelay = time.Minute
+	}
+	if o.MaxDelay <= 0 {
+		o.MaxDelay = 6 * time.Hour
+	}
+	if o.ScanGrace <= 0 {
+		o.ScanGrace = time.Hour
+	}
+}
+
+// delay return delay before next attempt after attempts failed
+func (o *Options) delay(attempts int) time.Duration {
+
+	d := o.BaseDelay
+	for i := 1; i < attempts && d < o.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > o.MaxDelay {
+		d = o.MaxDelay
+	}
+	return d
+}
+
+// ScanProgress progress of the last orphan scan
+type ScanProgress struct {
+	Running  bool       `json:"running"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Buckets  int        `json:"buckets"`
+	Objects  int64      `json:"objects"`
+	Keys     int64      `json:"keys"`    // object keys found in storage
+	Orphans  int        `json:"orphans"` // orphaned keys queued
+	Bytes    uint64     `json:"bytes"`   // size of orphaned keys
+	Error    string     `json:"error,omitempty"`
+}
+
+// Stats progress of collector since it is created
+type Stats struct {
+	Running        bool         `json:"running"` // collecting keys in queue
+	Deleted        int64        `json:"deleted"` // keys whose data is deleted
+	Skipped        int64        `json:"skipped"` // keys without data, or of existing objects
+	Failed         int64        `json:"failed"`  // failed attempts
+	ReclaimedBytes uint64       `json:"reclaimed_bytes"`
+	LastRun        *time.Time   `json:"last_run,omitempty"`
+	LastError      string       `json:"last_error,omitempty"`
+	Scan           ScanProgress `json:"scan"`
+}
+
+// Collector delete data of keys in queue, run in background by Start or once by Collect
+type Collector struct {
+	opts     Options
+	newStore func() (Store, error)
+	log      *logrus.Entry
+
+	mu    sync.Mutex // protect stats
+	stats Stats
+
+	collectMu sync.Mutex // one collection at a time
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// New return a collector, newStore is called to connect to storage backend for each collection or scan
+func New(newStore func() (Store, error), opts Options, log *logrus.Entry) *Collector {
+
+	opts.setDefaults()
+	return &Collector{
+		opts:     opts,
+		newStore: newStore,
+		log:      log,
+		stop:     make(chan struct{}),
+	}
+}
+
+// NewStore connect to the configured storage backend
+func NewStore() (Store, error) {
+
+	s, err := storages.NewStore()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewConfigured return a collector of the configured storage backend and config "gc"
+func NewConfigured(log *logrus.Entry) *Collector {
+
+	c := config.GetConfigs().GC
+	return New(NewStore, Options{
+		Interval:     c.Interval,
+		BatchSize:    c.BatchSize,
+		BaseDelay:    c.BaseDelay,
+		MaxDelay:     c.MaxDelay,
+		ScanInterval: c.ScanInterval,
+		ScanGrace:    c.ScanGrace,
+	}, log)
+}
+
+// Stats return progress of collector
+func (c *Collector) Stats() Stats {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Start collect due keys every Interval and scan orphans every ScanInterval in background
+func (c *Collector) Start() {
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		collect := time.NewTicker(c.opts.Interval)
+		defer collect.Stop()
+		var scan <-chan time.Time
+		if c.opts.ScanInterval > 0 {
+			t := time.NewTicker(c.opts.ScanInterval)
+			defer t.Stop()
+			scan = t.C
+		}
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-collect.C:
+				if _, err := c.Collect(); err != nil {
+					c.log.WithError(err).Error("collect garbage failed")
+				}
+			case <-scan:
+				if _, err := c.Scan(); err != nil && err != ErrScanRunning {
+					c.log.WithError(err).Error("scan orphans failed")
+				}
+			}
+		}
+	}()
+}
+
+// Stop background collecting after the key in progress is done
+func (c *Collector) Stop() {
+
+	close(c.stop)
+	c.wg.Wait()
+}
+
+func (c *Collector) stopped() bool {
+
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Collect delete data of all due keys in queue, return the number of deleted keys
+func (c *Collector) Collect() (int, error) {
+
+	c.collectMu.Lock()
+	defer c.collectMu.Unlock()
+	c.mu.Lock()
+	c.stats.Running = true
+	c.mu.Unlock()
+
+	n, err := c.collect()
+
+	now := time.Now()
+	c.mu.Lock()
+	c.stats.Running = false
+	c.stats.LastRun = &now
+	c.stats.LastError = ""
+	if err != nil {
+		c.stats.LastError = err.Error()
+	}
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *Collector) collect() (int, error) {
+
+	m := models.NewGCManager()
+	tasks, err := m.GetDueTasks(c.opts.BatchSize)
+	if err != nil || len(tasks) == 0 {
+		return 0, err
+	}
+	store, err := c.newStore()
+	if err != nil {
+		return 0, err
+	}
+	defer store.Close()
+
+	n := 0
+	for len(tasks) > 0 {
+		for _, task := range tasks {
+			if c.stopped() {
+				return n, nil
+			}
+			deleted, err := c.collectTask(store, m, task)
+			if err != nil {
+				return n, err
+			}
+			if deleted {
+				n++
+			}
+		}
+		// tasks failed are scheduled later, so that they are not got again
+		if tasks, err = m.GetDueTasks(c.opts.BatchSize); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// collectTask delete data of task's key, the failed attempt is recorded in task,
+// error is returned only if the queue can not be updated
+func (c *Collector) collectTask(store Store, m *models.GCManager, task *models.GCTask) (bool, error) {
+
+	// the key is not written by others until the task is deleted or retried,
+	// so that data written since the task is got is not deleted
+	claimed, err := m.ClaimTask(task, claimLease)
+	if err != nil || !claimed {
+		return false, err
+	}
+	log := c.log.WithFields(logrus.Fields{"key": task.Key, "reason": task.Reason, "attempts": task.Attempts})
+	size, exists, err := c.deleteData(store, task)
+	if err != nil {
+		c.mu.Lock()
+		c.stats.Failed++
+		c.mu.Unlock()
+		metrics.GCKeys.WithLabelValues("failed").Inc()
+		log.WithError(err).Warn("delete data failed")
+		return false, m.RetryTask(task, err, c.opts.delay(task.Attempts+1))
+	}
+	if err := m.DeleteTask(task); err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !exists {
+		c.stats.Skipped++
+		metrics.GCKeys.WithLabelValues("skipped").Inc()
+		return false, nil
+	}
+	c.stats.Deleted++
+	c.stats.ReclaimedBytes += size
+	metrics.GCKeys.WithLabelValues("deleted").Inc()
+	metrics.GCReclaimedBytes.Add(float64(size))
+	log.WithField("size", size).Debug("data deleted")
+	return true, nil
+}
+
+// deleteData delete data of task's key if its object does not exist, return size of deleted data,
+// exists is false if there is no data or the object exists; task must be claimed
+func (c *Collector) deleteData(store Store, task *models.GCTask) (size uint64, exists bool, err error) {
+
+	live, err := IsLive(task.Key)
+	if err != nil || live {
+		return 0, false, err
+	}
+	size, exists, err = store.Stat(task.Key)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+	// size of parts to delete, parts beyond the stated size may exist after a partial write
+	removeSize := size
+	if task.Size > removeSize {
+		removeSize = task.Size
+	}
+	if err := store.Remove(task.Key, removeSize); err != nil {
+		return 0, false, err
+	}
+	return size, true, nil
+}
+
+// IsLive return true if the object of key exists and it is not deduplicated, or the blob of key exists,
+// its data must not be deleted; ids of objects rolled back may be reused by new objects in some databases
+func IsLive(key string) (bool, error) {
+
+	if id, ok := fsck.ParseBlobKey(key); ok {
+		blob, err := models.NewBlobManager().GetBlobByID(id)
+		return blob != nil, err
+	}
+	bucketID, objID, ok := fsck.ParseKey(key)
+	if !ok {
+		return false, nil
+	}
+	bucket, err := models.NewBucketManager("", nil).GetBucketByIDWithDeleted(bucketID)
+	if err != nil || bucket == nil {
+		return false, err
+	}
+	table := bucket.GetObjsTableName()
+	if !database.GetDB("objs").HasTable(table) {
+		return false, nil
+	}
+	obj, err := models.NewHarborObjectManager(table, "", "").GetObjectByID(objID)
+	if err != nil || obj == nil {
+		return false, err
+	}
+	return obj.IsFile() && !obj.IsDeduplicated(), nil
+}
+
+// Scan find keys in storage whose objects do not exist, and queue them to be deleted after ScanGrace,
+// the objects are checked again before deletion, as they may be created during the scan
+func (c *Collector) Scan() (ScanProgress, error) {
+
+	now := time.Now()
+	c.mu.Lock()
+	if c.stats.Scan.Running {
+		c.mu.Unlock()
+		return ScanProgress{}, ErrScanRunning
+	}
+	c.stats.Scan = ScanProgress{Running: true, Started: &now}
+	c.mu.Unlock()
+
+	p, err := c.scan()
+	finished := time.Now()
+	p.Started, p.Finished = &now, &finished
+	if err != nil {
+		p.Error = err.Error()
+	}
+	c.mu.Lock()
+	c.stats.Scan = p
+	c.mu.Unlock()
+	return p, err
+}
+
+func (c *Collector) scan() (ScanProgress, error) {
+
+	p := ScanProgress{}
+	store, err := c.newStore()
+	if err != nil {
+		return p, err
+	}
+	defer store.Close()
+
+	report, err := fsck.Run(store, fsck.Options{OrphansOnly: true})
+	if report != nil {
+		p.Buckets, p.Objects, p.Keys = report.Buckets, report.Objects, report.Keys
+	}
+	if err != nil {
+		return p, err
+	}
+	m := models.NewGCManager()
+	for _, problem := range report.Problems {
+		if problem.Kind != fsck.OrphanData {
+			continue
+		}
+		if err := m.Enqueue(problem.Key, problem.DataSize, models.GCOrphan, c.opts.ScanGrace); err != nil {
+			return p, err
+		}
+		p.Orphans++
+		p.Bytes += problem.DataSize
+	}
+	c.log.WithFields(logrus.Fields{"keys": p.Keys, "orphans": p.Orphans, "bytes": p.Bytes}).Info("orphan scan finished")
+	return p, nil
+}
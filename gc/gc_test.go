@@ -0,0 +1,146 @@
+package gc_test
+
+import (
+	"harbor/gc"
+	"harbor/internal/testdb"
+	"harbor/models"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCollectAndScan(t *testing.T) {
+
+	testdb.Setup(t)
+	user := &models.UserProfile{ID: 1}
+	bm := models.NewBucketManager("", user)
+	bucket, err := bm.CreateBucketByName("gc", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bm.CreateObjsTable(bucket); err != nil {
+		t.Fatal(err)
+	}
+	om := models.NewHarborObjectManager(bucket.GetObjsTableName(), "", "")
+	om.ResetObjName("live")
+	live, created := om.GetObjOrCreat()
+	if !created {
+		t.Fatal("object should be created")
+	}
+	liveKey := live.GetObjKey(bucket)
+	prefix := liveKey[:len(liveKey)-len("1")]
+
+	store := testdb.NewStore(map[string]uint64{
+		liveKey:        5,
+		prefix + "100": 10, // deleted object
+		prefix + "101": 20, // fails to be removed
+		prefix + "102": 30, // orphan
+		"foreign":      1,
+	})
+	store.Failing[prefix+"101"] = true
+	qm := models.NewGCManager()
+	for _, key := range []string{liveKey, prefix + "100", prefix + "101", prefix + "103"} {
+		if err := qm.Enqueue(key, 10, models.GCObjectDeleted, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// queued twice
+	if err := qm.Enqueue(prefix+"100", 10, models.GCObjectDeleted, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	c := gc.New(func() (gc.Store, error) { return store, nil }, gc.Options{BaseDelay: time.Hour}, logrus.NewEntry(logrus.New()))
+	n, err := c.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d keys deleted, want 1", n)
+	}
+	stats := c.Stats()
+	if stats.Deleted != 1 || stats.Skipped != 2 || stats.Failed != 1 || stats.ReclaimedBytes != 10 {
+		t.Errorf("got stats %+v", stats)
+	}
+	if _, ok := store.Data[liveKey]; !ok {
+		t.Error("data of live object should be kept")
+	}
+	if _, ok := store.Data[prefix+"100"]; ok {
+		t.Error("data of deleted object should be removed")
+	}
+	q, err := qm.GetQueueStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Pending != 1 || q.Retrying != 1 {
+		t.Errorf("failed key should be retried later, got queue %+v", q)
+	}
+
+	p, err := c.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// key failed to be removed is already queued
+	if p.Orphans != 2 || p.Bytes != 50 || p.Keys != 3 {
+		t.Errorf("got scan %+v", p)
+	}
+	q, err = qm.GetQueueStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Pending != 2 {
+		t.Errorf("orphan should be queued once, got queue %+v", q)
+	}
+	// orphans are not due before grace
+	if n, err := c.Collect(); err != nil || n != 0 {
+		t.Errorf("got %d keys deleted and error %v, want none", n, err)
+	}
+	if _, ok := store.Data[prefix+"102"]; !ok {
+		t.Error("orphan should be kept until grace expires")
+	}
+}
+
+func TestClaimTask(t *testing.T) {
+
+	testdb.Setup(t)
+	m := models.NewGCManager()
+	for _, key := range []string{"deleted", "claimed"} {
+		if err := m.Enqueue(key, 10, models.GCDeduplicated, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tasks, err := m.GetDueTasks(10)
+	if err != nil || len(tasks) != 2 {
+		t.Fatalf("got %d tasks, %v", len(tasks), err)
+	}
+
+	// key is written again after the task is got by collector
+	if err := m.DeleteTaskByKey(tasks[0].Key); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m.ClaimTask(tasks[0], time.Hour); err != nil || ok {
+		t.Errorf("deleted task should not be claimed, got %v, %v", ok, err)
+	}
+
+	if ok, err := m.ClaimTask(tasks[1], time.Hour); err != nil || !ok {
+		t.Fatalf("got %v, %v", ok, err)
+	}
+	if ok, _ := m.ClaimTask(tasks[1], time.Hour); ok {
+		t.Error("task should not be claimed twice")
+	}
+	if err := m.DeleteTaskByKey(tasks[1].Key); err != models.ErrGCTaskClaimed {
+		t.Errorf("got error %v, want %s", err, models.ErrGCTaskClaimed)
+	}
+	if due, _ := m.GetDueTasks(10); len(due) != 0 {
+		t.Errorf("claimed task should not be due, got %d tasks", len(due))
+	}
+	if err := m.RetryTask(tasks[1], models.ErrGCTaskClaimed, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeleteTaskByKey(tasks[1].Key); err != nil {
+		t.Errorf("retried task should be deleted, got %v", err)
+	}
+	if q, _ := m.GetQueueStats(); q.Pending != 0 {
+		t.Errorf("got %d tasks in queue, want 0", q.Pending)
+	}
+}
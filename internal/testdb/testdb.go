@@ -0,0 +1,99 @@
+// Package testdb databases and storage for tests: in-memory sqlite databases with all migrations applied,
+// and an in-memory store of data keys.
+package testdb
+
+import (
+	"errors"
+	"harbor/config"
+	"harbor/database"
+	"harbor/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// Setup load config of databases "default" and "objs" in in-memory sqlite and apply all migrations,
+// the databases are new at each call
+func Setup(t testing.TB) {
+
+	t.Helper()
+	dir, err := ioutil.TempDir("", "harbor-testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(file, []byte(`{
+		"secret_key": "test",
+		"databases": [
+			{"alias": "default", "engine": "sqlite3", "name": ":memory:"},
+			{"alias": "objs", "engine": "sqlite3", "name": ":memory:"}
+		],
+		"storage": {"backend": "filesystem"}
+	}`), 0600)
+	if err := config.Load(file, dir); err != nil {
+		t.Fatal(err)
+	}
+	database.InitDatabase()
+	m, err := models.NewMigrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Store in-memory store of sizes of data by key, implements fsck.Store and gc.Store;
+// keys in Failing can not be removed
+type Store struct {
+	Data    map[string]uint64
+	Failing map[string]bool
+}
+
+// NewStore return store of data
+func NewStore(data map[string]uint64) *Store {
+
+	return &Store{Data: data, Failing: map[string]bool{}}
+}
+
+// Stat return size of data of key
+func (s *Store) Stat(key string) (uint64, bool, error) {
+
+	size, ok := s.Data[key]
+	return size, ok, nil
+}
+
+// Keys call fn with each key in order
+func (s *Store) Keys(fn func(key string) error) error {
+
+	keys := make([]string, 0, len(s.Data))
+	for k := range s.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove delete data of key, fail if key is in Failing
+func (s *Store) Remove(key string, size uint64) error {
+
+	if s.Failing[key] {
+		return errors.New("remove failed")
+	}
+	delete(s.Data, key)
+	return nil
+}
+
+// Close do nothing
+func (s *Store) Close() error {
+
+	return nil
+}
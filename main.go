@@ -1,16 +1,27 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"harbor/config"
+	ctls "harbor/controllers"
 	"harbor/database"
 	"harbor/middlewares"
-	"harbor/models"
 	"harbor/routes"
+	"harbor/utils/auth"
+	"harbor/utils/logger"
 	"harbor/utils/renders"
+	"harbor/utils/server"
+	"harbor/utils/storages/radosio"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 
@@ -26,10 +37,62 @@ func GetCurrentPath() (string, error) {
 	return path, nil
 }
 
+// configPath path of config file given by flag "-config" or environment variable HARBOR_CONFIG
+var configPath string
+
 func init() {
+	flag.StringVar(&configPath, "config", os.Getenv("HARBOR_CONFIG"),
+		"config file, or directory containing config.json (default config/config.json next to the executable)")
+	flag.Usage = usage
+}
+
+// loadConfig load configs and configure logger, password hashers and trusted proxies, exit with readable error if configs are invalid
+func loadConfig() {
+
 	baseDir, _ := GetCurrentPath()
-	config.LoadConfigFile(baseDir)
-	database.InitDatabase()
+	if err := config.Load(configPath, baseDir); err != nil {
+		fatalf("%s\n", err)
+	}
+	if err := initLogger(); err != nil {
+		fatalf("invalid config log: %s\n", err)
+	}
+	if err := initPasswordHashers(); err != nil {
+		fatalf("invalid config password_hasher: %s\n", err)
+	}
+	if err := middlewares.ConfigureTrustedProxies(config.GetConfigs().Server.TrustedProxies); err != nil {
+		fatalf("invalid config server.trusted_proxies: %s\n", err)
+	}
+}
+
+// fatalf print error message to stderr and exit
+func fatalf(format string, a ...interface{}) {
+
+	fmt.Fprintf(os.Stderr, format, a...)
+	os.Exit(1)
+}
+
+// initLogger configure the process wide logger by config "log"
+func initLogger() error {
+
+	c := config.GetConfigs()
+	file := c.Log.File
+	if file != "" {
+		file = c.AbsPath(file)
+	}
+	return logger.Configure(c.Log.Level, c.Log.Format, file)
+}
+
+// initPasswordHashers set the preferred password hasher and cost parameters
+func initPasswordHashers() error {
+
+	c := config.GetConfigs().Hasher
+	return auth.ConfigureHashers(c.Algorithm, auth.HasherOptions{
+		PBKDF2Iterations:  c.PBKDF2Iterations,
+		Argon2Time:        c.Argon2Time,
+		Argon2Memory:      c.Argon2Memory,
+		Argon2Parallelism: c.Argon2Parallelism,
+		BcryptCost:        c.BcryptCost,
+	})
 }
 
 // @title EVHarbor API
@@ -54,23 +117,143 @@ func init() {
 // @BasePath /
 func main() {
 
-	db := database.GetDBDefault()
-	defer db.Close()
-	db.AutoMigrate(&models.UserProfile{},
-		&models.HarborObject{},
-		&models.Bucket{},
-		&models.Token{},
-	)
+	flag.Parse()
+	loadConfig()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		runServer()
+		return
+	}
+	switch args[0] {
+	case "serve":
+		if len(args) > 1 {
+			fmt.Fprintln(os.Stderr, "usage: serve")
+			os.Exit(2)
+		}
+		runServer()
+	case "createsuperuser":
+		createSuperUserCommand(args[1:])
+	case "user":
+		userCommand(args[1:])
+	case "bucket":
+		bucketCommand(args[1:])
+	case "token":
+		tokenCommand(args[1:])
+	case "fsck":
+		fsckCommand(args[1:])
+	case "gc":
+		gcCommand(args[1:])
+	case "dedup":
+		dedupCommand(args[1:])
+	case "encryption":
+		encryptionCommand(args[1:])
+	case "multidisk":
+		multidiskCommand(args[1:])
+	case "config":
+		configCommand(args[1:])
+	case "migrate":
+		migrateCommand(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		usage()
+		os.Exit(2)
+	}
+}
+
+// runServer migrate database and serve http until shutdown
+func runServer() {
 
-	app := gin.Default()
+	database.InitDatabase()
+	if err := migrateOnStart(); err != nil {
+		fatalf("%s\n", err)
+	}
+	if config.GetConfigs().Email.Backend == "" {
+		logger.Std().Warn("email.backend is not set, emails of email verification and password reset are not delivered")
+	}
+	ctls.StartWebhooks()
+	ctls.StartGC()
+	ctls.StartDedup()
+
+	app := gin.New()
+	app.Use(middlewares.RequestIDMiddleware())
+	if !config.GetConfigs().Log.NoAccess {
+		app.Use(middlewares.AccessLogMiddleware())
+	}
+	app.Use(middlewares.RecoveryMiddleware())
 	app.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	app.Use(middlewares.BasicAuth(db, &models.UserProfile{ID: 20}))
+	app.Use(middlewares.MetricsMiddleware())
+	app.Use(middlewares.BasicAuth())
 	routes.Urls(app)
 	app.GET("/", index)
 	app.Static("/static", "./static") // 设置静态资源
 	app.LoadHTMLGlob("views/**/*")    //加载模板
 
-	app.Run(":9999")
+	serve(app)
+}
+
+// serve run http server until SIGTERM or SIGINT, then shut down gracefully;
+// TLS certificate is reloaded on SIGHUP
+func serve(handler http.Handler) {
+
+	c := config.GetConfigs().Server
+	log := logger.Std()
+	srv, err := server.New(c, handler)
+	if err != nil {
+		panic(err)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	log.WithFields(logrus.Fields{"address": srv.Addr(), "tls": srv.TLS()}).Info("server started")
+
+	for {
+		select {
+		case err := <-errc:
+			log.WithError(err).Error("server stopped")
+			closeResources()
+			os.Exit(1)
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				if err := srv.ReloadCert(); err != nil {
+					log.WithError(err).Error("reload TLS certificate failed")
+				} else if srv.TLS() {
+					log.Info("TLS certificate reloaded")
+				}
+				continue
+			}
+
+			log.WithField("signal", sig.String()).Info("shutting down")
+			ctls.SetShuttingDown()
+			if c.DrainDelay > 0 {
+				time.Sleep(c.DrainDelay)
+			}
+			start := time.Now()
+			if err := srv.Shutdown(); err != nil {
+				log.WithError(err).Warn("in-flight requests are not finished before shutdown timeout")
+			}
+			<-errc
+			log.WithField("duration", time.Since(start).String()).Info("in-flight requests drained")
+			closeResources()
+			return
+		}
+	}
+}
+
+// closeResources stop webhook workers, deduplicator and garbage collector, close connections to ceph cluster and databases
+func closeResources() {
+
+	log := logger.Std()
+	ctls.StopWebhooks()
+	ctls.StopDedup()
+	ctls.StopGC()
+	radosio.CloseAll()
+	if err := database.CloseAll(); err != nil {
+		log.WithError(err).Error("close database failed")
+	}
+	log.Info("server exited")
 }
 
 func index(ctx *gin.Context) {
@@ -0,0 +1,114 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"harbor/config"
+	"harbor/database"
+	"harbor/models"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidCredentials indicates the username or password is not correct
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Authenticator authenticate user by username and password
+type Authenticator interface {
+	Authenticate(username, password string) (*models.UserProfile, error)
+}
+
+// LocalAuthenticator authenticate user by the password hash of local user
+type LocalAuthenticator struct{}
+
+// Authenticate check password of local user,
+// the password is rehashed if it is not hashed by the preferred hasher or cost parameters are raised
+func (a LocalAuthenticator) Authenticate(username, password string) (*models.UserProfile, error) {
+
+	user := &models.UserProfile{}
+	db := database.GetDB("default")
+	if err := Authenticate(db, username, password, user); err != nil {
+		return nil, err
+	}
+
+	if user.PasswordMustUpdate() {
+		user.SetPassword(password)
+		// login is not failed if rehash failed, try next time
+		models.NewUserManager().UpdatePassword(user)
+	}
+	return user, nil
+}
+
+// AuthenticatorChain try authenticators in order, return the user authenticated by the first one succeeded
+type AuthenticatorChain []Authenticator
+
+// Authenticate try authenticators in order, return the error of the last one if all failed
+func (chain AuthenticatorChain) Authenticate(username, password string) (*models.UserProfile, error) {
+
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	err := ErrInvalidCredentials
+	for _, a := range chain {
+		user, e := a.Authenticate(username, password)
+		if e == nil {
+			return user, nil
+		}
+		err = e
+	}
+	return nil, err
+}
+
+var (
+	authChain     AuthenticatorChain
+	authChainOnce sync.Once
+	authChainErr  error
+)
+
+// NewAuthenticatorChain return authenticator chain from config "auth_backends", default ["local"]
+func NewAuthenticatorChain(configs *config.Config) (AuthenticatorChain, error) {
+
+	backends := configs.AuthBackends
+	if len(backends) == 0 {
+		backends = []string{"local"}
+	}
+
+	chain := AuthenticatorChain{}
+	for _, name := range backends {
+		switch strings.ToLower(name) {
+		case "local":
+			chain = append(chain, LocalAuthenticator{})
+		case "ldap":
+			a, err := NewLDAPAuthenticator(&configs.LDAP)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, a)
+		default:
+			return nil, fmt.Errorf("unknown auth backend '%s'", name)
+		}
+	}
+	return chain, nil
+}
+
+// SetAuthenticators replace the authenticator chain used by AuthenticateUser
+func SetAuthenticators(chain AuthenticatorChain) {
+
+	authChainOnce.Do(func() {})
+	authChain = chain
+	authChainErr = nil
+}
+
+// AuthenticateUser authenticate user by the authenticator chain configured,
+// used by basic auth, jwt login and token login
+func AuthenticateUser(username, password string) (*models.UserProfile, error) {
+
+	authChainOnce.Do(func() {
+		authChain, authChainErr = NewAuthenticatorChain(config.GetConfigs())
+	})
+	if authChainErr != nil {
+		return nil, authChainErr
+	}
+	return authChain.Authenticate(username, password)
+}
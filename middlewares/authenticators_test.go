@@ -0,0 +1,60 @@
+package middlewares_test
+
+import (
+	"errors"
+	"harbor/middlewares"
+	"harbor/models"
+	"testing"
+)
+
+// fakeAuthenticator accept only the given username and password
+type fakeAuthenticator struct {
+	username, password string
+	err                error
+	calls              int
+}
+
+func (a *fakeAuthenticator) Authenticate(username, password string) (*models.UserProfile, error) {
+
+	a.calls++
+	if a.err != nil {
+		return nil, a.err
+	}
+	if username != a.username || password != a.password {
+		return nil, middlewares.ErrInvalidCredentials
+	}
+	return &models.UserProfile{Username: username}, nil
+}
+
+func TestAuthenticatorChain(t *testing.T) {
+
+	down := errors.New("ldap server is down")
+	ldap := &fakeAuthenticator{username: "alice", password: "ldap-pw"}
+	local := &fakeAuthenticator{username: "bob", password: "local-pw"}
+	chain := middlewares.AuthenticatorChain{ldap, local}
+
+	if u, err := chain.Authenticate("alice", "ldap-pw"); err != nil || u.Username != "alice" {
+		t.Errorf("alice should be authenticated by the first backend, %v", err)
+	}
+	if local.calls != 0 {
+		t.Errorf("the second backend should not be tried after success")
+	}
+	if u, err := chain.Authenticate("bob", "local-pw"); err != nil || u.Username != "bob" {
+		t.Errorf("bob should fall back to the second backend, %v", err)
+	}
+	if _, err := chain.Authenticate("bob", "wrong"); err != middlewares.ErrInvalidCredentials {
+		t.Errorf("want invalid credentials, got %v", err)
+	}
+	if _, err := chain.Authenticate("alice", ""); err != middlewares.ErrInvalidCredentials {
+		t.Errorf("empty password should be rejected, got %v", err)
+	}
+
+	ldap.err = down
+	if u, err := chain.Authenticate("bob", "local-pw"); err != nil || u.Username != "bob" {
+		t.Errorf("local backend should work when ldap is down, %v", err)
+	}
+	reversed := middlewares.AuthenticatorChain{local, ldap}
+	if _, err := reversed.Authenticate("alice", "ldap-pw"); err != down {
+		t.Errorf("want the error of the last backend, got %v", err)
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jinzhu/gorm"
 )
 
 // IBasicAuth basic auth interface
@@ -21,15 +20,15 @@ type IBasicAuth interface {
 // AuthUserKey is the cookie name for user credential in basic auth
 const AuthUserKey string = "user"
 
-// BasicAuth returns a Basic HTTP Authorization middleware. It takes as argument a map[string]string where
-// the key is the user name and the value is the password.
-func BasicAuth(db *gorm.DB, user interface{}) gin.HandlerFunc {
-	return BasicAuthForRealm(db, user, "")
+// BasicAuth returns a Basic HTTP Authorization middleware.
+// Users are authenticated by the authenticator chain, see AuthenticateUser.
+func BasicAuth() gin.HandlerFunc {
+	return BasicAuthForRealm("")
 }
 
 // BasicAuthForRealm returns a Basic HTTP Authorization middleware.
 // If the realm is empty, "Authorization Required" will be used by default.
-func BasicAuthForRealm(db *gorm.DB, user interface{}, realm string) gin.HandlerFunc {
+func BasicAuthForRealm(realm string) gin.HandlerFunc {
 	if realm == "" {
 		realm = "Authorization Required"
 	}
@@ -52,17 +51,30 @@ func BasicAuthForRealm(db *gorm.DB, user interface{}, realm string) gin.HandlerF
 			return
 		}
 
-		nUser := CopyEmptyStruct(user)
-		err = Authenticate(db, username, password, nUser)
+		user, err := LoginAuthenticate(ctx, username, password)
 		if err != nil {
+			if IsThrottled(err) {
+				ctx.AbortWithStatusJSON(http.StatusTooManyRequests, err.Error())
+				return
+			}
 			// Credentials doesn't match, we return 401 and abort handlers chain.
 			ctx.Header("WWW-Authenticate", realm)
 			ctx.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
 
+		// password only login is not allowed if two-factor authentication is enabled or required,
+		// use jwt or auth token instead
+		if enrollOnly, err := LoginSecondFactor(ctx, user, ""); err != nil || enrollOnly {
+			if err == nil {
+				err = ErrTwoFactorEnrollRequired
+			}
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, err.Error())
+			return
+		}
+
 		// The user credentials was found, set user object to key AuthUserKey in this context
-		ctx.Set(AuthUserKey, nUser)
+		ctx.Set(AuthUserKey, user)
 	}
 }
 
@@ -0,0 +1,95 @@
+package middlewares
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ParseTrustedProxies parse IPs or CIDRs of "server.trusted_proxies"
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: p}
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			p += "/" + strconv.Itoa(bits)
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trustedProxies parsed "server.trusted_proxies", set by ConfigureTrustedProxies
+var trustedProxies []*net.IPNet
+
+// ConfigureTrustedProxies parse proxies and use them by ClientIP, called once after configs are loaded
+func ConfigureTrustedProxies(proxies []string) error {
+
+	nets, err := ParseTrustedProxies(proxies)
+	if err != nil {
+		return err
+	}
+	trustedProxies = nets
+	return nil
+}
+
+func trusted(nets []*net.IPNet, ip net.IP) bool {
+
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP return ip of client, which is the peer address of connection, or from header "X-Forwarded-For"
+// and "X-Real-IP" only if the peer is a trusted proxy set by ConfigureTrustedProxies; addresses of trusted
+// proxies in "X-Forwarded-For" are skipped from the right
+func ClientIP(ctx *gin.Context) string {
+
+	host, _, err := net.SplitHostPort(strings.TrimSpace(ctx.Request.RemoteAddr))
+	if err != nil {
+		host = strings.TrimSpace(ctx.Request.RemoteAddr)
+	}
+	peer := net.ParseIP(host)
+	if peer == nil {
+		return host
+	}
+	nets := trustedProxies
+	if !trusted(nets, peer) {
+		return peer.String()
+	}
+
+	if xff := ctx.GetHeader("X-Forwarded-For"); xff != "" {
+		addrs := strings.Split(xff, ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+			if ip == nil {
+				break
+			}
+			if !trusted(nets, ip) || i == 0 {
+				return ip.String()
+			}
+		}
+		return peer.String()
+	}
+	if ip := net.ParseIP(strings.TrimSpace(ctx.GetHeader("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return peer.String()
+}
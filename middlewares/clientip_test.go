@@ -0,0 +1,50 @@
+package middlewares_test
+
+import (
+	"harbor/config"
+	"harbor/middlewares"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClientIP(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+	if err := middlewares.ConfigureTrustedProxies([]string{"10.0.0.1", "192.168.0.0/16"}); err != nil {
+		t.Fatal(err)
+	}
+	defer middlewares.ConfigureTrustedProxies(config.GetConfigs().Server.TrustedProxies)
+	if err := middlewares.ConfigureTrustedProxies([]string{"10.0.0.1", "bad"}); err == nil {
+		t.Error("invalid proxy should fail")
+	}
+
+	for _, c := range []struct {
+		remote, xff, realIP, want string
+	}{
+		{"1.2.3.4:5000", "", "", "1.2.3.4"},
+		{"1.2.3.4:5000", "9.9.9.9", "8.8.8.8", "1.2.3.4"}, // headers of untrusted peer are ignored
+		{"10.0.0.1:5000", "9.9.9.9", "", "9.9.9.9"},
+		{"10.0.0.1:5000", "6.6.6.6, 9.9.9.9, 192.168.1.2", "", "9.9.9.9"}, // spoofed leftmost address is skipped
+		{"10.0.0.1:5000", "192.168.1.3, 192.168.1.2", "", "192.168.1.3"},
+		{"10.0.0.1:5000", "bad, 9.9.9.9", "", "9.9.9.9"},
+		{"10.0.0.1:5000", "", "8.8.8.8", "8.8.8.8"},
+		{"10.0.0.2:5000", "9.9.9.9", "", "10.0.0.2"},
+		{"[::1]:5000", "9.9.9.9", "", "::1"},
+	} {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request, _ = http.NewRequest("GET", "/", nil)
+		ctx.Request.RemoteAddr = c.remote
+		if c.xff != "" {
+			ctx.Request.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if c.realIP != "" {
+			ctx.Request.Header.Set("X-Real-IP", c.realIP)
+		}
+		if got := middlewares.ClientIP(ctx); got != c.want {
+			t.Errorf("remote %s, X-Forwarded-For %q: got %s, want %s", c.remote, c.xff, got, c.want)
+		}
+	}
+}
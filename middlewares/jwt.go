@@ -1,10 +1,13 @@
 package middlewares
 
 import (
+	"errors"
+	"fmt"
 	"harbor/config"
-	"harbor/database"
 	"harbor/middlewares/jwt"
 	"harbor/models"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,6 +19,7 @@ var identityKey = "user"
 type JWTLoginForm struct {
 	Username string `json:"username" form:"username"`
 	Password string `json:"password" form:"password"`
+	OTPCode  string `json:"otp_code,omitempty" form:"otp_code"` // TOTP code or recovery code, required if two-factor authentication enabled
 }
 
 // JWTRefreshForm jwt login form struct
@@ -32,23 +36,46 @@ func jwtAuthenticator(c *gin.Context) (interface{}, error) {
 	username := loginForm.Username
 	password := loginForm.Password
 
-	user := &models.UserProfile{}
-	db := database.GetDB("default")
-	err := Authenticate(db, username, password, user)
+	user, err := LoginAuthenticate(c, username, password)
 	if err != nil {
 		return nil, err
 	}
 
+	enrollOnly, err := LoginSecondFactor(c, user, loginForm.OTPCode)
+	if err != nil {
+		return nil, err
+	}
+	if enrollOnly {
+		return &twoFactorEnrollUser{user}, nil
+	}
 	return user, nil
 }
 
+// twoFactorEnrollUser user must enroll two-factor authentication, jwt of it only allow to access enrollment APIs
+type twoFactorEnrollUser struct {
+	*models.UserProfile
+}
+
+// TwoFactorEnrollIdentity return identity of user for jwt which only allows to access enrollment APIs
+func TwoFactorEnrollIdentity(user *models.UserProfile) interface{} {
+
+	return &twoFactorEnrollUser{user}
+}
+
 // Which user information is used to generate payload
 func jwtPayloadFunc(data interface{}) jwt.MapClaims {
+	if u, ok := data.(*twoFactorEnrollUser); ok {
+		claims := jwtPayloadFunc(u.UserProfile)
+		claims[TwoFactorEnrollClaim] = true
+		return claims
+	}
 	if user, ok := data.(*models.UserProfile); ok {
 		return jwt.MapClaims{
 			"id":           user.ID,
 			"username":     user.Username,
 			"is_superuser": user.IsSuperUser,
+			"is_staff":     user.IsStaff,
+			"role":         user.Role,
 		}
 	}
 	return jwt.MapClaims{}
@@ -59,32 +86,70 @@ func JWTAuthMiddleware() (*jwt.GinJWTMiddleware, error) {
 
 	configs := config.GetConfigs()
 	secretKey := configs.SecretKey
+	jwtConfig := configs.JWT
+
+	algorithm := strings.ToUpper(jwtConfig.Algorithm)
+	if algorithm == "" {
+		algorithm = "HS256"
+	}
+	timeout := jwtConfig.Timeout
+	if timeout <= 0 {
+		timeout = 24 * time.Hour
+	}
+	maxRefresh := jwtConfig.MaxRefresh
+	if maxRefresh <= 0 {
+		maxRefresh = 7 * 24 * time.Hour
+	}
+
+	// asymmetric algorithm use key set
+	var keySet *jwt.KeySet
+	if !strings.HasPrefix(algorithm, "HS") {
+		ks, err := jwtKeySetFromConfig(configs, algorithm)
+		if err != nil {
+			return nil, err
+		}
+		keySet = ks
+	}
 
 	// the jwt middleware
 	return jwt.New(&jwt.GinJWTMiddleware{
-		Realm:       "",
-		Key:         []byte(secretKey),
-		Timeout:     24 * time.Hour,
-		MaxRefresh:  7 * 24 * time.Hour,
-		IdentityKey: identityKey,
-		PayloadFunc: jwtPayloadFunc,
+		Realm:            "",
+		SigningAlgorithm: algorithm,
+		Key:              []byte(secretKey),
+		KeySet:           keySet,
+		Timeout:          timeout,
+		MaxRefresh:       maxRefresh,
+		IdentityKey:      identityKey,
+		PayloadFunc:      jwtPayloadFunc,
 		IdentityHandler: func(c *gin.Context) interface{} {
 			claims := jwt.ExtractClaims(c)
+			// "is_staff" and "role" are absent in jwt issued by older versions
+			isStaff, _ := claims["is_staff"].(bool)
+			role, _ := claims["role"].(float64)
 			return &models.UserProfile{
 				ID:          uint(claims["id"].(float64)),
 				Username:    claims["username"].(string),
 				IsSuperUser: claims["is_superuser"].(bool),
+				IsStaff:     isStaff,
+				Role:        int16(role),
 			}
 		},
 		Authenticator: jwtAuthenticator,
 		Authorizator: func(data interface{}, c *gin.Context) bool {
-			if v, ok := data.(*models.UserProfile); ok && (v.ID > 0) {
-				return true
+			v, ok := data.(*models.UserProfile)
+			if !ok || v.ID == 0 {
+				return false
 			}
-
-			return false
+			// enroll-only token
+			if enroll, _ := jwt.ExtractClaims(c)[TwoFactorEnrollClaim].(bool); enroll {
+				return strings.HasPrefix(c.Request.URL.Path, TwoFactorEnrollPath)
+			}
+			return true
 		},
 		Unauthorized: func(c *gin.Context, code int, message string) {
+			if c.GetBool(loginThrottledKey) {
+				code = http.StatusTooManyRequests
+			}
 			c.JSON(code, gin.H{
 				"code":      code,
 				"code_text": message,
@@ -108,14 +173,65 @@ func JWTAuthMiddleware() (*jwt.GinJWTMiddleware, error) {
 
 		// TimeFunc provides the current time. You can override it to use another time value. This is useful for testing or if your server uses a different time zone than your tokens.
 		TimeFunc:                time.Now,
-		DisabledAbort:           true, //
+		DisabledAbort:           false,
 		DoNothingIfNotJWTHeader: true,
 	})
 }
 
+// jwtKeySetFromConfig build key set from jwt configs
+// if no keys configured, use the single key pair "priv_key_file" and "pub_key_file" with kid "default"
+func jwtKeySetFromConfig(configs *config.Config, algorithm string) (*jwt.KeySet, error) {
+
+	jwtConfig := configs.JWT
+	keyConfigs := jwtConfig.Keys
+	if len(keyConfigs) == 0 {
+		if jwtConfig.PrivKeyFile == "" || jwtConfig.PubKeyFile == "" {
+			return nil, errors.New("jwt config 'priv_key_file' and 'pub_key_file' or 'keys' are required for algorithm " + algorithm)
+		}
+		keyConfigs = []config.JWTKeyConfig{
+			{
+				KeyID:       "default",
+				PrivKeyFile: jwtConfig.PrivKeyFile,
+				PubKeyFile:  jwtConfig.PubKeyFile,
+			},
+		}
+	}
+
+	ks := jwt.NewKeySet()
+	for _, kc := range keyConfigs {
+		key := &jwt.SigningKey{
+			KeyID:       kc.KeyID,
+			Algorithm:   strings.ToUpper(kc.Algorithm),
+			PrivKeyFile: configs.AbsPath(kc.PrivKeyFile),
+			PubKeyFile:  configs.AbsPath(kc.PubKeyFile),
+		}
+		if key.Algorithm == "" {
+			key.Algorithm = algorithm
+		}
+		if kc.ActiveFrom != "" {
+			t, err := time.Parse(time.RFC3339, kc.ActiveFrom)
+			if err != nil {
+				return nil, fmt.Errorf("invalid 'active_from' of jwt key '%s': %s", kc.KeyID, err)
+			}
+			key.ActiveFrom = t
+		}
+		if kc.ExpireAt != "" {
+			t, err := time.Parse(time.RFC3339, kc.ExpireAt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid 'expire_at' of jwt key '%s': %s", kc.KeyID, err)
+			}
+			key.ExpireAt = t
+		}
+		ks.Keys = append(ks.Keys, key)
+	}
+	return ks, nil
+}
+
 // jwtLoginHandler API document
 // @Summary 认证获取jwt
 // @Description jwt login
+// @Description 开启了两步验证(TOTP)的用户需要提交“otp_code”，动态验证码或恢复码；
+// @Description 要求两步验证但未绑定的职员或超级用户，获取的jwt只能访问/api/v1/2fa/绑定两步验证
 // @Tags jwt
 // @Accept  json
 // @Produce  json
@@ -144,6 +260,16 @@ func jwtLoginHandler() {}
 // @Router /api/v1/jwt-token-refresh/ [post]
 func jwtRefreshHandler() {}
 
+// jwksHandler API document
+// @Summary 获取jwt公钥集合(JWKS)
+// @Description 获取签发jwt使用的公钥集合(JWK Set)，其他服务可以通过"kid"找到对应的公钥来验证jwt；
+// @Description 只有非对称签名算法(RS*、ES*)时有公钥，HS*算法时返回空集合
+// @Tags jwt
+// @Produce json
+// @Success 200 {object} jwt.JSONWebKeySet
+// @Router /.well-known/jwks.json [get]
+func jwksHandler() {}
+
 // UserFromJWTPayload return user or nil
 func UserFromJWTPayload(ctx *gin.Context) *models.UserProfile {
 
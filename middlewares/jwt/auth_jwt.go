@@ -106,6 +106,10 @@ type GinJWTMiddleware struct {
 	// Public key
 	pubKey *rsa.PublicKey
 
+	// Key set for asymmetric algorithms, supports multiple keys identified by kid and key rotation.
+	// If set, SigningAlgorithm, Key, PrivKeyFile and PubKeyFile are ignored.
+	KeySet *KeySet
+
 	// Optionally return the token as a cookie
 	SendCookie bool
 
@@ -357,6 +361,10 @@ func (mw *GinJWTMiddleware) MiddlewareInit() error {
 		mw.CookieName = "jwt"
 	}
 
+	if mw.KeySet != nil {
+		return mw.KeySet.Load()
+	}
+
 	if mw.usingPublicKeyAlgo() {
 		return mw.readKeys()
 	}
@@ -492,6 +500,16 @@ func (mw *GinJWTMiddleware) LoginHandler(c *gin.Context) {
 func (mw *GinJWTMiddleware) signedString(token *jwt.Token) (string, error) {
 	var tokenString string
 	var err error
+	if mw.KeySet != nil {
+		key, err := mw.KeySet.SigningKey(mw.TimeFunc())
+		if err != nil {
+			return "", err
+		}
+		token.Method = jwt.GetSigningMethod(key.Algorithm)
+		token.Header["alg"] = key.Algorithm
+		token.Header["kid"] = key.KeyID
+		return token.SignedString(key.privKey)
+	}
 	if mw.usingPublicKeyAlgo() {
 		tokenString, err = token.SignedString(mw.privKey)
 	} else {
@@ -682,32 +700,45 @@ func (mw *GinJWTMiddleware) ParseToken(c *gin.Context) (*jwt.Token, error) {
 	}
 
 	return jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		if jwt.GetSigningMethod(mw.SigningAlgorithm) != t.Method {
-			return nil, ErrInvalidSigningAlgorithm
-		}
-		if mw.usingPublicKeyAlgo() {
-			return mw.pubKey, nil
+		key, err := mw.keyFunc(t)
+		if err != nil {
+			return nil, err
 		}
 
 		// save token string if vaild
 		c.Set("JWT_TOKEN", token)
 
-		return mw.Key, nil
+		return key, nil
 	})
 }
 
 // ParseTokenString parse jwt token string
 func (mw *GinJWTMiddleware) ParseTokenString(token string) (*jwt.Token, error) {
-	return jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		if jwt.GetSigningMethod(mw.SigningAlgorithm) != t.Method {
-			return nil, ErrInvalidSigningAlgorithm
+	return jwt.Parse(token, mw.keyFunc)
+}
+
+// keyFunc return the key to verify the token
+func (mw *GinJWTMiddleware) keyFunc(t *jwt.Token) (interface{}, error) {
+	if mw.KeySet != nil {
+		kid, _ := t.Header["kid"].(string)
+		key, err := mw.KeySet.VerifyKey(kid, mw.TimeFunc())
+		if err != nil {
+			return nil, err
 		}
-		if mw.usingPublicKeyAlgo() {
-			return mw.pubKey, nil
+		if jwt.GetSigningMethod(key.Algorithm) != t.Method {
+			return nil, ErrInvalidSigningAlgorithm
 		}
+		return key.pubKey, nil
+	}
 
-		return mw.Key, nil
-	})
+	if jwt.GetSigningMethod(mw.SigningAlgorithm) != t.Method {
+		return nil, ErrInvalidSigningAlgorithm
+	}
+	if mw.usingPublicKeyAlgo() {
+		return mw.pubKey, nil
+	}
+
+	return mw.Key, nil
 }
 
 func (mw *GinJWTMiddleware) unauthorized(c *gin.Context, code int, message string) {
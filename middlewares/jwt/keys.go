@@ -0,0 +1,272 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrNoActiveSigningKey indicates there is no key can be used to sign token now
+	ErrNoActiveSigningKey = errors.New("no active signing key")
+
+	// ErrUnknownKeyID indicates the kid in token header is not found or the key is expired
+	ErrUnknownKeyID = errors.New("unknown or expired key id")
+
+	// ErrDuplicateKeyID indicates that more than one key has the same kid
+	ErrDuplicateKeyID = errors.New("duplicate key id")
+)
+
+// SigningKey a asymmetric key pair used to sign or verify jwt, identified by KeyID(kid)
+type SigningKey struct {
+	// Key id, it will be set to the "kid" header of the token. Required.
+	KeyID string
+
+	// Signing algorithm - possible values are RS256, RS384, RS512, ES256, ES384, ES512
+	Algorithm string
+
+	// Private key file, optional for a key only used to verify
+	PrivKeyFile string
+
+	// Public key file. Required.
+	PubKeyFile string
+
+	// The key is used to sign new token since ActiveFrom, zero means always.
+	// Before ActiveFrom the key is only published and used to verify.
+	ActiveFrom time.Time
+
+	// The key is not used to sign or verify token after ExpireAt, zero means never.
+	ExpireAt time.Time
+
+	privKey interface{}
+	pubKey  interface{}
+}
+
+// usingRSA return true if key is a RSA key
+func (k *SigningKey) usingRSA() bool {
+	switch k.Algorithm {
+	case "RS256", "RS384", "RS512":
+		return true
+	}
+	return false
+}
+
+// usingECDSA return true if key is a ECDSA key
+func (k *SigningKey) usingECDSA() bool {
+	switch k.Algorithm {
+	case "ES256", "ES384", "ES512":
+		return true
+	}
+	return false
+}
+
+// load read private key and public key from file
+func (k *SigningKey) load() error {
+
+	if !k.usingRSA() && !k.usingECDSA() {
+		return ErrInvalidSigningAlgorithm
+	}
+
+	if k.PrivKeyFile != "" {
+		keyData, err := ioutil.ReadFile(k.PrivKeyFile)
+		if err != nil {
+			return ErrNoPrivKeyFile
+		}
+		if k.usingRSA() {
+			k.privKey, err = jwt.ParseRSAPrivateKeyFromPEM(keyData)
+		} else {
+			k.privKey, err = jwt.ParseECPrivateKeyFromPEM(keyData)
+		}
+		if err != nil {
+			return ErrInvalidPrivKey
+		}
+	}
+
+	keyData, err := ioutil.ReadFile(k.PubKeyFile)
+	if err != nil {
+		return ErrNoPubKeyFile
+	}
+	if k.usingRSA() {
+		k.pubKey, err = jwt.ParseRSAPublicKeyFromPEM(keyData)
+	} else {
+		k.pubKey, err = jwt.ParseECPublicKeyFromPEM(keyData)
+	}
+	if err != nil {
+		return ErrInvalidPubKey
+	}
+	return nil
+}
+
+// canSign return true if the key can be used to sign token at time now
+func (k *SigningKey) canSign(now time.Time) bool {
+
+	if k.privKey == nil || k.isExpired(now) {
+		return false
+	}
+	return k.ActiveFrom.IsZero() || !now.Before(k.ActiveFrom)
+}
+
+// isExpired return true if the key is expired at time now
+func (k *SigningKey) isExpired(now time.Time) bool {
+
+	return !k.ExpireAt.IsZero() && !now.Before(k.ExpireAt)
+}
+
+// KeySet a set of signing keys, supports multiple active keys and scheduled rotation.
+// The key with the latest ActiveFrom in all keys that can sign is used to sign new token,
+// all not expired keys are used to verify token by the "kid" header.
+type KeySet struct {
+	Keys []*SigningKey
+}
+
+// NewKeySet return a key set
+func NewKeySet(keys ...*SigningKey) *KeySet {
+
+	return &KeySet{Keys: keys}
+}
+
+// Load read all keys from files
+func (ks *KeySet) Load() error {
+
+	if len(ks.Keys) == 0 {
+		return ErrNoActiveSigningKey
+	}
+
+	ids := map[string]bool{}
+	for _, k := range ks.Keys {
+		if k.KeyID == "" || ids[k.KeyID] {
+			return ErrDuplicateKeyID
+		}
+		ids[k.KeyID] = true
+
+		if err := k.load(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SigningKey return the key used to sign token at time now
+func (ks *KeySet) SigningKey(now time.Time) (*SigningKey, error) {
+
+	var key *SigningKey
+	for _, k := range ks.Keys {
+		if !k.canSign(now) {
+			continue
+		}
+		if key == nil || k.ActiveFrom.After(key.ActiveFrom) {
+			key = k
+		}
+	}
+	if key == nil {
+		return nil, ErrNoActiveSigningKey
+	}
+	return key, nil
+}
+
+// VerifyKey return the not expired key by kid
+func (ks *KeySet) VerifyKey(kid string, now time.Time) (*SigningKey, error) {
+
+	for _, k := range ks.Keys {
+		if k.KeyID == kid && !k.isExpired(now) {
+			return k, nil
+		}
+	}
+	return nil, ErrUnknownKeyID
+}
+
+// JSONWebKey public key in JWK format(RFC 7517)
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet JWK set
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS return all not expired public keys in JWK set format
+func (ks *KeySet) JWKS(now time.Time) *JSONWebKeySet {
+
+	set := &JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, k := range ks.Keys {
+		if k.isExpired(now) {
+			continue
+		}
+		jwk := JSONWebKey{
+			KeyID:     k.KeyID,
+			Use:       "sig",
+			Algorithm: k.Algorithm,
+		}
+		switch pub := k.pubKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = pub.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(padBytes(pub.X.Bytes(), size))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(padBytes(pub.Y.Bytes(), size))
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	// newest key first
+	sort.SliceStable(set.Keys, func(i, j int) bool {
+		return ks.activeFrom(set.Keys[i].KeyID).After(ks.activeFrom(set.Keys[j].KeyID))
+	})
+	return set
+}
+
+func (ks *KeySet) activeFrom(kid string) time.Time {
+
+	for _, k := range ks.Keys {
+		if k.KeyID == kid {
+			return k.ActiveFrom
+		}
+	}
+	return time.Time{}
+}
+
+// padBytes left pad b with zero to size
+func padBytes(b []byte, size int) []byte {
+
+	if len(b) >= size {
+		return b
+	}
+	p := make([]byte, size)
+	copy(p[size-len(b):], b)
+	return p
+}
+
+// JWKSHandler publish public keys of the key set, used by other services to verify tokens
+func (mw *GinJWTMiddleware) JWKSHandler(c *gin.Context) {
+
+	if mw.KeySet == nil {
+		c.JSON(200, &JSONWebKeySet{Keys: []JSONWebKey{}})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(200, mw.KeySet.JWKS(mw.TimeFunc()))
+}
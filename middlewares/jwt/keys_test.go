@@ -0,0 +1,79 @@
+package jwt_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"harbor/middlewares/jwt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeRSAKeyPair(t *testing.T, dir, name string) (string, string) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privFile := filepath.Join(dir, name+".key")
+	pubFile := filepath.Join(dir, name+".pub")
+	privPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	pubBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
+	if err := ioutil.WriteFile(privFile, privPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pubFile, pubPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return privFile, pubFile
+}
+
+func TestKeySetRotation(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "jwtkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	oldPriv, oldPub := writeRSAKeyPair(t, dir, "old")
+	newPriv, newPub := writeRSAKeyPair(t, dir, "new")
+	ks := jwt.NewKeySet(
+		&jwt.SigningKey{KeyID: "old", Algorithm: "RS256", PrivKeyFile: oldPriv, PubKeyFile: oldPub,
+			ExpireAt: now.Add(48 * time.Hour)},
+		&jwt.SigningKey{KeyID: "new", Algorithm: "RS256", PrivKeyFile: newPriv, PubKeyFile: newPub,
+			ActiveFrom: now.Add(24 * time.Hour)},
+	)
+	if err := ks.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if k, err := ks.SigningKey(now); err != nil || k.KeyID != "old" {
+		t.Errorf("signing key should be 'old' before rotation")
+	}
+	if k, err := ks.SigningKey(now.Add(25 * time.Hour)); err != nil || k.KeyID != "new" {
+		t.Errorf("signing key should be 'new' after rotation")
+	}
+	if _, err := ks.VerifyKey("old", now.Add(25*time.Hour)); err != nil {
+		t.Errorf("key 'old' should be accepted until it expires")
+	}
+	if _, err := ks.VerifyKey("old", now.Add(49*time.Hour)); err != jwt.ErrUnknownKeyID {
+		t.Errorf("key 'old' should not be accepted after it expires")
+	}
+
+	if jwks := ks.JWKS(now); len(jwks.Keys) != 2 || jwks.Keys[0].KeyID != "new" {
+		t.Errorf("jwks should publish 2 keys, newest first")
+	}
+	if jwks := ks.JWKS(now.Add(49 * time.Hour)); len(jwks.Keys) != 1 || jwks.Keys[0].KeyType != "RSA" {
+		t.Errorf("jwks should not publish expired key")
+	}
+}
@@ -0,0 +1,335 @@
+package middlewares
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"harbor/config"
+	"harbor/models"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapUserFields UserProfile fields can be mapped from LDAP attributes
+var ldapUserFields = map[string]func(u *models.UserProfile, value string){
+	"email":      func(u *models.UserProfile, v string) { u.Email = v },
+	"first_name": func(u *models.UserProfile, v string) { u.FirstName = v },
+	"last_name":  func(u *models.UserProfile, v string) { u.LastName = v },
+	"company":    func(u *models.UserProfile, v string) { u.Company = v },
+	"telephone":  func(u *models.UserProfile, v string) { u.Telephone = v },
+}
+
+// LDAPAuthenticator authenticate user by LDAP bind, local user is created at first login if "auto_create";
+// successful binds are cached for "bind_cache_ttl", so that Basic auth does not dial LDAP for every request
+type LDAPAuthenticator struct {
+	conf  *config.LDAPConfig
+	cache *ldapBindCache // nil if disabled
+}
+
+// ldapBindCache passwords of successful binds by username, kept as HMAC by a random key of the process
+type ldapBindCache struct {
+	ttl     time.Duration
+	key     []byte
+	mu      sync.Mutex
+	entries map[string]ldapCachedBind
+}
+
+type ldapCachedBind struct {
+	mac    []byte
+	expire time.Time
+}
+
+// ldapBindCacheMaxSize expired entries are removed when the cache grows beyond it
+const ldapBindCacheMaxSize = 1024
+
+func newLDAPBindCache(ttl time.Duration) *ldapBindCache {
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil
+	}
+	return &ldapBindCache{ttl: ttl, key: key, entries: map[string]ldapCachedBind{}}
+}
+
+func (c *ldapBindCache) mac(username, password string) []byte {
+
+	h := hmac.New(sha256.New, c.key)
+	h.Write([]byte(username))
+	h.Write([]byte{0})
+	h.Write([]byte(password))
+	return h.Sum(nil)
+}
+
+// valid return true if username bound with password within ttl
+func (c *ldapBindCache) valid(username, password string) bool {
+
+	c.mu.Lock()
+	e, ok := c.entries[username]
+	c.mu.Unlock()
+	return ok && time.Now().Before(e.expire) && hmac.Equal(e.mac, c.mac(username, password))
+}
+
+func (c *ldapBindCache) add(username, password string) {
+
+	mac := c.mac(username, password)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= ldapBindCacheMaxSize {
+		for k, e := range c.entries {
+			if !now.Before(e.expire) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if len(c.entries) < ldapBindCacheMaxSize {
+		c.entries[username] = ldapCachedBind{mac: mac, expire: now.Add(c.ttl)}
+	}
+}
+
+func (c *ldapBindCache) remove(username string) {
+
+	c.mu.Lock()
+	delete(c.entries, username)
+	c.mu.Unlock()
+}
+
+// NewLDAPAuthenticator return a LDAP authenticator
+func NewLDAPAuthenticator(c *config.LDAPConfig) (*LDAPAuthenticator, error) {
+
+	if c.URL == "" {
+		return nil, errors.New("ldap config 'url' is required")
+	}
+	if c.UserDNTemplate == "" && c.UserSearchBase == "" {
+		return nil, errors.New("ldap config 'user_dn_template' or 'user_search_base' is required")
+	}
+	for field := range c.Attributes {
+		if _, ok := ldapUserFields[field]; !ok {
+			return nil, errors.New("ldap config 'attributes': unknown user field " + field)
+		}
+	}
+	a := &LDAPAuthenticator{conf: c}
+	ttl := c.BindCacheTTL
+	if ttl == 0 {
+		ttl = time.Minute
+	}
+	if ttl > 0 {
+		a.cache = newLDAPBindCache(ttl)
+	}
+	return a, nil
+}
+
+// dial connect to LDAP server, upgrade to TLS if "start_tls"
+func (a *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+
+	timeout := a.conf.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	u, err := url.Parse(a.conf.URL)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: a.conf.InsecureSkipVerify,
+	}
+
+	conn, err := ldap.DialURL(a.conf.URL, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+	if a.conf.StartTLS && u.Scheme == "ldap" {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// attributes return the LDAP attributes to read
+func (a *LDAPAuthenticator) attributes() []string {
+
+	attrs := []string{}
+	for _, attr := range a.conf.Attributes {
+		attrs = append(attrs, attr)
+	}
+	return attrs
+}
+
+// findUser return the entry of user, search by "user_filter" or read "user_dn_template" directly
+// it must be called after binding with the service account, or the user if "user_dn_template"
+func (a *LDAPAuthenticator) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+
+	var req *ldap.SearchRequest
+	if a.conf.UserDNTemplate != "" {
+		dn := strings.Replace(a.conf.UserDNTemplate, "%s", escapeDNValue(username), -1)
+		req = ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+			"(objectClass=*)", a.attributes(), nil)
+	} else {
+		filter := a.conf.UserFilter
+		if filter == "" {
+			filter = "(uid=%s)"
+		}
+		filter = strings.Replace(filter, "%s", ldap.EscapeFilter(username), -1)
+		req = ldap.NewSearchRequest(a.conf.UserSearchBase, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+			filter, a.attributes(), nil)
+	}
+
+	result, err := conn.Search(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) || ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	// user not found or username is ambiguous
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return result.Entries[0], nil
+}
+
+// Authenticate bind with user's DN and password, the local user is returned without binding
+// if the user bound with the same password recently
+func (a *LDAPAuthenticator) Authenticate(username, password string) (*models.UserProfile, error) {
+
+	// empty password is an unauthenticated bind, always success
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	if a.cache != nil && a.cache.valid(username, password) {
+		user, err := models.NewUserManager().GetUserByName(username)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil && user.IsActived() {
+			return user, nil
+		}
+		a.cache.remove(username)
+	}
+
+	user, err := a.bind(username, password)
+	if err != nil {
+		return nil, err
+	}
+	if a.cache != nil {
+		a.cache.add(username, password)
+	}
+	return user, nil
+}
+
+// bind bind with user's DN and password, and sync the local user
+func (a *LDAPAuthenticator) bind(username, password string) (*models.UserProfile, error) {
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var entry *ldap.Entry
+	if a.conf.UserDNTemplate != "" {
+		dn := strings.Replace(a.conf.UserDNTemplate, "%s", escapeDNValue(username), -1)
+		if err := ldapBind(conn, dn, password); err != nil {
+			return nil, err
+		}
+		if entry, err = a.findUser(conn, username); err != nil {
+			return nil, err
+		}
+	} else {
+		if a.conf.BindDN != "" {
+			if err := conn.Bind(a.conf.BindDN, a.conf.BindPassword); err != nil {
+				return nil, err
+			}
+		}
+		if entry, err = a.findUser(conn, username); err != nil {
+			return nil, err
+		}
+		if err := ldapBind(conn, entry.DN, password); err != nil {
+			return nil, err
+		}
+	}
+
+	return a.syncUser(username, entry)
+}
+
+// syncUser get or create local user, update fields from LDAP attributes
+func (a *LDAPAuthenticator) syncUser(username string, entry *ldap.Entry) (*models.UserProfile, error) {
+
+	um := models.NewUserManager()
+	user, err := um.GetUserByName(username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		if !a.conf.AutoCreate {
+			return nil, errors.New("invalid username,user is not found")
+		}
+		user = models.NewUserProfile()
+		user.Username = username
+		user.IsActive = true
+		user.EmailVerified = true
+		user.SetPassword("") // login by LDAP only
+	} else if !user.IsActived() {
+		return nil, errors.New("user is not actived")
+	}
+
+	for field, attr := range a.conf.Attributes {
+		if v := entry.GetAttributeValue(attr); v != "" {
+			ldapUserFields[field](user, v)
+		}
+	}
+	if user.Email == "" && strings.Contains(username, "@") {
+		user.Email = username
+	}
+
+	user.LastLogin = models.JSONTimeNow()
+	if err := um.SaveUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ldapBind bind, return ErrInvalidCredentials if password is not correct
+func ldapBind(conn *ldap.Conn, dn, password string) error {
+
+	if err := conn.Bind(dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+	return nil
+}
+
+// escapeDNValue escape special characters of a DN attribute value(RFC 4514)
+func escapeDNValue(s string) string {
+
+	b := strings.Builder{}
+	for i, c := range s {
+		switch {
+		case strings.ContainsRune(",+\"\\<>;=", c),
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(s)-1 && c == ' ':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c == 0:
+			b.WriteString("\\00")
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
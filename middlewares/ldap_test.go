@@ -0,0 +1,239 @@
+package middlewares_test
+
+import (
+	"harbor/config"
+	"harbor/internal/testdb"
+	"harbor/middlewares"
+	"harbor/models"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeLDAP LDAP server supporting simple bind and search by base object or equality filter
+type fakeLDAP struct {
+	ln        net.Listener
+	passwords map[string]string              // dn -> password
+	entries   map[string]map[string][]string // dn -> attributes
+	mu        sync.Mutex
+	binds     int
+}
+
+func newFakeLDAP(t *testing.T) *fakeLDAP {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeLDAP{ln: ln, passwords: map[string]string{}, entries: map[string]map[string][]string{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeLDAP) URL() string {
+
+	return "ldap://" + s.ln.Addr().String()
+}
+
+func (s *fakeLDAP) Binds() int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.binds
+}
+
+func ldapResult(id interface{}, tag ber.Tag, code int) *ber.Packet {
+
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	r := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	r.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	p.AppendChild(r)
+	return p
+}
+
+// match return true if entry matches filter of present or equality match
+func match(filter *ber.Packet, attrs map[string][]string) bool {
+
+	switch filter.Tag {
+	case ldap.FilterPresent:
+		return strings.EqualFold(filter.Data.String(), "objectClass") || attrs[filter.Data.String()] != nil
+	case ldap.FilterEqualityMatch:
+		for _, v := range attrs[filter.Children[0].Value.(string)] {
+			if v == filter.Children[1].Value.(string) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *fakeLDAP) serve(conn net.Conn) {
+
+	defer conn.Close()
+	for {
+		p, err := ber.ReadPacket(conn)
+		if err != nil || len(p.Children) < 2 {
+			return
+		}
+		id, op := p.Children[0].Value, p.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, password := op.Children[1].Value.(string), op.Children[2].Data.String()
+			s.mu.Lock()
+			s.binds++
+			s.mu.Unlock()
+			code := ldap.LDAPResultInvalidCredentials
+			if pw, ok := s.passwords[dn]; ok && pw == password {
+				code = ldap.LDAPResultSuccess
+			}
+			conn.Write(ldapResult(id, ldap.ApplicationBindResponse, int(code)).Bytes())
+		case ldap.ApplicationSearchRequest:
+			base, scope, filter := op.Children[0].Value.(string), op.Children[1].Value.(int64), op.Children[6]
+			code := ldap.LDAPResultSuccess
+			if _, ok := s.entries[base]; !ok && scope == ldap.ScopeBaseObject {
+				code = ldap.LDAPResultNoSuchObject
+			}
+			for dn, attrs := range s.entries {
+				if (scope == ldap.ScopeBaseObject && dn != base) || !strings.HasSuffix(dn, base) || !match(filter, attrs) {
+					continue
+				}
+				r := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+				r.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+				e := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+				e.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+				list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+				for name, values := range attrs {
+					a := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+					a.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+					set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+					for _, v := range values {
+						set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+					}
+					a.AppendChild(set)
+					list.AppendChild(a)
+				}
+				e.AppendChild(list)
+				r.AppendChild(e)
+				conn.Write(r.Bytes())
+			}
+			conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, int(code)).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+var dbOnce sync.Once
+
+// setupDB init databases "default" and "objs" with in-memory sqlite
+func setupDB(t *testing.T) {
+
+	dbOnce.Do(func() { testdb.Setup(t) })
+}
+
+func TestLDAPAuthenticator(t *testing.T) {
+
+	setupDB(t)
+	srv := newFakeLDAP(t)
+	defer srv.ln.Close()
+	srv.passwords["cn=harbor,dc=example"] = "service"
+	srv.passwords["uid=alice,ou=people,dc=example"] = "alice-pw"
+	srv.entries["uid=alice,ou=people,dc=example"] = map[string][]string{
+		"uid": {"alice"}, "mail": {"alice@example.com"}, "givenName": {"Alice"}, "sn": {"Liddell"},
+	}
+	srv.passwords["uid=bob,ou=people,dc=example"] = "bob-pw"
+	srv.entries["uid=bob,ou=people,dc=example"] = map[string][]string{"uid": {"bob"}}
+
+	c := &config.LDAPConfig{
+		URL:            srv.URL(),
+		BindDN:         "cn=harbor,dc=example",
+		BindPassword:   "service",
+		UserSearchBase: "ou=people,dc=example",
+		Attributes:     map[string]string{"email": "mail", "first_name": "givenName", "last_name": "sn"},
+		AutoCreate:     true,
+	}
+	a, err := middlewares.NewLDAPAuthenticator(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := a.Authenticate("alice", "alice-pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "alice@example.com" || user.FirstName != "Alice" || user.LastName != "Liddell" {
+		t.Errorf("attributes are not mapped, got %s %s %s", user.Email, user.FirstName, user.LastName)
+	}
+	if u, err := models.NewUserManager().GetUserByName("alice"); err != nil || u == nil || u.ID != user.ID {
+		t.Fatalf("user should be created at first login, got %v %v", u, err)
+	}
+	if _, err := a.Authenticate("alice", "wrong"); err != middlewares.ErrInvalidCredentials {
+		t.Errorf("want invalid credentials, got %v", err)
+	}
+	if _, err := a.Authenticate("carol", "carol-pw"); err != middlewares.ErrInvalidCredentials {
+		t.Errorf("user not in directory should be invalid, got %v", err)
+	}
+
+	// recent bind is reused for the same password only
+	binds := srv.Binds()
+	if u, err := a.Authenticate("alice", "alice-pw"); err != nil || u.ID != user.ID {
+		t.Fatalf("cached login failed, %v", err)
+	}
+	if srv.Binds() != binds {
+		t.Error("successful bind should be cached")
+	}
+	if _, err := a.Authenticate("alice", "wrong"); err != middlewares.ErrInvalidCredentials || srv.Binds() == binds {
+		t.Errorf("other password should be checked by LDAP, got %v", err)
+	}
+
+	c.AutoCreate, c.BindCacheTTL = false, -1
+	a, _ = middlewares.NewLDAPAuthenticator(c)
+	if _, err := a.Authenticate("bob", "bob-pw"); err == nil {
+		t.Error("user should not be created without auto_create")
+	}
+	binds = srv.Binds()
+	a.Authenticate("alice", "alice-pw")
+	if srv.Binds() == binds {
+		t.Error("bind should not be cached if disabled")
+	}
+}
+
+func TestLDAPUserDNTemplate(t *testing.T) {
+
+	setupDB(t)
+	srv := newFakeLDAP(t)
+	defer srv.ln.Close()
+	// special characters of the username are escaped in the DN
+	for username, dn := range map[string]string{
+		"a,b+c": `uid=a\,b\+c,ou=people,dc=example`,
+		"#lead": `uid=\#lead,ou=people,dc=example`,
+		"tail ": `uid=tail\ ,ou=people,dc=example`,
+	} {
+		srv.passwords[dn] = "pw"
+		srv.entries[dn] = map[string][]string{"mail": {"x@example.com"}}
+		a, _ := middlewares.NewLDAPAuthenticator(&config.LDAPConfig{
+			URL:            srv.URL(),
+			UserDNTemplate: "uid=%s,ou=people,dc=example",
+			Timeout:        5 * time.Second,
+			AutoCreate:     true,
+		})
+		if user, err := a.Authenticate(username, "pw"); err != nil || user.Username != username {
+			t.Errorf("login of %q failed: %v", username, err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package middlewares
+
+import (
+	"fmt"
+	"harbor/config"
+	"harbor/models"
+	"harbor/utils/lockout"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ThrottledError indicates too many failed login attempts of the username or client ip
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry after %d seconds", retryAfterSeconds(e.RetryAfter))
+}
+
+// IsThrottled return true if err is *ThrottledError
+func IsThrottled(err error) bool {
+
+	_, ok := err.(*ThrottledError)
+	return ok
+}
+
+// loginThrottledKey context key, set if login is throttled
+const loginThrottledKey = "login_throttled"
+
+var (
+	userTracker  *lockout.Tracker
+	ipTracker    *lockout.Tracker
+	trackersOnce sync.Once
+)
+
+// loginTrackers return failed login trackers of username and client ip, nil if lockout disabled
+func loginTrackers() (*lockout.Tracker, *lockout.Tracker) {
+
+	trackersOnce.Do(func() {
+		c := config.GetConfigs().Lockout
+		if c.Disabled {
+			return
+		}
+		policy := lockout.Policy{
+			FreeAttempts: c.FreeAttempts,
+			BaseDelay:    c.BaseDelay,
+			MaxDelay:     c.MaxDelay,
+			ResetAfter:   c.ResetAfter,
+		}
+		if policy.FreeAttempts <= 0 {
+			policy.FreeAttempts = 5
+		}
+		if policy.BaseDelay <= 0 {
+			policy.BaseDelay = time.Second
+		}
+		if policy.MaxDelay <= 0 {
+			policy.MaxDelay = 15 * time.Minute
+		}
+		userTracker = lockout.NewTracker(policy)
+
+		policy.FreeAttempts = c.IPFreeAttempts
+		if policy.FreeAttempts <= 0 {
+			policy.FreeAttempts = 20
+		}
+		ipTracker = lockout.NewTracker(policy)
+	})
+	return userTracker, ipTracker
+}
+
+func userLockoutKey(username string) string {
+	return strings.ToLower(username)
+}
+
+func retryAfterSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// CheckLoginThrottle return *ThrottledError if username or client ip is locked,
+// header "Retry-After" is set
+func CheckLoginThrottle(ctx *gin.Context, username string) error {
+
+	ut, it := loginTrackers()
+	if ut == nil {
+		return nil
+	}
+	d := ut.RetryAfter(userLockoutKey(username))
+	if ipd := it.RetryAfter(ClientIP(ctx)); ipd > d {
+		d = ipd
+	}
+	if d <= 0 {
+		return nil
+	}
+	ctx.Header("Retry-After", strconv.FormatInt(retryAfterSeconds(d), 10))
+	ctx.Set(loginThrottledKey, true)
+	return &ThrottledError{RetryAfter: d}
+}
+
+func loginFailed(ctx *gin.Context, username string) {
+
+	if ut, it := loginTrackers(); ut != nil {
+		ut.Fail(userLockoutKey(username))
+		it.Fail(ClientIP(ctx))
+	}
+}
+
+func loginSucceeded(username string) {
+
+	if ut, _ := loginTrackers(); ut != nil {
+		ut.Reset(userLockoutKey(username))
+	}
+}
+
+// LoginAuthenticate authenticate user by password with brute-force protection,
+// return *ThrottledError without checking password if the username or client ip is locked
+func LoginAuthenticate(ctx *gin.Context, username, password string) (*models.UserProfile, error) {
+
+	if err := CheckLoginThrottle(ctx, username); err != nil {
+		return nil, err
+	}
+	user, err := AuthenticateUser(username, password)
+	if err != nil {
+		loginFailed(ctx, username)
+		return nil, err
+	}
+	return user, nil
+}
+
+// LoginSecondFactor check second factor after LoginAuthenticate, invalid code is a failed attempt;
+// failed attempts of the username are forgotten after login succeeded
+func LoginSecondFactor(ctx *gin.Context, user *models.UserProfile, code string) (enrollOnly bool, err error) {
+
+	enrollOnly, err = CheckSecondFactor(user, code)
+	if err == ErrInvalidOTP {
+		loginFailed(ctx, user.Username)
+	} else if err == nil {
+		loginSucceeded(user.Username)
+	}
+	return enrollOnly, err
+}
+
+// LoginLockouts return locked usernames and client ips
+func LoginLockouts() (users []lockout.Record, ips []lockout.Record) {
+
+	ut, it := loginTrackers()
+	if ut == nil {
+		return []lockout.Record{}, []lockout.Record{}
+	}
+	return ut.Locked(), it.Locked()
+}
+
+// UnlockLogin forget failed login attempts of username or client ip, return false if not found
+func UnlockLogin(username, ip string) bool {
+
+	ut, it := loginTrackers()
+	if ut == nil {
+		return false
+	}
+	ok := false
+	if username != "" && ut.Reset(userLockoutKey(username)) {
+		ok = true
+	}
+	if ip != "" && it.Reset(ip) {
+		ok = true
+	}
+	return ok
+}
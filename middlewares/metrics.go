@@ -0,0 +1,55 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"harbor/config"
+	"harbor/utils/metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsMiddleware return middleware counts requests and observes latency by handler and method,
+// it should be the first middleware
+func MetricsMiddleware() gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		start := time.Now()
+		ctx.Next()
+
+		handler := ctx.GetString(metrics.HandlerKey)
+		if handler == "" {
+			handler = metrics.HandlerName(ctx.HandlerName())
+			// no route matched, the last handler is a global middleware
+			if strings.HasPrefix(handler, "middlewares.") {
+				handler = "none"
+			}
+		}
+		method := ctx.Request.Method
+		metrics.HTTPRequests.WithLabelValues(handler, method, strconv.Itoa(ctx.Writer.Status())).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(handler, method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// MetricsHandler return metrics endpoint handler, bearer token is required if config "metrics.token" is set
+func MetricsHandler() gin.HandlerFunc {
+
+	token := config.GetConfigs().Metrics.Token
+	h := metrics.Handler()
+	return func(ctx *gin.Context) {
+
+		if token != "" {
+			auth := ctx.GetHeader("Authorization")
+			if !strings.HasPrefix(auth, "Bearer ") ||
+				subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(token)) != 1 {
+				ctx.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(ctx.Writer, ctx.Request)
+	}
+}
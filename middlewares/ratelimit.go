@@ -0,0 +1,162 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"harbor/config"
+	"harbor/models"
+	"harbor/utils/metrics"
+	"harbor/utils/ratelimit"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitDownloadKey context key of download buckets of request
+const rateLimitDownloadKey = "rate_limit_download"
+
+// rateLimiter limits by config "rate_limit"
+type rateLimiter struct {
+	config  *config.RateLimitConfig
+	global  *ratelimit.Limits
+	limiter *ratelimit.Limiter
+}
+
+func newLimits(rule config.RateLimitRule) *ratelimit.Limits {
+
+	return &ratelimit.Limits{
+		Requests: ratelimit.NewBucket(rule.RequestRate, rule.RequestBurst),
+		Upload:   ratelimit.NewBucket(float64(rule.UploadRate), rule.UploadRate),
+		Download: ratelimit.NewBucket(float64(rule.DownloadRate), rule.DownloadRate),
+	}
+}
+
+// userRole return role name of user used by config "rate_limit.roles"
+func userRole(user *models.UserProfile) string {
+
+	switch {
+	case user == nil:
+		return "anonymous"
+	case user.IsSuperUser:
+		return "superuser"
+	case user.IsStaff:
+		return "staff"
+	case user.IsAppSuperUser():
+		return "app_superuser"
+	}
+	return "normal"
+}
+
+// keyRule return rate limit key and rule of request
+func (rl *rateLimiter) keyRule(ctx *gin.Context) (string, config.RateLimitRule) {
+
+	var user *models.UserProfile
+	if v, ok := ctx.Get(AuthUserKey); ok {
+		user, _ = v.(*models.UserProfile)
+	}
+
+	rule, ok := rl.config.Default, false
+	if user != nil {
+		rule, ok = rl.config.Users[strings.ToLower(user.Username)]
+	}
+	if !ok {
+		if r, exists := rl.config.Roles[userRole(user)]; exists {
+			rule = r
+		} else {
+			rule = rl.config.Default
+		}
+	}
+
+	if user == nil || strings.ToLower(rl.config.KeyBy) == "ip" {
+		return "ip:" + ClientIP(ctx), rule
+	}
+	if strings.ToLower(rl.config.KeyBy) == "token" {
+		auth := ctx.GetHeader("Authorization")
+		if i := strings.IndexByte(auth, ' '); i > 0 && strings.ToLower(auth[:i]) != "basic" {
+			sum := sha256.Sum256([]byte(auth[i+1:]))
+			return "token:" + hex.EncodeToString(sum[:16]), rule
+		}
+	}
+	return "user:" + strconv.FormatUint(uint64(user.ID), 10), rule
+}
+
+// readCloser throttled request body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// RateLimitMiddleware return request rate limiting and bandwidth throttling middleware by config "rate_limit",
+// it should be used after authentication middlewares so that requests are limited by user;
+// requests over the request rate are rejected with 429, uploads and downloads over the byte rate are slowed down
+func RateLimitMiddleware() gin.HandlerFunc {
+
+	c := config.GetConfigs().RateLimit
+	if !c.Enabled {
+		return func(ctx *gin.Context) {}
+	}
+	rl := &rateLimiter{
+		config:  &c,
+		global:  newLimits(c.Global),
+		limiter: ratelimit.NewLimiter(0),
+	}
+
+	return func(ctx *gin.Context) {
+
+		key, rule := rl.keyRule(ctx)
+		limits := rl.limiter.Get(key, func() *ratelimit.Limits { return newLimits(rule) })
+
+		if ok, d := (ratelimit.Buckets{rl.global.Requests, limits.Requests}).Allow(); !ok {
+			metrics.RateLimitEvents.WithLabelValues("request").Inc()
+			ctx.Header("Retry-After", strconv.FormatInt(retryAfterSeconds(d), 10))
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"code":      http.StatusTooManyRequests,
+				"code_text": "too many requests",
+			})
+			return
+		}
+
+		up := ratelimit.Buckets{rl.global.Upload, limits.Upload}
+		if body := ctx.Request.Body; body != nil && up.Limited() {
+			ctx.Request.Body = readCloser{
+				Reader: ratelimit.NewReader(body, up, metrics.RateLimitEvents.WithLabelValues("upload").Inc),
+				Closer: body,
+			}
+		}
+		ctx.Set(rateLimitDownloadKey, ratelimit.Buckets{rl.global.Download, limits.Download})
+	}
+}
+
+// downloadBuckets return download buckets of request, nil if not limited
+func downloadBuckets(ctx *gin.Context) ratelimit.Buckets {
+
+	if v, ok := ctx.Get(rateLimitDownloadKey); ok {
+		if bs, ok := v.(ratelimit.Buckets); ok && bs.Limited() {
+			return bs
+		}
+	}
+	return nil
+}
+
+// ThrottleStepWrite return step write function for gin Stream(), limited by the download rate limits of request
+func ThrottleStepWrite(ctx *gin.Context, step func(io.Writer) bool) func(io.Writer) bool {
+
+	bs := downloadBuckets(ctx)
+	if bs == nil {
+		return step
+	}
+	return func(w io.Writer) bool {
+		return step(ratelimit.NewWriter(w, bs, metrics.RateLimitEvents.WithLabelValues("download").Inc))
+	}
+}
+
+// WaitDownload wait for the download rate limits of request before sending n bytes
+func WaitDownload(ctx *gin.Context, n int64) {
+
+	if bs := downloadBuckets(ctx); bs != nil && bs.Wait(n) {
+		metrics.RateLimitEvents.WithLabelValues("download").Inc()
+	}
+}
@@ -0,0 +1,91 @@
+package middlewares_test
+
+import (
+	"harbor/config"
+	"harbor/middlewares"
+	"harbor/models"
+	"harbor/utils/metrics"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+	configs := config.GetConfigs()
+	defer func(c config.RateLimitConfig) { configs.RateLimit = c }(configs.RateLimit)
+	configs.RateLimit = config.RateLimitConfig{
+		Enabled: true,
+		Default: config.RateLimitRule{RequestRate: 0.001, RequestBurst: 1},
+	}
+	app := gin.New()
+	app.Use(middlewares.RateLimitMiddleware())
+	app.GET("/", func(ctx *gin.Context) { ctx.String(200, "ok") })
+
+	exceeded := metrics.RateLimitEvents.WithLabelValues("request")
+	before := testutil.ToFloat64(exceeded)
+	for i, want := range []int{200, 429} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "1.2.3.4:5000"
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Fatalf("request %d: got status %d, want %d", i, w.Code, want)
+		}
+	}
+	if got := testutil.ToFloat64(exceeded) - before; got != 1 {
+		t.Errorf("rejected requests should be counted, got %v", got)
+	}
+}
+
+func TestRateLimitJWTRole(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+	configs := config.GetConfigs()
+	defer func(c config.RateLimitConfig) { configs.RateLimit = c }(configs.RateLimit)
+	configs.RateLimit = config.RateLimitConfig{
+		Enabled: true,
+		Default: config.RateLimitRule{RequestRate: 0.001, RequestBurst: 1},
+		Roles: map[string]config.RateLimitRule{
+			"staff":         {RequestRate: 0.001, RequestBurst: 2},
+			"app_superuser": {RequestRate: 0.001, RequestBurst: 3},
+		},
+	}
+	jwtAuth, err := middlewares.JWTAuthMiddleware()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := gin.New()
+	app.Use(jwtAuth.MiddlewareFunc(), middlewares.RateLimitMiddleware())
+	app.GET("/", func(ctx *gin.Context) { ctx.String(200, "ok") })
+
+	for _, c := range []struct {
+		user  *models.UserProfile
+		burst int
+	}{
+		{&models.UserProfile{ID: 1, Username: "jwt-staff", IsStaff: true}, 2},
+		{&models.UserProfile{ID: 2, Username: "jwt-app", Role: models.RoleAppSuperUser.Value()}, 3},
+	} {
+		token, _, err := jwtAuth.TokenGenerator(c.user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i <= c.burst; i++ {
+			want := 200
+			if i == c.burst {
+				want = 429
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set("Authorization", "JWT "+token)
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, req)
+			if w.Code != want {
+				t.Fatalf("%s request %d: got status %d, want %d", c.user.Username, i, w.Code, want)
+			}
+		}
+	}
+}
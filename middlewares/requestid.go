@@ -0,0 +1,134 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"harbor/models"
+	"harbor/utils/logger"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// RequestIDKey context key of request id
+const RequestIDKey = "request_id"
+
+// RequestIDHeader header of request id
+const RequestIDHeader = "X-Request-Id"
+
+// LoggerKey context key of request-scoped logger
+const LoggerKey = "logger"
+
+// GetRequestID return request id of request
+func GetRequestID(ctx *gin.Context) string {
+
+	return ctx.GetString(RequestIDKey)
+}
+
+// GetLogger return request-scoped logger with the request id field
+func GetLogger(ctx *gin.Context) *logrus.Entry {
+
+	if v, ok := ctx.Get(LoggerKey); ok {
+		if l, ok := v.(*logrus.Entry); ok {
+			return l
+		}
+	}
+	return logger.Or(nil)
+}
+
+// validRequestID return true if id from client can be used, at most 128 letters, digits and "-_.:"
+func validRequestID(id string) bool {
+
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestIDMiddleware return middleware propagates the request id of header "X-Request-Id" or assigns a new one,
+// and sets the request-scoped logger; it should be the first middleware
+func RequestIDMiddleware() gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		id := ctx.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		ctx.Set(RequestIDKey, id)
+		ctx.Header(RequestIDHeader, id)
+		ctx.Set(LoggerKey, logger.Or(nil).WithField("request_id", id))
+		ctx.Next()
+	}
+}
+
+// AccessLogMiddleware return middleware logs every request after it is handled,
+// responses of 5xx are logged at error level, others at info level
+func AccessLogMiddleware() gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		start := time.Now()
+		path := ctx.Request.URL.Path
+		ctx.Next()
+
+		fields := logrus.Fields{
+			"method":     ctx.Request.Method,
+			"path":       path,
+			"status":     ctx.Writer.Status(),
+			"latency_ms": float64(time.Since(start)) / float64(time.Millisecond),
+			"ip":         ClientIP(ctx),
+			"bytes":      ctx.Writer.Size(),
+			"user_agent": ctx.Request.UserAgent(),
+		}
+		if v, ok := ctx.Get(AuthUserKey); ok {
+			if user, ok := v.(*models.UserProfile); ok && user != nil {
+				fields["user"] = user.Username
+			}
+		}
+		l := GetLogger(ctx).WithFields(fields)
+		if len(ctx.Errors) > 0 {
+			l = l.WithField("errors", ctx.Errors.String())
+		}
+		if ctx.Writer.Status() >= 500 {
+			l.Error("request")
+			return
+		}
+		l.Info("request")
+	}
+}
+
+// RecoveryMiddleware return middleware recovers from panics, logs it with the request id and responds 500
+func RecoveryMiddleware() gin.HandlerFunc {
+
+	return func(ctx *gin.Context) {
+
+		defer func() {
+			if err := recover(); err != nil {
+				GetLogger(ctx).WithFields(logrus.Fields{
+					"panic": err,
+					"stack": string(debug.Stack()),
+				}).Error("panic recovered")
+				ctx.AbortWithStatus(500)
+			}
+		}()
+		ctx.Next()
+	}
+}
@@ -0,0 +1,49 @@
+package middlewares_test
+
+import (
+	"harbor/middlewares"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+	app := gin.New()
+	app.Use(middlewares.RequestIDMiddleware())
+	app.GET("/", func(ctx *gin.Context) {
+		id := middlewares.GetRequestID(ctx)
+		if middlewares.GetLogger(ctx).Data["request_id"] != id {
+			t.Errorf("logger should have the request id field")
+		}
+		ctx.String(200, id)
+	})
+
+	do := func(header string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/", nil)
+		if header != "" {
+			req.Header.Set(middlewares.RequestIDHeader, header)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("abc-123")
+	if w.Body.String() != "abc-123" || w.Header().Get(middlewares.RequestIDHeader) != "abc-123" {
+		t.Errorf("request id of client should be propagated, got %q", w.Body.String())
+	}
+
+	w = do("")
+	if id := w.Body.String(); len(id) != 32 || w.Header().Get(middlewares.RequestIDHeader) != id {
+		t.Errorf("request id should be assigned, got %q", id)
+	}
+
+	w = do("bad id\nx")
+	if w.Body.String() == "bad id\nx" {
+		t.Errorf("invalid request id should be replaced")
+	}
+}
@@ -0,0 +1,91 @@
+package middlewares
+
+import (
+	"errors"
+	"harbor/config"
+	"harbor/models"
+	"harbor/utils/totp"
+	"time"
+)
+
+var (
+	// ErrOTPRequired indicates the user enabled two-factor authentication, the code is required
+	ErrOTPRequired = errors.New("two-factor authentication code is required")
+
+	// ErrInvalidOTP indicates the code is not correct or already used
+	ErrInvalidOTP = errors.New("invalid two-factor authentication code")
+
+	// ErrTwoFactorEnrollRequired indicates the user must enroll two-factor authentication first
+	ErrTwoFactorEnrollRequired = errors.New("two-factor authentication enrollment is required")
+)
+
+// TwoFactorEnrollClaim jwt claim of token which is only allowed to enroll two-factor authentication
+const TwoFactorEnrollClaim = "2fa_enroll"
+
+// TwoFactorEnrollPath the path prefix of APIs allowed by enroll-only jwt
+const TwoFactorEnrollPath = "/api/v1/2fa/"
+
+// GetConfirmedTOTP return user's confirmed TOTP device, nil if the user not enrolled
+func GetConfirmedTOTP(user *models.UserProfile) (*models.UserTOTP, error) {
+
+	t, err := models.NewTOTPManager(user).GetTOTP()
+	if err != nil || t == nil || !t.Confirmed {
+		return nil, err
+	}
+	return t, nil
+}
+
+// TwoFactorEnrollRequired return true if user is staff or super user, not enrolled, and enrollment is required by config
+func TwoFactorEnrollRequired(user *models.UserProfile, enrolled bool) bool {
+
+	c := config.GetConfigs().TwoFactor
+	return c.RequireForPrivilege && !enrolled && (user.IsSuperUser || user.IsStaff)
+}
+
+// VerifyOTP check TOTP code or recovery code of device, the used code can not be used again
+func VerifyOTP(t *models.UserTOTP, code string) error {
+
+	if code == "" {
+		return ErrOTPRequired
+	}
+
+	skew := config.GetConfigs().TwoFactor.Skew
+	if skew <= 0 {
+		skew = 1
+	}
+	m := models.NewTOTPManager(nil)
+	if counter, ok := totp.Validate(t.Secret, code, time.Now(), skew); ok {
+		used, err := m.UseCounter(t, counter)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidOTP
+		}
+		return nil
+	}
+
+	used, err := m.UseRecoveryCode(t, code)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidOTP
+	}
+	return nil
+}
+
+// CheckSecondFactor the second step of password login,
+// code is required if the user enrolled two-factor authentication;
+// return enrollOnly true if the user must enroll first, only enrollment APIs should be allowed
+func CheckSecondFactor(user *models.UserProfile, code string) (enrollOnly bool, err error) {
+
+	t, err := GetConfirmedTOTP(user)
+	if err != nil {
+		return false, err
+	}
+	if t == nil {
+		return TwoFactorEnrollRequired(user, false), nil
+	}
+	return false, VerifyOTP(t, code)
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"encoding/json"
+)
+
+// audit event results
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+)
+
+// AuditEvent a record of who changed which data or permission
+type AuditEvent struct {
+	ID         uint64                 `gorm:"PRIMARY_KEY;AUTO_INCREMENT;not null" json:"id"`
+	Time       TypeJSONTime           `gorm:"column:time;type:datetime;index:idx_audit_time" json:"time"`
+	ActorID    uint                   `gorm:"column:actor_id;index:idx_audit_actor_id" json:"actor_id"`
+	Actor      string                 `gorm:"column:actor;type:varchar(150)" json:"actor"` // username
+	Action     string                 `gorm:"column:action;type:varchar(64);index:idx_audit_action" json:"action"`
+	TargetType string                 `gorm:"column:target_type;type:varchar(32)" json:"target_type"` // object, dir, bucket, user, token
+	Target     string                 `gorm:"column:target;type:varchar(1024)" json:"target"`         // e.g. "{bucket}/{path}", bucket id, user id
+	SourceIP   string                 `gorm:"column:source_ip;type:varchar(64)" json:"source_ip"`
+	Result     string                 `gorm:"column:result;type:varchar(16)" json:"result"`
+	StatusCode int                    `gorm:"column:status_code" json:"status_code"`
+	RequestID  string                 `gorm:"column:request_id;type:varchar(64);index:idx_audit_request_id" json:"request_id"`
+	DetailJSON string                 `gorm:"column:detail;type:text" json:"-"`
+	Detail     map[string]interface{} `gorm:"-" json:"detail,omitempty"`
+}
+
+// TableName Set AuditEvent's table name
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
+
+// NewAuditEvent return a audit event happening now
+func NewAuditEvent(action, targetType, target string) *AuditEvent {
+
+	return &AuditEvent{
+		Time:       JSONTimeNow(),
+		Action:     action,
+		TargetType: targetType,
+		Target:     target,
+	}
+}
+
+// SetActor set user who did it
+func (e *AuditEvent) SetActor(user *UserProfile) {
+
+	e.ActorID = user.ID
+	e.Actor = user.Username
+}
+
+// AddDetail add extra information of event
+func (e *AuditEvent) AddDetail(key string, value interface{}) *AuditEvent {
+
+	if e.Detail == nil {
+		e.Detail = map[string]interface{}{}
+	}
+	e.Detail[key] = value
+	return e
+}
+
+// BeforeSave encode detail, called by gorm
+func (e *AuditEvent) BeforeSave() error {
+
+	if len(e.Detail) == 0 {
+		e.DetailJSON = ""
+		return nil
+	}
+	b, err := json.Marshal(e.Detail)
+	if err != nil {
+		return err
+	}
+	e.DetailJSON = string(b)
+	return nil
+}
+
+// AfterFind decode detail, called by gorm
+func (e *AuditEvent) AfterFind() error {
+
+	if e.DetailJSON == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(e.DetailJSON), &e.Detail)
+}
@@ -0,0 +1,42 @@
+package models_test
+
+import (
+	"encoding/json"
+	"harbor/models"
+	"testing"
+)
+
+func TestAuditEventDetail(t *testing.T) {
+
+	e := models.NewAuditEvent("object.move", "object", "bucket/a/b.txt")
+	e.SetActor(&models.UserProfile{ID: 3, Username: "test"})
+	if err := e.BeforeSave(); err != nil || e.DetailJSON != "" {
+		t.Fatalf("empty detail should be saved as empty string, %q %v", e.DetailJSON, err)
+	}
+
+	e.AddDetail("move_to", "c").AddDetail("rename", "d.txt")
+	if err := e.BeforeSave(); err != nil {
+		t.Fatal(err)
+	}
+
+	found := models.AuditEvent{DetailJSON: e.DetailJSON}
+	if err := found.AfterFind(); err != nil {
+		t.Fatal(err)
+	}
+	if found.Detail["move_to"] != "c" || found.Detail["rename"] != "d.txt" {
+		t.Errorf("detail should be decoded, got %v", found.Detail)
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	json.Unmarshal(b, &m)
+	if _, ok := m["DetailJSON"]; ok {
+		t.Errorf("raw detail should not be in json")
+	}
+	if m["actor"] != "test" || m["action"] != "object.move" {
+		t.Errorf("unexpected json %s", b)
+	}
+}
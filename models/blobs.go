@@ -0,0 +1,32 @@
+package models
+
+import "fmt"
+
+// Blob data shared by deduplicated objects of the same content, stored by key BlobKey(id)
+type Blob struct {
+	ID          uint64       `gorm:"PRIMARY_KEY;AUTO_INCREMENT;not null" json:"id"`
+	Hash        string       `gorm:"column:hash;type:varchar(64);unique_index:uidx_blobs_hash;not null" json:"hash"` // sha256 of content in hex
+	Size        uint64       `gorm:"column:size;not null" json:"size"`
+	StoredSize  uint64       `gorm:"column:stored_size;not null;default:0" json:"stored_size"`
+	Compression string       `gorm:"column:compression;type:varchar(16);not null;default:''" json:"compression"`
+	Refs        int64        `gorm:"column:refs;not null;default:0" json:"refs"`             // objects referencing the blob
+	Complete    bool         `gorm:"column:complete;not null;default:false" json:"complete"` // data is written, objects can reference the blob
+	CreatedTime TypeJSONTime `gorm:"column:created_time;type:datetime" json:"created_time"`
+}
+
+// TableName Set Blob's table name
+func (Blob) TableName() string {
+	return "blobs"
+}
+
+// BlobKey return storage key of data of blob id
+func BlobKey(id uint64) string {
+
+	return fmt.Sprintf("blob_%d", id)
+}
+
+// IsCompressed return true if data of blob is compressed
+func (b *Blob) IsCompressed() bool {
+
+	return b.Compression != ""
+}
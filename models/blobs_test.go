@@ -0,0 +1,110 @@
+package models_test
+
+import (
+	"harbor/models"
+	"testing"
+	"time"
+)
+
+func TestBlobRefs(t *testing.T) {
+
+	setupSQLite(t)
+	user := &models.UserProfile{ID: 1}
+	bm := models.NewBucketManager("", user)
+	bucket, err := bm.CreateBucketByName("blobs", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bm.CreateObjsTable(bucket); err != nil {
+		t.Fatal(err)
+	}
+	om := models.NewHarborObjectManager(bucket.GetObjsTableName(), "", "")
+	var objs []*models.HarborObject
+	for _, name := range []string{"a", "b"} {
+		om.ResetObjName(name)
+		obj, created := om.GetObjOrCreat()
+		if !created {
+			t.Fatal("object should be created")
+		}
+		obj.Size = 100
+		obj.UpdateTime = models.TypeJSONTime{Time: time.Now().Add(-time.Hour)}
+		if err := om.SaveObject(obj); err != nil {
+			t.Fatal(err)
+		}
+		objs = append(objs, obj)
+	}
+
+	m := models.NewBlobManager()
+	blob := &models.Blob{Hash: "h1", Size: 100, Compression: models.CompressionGzip}
+	if created, err := m.CreateBlob(blob); err != nil || !created {
+		t.Fatalf("blob should be created, got %v %v", created, err)
+	}
+	if created, err := m.CreateBlob(&models.Blob{Hash: "h1", Size: 100}); err != nil || created {
+		t.Fatalf("blob of the same hash should not be created, got %v %v", created, err)
+	}
+	if ok, err := m.AddBlobRef(blob); err != nil || ok {
+		t.Fatalf("incomplete blob should not be referenced, got %v %v", ok, err)
+	}
+	blob.StoredSize = 40
+	if err := m.CompleteBlob(blob); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m.AddBlobRef(blob); err != nil || !ok {
+		t.Fatalf("complete blob should be referenced, got %v %v", ok, err)
+	}
+
+	before := time.Now().Add(-time.Minute)
+	for _, obj := range objs {
+		if ok, err := om.AttachObjectBlob(obj, blob, before); err != nil || !ok {
+			t.Fatalf("object should reference blob, got %v %v", ok, err)
+		}
+	}
+	if ok, err := om.AttachObjectBlob(objs[0], blob, before); err != nil || ok {
+		t.Errorf("deduplicated object should not be attached again, got %v %v", ok, err)
+	}
+	objs[1].Size = 200
+	objs[1].UpdateModyfiedTime()
+	if ok, err := om.UpdateUnsharedObjectSize(objs[1]); err != nil || ok {
+		t.Errorf("size of deduplicated object should not be updated, got %v %v", ok, err)
+	}
+	objs[1].Size = 100
+	if key := objs[0].GetObjKey(bucket); key != models.BlobKey(blob.ID) || objs[0].Compression != models.CompressionGzip {
+		t.Errorf("data of deduplicated object should be read from blob, got key %s", key)
+	}
+	if c, err := om.GetDedupCandidates(0, 1, time.Now(), 10); err != nil || len(c) != 0 {
+		t.Errorf("deduplicated objects should not be candidates, got %d %v", len(c), err)
+	}
+	refs, err := bm.GetObjsBlobRefs(bucket)
+	if err != nil || refs[blob.ID] != 2 {
+		t.Errorf("got refs %v %v, want 2", refs, err)
+	}
+
+	stats, err := m.GetBlobStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blobs != 1 || stats.Refs != 2 || stats.StoredSize != 40 || stats.SavedBytes != 40 {
+		t.Errorf("got stats %+v", stats)
+	}
+
+	gm := models.NewGCManager()
+	for i, obj := range objs {
+		if err := gm.EnqueueObject(bucket, obj, models.GCObjectDeleted); err != nil {
+			t.Fatal(err)
+		}
+		found, err := m.GetBlobByID(blob.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last := i == len(objs)-1; last != (found == nil) {
+			t.Fatalf("blob should only be deleted after the last reference is released, got %+v", found)
+		}
+	}
+	q, err := gm.GetQueueStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Pending != 1 || q.Bytes != 100 {
+		t.Errorf("data of released blob should be queued once, got queue %+v", q)
+	}
+}
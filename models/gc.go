@@ -0,0 +1,35 @@
+package models
+
+import "errors"
+
+// ErrGCTaskClaimed data of the key is being deleted by garbage collector
+var ErrGCTaskClaimed = errors.New("data of key is being deleted by garbage collector")
+
+// reasons of garbage collection tasks
+const (
+	GCObjectDeleted = "object_deleted" // object is deleted
+	GCUploadFailed  = "upload_failed"  // new object is rolled back after data is written
+	GCBucketPurged  = "bucket_purged"  // bucket is purged
+	GCOrphan        = "orphan"         // found by orphan scan
+	GCDeduplicated  = "deduplicated"   // object is deduplicated, its data is shared by a blob
+	GCBlobReleased  = "blob_released"  // blob is no longer referenced
+)
+
+// GCTask a storage key whose data is to be deleted by garbage collector
+type GCTask struct {
+	ID          uint64       `gorm:"PRIMARY_KEY;AUTO_INCREMENT;not null" json:"id"`
+	Key         string       `gorm:"column:storage_key;type:varchar(128);unique_index:uidx_gc_queue_key;not null" json:"key"`
+	Size        uint64       `gorm:"column:size" json:"size"` // size of object when enqueued, 0 if unknown
+	Reason      string       `gorm:"column:reason;type:varchar(32)" json:"reason"`
+	Attempts    int          `gorm:"column:attempts" json:"attempts"`
+	Error       string       `gorm:"column:error;type:varchar(1024)" json:"error"` // error of last attempt
+	CreatedTime TypeJSONTime `gorm:"column:created_time;type:datetime" json:"created_time"`
+	NextAttempt TypeJSONTime `gorm:"column:next_attempt;type:datetime;index:idx_gc_queue_next_attempt" json:"next_attempt"`
+	// the task is being collected until then, null if it is not claimed by a collector
+	ClaimedUntil TypeJSONTime `gorm:"column:claimed_until;type:datetime" json:"-"`
+}
+
+// TableName Set GCTask's table name
+func (GCTask) TableName() string {
+	return "gc_queue"
+}
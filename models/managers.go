@@ -4,8 +4,10 @@ import (
 	"errors"
 	"harbor/database"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
 )
 
 // Manager base manager for db operation
@@ -15,6 +17,7 @@ type Manager struct {
 	db              *gorm.DB
 	tx              *gorm.DB //transaction db
 	isInTransaction bool     //是否开启了事务
+	log             *logrus.Entry
 }
 
 // NewManager return a manager
@@ -41,6 +44,13 @@ func (m *Manager) getTableName() string {
 	return m.TableName
 }
 
+// SetLogger set request-scoped logger, db operations are logged with its fields
+func (m *Manager) SetLogger(l *logrus.Entry) {
+
+	m.log = l
+	m.db = nil
+}
+
 // BeginTransaction start a transaction
 func (m *Manager) BeginTransaction() {
 
@@ -76,6 +86,9 @@ func (m *Manager) GetDB() *gorm.DB {
 
 	if m.db == nil {
 		m.db = database.GetDB(m.GetDBAlias()).Table(m.getTableName())
+		if m.log != nil {
+			m.db = m.db.Set(database.LoggerKey, m.log)
+		}
 	}
 
 	// 是否开启了数据库事务
@@ -124,6 +137,13 @@ func NewHarborObjectManager(tableName, dirPath, objName string) *HarborObjectMan
 	}
 }
 
+// WithLogger set request-scoped logger and return the manager
+func (m *HarborObjectManager) WithLogger(l *logrus.Entry) *HarborObjectManager {
+
+	m.SetLogger(l)
+	return m
+}
+
 // GetObjPathName return full path object name
 func (m HarborObjectManager) GetObjPathName() string {
 
@@ -367,7 +387,7 @@ func (m HarborObjectManager) UpdateObjectSize(obj *HarborObject) error {
 
 	db := m.GetDB()
 	if r := db.Where("id = ?", obj.ID).Updates(map[string]interface{}{
-		"si":  gorm.Expr("CASE WHEN (`si` < ?) THEN ? ELSE `si` END", obj.Size, obj.Size),
+		"si":  gorm.Expr("CASE WHEN si < ? THEN ? ELSE si END", obj.Size, obj.Size),
 		"upt": obj.UpdateTime,
 	}); r.Error != nil {
 		if r.RecordNotFound() {
@@ -378,6 +398,149 @@ func (m HarborObjectManager) UpdateObjectSize(obj *HarborObject) error {
 	return nil
 }
 
+// UpdateUnsharedObjectSize update object like UpdateObjectSize only if it does not share data of a blob,
+// return false if it is not updated, or its metadata is not changed
+func (m HarborObjectManager) UpdateUnsharedObjectSize(obj *HarborObject) (bool, error) {
+
+	db := m.GetDB()
+	r := db.Where("id = ? AND bid = ?", obj.ID, 0).Updates(map[string]interface{}{
+		"si":  gorm.Expr("CASE WHEN si < ? THEN ? ELSE si END", obj.Size, obj.Size),
+		"upt": obj.UpdateTime,
+	})
+	if r.Error != nil {
+		return false, errors.New("failed to update object's metadata")
+	}
+	return r.RowsAffected > 0, nil
+}
+
+// GetObjectByID return object or dir by id, nil if not found
+func (m HarborObjectManager) GetObjectByID(id uint64) (*HarborObject, error) {
+
+	obj := &HarborObject{}
+	db := m.GetDB()
+	if r := db.Where("id = ?", id).First(obj); r.Error != nil {
+		if r.RecordNotFound() {
+			return nil, nil
+		}
+		return nil, errors.New(r.Error.Error())
+	}
+	return obj, nil
+}
+
+// SetObjectSize set size of object to database, unlike UpdateObjectSize the size can be decreased
+func (m HarborObjectManager) SetObjectSize(obj *HarborObject) error {
+
+	db := m.GetDB()
+	if r := db.Where("id = ?", obj.ID).Update("si", obj.Size); r.Error != nil {
+		return errors.New("failed to update object's metadata")
+	}
+	return nil
+}
+
+// SetObjectEncryption set encryption and wrapped data key of object to database
+func (m HarborObjectManager) SetObjectEncryption(obj *HarborObject) error {
+
+	db := m.GetDB()
+	if r := db.Where("id = ?", obj.ID).Updates(map[string]interface{}{
+		"enc":  obj.Encryption,
+		"ekid": obj.KeyID,
+		"edk":  obj.DataKey,
+	}); r.Error != nil {
+		return errors.New("failed to update object's metadata")
+	}
+	return nil
+}
+
+// ReplaceObjectDataKey set key id and wrapped data key of object to database if its wrapped data key is still oldDataKey,
+// return false if the object is changed or deleted
+func (m HarborObjectManager) ReplaceObjectDataKey(obj *HarborObject, oldDataKey string) (bool, error) {
+
+	db := m.GetDB()
+	r := db.Where("id = ? AND enc = ? AND edk = ?", obj.ID, obj.Encryption, oldDataKey).Updates(map[string]interface{}{
+		"ekid": obj.KeyID,
+		"edk":  obj.DataKey,
+	})
+	if r.Error != nil {
+		return false, errors.New(r.Error.Error())
+	}
+	return r.RowsAffected > 0, nil
+}
+
+// SetObjectCompression set compression of object to database
+func (m HarborObjectManager) SetObjectCompression(obj *HarborObject) error {
+
+	db := m.GetDB()
+	if r := db.Where("id = ?", obj.ID).Update("cmp", obj.Compression); r.Error != nil {
+		return errors.New("failed to update object's metadata")
+	}
+	return nil
+}
+
+// AddObjectStoredSize add delta to size of stored data of object in database, the size is not less than 0
+func (m HarborObjectManager) AddObjectStoredSize(obj *HarborObject, delta int64) error {
+
+	var expr interface{}
+	if delta >= 0 {
+		expr = gorm.Expr("psi + ?", delta)
+	} else {
+		expr = gorm.Expr("CASE WHEN psi > ? THEN psi - ? ELSE 0 END", -delta, -delta)
+	}
+	db := m.GetDB()
+	if r := db.Where("id = ?", obj.ID).Update("psi", expr); r.Error != nil {
+		return errors.New("failed to update object's metadata")
+	}
+	return nil
+}
+
+// SetObjectBlob set blob, size of stored data and compression of object to database,
+// used when the object is detached from its blob
+func (m HarborObjectManager) SetObjectBlob(obj *HarborObject) error {
+
+	db := m.GetDB()
+	if r := db.Where("id = ?", obj.ID).Updates(map[string]interface{}{
+		"bid": obj.BlobID,
+		"psi": obj.StoredSize,
+		"cmp": obj.Compression,
+	}); r.Error != nil {
+		return errors.New("failed to update object's metadata")
+	}
+	return nil
+}
+
+// AttachObjectBlob let object share data of blob, only if the object is not deduplicated, and its size is not
+// changed and it is not modified since before; return false if the object is not updated
+func (m HarborObjectManager) AttachObjectBlob(obj *HarborObject, blob *Blob, before time.Time) (bool, error) {
+
+	db := m.GetDB()
+	r := db.Where("id = ? AND fod = ? AND bid = ? AND si = ? AND upt < ?", obj.ID, true, 0, blob.Size, before).Updates(map[string]interface{}{
+		"bid": blob.ID,
+		"psi": blob.StoredSize,
+		"cmp": blob.Compression,
+	})
+	if r.Error != nil {
+		return false, errors.New(r.Error.Error())
+	}
+	if r.RowsAffected == 0 {
+		return false, nil
+	}
+	obj.BlobID, obj.StoredSize, obj.Compression = blob.ID, blob.StoredSize, blob.Compression
+	return true, nil
+}
+
+// GetDedupCandidates return at most limit objects order by id after afterID, which are not encrypted
+// nor deduplicated, not smaller than minSize and not modified since before
+func (m HarborObjectManager) GetDedupCandidates(afterID, minSize uint64, before time.Time, limit int) ([]*HarborObject, error) {
+
+	var objs []*HarborObject
+	db := m.GetDB()
+	r := db.Where("id > ? AND fod = ? AND bid = ? AND enc = ? AND si >= ? AND upt < ?", afterID, true, 0, "", minSize, before).
+		Order("id").Limit(limit).Find(&objs)
+	if r.Error != nil {
+		return nil, errors.New(r.Error.Error())
+	}
+	return objs, nil
+}
+
 // InsertObject create object to database
 func (m HarborObjectManager) InsertObject(obj *HarborObject) error {
 
@@ -412,7 +575,7 @@ func (m HarborObjectManager) DeleteDir(dir *HarborObject) error {
 func (m HarborObjectManager) IncreaseDownloadCount(obj *HarborObject) error {
 
 	db := m.GetDB()
-	if r := db.Where("id", obj.ID).Update("dlc", "dlc + 1"); r.Error != nil {
+	if r := db.Where("id = ?", obj.ID).Update("dlc", gorm.Expr("dlc + ?", 1)); r.Error != nil {
 		return errors.New("failed to update object's metadata")
 	}
 	return nil
@@ -475,6 +638,13 @@ func NewBucketManager(bucketName string, user *UserProfile) *BucketManager {
 	}
 }
 
+// WithLogger set request-scoped logger and return the manager
+func (bm *BucketManager) WithLogger(l *logrus.Entry) *BucketManager {
+
+	bm.SetLogger(l)
+	return bm
+}
+
 // GetBucketByName return Bucket instance
 // return:
 //		*Bucket, nil: exists and no error
@@ -494,6 +664,45 @@ func (bm BucketManager) GetBucketByName(name string) (*Bucket, error) {
 	return bucket, nil
 }
 
+// GetBucketByNameWithDeleted return Bucket instance by name, soft deleted bucket is also returned,
+// its name is the one after soft delete, e.g. "_1-name"
+// return:
+//		*Bucket, nil: exists and no error
+//		nil, nil: not exists and no error
+//		nil, error: have a error
+func (bm BucketManager) GetBucketByNameWithDeleted(name string) (*Bucket, error) {
+
+	bucket := &Bucket{}
+	db := bm.GetDB()
+	if r := db.Where("name = ?", name).Find(&bucket); r.Error != nil {
+		if r.RecordNotFound() {
+			return nil, nil
+		}
+
+		return nil, errors.New(r.Error.Error())
+	}
+	return bucket, nil
+}
+
+// GetBucketByIDWithDeleted return Bucket instance by id, soft deleted bucket is also returned
+// return:
+//		*Bucket, nil: exists and no error
+//		nil, nil: not exists and no error
+//		nil, error: have a error
+func (bm BucketManager) GetBucketByIDWithDeleted(id uint64) (*Bucket, error) {
+
+	bucket := &Bucket{}
+	db := bm.GetDB()
+	if r := db.Where("id = ?", id).Find(&bucket); r.Error != nil {
+		if r.RecordNotFound() {
+			return nil, nil
+		}
+
+		return nil, errors.New(r.Error.Error())
+	}
+	return bucket, nil
+}
+
 // GetBucketByID return Bucket instance
 // return:
 //		*Bucket, nil: exists and no error
@@ -623,6 +832,14 @@ func (bm BucketManager) CreateObjsTable(bucket *Bucket) error {
 		// fmt.Println(r.)
 		return errors.New(r.Error.Error())
 	}
+	// apply the migrations of object tables, so that the new table has the same schema as existing ones
+	m, err := NewMigrator()
+	if err != nil {
+		return err
+	}
+	if err := m.PrepareTable(tableName); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -715,6 +932,157 @@ func (bm BucketManager) BucketRename(bucket *Bucket, rename string) error {
 	return nil
 }
 
+// SetBucketEncryption set encryption of new objects in bucket, existing objects are not changed
+func (bm BucketManager) SetBucketEncryption(bucket *Bucket, encryption string) error {
+
+	db := bm.GetDB()
+	if r := db.Model(bucket).Update("encryption", encryption); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// SetBucketCompression set compression of new objects in bucket, existing objects are not changed
+func (bm BucketManager) SetBucketCompression(bucket *Bucket, compression string) error {
+
+	db := bm.GetDB()
+	if r := db.Model(bucket).Update("compression", compression); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// ListBuckets return buckets order by id, only buckets of user if user is not nil,
+// soft deleted buckets are included if withDeleted is true
+func (bm BucketManager) ListBuckets(user *UserProfile, withDeleted bool) ([]Bucket, error) {
+
+	var buckets []Bucket
+	db := bm.GetDB()
+	if user != nil {
+		db = db.Where("user_id = ?", user.ID)
+	}
+	if !withDeleted {
+		db = db.Where("soft_delete = ?", false)
+	}
+	if r := db.Order("id").Find(&buckets); r.Error != nil {
+		return nil, errors.New(r.Error.Error())
+	}
+	return buckets, nil
+}
+
+// BucketObjsStats statistics of objects in bucket's table
+type BucketObjsStats struct {
+	Objects    uint64 `json:"objects"`
+	Dirs       uint64 `json:"dirs"`
+	Size       uint64 `json:"size"`
+	StoredSize uint64 `json:"stored_size"` // size of data in storage, after compression or encryption
+}
+
+// GetObjsStats count objects and dirs, and sum size and stored size of objects in bucket's table
+func (bm BucketManager) GetObjsStats(bucket *Bucket) (*BucketObjsStats, error) {
+
+	var rows []struct {
+		Fod        bool
+		Count      uint64
+		Size       uint64
+		StoredSize uint64
+	}
+	db := database.GetDB("objs").Table(bucket.GetObjsTableName())
+	r := db.Select("fod, COUNT(*) AS count, COALESCE(SUM(si), 0) AS size, COALESCE(SUM(psi), 0) AS stored_size").Group("fod").Scan(&rows)
+	if r.Error != nil {
+		return nil, errors.New(r.Error.Error())
+	}
+
+	stats := &BucketObjsStats{}
+	for _, row := range rows {
+		if row.Fod {
+			stats.Objects, stats.Size, stats.StoredSize = row.Count, row.Size, row.StoredSize
+		} else {
+			stats.Dirs = row.Count
+		}
+	}
+	return stats, nil
+}
+
+// GetObjsBlobRefs return the number of objects referencing each blob in bucket's table
+func (bm BucketManager) GetObjsBlobRefs(bucket *Bucket) (map[uint64]int64, error) {
+
+	var rows []struct {
+		Bid   uint64
+		Count int64
+	}
+	db := database.GetDB("objs").Table(bucket.GetObjsTableName())
+	if r := db.Select("bid, COUNT(*) AS count").Where("bid > ?", 0).Group("bid").Scan(&rows); r.Error != nil {
+		return nil, errors.New(r.Error.Error())
+	}
+	refs := make(map[uint64]int64, len(rows))
+	for _, row := range rows {
+		refs[row.Bid] = row.Count
+	}
+	return refs, nil
+}
+
+// WalkObjs call fn with objects (not dirs) in bucket's table in batches order by id,
+// stop and return the error if fn returns an error; objects can be deleted by fn
+func (bm BucketManager) WalkObjs(bucket *Bucket, fn func(objs []*HarborObject) error) error {
+
+	const batchSize = 1000
+
+	db := database.GetDB("objs").Table(bucket.GetObjsTableName())
+	var lastID uint64
+	for {
+		var objs []*HarborObject
+		r := db.Where("id > ? AND fod = ?", lastID, true).Order("id").Limit(batchSize).Find(&objs)
+		if r.Error != nil {
+			return errors.New(r.Error.Error())
+		}
+		if len(objs) == 0 {
+			return nil
+		}
+		if err := fn(objs); err != nil {
+			return err
+		}
+		lastID = objs[len(objs)-1].ID
+	}
+}
+
+// PurgeBucket permanently delete bucket, its objects and table of objects;
+// remove is called for each object before its metadata is deleted, to delete or queue its data,
+// return the number of deleted objects
+func (bm BucketManager) PurgeBucket(bucket *Bucket, remove func(obj *HarborObject) error) (int64, error) {
+
+	db := database.GetDB("objs")
+	tableName := bucket.GetObjsTableName()
+	var n int64
+	if db.HasTable(tableName) {
+		err := bm.WalkObjs(bucket, func(objs []*HarborObject) error {
+			ids := make([]uint64, 0, len(objs))
+			for _, obj := range objs {
+				if err := remove(obj); err != nil {
+					return err
+				}
+				ids = append(ids, obj.ID)
+			}
+			if r := db.Table(tableName).Where("id IN (?)", ids).Delete(HarborObject{}); r.Error != nil {
+				return errors.New(r.Error.Error())
+			}
+			n += int64(len(objs))
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+		if r := db.DropTable(tableName); r.Error != nil {
+			return n, errors.New(r.Error.Error())
+		}
+	}
+
+	if err := bm.DeleteBucket(bucket); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
 // TokenManager token manager
 type TokenManager struct {
 	Manager
@@ -731,7 +1099,8 @@ func NewTokenManager(user *UserProfile) *TokenManager {
 	}
 }
 
-// GetOrCreateToken return token if it exists,otherwise create one
+// GetOrCreateToken return token if it exists,otherwise create one;
+// created is true only if the token is created by this call, a token failed to create is not returned
 // return:
 //		token, true, nil
 //		token, false, nil
@@ -750,8 +1119,10 @@ func (m *TokenManager) GetOrCreateToken() (tk *Token, created bool, err error) {
 		tk = NewToken(m.User)
 		err = m.CreateToken(tk)
 		if err != nil {
-			created = true
+			tk = nil
+			return
 		}
+		created = true
 		return
 	}
 	tk = &token
@@ -770,12 +1141,12 @@ func (m *TokenManager) CreateToken(token *Token) error {
 	return nil
 }
 
-// DeleteToken remove token
+// DeleteToken remove token, it is not an error if the token does not exist; errors of database are returned
 func (m *TokenManager) DeleteToken(token *Token) error {
 
 	db := m.GetDB()
 	if r := db.Delete(token); r.Error != nil {
-		if !r.RecordNotFound() {
+		if r.RecordNotFound() {
 			return nil
 		}
 
@@ -785,6 +1156,17 @@ func (m *TokenManager) DeleteToken(token *Token) error {
 	return nil
 }
 
+// DeleteUserTokens remove all tokens of user, return the number of removed tokens
+func (m *TokenManager) DeleteUserTokens() (int64, error) {
+
+	db := m.GetDB()
+	r := db.Where("user_id = ?", m.User.ID).Delete(&Token{})
+	if r.Error != nil {
+		return 0, errors.New(r.Error.Error())
+	}
+	return r.RowsAffected, nil
+}
+
 // GetTokenWithUser get token
 func (m *TokenManager) GetTokenWithUser(token string) (*Token, error) {
 
@@ -800,3 +1182,767 @@ func (m *TokenManager) GetTokenWithUser(token string) (*Token, error) {
 
 	return tk, nil
 }
+
+// UserManager user manager
+type UserManager struct {
+	Manager
+}
+
+// NewUserManager return manager for manage user
+func NewUserManager() *UserManager {
+
+	tableName := UserProfile{}.TableName()
+	return &UserManager{
+		Manager: *NewManager("default", tableName),
+	}
+}
+
+// GetUserByName return user by username, nil if not found
+func (m *UserManager) GetUserByName(username string) (*UserProfile, error) {
+
+	user := &UserProfile{}
+	db := m.GetDB()
+	if r := db.Where("username = ?", username).First(user); r.Error != nil {
+		if r.RecordNotFound() {
+			return nil, nil
+		}
+		return nil, errors.New(r.Error.Error())
+	}
+	return user, nil
+}
+
+// GetUserByOIDCSubject return user of subject at the OIDC IdP, nil if not found
+func (m *UserManager) GetUserByOIDCSubject(sub string) (*UserProfile, error) {
+
+	user := &UserProfile{}
+	db := m.GetDB()
+	if r := db.Where("oidc_sub = ?", sub).First(user); r.Error != nil {
+		if r.RecordNotFound() {
+			return nil, nil
+		}
+		return nil, errors.New(r.Error.Error())
+	}
+	return user, nil
+}
+
+// SaveUser create or update user
+func (m *UserManager) SaveUser(user *UserProfile) error {
+
+	db := m.GetDB()
+	if r := db.Save(user); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// ListUsers return all users order by id
+func (m *UserManager) ListUsers() ([]UserProfile, error) {
+
+	var users []UserProfile
+	db := m.GetDB()
+	if r := db.Order("id").Find(&users); r.Error != nil {
+		return nil, errors.New(r.Error.Error())
+	}
+	return users, nil
+}
+
+// UpdatePassword update only the password column of user
+func (m *UserManager) UpdatePassword(user *UserProfile) error {
+
+	db := m.GetDB()
+	if r := db.Where("id = ?", user.ID).Update("password", user.Password); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// TOTPManager user's TOTP device manager
+type TOTPManager struct {
+	Manager
+	User *UserProfile
+}
+
+// NewTOTPManager return manager for manage user's TOTP device
+func NewTOTPManager(user *UserProfile) *TOTPManager {
+
+	tableName := UserTOTP{}.TableName()
+	return &TOTPManager{
+		Manager: *NewManager("default", tableName),
+		User:    user,
+	}
+}
+
+// GetTOTP return user's TOTP device, nil if not exists
+func (m *TOTPManager) GetTOTP() (*UserTOTP, error) {
+
+	t := &UserTOTP{}
+	db := m.GetDB()
+	if r := db.Where("user_id = ?", m.User.ID).First(t); r.Error != nil {
+		if r.RecordNotFound() {
+			return nil, nil
+		}
+		return nil, errors.New(r.Error.Error())
+	}
+	return t, nil
+}
+
+// SaveTOTP create or update TOTP device
+func (m *TOTPManager) SaveTOTP(t *UserTOTP) error {
+
+	db := m.GetDB()
+	if r := db.Save(t); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// DeleteTOTP remove TOTP device
+func (m *TOTPManager) DeleteTOTP(t *UserTOTP) error {
+
+	db := m.GetDB()
+	if r := db.Delete(t); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// UseCounter save the time step counter of used code, return false if the code of counter
+// or a later one has been used, to prevent replay
+func (m *TOTPManager) UseCounter(t *UserTOTP, counter int64) (bool, error) {
+
+	db := m.GetDB()
+	r := db.Where("id = ? AND last_counter < ?", t.ID, counter).Update("last_counter", counter)
+	if r.Error != nil {
+		return false, errors.New(r.Error.Error())
+	}
+	if r.RowsAffected == 0 {
+		return false, nil
+	}
+	t.LastCounter = counter
+	return true, nil
+}
+
+// SaveRecoveryCodes update confirmed and recovery codes of device only, the last used counter is not overwritten
+func (m *TOTPManager) SaveRecoveryCodes(t *UserTOTP) error {
+
+	r := m.GetDB().Where("id = ?", t.ID).Updates(map[string]interface{}{
+		"confirmed":      t.Confirmed,
+		"recovery_codes": t.RecoveryCodes,
+	})
+	if r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// UseRecoveryCode remove the recovery code from device if it is unused, return false if it is not;
+// the codes are updated only if not changed since read, so that a code can not be used by concurrent requests
+func (m *TOTPManager) UseRecoveryCode(t *UserTOTP, code string) (bool, error) {
+
+	for i := 0; i < 3; i++ {
+		old := t.RecoveryCodes
+		if !t.UseRecoveryCode(code) {
+			return false, nil
+		}
+		r := m.GetDB().Where("id = ? AND recovery_codes = ?", t.ID, old).Update("recovery_codes", t.RecoveryCodes)
+		if r.Error != nil {
+			t.RecoveryCodes = old
+			return false, errors.New(r.Error.Error())
+		}
+		if r.RowsAffected == 1 {
+			return true, nil
+		}
+		// changed by another request, try again with the current codes
+		current := &UserTOTP{}
+		if r := m.GetDB().Select("recovery_codes").Where("id = ?", t.ID).First(current); r.Error != nil {
+			if r.RecordNotFound() {
+				return false, nil
+			}
+			return false, errors.New(r.Error.Error())
+		}
+		t.RecoveryCodes = current.RecoveryCodes
+	}
+	return false, nil
+}
+
+// GetUserByID return user by id, nil if not found
+func (m *UserManager) GetUserByID(id uint) (*UserProfile, error) {
+
+	user := &UserProfile{}
+	db := m.GetDB()
+	if r := db.Where("id = ?", id).First(user); r.Error != nil {
+		if r.RecordNotFound() {
+			return nil, nil
+		}
+		return nil, errors.New(r.Error.Error())
+	}
+	return user, nil
+}
+
+// AuditEventFilter filters of audit events query, zero value fields are ignored
+type AuditEventFilter struct {
+	ActorID      uint
+	Actor        string
+	Action       string // action or prefix ending with ".", e.g. "object."
+	TargetType   string
+	TargetPrefix string
+	SourceIP     string
+	Result       string
+	RequestID    string
+	Since        time.Time
+	Until        time.Time
+}
+
+// AuditManager audit event manager
+type AuditManager struct {
+	Manager
+}
+
+// NewAuditManager return manager for manage audit events
+func NewAuditManager() *AuditManager {
+
+	tableName := AuditEvent{}.TableName()
+	return &AuditManager{
+		Manager: *NewManager("default", tableName),
+	}
+}
+
+// CreateEvent save a audit event
+func (m *AuditManager) CreateEvent(e *AuditEvent) error {
+
+	db := m.GetDB()
+	if r := db.Create(e); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// GetEventsQuery return query of audit events filtered, newest first
+func (m *AuditManager) GetEventsQuery(f AuditEventFilter) *gorm.DB {
+
+	db := m.GetDB().Order("id desc")
+	if f.ActorID > 0 {
+		db = db.Where("actor_id = ?", f.ActorID)
+	}
+	if f.Actor != "" {
+		db = db.Where("actor = ?", f.Actor)
+	}
+	if strings.HasSuffix(f.Action, ".") {
+		db = db.Where("action LIKE ? ESCAPE '!'", escapeLike(f.Action)+"%")
+	} else if f.Action != "" {
+		db = db.Where("action = ?", f.Action)
+	}
+	if f.TargetType != "" {
+		db = db.Where("target_type = ?", f.TargetType)
+	}
+	if f.TargetPrefix != "" {
+		db = db.Where("target LIKE ? ESCAPE '!'", escapeLike(f.TargetPrefix)+"%")
+	}
+	if f.SourceIP != "" {
+		db = db.Where("source_ip = ?", f.SourceIP)
+	}
+	if f.Result != "" {
+		db = db.Where("result = ?", f.Result)
+	}
+	if f.RequestID != "" {
+		db = db.Where("request_id = ?", f.RequestID)
+	}
+	if !f.Since.IsZero() {
+		db = db.Where("time >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		db = db.Where("time < ?", f.Until)
+	}
+	return db
+}
+
+// escapeLike escape wildcard characters of LIKE pattern with "!", which is the escape character
+// of all dialects(backslash is not in sqlite and needs escaping in mysql string literal)
+func escapeLike(s string) string {
+
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// WebhookManager webhook and delivery manager
+type WebhookManager struct {
+	Manager
+}
+
+// NewWebhookManager return manager for manage webhooks
+func NewWebhookManager() *WebhookManager {
+
+	tableName := Webhook{}.TableName()
+	return &WebhookManager{
+		Manager: *NewManager("default", tableName),
+	}
+}
+
+// GetWebhookByID return webhook instance
+// return:
+//		*Webhook, nil: exists and no error
+//		nil, nil: not exists and no error
+//		nil, error: have a error
+func (m *WebhookManager) GetWebhookByID(id uint64) (*Webhook, error) {
+
+	hook := &Webhook{}
+	if r := m.GetDB().Where("id = ?", id).First(hook); r.Error != nil {
+		if r.RecordNotFound() {
+			return nil, nil
+		}
+		return nil, errors.New(r.Error.Error())
+	}
+	return hook, nil
+}
+
+// GetBucketWebhooks return active webhooks of buckets
+func (m *WebhookManager) GetBucketWebhooks(bucketIDs ...uint64) ([]*Webhook, error) {
+
+	var hooks []*Webhook
+	if r := m.GetDB().Where("bucket_id in (?) AND is_active = ?", bucketIDs, true).Find(&hooks); r.Error != nil {
+		return nil, errors.New(r.Error.Error())
+	}
+	return hooks, nil
+}
+
+// GetUserWebhooksQuery return query of webhooks of user's buckets, filtered by bucket id if bucketID > 0
+func (m *WebhookManager) GetUserWebhooksQuery(user *UserProfile, bucketID uint64) *gorm.DB {
+
+	db := m.GetDB().Order("id desc").Where("bucket_id in (?)",
+		database.GetDBDefault().Table(Bucket{}.TableName()).Select("id").Where("user_id = ? AND soft_delete = ?", user.ID, false).QueryExpr())
+	if bucketID > 0 {
+		db = db.Where("bucket_id = ?", bucketID)
+	}
+	return db
+}
+
+// CountBucketWebhooks return number of webhooks of bucket
+func (m *WebhookManager) CountBucketWebhooks(bucketID uint64) (int, error) {
+
+	var count int
+	if r := m.GetDB().Where("bucket_id = ?", bucketID).Count(&count); r.Error != nil {
+		return 0, errors.New(r.Error.Error())
+	}
+	return count, nil
+}
+
+// SaveWebhook create or update webhook
+func (m *WebhookManager) SaveWebhook(hook *Webhook) error {
+
+	if r := m.GetDB().Save(hook); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// DeleteWebhook delete webhook and its deliveries
+func (m *WebhookManager) DeleteWebhook(hook *Webhook) error {
+
+	db := database.GetDBDefault()
+	tx := db.Begin()
+	if r := tx.Where("webhook_id = ?", hook.ID).Delete(WebhookDelivery{}); r.Error != nil {
+		tx.Rollback()
+		return errors.New(r.Error.Error())
+	}
+	if r := tx.Delete(hook); r.Error != nil {
+		tx.Rollback()
+		return errors.New(r.Error.Error())
+	}
+	if r := tx.Commit(); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// CreateDelivery save a new delivery
+func (m *WebhookManager) CreateDelivery(d *WebhookDelivery) error {
+
+	db := database.GetDBDefault()
+	if r := db.Create(d); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// SaveDelivery update delivery
+func (m *WebhookManager) SaveDelivery(d *WebhookDelivery) error {
+
+	db := database.GetDBDefault()
+	if r := db.Save(d); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// UpdateDelivery update fields of delivery
+func (m *WebhookManager) UpdateDelivery(id uint64, fields map[string]interface{}) error {
+
+	db := database.GetDBDefault()
+	if r := db.Model(&WebhookDelivery{}).Where("id = ?", id).Updates(fields); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// GetDelivery return delivery of webhook
+// return:
+//		*WebhookDelivery, nil: exists and no error
+//		nil, nil: not exists and no error
+//		nil, error: have a error
+func (m *WebhookManager) GetDelivery(webhookID, id uint64) (*WebhookDelivery, error) {
+
+	d := &WebhookDelivery{}
+	db := database.GetDBDefault()
+	if r := db.Where("id = ? AND webhook_id = ?", id, webhookID).First(d); r.Error != nil {
+		if r.RecordNotFound() {
+			return nil, nil
+		}
+		return nil, errors.New(r.Error.Error())
+	}
+	return d, nil
+}
+
+// GetDeliveriesQuery return query of deliveries of webhook, newest first, filtered by status if not empty
+func (m *WebhookManager) GetDeliveriesQuery(webhookID uint64, status string) *gorm.DB {
+
+	db := database.GetDBDefault().Model(&WebhookDelivery{}).Order("id desc").Where("webhook_id = ?", webhookID)
+	if status != "" {
+		db = db.Where("status = ?", status)
+	}
+	return db
+}
+
+// GetUnfinishedDeliveries return deliveries waiting for attempt
+func (m *WebhookManager) GetUnfinishedDeliveries() ([]*WebhookDelivery, error) {
+
+	var ds []*WebhookDelivery
+	db := database.GetDBDefault()
+	if r := db.Where("status in (?)", []string{DeliveryPending, DeliveryRetrying}).Order("id").Find(&ds); r.Error != nil {
+		return nil, errors.New(r.Error.Error())
+	}
+	return ds, nil
+}
+
+// GCManager garbage collection queue manager
+type GCManager struct {
+	Manager
+}
+
+// NewGCManager return manager for manage garbage collection queue
+func NewGCManager() *GCManager {
+
+	tableName := GCTask{}.TableName()
+	return &GCManager{
+		Manager: *NewManager("default", tableName),
+	}
+}
+
+// Enqueue add storage key to the queue, its data is deleted after delay; nothing is done if the key is queued
+func (m *GCManager) Enqueue(key string, size uint64, reason string, delay time.Duration) error {
+
+	db := m.GetDB()
+	var n int
+	if r := db.Where("storage_key = ?", key).Count(&n); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	if n > 0 {
+		return nil
+	}
+	now := time.Now()
+	task := &GCTask{
+		Key:         key,
+		Size:        size,
+		Reason:      reason,
+		CreatedTime: TypeJSONTime{Time: now},
+		NextAttempt: TypeJSONTime{Time: now.Add(delay)},
+	}
+	if r := db.Create(task); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// EnqueueObject queue data of deleted object, the reference to its blob is released instead
+// if the object is deduplicated, and the blob is queued if it is no longer referenced
+func (m *GCManager) EnqueueObject(bucket *Bucket, obj *HarborObject, reason string) error {
+
+	if obj.IsDeduplicated() {
+		return NewBlobManager().ReleaseBlob(obj.BlobID)
+	}
+	return m.Enqueue(obj.GetObjKey(bucket), obj.Size, reason, 0)
+}
+
+// GetDueTasks return at most limit tasks whose next attempt is due, order by next attempt
+func (m *GCManager) GetDueTasks(limit int) ([]*GCTask, error) {
+
+	var tasks []*GCTask
+	db := m.GetDB()
+	if r := db.Where("next_attempt <= ?", time.Now()).Order("next_attempt, id").Limit(limit).Find(&tasks); r.Error != nil {
+		return nil, errors.New(r.Error.Error())
+	}
+	return tasks, nil
+}
+
+// DeleteTask remove finished task from the queue
+func (m *GCManager) DeleteTask(task *GCTask) error {
+
+	db := m.GetDB()
+	if r := db.Where("id = ?", task.ID).Delete(GCTask{}); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// ClaimTask claim task for a collector for lease, its key must not be written by others before the task is
+// deleted or retried; false is returned if the task is deleted or claimed by another collector
+func (m *GCManager) ClaimTask(task *GCTask, lease time.Duration) (bool, error) {
+
+	now := time.Now()
+	until := TypeJSONTime{Time: now.Add(lease)}
+	db := m.GetDB()
+	r := db.Where("id = ? AND (claimed_until IS NULL OR claimed_until < ?)", task.ID, now).Updates(map[string]interface{}{
+		"claimed_until": until,
+		"next_attempt":  until,
+	})
+	if r.Error != nil {
+		return false, errors.New(r.Error.Error())
+	}
+	if r.RowsAffected == 0 {
+		return false, nil
+	}
+	task.ClaimedUntil, task.NextAttempt = until, until
+	return true, nil
+}
+
+// DeleteTaskByKey remove task of key from the queue before the key is written again,
+// ErrGCTaskClaimed is returned if the task is claimed by a collector
+func (m *GCManager) DeleteTaskByKey(key string) error {
+
+	db := m.GetDB()
+	now := time.Now()
+	if r := db.Where("storage_key = ? AND (claimed_until IS NULL OR claimed_until < ?)", key, now).Delete(GCTask{}); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	var n int
+	if r := db.Where("storage_key = ?", key).Count(&n); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	if n > 0 {
+		return ErrGCTaskClaimed
+	}
+	return nil
+}
+
+// RetryTask record the failed attempt of task, and schedule the next attempt after delay
+func (m *GCManager) RetryTask(task *GCTask, attemptErr error, delay time.Duration) error {
+
+	msg := attemptErr.Error()
+	if len(msg) > 1024 {
+		msg = msg[:1024]
+	}
+	task.Attempts++
+	task.Error = msg
+	task.NextAttempt = TypeJSONTime{Time: time.Now().Add(delay)}
+	task.ClaimedUntil = TypeJSONTime{}
+	db := m.GetDB()
+	if r := db.Where("id = ?", task.ID).Updates(map[string]interface{}{
+		"attempts":      task.Attempts,
+		"error":         task.Error,
+		"next_attempt":  task.NextAttempt,
+		"claimed_until": task.ClaimedUntil,
+	}); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// GCQueueStats statistics of garbage collection queue
+type GCQueueStats struct {
+	Pending  int64  `json:"pending"`  // keys in queue
+	Retrying int64  `json:"retrying"` // keys failed at least once
+	Bytes    uint64 `json:"bytes"`    // total size of keys in queue
+}
+
+// GetQueueStats return statistics of the queue
+func (m *GCManager) GetQueueStats() (*GCQueueStats, error) {
+
+	var row struct {
+		Pending  int64
+		Retrying int64
+		Bytes    uint64
+	}
+	db := m.GetDB()
+	r := db.Select("COUNT(*) AS pending, COALESCE(SUM(CASE WHEN attempts > 0 THEN 1 ELSE 0 END), 0) AS retrying, " +
+		"COALESCE(SUM(size), 0) AS bytes").Scan(&row)
+	if r.Error != nil {
+		return nil, errors.New(r.Error.Error())
+	}
+	return &GCQueueStats{Pending: row.Pending, Retrying: row.Retrying, Bytes: row.Bytes}, nil
+}
+
+// BlobManager manager of blobs of deduplicated data
+type BlobManager struct {
+	Manager
+}
+
+// NewBlobManager return manager for manage blobs
+func NewBlobManager() *BlobManager {
+
+	tableName := Blob{}.TableName()
+	return &BlobManager{
+		Manager: *NewManager("default", tableName),
+	}
+}
+
+// GetBlobByID return blob of id, nil if not found
+func (m *BlobManager) GetBlobByID(id uint64) (*Blob, error) {
+
+	return m.getBlob("id = ?", id)
+}
+
+// GetBlobByHash return blob of content hash, nil if not found
+func (m *BlobManager) GetBlobByHash(hash string) (*Blob, error) {
+
+	return m.getBlob("hash = ?", hash)
+}
+
+func (m *BlobManager) getBlob(query string, arg interface{}) (*Blob, error) {
+
+	blob := &Blob{}
+	db := m.GetDB()
+	if r := db.Where(query, arg).First(blob); r.Error != nil {
+		if r.RecordNotFound() {
+			return nil, nil
+		}
+		return nil, errors.New(r.Error.Error())
+	}
+	return blob, nil
+}
+
+// CreateBlob create an incomplete blob referenced once by its creator, return false if a blob of the hash exists
+func (m *BlobManager) CreateBlob(blob *Blob) (bool, error) {
+
+	blob.Refs = 1
+	blob.Complete = false
+	blob.CreatedTime = JSONTimeNow()
+	db := m.GetDB()
+	if r := db.Create(blob); r.Error != nil {
+		// created by others at the same time
+		if b, err := m.GetBlobByHash(blob.Hash); err == nil && b != nil {
+			return false, nil
+		}
+		return false, errors.New(r.Error.Error())
+	}
+	return true, nil
+}
+
+// CompleteBlob set size of stored data of blob and mark its data written to database
+func (m *BlobManager) CompleteBlob(blob *Blob) error {
+
+	blob.Complete = true
+	db := m.GetDB()
+	if r := db.Model(blob).Updates(map[string]interface{}{
+		"stored_size": blob.StoredSize,
+		"complete":    true,
+	}); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return nil
+}
+
+// AddBlobRef add a reference of complete blob, return false if the blob is incomplete or no longer referenced
+func (m *BlobManager) AddBlobRef(blob *Blob) (bool, error) {
+
+	db := m.GetDB()
+	r := db.Model(&Blob{}).Where("id = ? AND refs > ? AND complete = ?", blob.ID, 0, true).Update("refs", gorm.Expr("refs + ?", 1))
+	if r.Error != nil {
+		return false, errors.New(r.Error.Error())
+	}
+	if r.RowsAffected == 0 {
+		return false, nil
+	}
+	blob.Refs++
+	return true, nil
+}
+
+// ReleaseBlob release a reference of blob id, the blob is deleted and its data queued for garbage collection
+// if it is no longer referenced
+func (m *BlobManager) ReleaseBlob(id uint64) error {
+
+	db := m.GetDB()
+	if r := db.Model(&Blob{}).Where("id = ? AND refs > ?", id, 0).Update("refs", gorm.Expr("refs - ?", 1)); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	return m.deleteUnreferenced(id)
+}
+
+// SetBlobRefs set references of blob to the counted refs, the blob is deleted and its data queued
+// for garbage collection if refs is 0
+func (m *BlobManager) SetBlobRefs(blob *Blob, refs int64) error {
+
+	db := m.GetDB()
+	if r := db.Model(&Blob{}).Where("id = ?", blob.ID).Update("refs", refs); r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	blob.Refs = refs
+	if refs > 0 {
+		return nil
+	}
+	return m.deleteUnreferenced(blob.ID)
+}
+
+func (m *BlobManager) deleteUnreferenced(id uint64) error {
+
+	blob, err := m.GetBlobByID(id)
+	if err != nil || blob == nil || blob.Refs > 0 {
+		return err
+	}
+	db := m.GetDB()
+	r := db.Where("id = ? AND refs = ?", id, 0).Delete(Blob{})
+	if r.Error != nil {
+		return errors.New(r.Error.Error())
+	}
+	if r.RowsAffected == 0 {
+		return nil
+	}
+	return NewGCManager().Enqueue(BlobKey(id), blob.Size, GCBlobReleased, 0)
+}
+
+// ListBlobs return at most limit blobs order by id after afterID
+func (m *BlobManager) ListBlobs(afterID uint64, limit int) ([]*Blob, error) {
+
+	var blobs []*Blob
+	db := m.GetDB()
+	if r := db.Where("id > ?", afterID).Order("id").Limit(limit).Find(&blobs); r.Error != nil {
+		return nil, errors.New(r.Error.Error())
+	}
+	return blobs, nil
+}
+
+// BlobStats statistics of blobs
+type BlobStats struct {
+	Blobs      int64  `json:"blobs"`
+	Refs       int64  `json:"refs"`        // objects referencing blobs
+	Size       uint64 `json:"size"`        // size of data of blobs
+	StoredSize uint64 `json:"stored_size"` // size of data of blobs in storage, after compression
+	SavedBytes uint64 `json:"saved_bytes"` // size of data not stored again for objects sharing blobs
+}
+
+// GetBlobStats return statistics of blobs
+func (m *BlobManager) GetBlobStats() (*BlobStats, error) {
+
+	var row struct {
+		Blobs      int64
+		Refs       int64
+		Size       uint64
+		StoredSize uint64
+		Saved      uint64
+	}
+	db := m.GetDB()
+	r := db.Select("COUNT(*) AS blobs, COALESCE(SUM(refs), 0) AS refs, COALESCE(SUM(size), 0) AS size, " +
+		"COALESCE(SUM(stored_size), 0) AS stored_size, " +
+		"COALESCE(SUM(CASE WHEN refs > 1 THEN (refs - 1) * stored_size ELSE 0 END), 0) AS saved").Scan(&row)
+	if r.Error != nil {
+		return nil, errors.New(r.Error.Error())
+	}
+	return &BlobStats{Blobs: row.Blobs, Refs: row.Refs, Size: row.Size, StoredSize: row.StoredSize, SavedBytes: row.Saved}, nil
+}
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"harbor/database"
+	"harbor/database/migrate"
+	"harbor/utils/logger"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Migrations schema migrations in order of version, applied migrations must not be changed,
+// add a new one for each schema change
+var Migrations = []migrate.Migration{
+	{
+		// create tables of database "default", existing tables get missing columns and indexes;
+		// it can not be reverted, tables of the existing database may be adopted by it
+		Version: 1,
+		Name:    "initial",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&initialUserProfile{},
+				&initialBucket{},
+				&initialToken{},
+				&initialUserTOTP{},
+				&initialAuditEvent{},
+				&initialWebhook{},
+				&initialWebhookDelivery{},
+			).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return migrate.ErrIrreversible
+		},
+	},
+	{
+		// indexes of per-bucket object tables, named per table in postgres and sqlite
+		Version: 2,
+		Name:    "bucket object indexes",
+		UpTable: func(db *gorm.DB, table string) error {
+			if name := database.IndexName(db, table, "idx_fod_did"); !db.Dialect().HasIndex(table, name) {
+				if err := db.Table(table).AddIndex(name, "fod", "did").Error; err != nil {
+					return err
+				}
+			}
+			if name := database.IndexName(db, table, "udx_did_name"); !db.Dialect().HasIndex(table, name) {
+				if err := db.Table(table).AddUniqueIndex(name, "did", "name").Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		DownTable: func(db *gorm.DB, table string) error {
+			for _, name := range []string{"idx_fod_did", "udx_did_name"} {
+				if name = database.IndexName(db, table, name); db.Dialect().HasIndex(table, name) {
+					if err := db.Table(table).RemoveIndex(name).Error; err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// indexes of table buckets were renamed to be unique in database for postgres and sqlite,
+		// drop the ones of old names created by AutoMigrate in mysql
+		Version: 3,
+		Name:    "drop legacy bucket indexes",
+		Up: func(db *gorm.DB) error {
+			table := initialBucket{}.TableName()
+			for _, name := range []string{"uidx_name", "idx_user_id"} {
+				if database.IsMySQL(db) && db.Dialect().HasIndex(table, name) {
+					if err := db.Table(table).RemoveIndex(name).Error; err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// queue of storage keys to be deleted by garbage collector
+		Version: 4,
+		Name:    "gc queue",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&gcQueueTask{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTableIfExists(&gcQueueTask{}).Error
+		},
+	},
+	{
+		// encryption at rest of bucket and object data
+		Version: 5,
+		Name:    "encryption",
+		Up: func(db *gorm.DB) error {
+			return database.AddColumns(db, initialBucket{}.TableName(), &encryptionBucket{}, "encryption")
+		},
+		Down: func(db *gorm.DB) error {
+			return database.DropColumns(db, initialBucket{}.TableName(), "encryption")
+		},
+		UpTable: func(db *gorm.DB, table string) error {
+			return database.AddColumns(db, table, &encryptionObject{}, "enc", "ekid", "edk")
+		},
+		DownTable: func(db *gorm.DB, table string) error {
+			return database.DropColumns(db, table, "enc", "ekid", "edk")
+		},
+	},
+	{
+		// compression of bucket and object data, and size of stored data of objects
+		Version: 6,
+		Name:    "compression",
+		Up: func(db *gorm.DB) error {
+			return database.AddColumns(db, initialBucket{}.TableName(), &compressionBucket{}, "compression")
+		},
+		Down: func(db *gorm.DB) error {
+			return database.DropColumns(db, initialBucket{}.TableName(), "compression")
+		},
+		UpTable: func(db *gorm.DB, table string) error {
+			if err := database.AddColumns(db, table, &compressionObject{}, "cmp", "psi"); err != nil {
+				return err
+			}
+			// data of existing objects is not compressed, overhead of encryption is not counted
+			return db.Table(table).Where("psi = ? AND cmp = ?", 0, "").UpdateColumn("psi", gorm.Expr("si")).Error
+		},
+		DownTable: func(db *gorm.DB, table string) error {
+			return database.DropColumns(db, table, "cmp", "psi")
+		},
+	},
+	{
+		// blobs of deduplicated data, and blob referenced by objects
+		Version: 7,
+		Name:    "dedup",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&dedupBlob{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTableIfExists(&dedupBlob{}).Error
+		},
+		UpTable: func(db *gorm.DB, table string) error {
+			if err := database.AddColumns(db, table, &dedupObject{}, "bid"); err != nil {
+				return err
+			}
+			if name := database.IndexName(db, table, "idx_bid"); !db.Dialect().HasIndex(table, name) {
+				return db.Table(table).AddIndex(name, "bid").Error
+			}
+			return nil
+		},
+		DownTable: func(db *gorm.DB, table string) error {
+			if name := database.IndexName(db, table, "idx_bid"); db.Dialect().HasIndex(table, name) {
+				if err := db.Table(table).RemoveIndex(name).Error; err != nil {
+					return err
+				}
+			}
+			return database.DropColumns(db, table, "bid")
+		},
+	},
+	{
+		// email verification apart from activation, so that users deactivated are not activated by verification;
+		// existing users active or ever logged in are taken as verified
+		Version: 8,
+		Name:    "email verified",
+		Up: func(db *gorm.DB) error {
+			table := initialUserProfile{}.TableName()
+			if err := database.AddColumns(db, table, &emailVerifiedUser{}, "email_verified"); err != nil {
+				return err
+			}
+			return db.Table(table).Where("is_active = ? OR last_login IS NOT NULL", true).UpdateColumn("email_verified", true).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return database.DropColumns(db, initialUserProfile{}.TableName(), "email_verified")
+		},
+	},
+	{
+		// OIDC users are matched by subject at the IdP
+		Version: 9,
+		Name:    "oidc subject",
+		Up: func(db *gorm.DB) error {
+			table := initialUserProfile{}.TableName()
+			if err := database.AddColumns(db, table, &oidcSubjectUser{}, "oidc_sub"); err != nil {
+				return err
+			}
+			if !db.Dialect().HasIndex(table, "idx_user_oidc_sub") {
+				return db.Table(table).AddIndex("idx_user_oidc_sub", "oidc_sub").Error
+			}
+			return nil
+		},
+		Down: func(db *gorm.DB) error {
+			table := initialUserProfile{}.TableName()
+			if db.Dialect().HasIndex(table, "idx_user_oidc_sub") {
+				if err := db.Table(table).RemoveIndex("idx_user_oidc_sub").Error; err != nil {
+					return err
+				}
+			}
+			return database.DropColumns(db, table, "oidc_sub")
+		},
+	},
+	{
+		// keys being collected are not written by others
+		Version: 10,
+		Name:    "gc queue claims",
+		Up: func(db *gorm.DB) error {
+			return database.AddColumns(db, gcQueueTask{}.TableName(), &gcQueueClaim{}, "claimed_until")
+		},
+		Down: func(db *gorm.DB) error {
+			return database.DropColumns(db, gcQueueTask{}.TableName(), "claimed_until")
+		},
+	},
+}
+
+// bucketObjsTables return names of object tables of all buckets
+func bucketObjsTables() ([]string, error) {
+
+	var buckets []Bucket
+	if err := database.GetDBDefault().Select("id, collection_name").Find(&buckets).Error; err != nil {
+		return nil, err
+	}
+	tables := make([]string, 0, len(buckets))
+	for i := range buckets {
+		tables = append(tables, buckets[i].GetObjsTableName())
+	}
+	return tables, nil
+}
+
+// NewMigrator return migrator of Migrations
+func NewMigrator() (*migrate.Migrator, error) {
+
+	return migrate.NewMigrator(database.GetDBDefault(), database.GetDB("objs"), bucketObjsTables, Migrations,
+		logger.Std().WithField("component", "migrate"))
+}
@@ -0,0 +1,125 @@
+package models
+
+// schema of tables created by migration 1 "initial", copied from the models of that version so that
+// the migration creates the same tables later; columns added by newer migrations must not be added here
+
+type initialUserProfile struct {
+	ID          uint         `gorm:"primary_key"`
+	Username    string       `gorm:"type:varchar(150);unique_index:uidx_name;not null"`
+	Password    string       `gorm:"type:varchar(128)"`
+	IsSuperUser bool         `gorm:"column:is_superuser;default:false;not null"`
+	IsStaff     bool         `gorm:"column:is_staff;default:false;not null"`
+	IsActive    bool         `gorm:"column:is_active;default:false;not null"`
+	FirstName   string       `gorm:"column:first_name;type:varchar(30)"`
+	LastName    string       `gorm:"column:last_name;type:varchar(150)"`
+	Email       string       `gorm:"type:varchar(254);not null"`
+	DateJoined  TypeJSONTime `gorm:"column:date_joined;type:datetime;not null"`
+	LastLogin   TypeJSONTime `gorm:"column:last_login;type:datetime"`
+	Company     string       `gorm:"type:varchar(255)"`
+	Telephone   string       `gorm:"type:varchar(11)"`
+	ThirdApp    uint         `gorm:"not null;default:0"`
+	SecretKey   string       `gorm:"type:varchar(20)"`
+	LastActive  TypeJSONTime `gorm:"index;type:date"`
+	Role        int16        `gorm:"type:smallint"`
+}
+
+func (initialUserProfile) TableName() string {
+	return "users_userprofile"
+}
+
+type initialBucket struct {
+	ID               uint64               `gorm:"PRIMARY_KEY;AUTO_INCREMENT;not null"`
+	Name             string               `gorm:"type:varchar(63);unique_index:uidx_bucket_name"`
+	UserID           uint                 `gorm:"column:user_id;index:idx_bucket_user_id;"`
+	CreatedTime      TypeJSONTime         `gorm:"column:created_time;type:datetime;"`
+	CollectionName   string               `gorm:"column:collection_name;type:varchar(50)"`
+	AccessPermission TypeBucketPermission `gorm:"column:access_permission;type:smallint"`
+	SoftDelete       bool                 `gorm:"column:soft_delete;"`
+	ModifiedTime     TypeJSONTime         `gorm:"column:modyfied_time;type:datetime;"`
+	ObjsCount        uint32               `gorm:"column:objs_count;"`
+	Size             uint64               `gorm:"column:size;"`
+	StatsTime        TypeJSONTime         `gorm:"column:stats_time;type:datetime;"`
+}
+
+func (initialBucket) TableName() string {
+	return "buckets_bucket"
+}
+
+type initialToken struct {
+	Key     string       `gorm:"type:varchar(40);PRIMARY_KEY;not null"`
+	UserID  uint         `gorm:"column:user_id;index:idx_user_id;"`
+	Created TypeJSONTime `gorm:"column:created;type:datetime;"`
+}
+
+func (initialToken) TableName() string {
+	return "authtoken_token"
+}
+
+type initialUserTOTP struct {
+	ID            uint         `gorm:"primary_key"`
+	UserID        uint         `gorm:"column:user_id;unique_index:uidx_totp_user_id;not null"`
+	Secret        string       `gorm:"type:varchar(64);not null"`
+	Confirmed     bool         `gorm:"default:false;not null"`
+	LastCounter   int64        `gorm:"column:last_counter;default:0;not null"`
+	RecoveryCodes string       `gorm:"column:recovery_codes;type:text"`
+	Created       TypeJSONTime `gorm:"column:created;type:datetime;"`
+}
+
+func (initialUserTOTP) TableName() string {
+	return "users_totp"
+}
+
+type initialAuditEvent struct {
+	ID         uint64       `gorm:"PRIMARY_KEY;AUTO_INCREMENT;not null"`
+	Time       TypeJSONTime `gorm:"column:time;type:datetime;index:idx_audit_time"`
+	ActorID    uint         `gorm:"column:actor_id;index:idx_audit_actor_id"`
+	Actor      string       `gorm:"column:actor;type:varchar(150)"`
+	Action     string       `gorm:"column:action;type:varchar(64);index:idx_audit_action"`
+	TargetType string       `gorm:"column:target_type;type:varchar(32)"`
+	Target     string       `gorm:"column:target;type:varchar(1024)"`
+	SourceIP   string       `gorm:"column:source_ip;type:varchar(64)"`
+	Result     string       `gorm:"column:result;type:varchar(16)"`
+	StatusCode int          `gorm:"column:status_code"`
+	RequestID  string       `gorm:"column:request_id;type:varchar(64);index:idx_audit_request_id"`
+	DetailJSON string       `gorm:"column:detail;type:text"`
+}
+
+func (initialAuditEvent) TableName() string {
+	return "audit_events"
+}
+
+type initialWebhook struct {
+	ID          uint64       `gorm:"PRIMARY_KEY;AUTO_INCREMENT;not null"`
+	BucketID    uint64       `gorm:"column:bucket_id;index:idx_webhook_bucket_id"`
+	UserID      uint         `gorm:"column:user_id"`
+	URL         string       `gorm:"column:url;type:varchar(2048)"`
+	Secret      string       `gorm:"column:secret;type:varchar(128)"`
+	EventsStr   string       `gorm:"column:events;type:varchar(255)"`
+	Prefix      string       `gorm:"column:prefix;type:varchar(1024)"`
+	IsActive    bool         `gorm:"column:is_active"`
+	CreatedTime TypeJSONTime `gorm:"column:created_time;type:datetime"`
+}
+
+func (initialWebhook) TableName() string {
+	return "webhooks"
+}
+
+type initialWebhookDelivery struct {
+	ID           uint64       `gorm:"PRIMARY_KEY;AUTO_INCREMENT;not null"`
+	WebhookID    uint64       `gorm:"column:webhook_id;index:idx_delivery_webhook_id"`
+	EventID      string       `gorm:"column:event_id;type:varchar(64)"`
+	EventType    string       `gorm:"column:event_type;type:varchar(32)"`
+	Payload      string       `gorm:"column:payload;type:text"`
+	Status       string       `gorm:"column:status;type:varchar(16);index:idx_delivery_status"`
+	Attempts     int          `gorm:"column:attempts"`
+	ResponseCode int          `gorm:"column:response_code"`
+	Error        string       `gorm:"column:error;type:varchar(1024)"`
+	DurationMs   int64        `gorm:"column:duration_ms"`
+	CreatedTime  TypeJSONTime `gorm:"column:created_time;type:datetime"`
+	LastAttempt  TypeJSONTime `gorm:"column:last_attempt;type:datetime"`
+	NextAttempt  TypeJSONTime `gorm:"column:next_attempt;type:datetime"`
+}
+
+func (initialWebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
@@ -0,0 +1,77 @@
+package models
+
+// schema of tables and columns created by migrations after "initial", copied from the models of their version
+// so that the migrations create the same schema later; each migration has its own types, which must not be
+// changed after the migration is released
+
+// migration 4 "gc queue"
+type gcQueueTask struct {
+	ID          uint64       `gorm:"PRIMARY_KEY;AUTO_INCREMENT;not null"`
+	Key         string       `gorm:"column:storage_key;type:varchar(128);unique_index:uidx_gc_queue_key;not null"`
+	Size        uint64       `gorm:"column:size"`
+	Reason      string       `gorm:"column:reason;type:varchar(32)"`
+	Attempts    int          `gorm:"column:attempts"`
+	Error       string       `gorm:"column:error;type:varchar(1024)"`
+	CreatedTime TypeJSONTime `gorm:"column:created_time;type:datetime"`
+	NextAttempt TypeJSONTime `gorm:"column:next_attempt;type:datetime;index:idx_gc_queue_next_attempt"`
+}
+
+func (gcQueueTask) TableName() string {
+	return "gc_queue"
+}
+
+// migration 5 "encryption"
+type encryptionBucket struct {
+	Encryption string `gorm:"column:encryption;type:varchar(16);not null;default:''"`
+}
+
+type encryptionObject struct {
+	Encryption string `gorm:"column:enc;type:varchar(16);not null;default:''"`
+	KeyID      string `gorm:"column:ekid;type:varchar(64);not null;default:''"`
+	DataKey    string `gorm:"column:edk;type:varchar(128);not null;default:''"`
+}
+
+// migration 6 "compression"
+type compressionBucket struct {
+	Compression string `gorm:"column:compression;type:varchar(16);not null;default:''"`
+}
+
+type compressionObject struct {
+	Compression string `gorm:"column:cmp;type:varchar(16);not null;default:''"`
+	StoredSize  uint64 `gorm:"column:psi;not null;default:0"`
+}
+
+// migration 7 "dedup"
+type dedupBlob struct {
+	ID          uint64       `gorm:"PRIMARY_KEY;AUTO_INCREMENT;not null"`
+	Hash        string       `gorm:"column:hash;type:varchar(64);unique_index:uidx_blobs_hash;not null"`
+	Size        uint64       `gorm:"column:size;not null"`
+	StoredSize  uint64       `gorm:"column:stored_size;not null;default:0"`
+	Compression string       `gorm:"column:compression;type:varchar(16);not null;default:''"`
+	Refs        int64        `gorm:"column:refs;not null;default:0"`
+	Complete    bool         `gorm:"column:complete;not null;default:false"`
+	CreatedTime TypeJSONTime `gorm:"column:created_time;type:datetime"`
+}
+
+func (dedupBlob) TableName() string {
+	return "blobs"
+}
+
+type dedupObject struct {
+	BlobID uint64 `gorm:"column:bid;not null;default:0"`
+}
+
+// migration 8 "email verified"
+type emailVerifiedUser struct {
+	EmailVerified bool `gorm:"column:email_verified;default:false;not null"`
+}
+
+// migration 9 "oidc subject"
+type oidcSubjectUser struct {
+	OIDCSubject string `gorm:"column:oidc_sub;type:varchar(255)"`
+}
+
+// migration 10 "gc queue claims"
+type gcQueueClaim struct {
+	ClaimedUntil TypeJSONTime `gorm:"column:claimed_until;type:datetime"`
+}
@@ -45,20 +45,31 @@ func (v *TypeBucketPermission) Scan(val interface{}) error {
 	return nil
 }
 
+// encryption of object data
+const (
+	EncryptionAES256   = "AES256" // data key is wrapped by master key in config
+	EncryptionCustomer = "SSE-C"  // data key is wrapped by key provided by client in each request
+)
+
+// CompressionGzip compression of object data in blocks by gzip
+const CompressionGzip = "gzip"
+
 // Bucket 存储桶结构
 type Bucket struct {
 	ID               uint64               `gorm:"PRIMARY_KEY;AUTO_INCREMENT;not null" json:"id"`
-	Name             string               `gorm:"type:varchar(63);unique_index:uidx_name" json:"name"`
-	User             UserProfile          `gorm:"ForeignKey:UserID;SAVE_ASSOCIATIONS:false" json:"-"` //所属用户
-	UserID           uint                 `gorm:"column:user_id;index:idx_user_id;" json:"user_id"`   //所属用户id
+	Name             string               `gorm:"type:varchar(63);unique_index:uidx_bucket_name" json:"name"`
+	User             UserProfile          `gorm:"ForeignKey:UserID;SAVE_ASSOCIATIONS:false" json:"-"`      //所属用户
+	UserID           uint                 `gorm:"column:user_id;index:idx_bucket_user_id;" json:"user_id"` //所属用户id
 	CreatedTime      TypeJSONTime         `gorm:"column:created_time;type:datetime;" json:"created_time"`
-	CollectionName   string               `gorm:"column:collection_name;type:varchar(50)" json:"-"`                //存储桶对应的表名
-	AccessPermission TypeBucketPermission `gorm:"column:access_permission;type:smallint" json:"access_permission"` //访问权限
-	SoftDelete       bool                 `gorm:"column:soft_delete;" json:"-"`                                    // True->删除状态
-	ModifiedTime     TypeJSONTime         `gorm:"column:modyfied_time;type:datetime;" json:"-"`                    // 修改时间可以指示删除时间
-	ObjsCount        uint32               `gorm:"column:objs_count;" json:"-"`                                     //桶内对象的数量
-	Size             uint64               `gorm:"column:size;" json:"-"`                                           //桶内对象的总大小
-	StatsTime        TypeJSONTime         `gorm:"column:stats_time;type:datetime;" json:"-"`                       //统计时间
+	CollectionName   string               `gorm:"column:collection_name;type:varchar(50)" json:"-"`                           //存储桶对应的表名
+	AccessPermission TypeBucketPermission `gorm:"column:access_permission;type:smallint" json:"access_permission"`            //访问权限
+	SoftDelete       bool                 `gorm:"column:soft_delete;" json:"-"`                                               // True->删除状态
+	ModifiedTime     TypeJSONTime         `gorm:"column:modyfied_time;type:datetime;" json:"-"`                               // 修改时间可以指示删除时间
+	ObjsCount        uint32               `gorm:"column:objs_count;" json:"-"`                                                //桶内对象的数量
+	Size             uint64               `gorm:"column:size;" json:"-"`                                                      //桶内对象的总大小
+	StatsTime        TypeJSONTime         `gorm:"column:stats_time;type:datetime;" json:"-"`                                  //统计时间
+	Encryption       string               `gorm:"column:encryption;type:varchar(16);not null;default:''" json:"encryption"`   // 新对象的加密方式, "AES256"或空(不加密)
+	Compression      string               `gorm:"column:compression;type:varchar(16);not null;default:''" json:"compression"` // 新对象的压缩方式, "gzip"或空(不压缩)
 }
 
 // NewBucketDefault create a bucket initialized with default value
@@ -152,20 +163,26 @@ func (b *Bucket) IsBelongToUser(user *UserProfile) bool {
 // HarborObject 对象结构
 type HarborObject struct {
 	ID               uint64       `gorm:"PRIMARY_KEY;AUTO_INCREMENT;not null" json:"id"`
-	PathName         string       `gorm:"column:na;not null" json:"na"`                                             //全路径文件名或目录名
-	FileOrDir        bool         `gorm:"column:fod;index:idx_fod_did;not null" json:"fod"`                         //True==文件，False==目录
-	ParentID         uint64       `gorm:"column:did;index:idx_fod_did;unique_index:udx_did_name;not null" json:"-"` //父节点id
-	Name             string       `gorm:"type:varchar(255);unique_index:udx_did_name;not null" json:"name"`         //文件名或目录名
-	Size             uint64       `gorm:"column:si;not null" json:"si"`                                             //文件大小, 字节数
-	UploadTime       TypeJSONTime `gorm:"column:ult;not null" json:"ult"`                                           //文件的上传时间，或目录的创建时间
-	UpdateTime       TypeJSONTime `gorm:"column:upt;not null" json:"upt"`                                           //修改时间
-	DownloadCount    uint64       `gorm:"column:dlc;not null" json:"dlc"`                                           //该文件的下载次数，目录时dlc为0
-	IsShared         bool         `gorm:"column:sh;not null" json:"-"`                                              //为True，则文件可共享，为False，则文件不能共享
-	ShareCode        string       `gorm:"column:shp;type:varchar(10);not null" json:"-"`                            //该文件的共享密码，目录时为空
-	IsSharedLimit    bool         `gorm:"column:stl;default:true;not null" json:"-"`                                //True: 文件有共享时间限制; False: 则文件无共享时间限制
-	SharedStartTime  time.Time    `gorm:"column:sst;not null" json:"-"`                                             //该文件的共享起始时间
-	SharedEndTime    time.Time    `gorm:"column:set;not null" json:"-"`                                             //该文件的共享终止时间
-	SoftDeleted      bool         `gorm:"column:sds;not null" json:"-"`                                             //软删除,True->删除状态
+	PathName         string       `gorm:"column:na;not null" json:"na"`                               //全路径文件名或目录名
+	FileOrDir        bool         `gorm:"column:fod;not null" json:"fod"`                             //True==文件，False==目录
+	ParentID         uint64       `gorm:"column:did;not null" json:"-"`                               //父节点id
+	Name             string       `gorm:"type:varchar(255);not null" json:"name"`                     //文件名或目录名
+	Size             uint64       `gorm:"column:si;not null" json:"si"`                               //文件大小, 字节数
+	UploadTime       TypeJSONTime `gorm:"column:ult;not null" json:"ult"`                             //文件的上传时间，或目录的创建时间
+	UpdateTime       TypeJSONTime `gorm:"column:upt;not null" json:"upt"`                             //修改时间
+	DownloadCount    uint64       `gorm:"column:dlc;not null" json:"dlc"`                             //该文件的下载次数，目录时dlc为0
+	IsShared         bool         `gorm:"column:sh;not null" json:"-"`                                //为True，则文件可共享，为False，则文件不能共享
+	ShareCode        string       `gorm:"column:shp;type:varchar(10);not null" json:"-"`              //该文件的共享密码，目录时为空
+	IsSharedLimit    bool         `gorm:"column:stl;default:true;not null" json:"-"`                  //True: 文件有共享时间限制; False: 则文件无共享时间限制
+	SharedStartTime  time.Time    `gorm:"column:sst;not null" json:"-"`                               //该文件的共享起始时间
+	SharedEndTime    time.Time    `gorm:"column:set;not null" json:"-"`                               //该文件的共享终止时间
+	SoftDeleted      bool         `gorm:"column:sds;not null" json:"-"`                               //软删除,True->删除状态
+	Encryption       string       `gorm:"column:enc;type:varchar(16);not null;default:''" json:"enc"` //加密方式, EncryptionAES256, EncryptionCustomer或空(不加密)
+	KeyID            string       `gorm:"column:ekid;type:varchar(64);not null;default:''" json:"-"`  //包装数据密钥的主密钥id, 客户提供密钥时为空
+	DataKey          string       `gorm:"column:edk;type:varchar(128);not null;default:''" json:"-"`  //被包装的数据密钥
+	Compression      string       `gorm:"column:cmp;type:varchar(16);not null;default:''" json:"cmp"` //压缩方式, CompressionGzip或空(不压缩)
+	StoredSize       uint64       `gorm:"column:psi;not null;default:0" json:"psi"`                   //存储的数据大小(压缩或加密后), 字节数
+	BlobID           uint64       `gorm:"column:bid;not null;default:0" json:"-"`                     //去重后共享数据的blob id, 0表示数据由对象独有
 	AccessPermission string       `gorm:"-" json:"access_permission"`
 	DownloadURL      string       `gorm:"-" json:"download_url"`
 }
@@ -236,12 +253,39 @@ func (ho *HarborObject) UpdateUploadTime() {
 	ho.UploadTime = JSONTimeNow()
 }
 
-// GetObjKey return object's identify key
+// GetObjKey return storage key of object's data, the key of its blob if the object is deduplicated
 func (ho *HarborObject) GetObjKey(b *Bucket) string {
 
+	if ho.BlobID != 0 {
+		return BlobKey(ho.BlobID)
+	}
+	return ho.GetOwnObjKey(b)
+}
+
+// GetOwnObjKey return object's identify key "{bucket id}_{object id}", the key of its own data
+func (ho *HarborObject) GetOwnObjKey(b *Bucket) string {
+
 	return fmt.Sprintf("%d_%d", b.ID, ho.ID)
 }
 
+// IsDeduplicated return true if data of object is shared by a blob
+func (ho *HarborObject) IsDeduplicated() bool {
+
+	return ho.BlobID != 0
+}
+
+// IsEncrypted return true if data of object is encrypted
+func (ho *HarborObject) IsEncrypted() bool {
+
+	return ho.Encryption != ""
+}
+
+// IsCompressed return true if data of object is compressed
+func (ho *HarborObject) IsCompressed() bool {
+
+	return ho.Compression != ""
+}
+
 // IsFile return true if it's object, return false if it's dir
 func (ho *HarborObject) IsFile() bool {
 
@@ -0,0 +1,135 @@
+package models_test
+
+import (
+	"harbor/database"
+	"harbor/database/migrate"
+	"harbor/internal/testdb"
+	"harbor/models"
+	"sync"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+var sqliteOnce sync.Once
+
+// setupSQLite init databases "default" and "objs" with in-memory sqlite
+func setupSQLite(t *testing.T) {
+
+	sqliteOnce.Do(func() { testdb.Setup(t) })
+}
+
+func TestSQLiteObjects(t *testing.T) {
+
+	setupSQLite(t)
+	user := &models.UserProfile{ID: 1}
+	bm := models.NewBucketManager("", user)
+
+	var table string
+	for _, name := range []string{"sqlite1", "sqlite2"} {
+		bucket, err := bm.CreateBucketByName(name, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// index names must not conflict between bucket tables
+		if err := bm.CreateObjsTable(bucket); err != nil {
+			t.Fatalf("create table of bucket %s: %v", name, err)
+		}
+		table = bucket.GetObjsTableName()
+		if db := database.GetDB("objs"); !db.Dialect().HasIndex(table, table+"_udx_did_name") {
+			t.Errorf("indexes of migrations should be created for new table %s", table)
+		}
+	}
+	if b, err := bm.GetBucketByName("sqlite1"); err != nil || b == nil {
+		t.Fatalf("bucket should be found, got %v %v", b, err)
+	}
+
+	m := models.NewHarborObjectManager(table, "", "a.txt")
+	obj, created := m.GetObjOrCreat()
+	if !created || obj == nil {
+		t.Fatal("object should be created")
+	}
+
+	obj.Size = 10
+	if err := m.UpdateObjectSize(obj); err != nil {
+		t.Fatal(err)
+	}
+	obj.Size = 5
+	if err := m.UpdateObjectSize(obj); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := m.IncreaseDownloadCount(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found, err := m.GetObjExists()
+	if err != nil || found == nil {
+		t.Fatalf("object should exist, got %v", err)
+	}
+	if found.Size != 10 || found.DownloadCount != 2 {
+		t.Errorf("size should only grow and download count increase, got size %d, dlc %d", found.Size, found.DownloadCount)
+	}
+
+	var count int
+	if err := m.GetDB().Where("fod = ?", true).Count(&count).Error; err != nil || count != 1 {
+		t.Errorf("boolean column should be compared with bool, got %d %v", count, err)
+	}
+
+	// data key is replaced only if it is not changed since read
+	obj.Encryption, obj.KeyID, obj.DataKey = models.EncryptionAES256, "k1", "old"
+	if err := m.SetObjectEncryption(obj); err != nil {
+		t.Fatal(err)
+	}
+	obj.KeyID, obj.DataKey = "k2", "new"
+	if ok, err := m.ReplaceObjectDataKey(obj, "stale"); ok || err != nil {
+		t.Errorf("data key changed since read should not be replaced, got %v %v", ok, err)
+	}
+	if ok, err := m.ReplaceObjectDataKey(obj, "old"); !ok || err != nil {
+		t.Errorf("data key should be replaced, got %v %v", ok, err)
+	}
+	if found, _ := m.GetObjExists(); found.KeyID != "k2" || found.DataKey != "new" {
+		t.Errorf("got key %s %s", found.KeyID, found.DataKey)
+	}
+}
+
+func TestSQLiteAuditLike(t *testing.T) {
+
+	setupSQLite(t)
+	am := models.NewAuditManager()
+	for _, target := range []string{"b_1/x", "bx1/y", "b!1/z"} {
+		if err := am.CreateEvent(models.NewAuditEvent("object.delete", "object", target)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for prefix, want := range map[string]int{"b_1/": 1, "b!1/": 1, "b": 3} {
+		var events []models.AuditEvent
+		if err := am.GetEventsQuery(models.AuditEventFilter{TargetPrefix: prefix}).Find(&events).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != want {
+			t.Errorf("prefix %q should match %d events, got %d", prefix, want, len(events))
+		}
+	}
+}
+
+func TestInitialMigration(t *testing.T) {
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	initial := models.Migrations[0]
+	if err := initial.Up(db); err != nil {
+		t.Fatal(err)
+	}
+	// columns of newer migrations are not created by the initial one
+	table := models.Bucket{}.TableName()
+	if !db.Dialect().HasColumn(table, "name") || db.Dialect().HasColumn(table, "encryption") {
+		t.Errorf("initial schema of %s should not change with the model", table)
+	}
+	if err := initial.Down(db); err != migrate.ErrIrreversible || !db.HasTable(table) {
+		t.Errorf("initial migration should be irreversible, got %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package models_test
+
+import (
+	"harbor/models"
+	"testing"
+)
+
+func TestGetOrCreateToken(t *testing.T) {
+
+	setupSQLite(t)
+	user := &models.UserProfile{ID: 4301}
+	m := models.NewTokenManager(user)
+	tk, created, err := m.GetOrCreateToken()
+	if err != nil || tk == nil || !created {
+		t.Fatalf("token should be created, got %v %v %v", tk, created, err)
+	}
+	got, created, err := m.GetOrCreateToken()
+	if err != nil || created || got.Key != tk.Key {
+		t.Errorf("existing token should be returned, got %v %v %v", got, created, err)
+	}
+
+	if err := m.DeleteToken(tk); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeleteToken(tk); err != nil {
+		t.Errorf("deleting a token not existing should not be an error, got %v", err)
+	}
+	if got, created, err := m.GetOrCreateToken(); err != nil || !created || got.Key == tk.Key {
+		t.Errorf("a new token should be created after delete, got %v %v %v", got, created, err)
+	}
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// RecoveryCodeCount number of recovery codes generated once
+const RecoveryCodeCount = 10
+
+// UserTOTP user's TOTP two-factor authentication device
+type UserTOTP struct {
+	ID            uint         `gorm:"primary_key" json:"-"`
+	UserID        uint         `gorm:"column:user_id;unique_index:uidx_totp_user_id;not null" json:"-"`
+	Secret        string       `gorm:"type:varchar(64);not null" json:"-"`
+	Confirmed     bool         `gorm:"default:false;not null" json:"confirmed"`         // enrollment is confirmed by a valid code
+	LastCounter   int64        `gorm:"column:last_counter;default:0;not null" json:"-"` // time step of the last used code, to prevent replay
+	RecoveryCodes string       `gorm:"column:recovery_codes;type:text" json:"-"`        // sha256 hex of unused recovery codes, separated by ","
+	Created       TypeJSONTime `gorm:"column:created;type:datetime;" json:"created_time"`
+}
+
+// TableName Set UserTOTP's table name
+func (UserTOTP) TableName() string {
+	return "users_totp"
+}
+
+// NewUserTOTP return a not confirmed TOTP device of user
+func NewUserTOTP(user *UserProfile, secret string) *UserTOTP {
+
+	return &UserTOTP{
+		UserID:  user.ID,
+		Secret:  secret,
+		Created: JSONTimeNow(),
+	}
+}
+
+func hashRecoveryCode(code string) string {
+
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateRecoveryCodes generate new recovery codes, old codes are invalid;
+// only hashes are saved, the codes returned should be shown to user once
+func (t *UserTOTP) GenerateRecoveryCodes() []string {
+
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		s := hex.EncodeToString(b)
+		code := s[:5] + "-" + s[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	t.RecoveryCodes = strings.Join(hashes, ",")
+	return codes
+}
+
+// RecoveryCodesLeft return number of unused recovery codes
+func (t UserTOTP) RecoveryCodesLeft() int {
+
+	if t.RecoveryCodes == "" {
+		return 0
+	}
+	return len(strings.Split(t.RecoveryCodes, ","))
+}
+
+// UseRecoveryCode return true and remove the code if it is a unused recovery code
+func (t *UserTOTP) UseRecoveryCode(code string) bool {
+
+	if t.RecoveryCodes == "" {
+		return false
+	}
+	h := hashRecoveryCode(code)
+	hashes := strings.Split(t.RecoveryCodes, ",")
+	for i, hash := range hashes {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(h)) == 1 {
+			hashes = append(hashes[:i], hashes[i+1:]...)
+			t.RecoveryCodes = strings.Join(hashes, ",")
+			return true
+		}
+	}
+	return false
+}
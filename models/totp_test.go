@@ -0,0 +1,76 @@
+package models_test
+
+import (
+	"harbor/models"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryCodes(t *testing.T) {
+
+	device := models.NewUserTOTP(&models.UserProfile{ID: 1}, "JBSWY3DPEHPK3PXP")
+	codes := device.GenerateRecoveryCodes()
+	if len(codes) != models.RecoveryCodeCount || device.RecoveryCodesLeft() != models.RecoveryCodeCount {
+		t.Fatalf("should generate %d recovery codes", models.RecoveryCodeCount)
+	}
+	if strings.Contains(device.RecoveryCodes, codes[0]) {
+		t.Errorf("recovery codes should not be saved in plain text")
+	}
+
+	if !device.UseRecoveryCode(strings.ToUpper(codes[3])) {
+		t.Errorf("recovery code should be accepted case insensitively")
+	}
+	if device.UseRecoveryCode(codes[3]) {
+		t.Errorf("recovery code should be used only once")
+	}
+	if device.RecoveryCodesLeft() != models.RecoveryCodeCount-1 {
+		t.Errorf("used recovery code should be removed")
+	}
+
+	old := codes[0]
+	device.GenerateRecoveryCodes()
+	if device.UseRecoveryCode(old) {
+		t.Errorf("old recovery codes should be invalid after regenerated")
+	}
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+
+	setupSQLite(t)
+	user := &models.UserProfile{ID: 30}
+	m := models.NewTOTPManager(user)
+	device := models.NewUserTOTP(user, "JBSWY3DPEHPK3PXP")
+	device.Confirmed = true
+	codes := device.GenerateRecoveryCodes()
+	if err := m.SaveTOTP(device); err != nil {
+		t.Fatal(err)
+	}
+
+	// two requests read the device before either used a code
+	first, _ := m.GetTOTP()
+	second, _ := m.GetTOTP()
+	if used, err := m.UseRecoveryCode(first, codes[0]); err != nil || !used {
+		t.Fatalf("recovery code should be used, %v", err)
+	}
+	if used, err := m.UseRecoveryCode(second, codes[0]); err != nil || used {
+		t.Fatalf("recovery code should be used only once by concurrent requests, %v", err)
+	}
+	if used, err := m.UseRecoveryCode(second, codes[1]); err != nil || !used {
+		t.Fatalf("other code should be used after codes changed, %v", err)
+	}
+
+	// regenerating codes does not overwrite the used counter
+	if used, _ := m.UseCounter(first, 100); !used {
+		t.Fatal("counter should be used")
+	}
+	second.GenerateRecoveryCodes()
+	if err := m.SaveRecoveryCodes(second); err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := m.UseCounter(second, 100); used {
+		t.Error("used counter should not be reset by saving recovery codes")
+	}
+	if d, _ := m.GetTOTP(); d.RecoveryCodesLeft() != models.RecoveryCodeCount {
+		t.Errorf("got %d recovery codes left", d.RecoveryCodesLeft())
+	}
+}
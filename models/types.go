@@ -4,6 +4,8 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"time"
+
+	"github.com/jinzhu/gorm"
 )
 
 // TypeJSONTime format json time field by myself
@@ -32,6 +34,15 @@ func (t TypeJSONTime) Value() (driver.Value, error) {
 	return t.Time, nil
 }
 
+// GormDataType column type of dialect, postgres has no type datetime
+func (t TypeJSONTime) GormDataType(dialect gorm.Dialect) string {
+
+	if dialect.GetName() == "postgres" {
+		return "timestamp"
+	}
+	return "datetime"
+}
+
 // Scan valueof time.Time
 func (t *TypeJSONTime) Scan(v interface{}) error {
 	value, ok := v.(time.Time)
@@ -39,5 +50,10 @@ func (t *TypeJSONTime) Scan(v interface{}) error {
 		*t = TypeJSONTime{Time: value}
 		return nil
 	}
+	// NULL
+	if v == nil {
+		*t = TypeJSONTime{}
+		return nil
+	}
 	return fmt.Errorf("can not convert %v to timestamp", v)
 }
@@ -1,7 +1,10 @@
 package models
 
 import (
+	"errors"
+	"fmt"
 	"harbor/utils/auth"
+	"strings"
 	"time"
 )
 
@@ -46,6 +49,9 @@ type UserProfile struct {
 	SecretKey   string       `gorm:"type:varchar(20)" json:"-"`
 	LastActive  TypeJSONTime `gorm:"index;type:date"  json:"-"`
 	Role        int16        `gorm:"type:smallint" json:"-"`
+	// email is verified, or the user is activated otherwise; only users not verified are activated by verification
+	EmailVerified bool   `gorm:"column:email_verified;default:false;not null" json:"-"`
+	OIDCSubject   string `gorm:"column:oidc_sub;type:varchar(255);index:idx_user_oidc_sub" json:"-"` // subject of the user at the OIDC IdP
 }
 
 // TableName Set UserProfile's table name
@@ -72,6 +78,19 @@ func (u UserProfile) CheckPassword(pw string) bool {
 	return auth.CheckPassword(pw, u.Password)
 }
 
+// PasswordMustUpdate return true if the password hash use an old algorithm or weaker cost parameters,
+// it should be rehashed by SetPassword after the password is checked
+func (u UserProfile) PasswordMustUpdate() bool {
+
+	return auth.MustUpdate(u.Password)
+}
+
+// HasUsablePassword return false if the user can not login by a local password, e.g. created by LDAP or OIDC
+func (u UserProfile) HasUsablePassword() bool {
+
+	return auth.IsPasswordUsable(u.Password)
+}
+
 // SetPassword set new password
 func (u *UserProfile) SetPassword(pw string) {
 
@@ -172,3 +191,58 @@ func (u *UserProfile) AddRole(role TypeRole) {
 	u.Role = u.Role | role.Value()
 	return
 }
+
+// roleNames names of roles, used by command line and configs
+var roleNames = []struct {
+	name string
+	role TypeRole
+}{
+	{"superuser", RoleSuperUser},
+	{"app_superuser", RoleAppSuperUser},
+	{"staff", RoleStaff},
+}
+
+// ParseRoles return role of comma separated role names, e.g. "superuser,staff";
+// "normal" means no role and can not be combined with others
+func ParseRoles(names string) (TypeRole, error) {
+
+	role := RoleNormal
+	normal := false
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "normal" {
+			normal = true
+			continue
+		}
+		found := false
+		for _, r := range roleNames {
+			if r.name == name {
+				role |= r.role
+				found = true
+				break
+			}
+		}
+		if !found {
+			return RoleNormal, fmt.Errorf("unknown role '%s'", name)
+		}
+	}
+	if normal && role != RoleNormal {
+		return RoleNormal, errors.New("role 'normal' can not be combined with other roles")
+	}
+	return role, nil
+}
+
+// RoleNames return names of user's roles, ["normal"] if user has no role
+func (u UserProfile) RoleNames() []string {
+
+	var names []string
+	for _, r := range roleNames {
+		if (r.role == RoleSuperUser && u.IsSuper()) || (r.role == RoleStaff && u.IsStaffUser()) || u.IsRole(r.role) {
+			names = append(names, r.name)
+		}
+	}
+	if len(names) == 0 {
+		return []string{"normal"}
+	}
+	return names
+}
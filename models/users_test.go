@@ -47,3 +47,36 @@ func TestRole(t *testing.T) {
 	}
 	t.Log("IsRole test ok")
 }
+
+func TestParseRoles(t *testing.T) {
+
+	cases := []struct {
+		names string
+		role  models.TypeRole
+		err   bool
+	}{
+		{"normal", models.RoleNormal, false},
+		{"superuser", models.RoleSuperUser, false},
+		{"Staff, superuser", models.RoleStaffSuperUser, false},
+		{"app_superuser", models.RoleAppSuperUser, false},
+		{"normal,staff", models.RoleNormal, true},
+		{"admin", models.RoleNormal, true},
+		{"", models.RoleNormal, true},
+	}
+	for _, c := range cases {
+		role, err := models.ParseRoles(c.names)
+		if (err != nil) != c.err || role != c.role {
+			t.Errorf("ParseRoles(%q) = %v, %v", c.names, role, err)
+		}
+	}
+
+	user := models.UserProfile{}
+	user.SetRole(models.RoleStaffSuperUser)
+	if names := user.RoleNames(); len(names) != 2 || names[0] != "superuser" || names[1] != "staff" {
+		t.Errorf("RoleNames() = %v", names)
+	}
+	user.SetRole(models.RoleNormal)
+	if names := user.RoleNames(); len(names) != 1 || names[0] != "normal" {
+		t.Errorf("RoleNames() = %v", names)
+	}
+}
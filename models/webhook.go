@@ -0,0 +1,96 @@
+package models
+
+import (
+	"strings"
+)
+
+// webhook delivery status
+const (
+	DeliveryPending  = "pending"  // waiting for the first attempt
+	DeliveryRetrying = "retrying" // failed, waiting for the next attempt
+	DeliverySuccess  = "success"
+	DeliveryDead     = "dead" // failed after max attempts
+)
+
+// Webhook a endpoint notified when events happen in bucket
+type Webhook struct {
+	ID          uint64       `gorm:"PRIMARY_KEY;AUTO_INCREMENT;not null" json:"id"`
+	BucketID    uint64       `gorm:"column:bucket_id;index:idx_webhook_bucket_id" json:"bucket_id"`
+	UserID      uint         `gorm:"column:user_id" json:"user_id"` // creator
+	URL         string       `gorm:"column:url;type:varchar(2048)" json:"url"`
+	Secret      string       `gorm:"column:secret;type:varchar(128)" json:"-"` // HMAC key of signature
+	EventsStr   string       `gorm:"column:events;type:varchar(255)" json:"-"` // comma separated event types, empty for all
+	Events      []string     `gorm:"-" json:"events"`
+	Prefix      string       `gorm:"column:prefix;type:varchar(1024)" json:"prefix"` // object path prefix, empty for all
+	IsActive    bool         `gorm:"column:is_active" json:"is_active"`
+	CreatedTime TypeJSONTime `gorm:"column:created_time;type:datetime" json:"created_time"`
+}
+
+// TableName Set Webhook's table name
+func (Webhook) TableName() string {
+	return "webhooks"
+}
+
+// BeforeSave encode events, called by gorm
+func (h *Webhook) BeforeSave() error {
+
+	h.EventsStr = strings.Join(h.Events, ",")
+	return nil
+}
+
+// AfterFind decode events, called by gorm
+func (h *Webhook) AfterFind() error {
+
+	h.Events = []string{}
+	if h.EventsStr != "" {
+		h.Events = strings.Split(h.EventsStr, ",")
+	}
+	return nil
+}
+
+// Match return true if the webhook subscribes the event of object path,
+// path is ignored for bucket events
+func (h *Webhook) Match(eventType, path string) bool {
+
+	if !h.IsActive {
+		return false
+	}
+	if len(h.Events) > 0 {
+		found := false
+		for _, e := range h.Events {
+			if e == eventType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if h.Prefix == "" || strings.HasPrefix(eventType, "bucket.") {
+		return true
+	}
+	return strings.HasPrefix(strings.TrimPrefix(path, "/"), strings.TrimPrefix(h.Prefix, "/"))
+}
+
+// WebhookDelivery a event sent or to be sent to webhook, dead ones are kept as dead letters
+type WebhookDelivery struct {
+	ID           uint64       `gorm:"PRIMARY_KEY;AUTO_INCREMENT;not null" json:"id"`
+	WebhookID    uint64       `gorm:"column:webhook_id;index:idx_delivery_webhook_id" json:"webhook_id"`
+	EventID      string       `gorm:"column:event_id;type:varchar(64)" json:"event_id"`
+	EventType    string       `gorm:"column:event_type;type:varchar(32)" json:"event_type"`
+	Payload      string       `gorm:"column:payload;type:text" json:"payload"`
+	Status       string       `gorm:"column:status;type:varchar(16);index:idx_delivery_status" json:"status"`
+	Attempts     int          `gorm:"column:attempts" json:"attempts"`
+	ResponseCode int          `gorm:"column:response_code" json:"response_code"`
+	Error        string       `gorm:"column:error;type:varchar(1024)" json:"error"`
+	DurationMs   int64        `gorm:"column:duration_ms" json:"duration_ms"` // duration of last attempt
+	CreatedTime  TypeJSONTime `gorm:"column:created_time;type:datetime" json:"created_time"`
+	LastAttempt  TypeJSONTime `gorm:"column:last_attempt;type:datetime" json:"last_attempt"`
+	NextAttempt  TypeJSONTime `gorm:"column:next_attempt;type:datetime" json:"next_attempt"`
+}
+
+// TableName Set WebhookDelivery's table name
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
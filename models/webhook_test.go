@@ -0,0 +1,44 @@
+package models_test
+
+import (
+	"harbor/models"
+	"testing"
+)
+
+func TestWebhookMatch(t *testing.T) {
+
+	hook := &models.Webhook{EventsStr: "object.created,bucket.deleted", Prefix: "/data/raw", IsActive: true}
+	hook.AfterFind()
+
+	cases := []struct {
+		event, path string
+		want        bool
+	}{
+		{"object.created", "data/raw/a.txt", true},
+		{"object.created", "/data/raw2/a.txt", true},
+		{"object.created", "data/a.txt", false},
+		{"object.deleted", "data/raw/a.txt", false},
+		{"bucket.deleted", "", true},
+	}
+	for _, c := range cases {
+		if got := hook.Match(c.event, c.path); got != c.want {
+			t.Errorf("Match(%q, %q) should be %v", c.event, c.path, c.want)
+		}
+	}
+
+	all := &models.Webhook{IsActive: true}
+	all.AfterFind()
+	if !all.Match("object.moved", "x/y") {
+		t.Errorf("webhook without events and prefix should match all")
+	}
+	all.IsActive = false
+	if all.Match("object.moved", "x/y") {
+		t.Errorf("inactive webhook should not match")
+	}
+
+	hook.Events = []string{"object.shared"}
+	hook.BeforeSave()
+	if hook.EventsStr != "object.shared" {
+		t.Errorf("events should be encoded, got %q", hook.EventsStr)
+	}
+}
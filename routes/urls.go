@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"harbor/config"
 	ctls "harbor/controllers"
 	"harbor/middlewares"
 
@@ -12,15 +13,37 @@ func Urls(ng *gin.Engine) {
 
 	jwtAuth, err := middlewares.JWTAuthMiddleware()
 	if err != nil {
-		panic("JWT Error: jwt middleware")
+		panic("JWT Error: jwt middleware, " + err.Error())
 	}
 
+	// shared by groups, limits are counted across them
+	rateLimit := middlewares.RateLimitMiddleware()
+
 	ng.GET("/docs/", ctls.Docs)
+	ng.GET("/healthz", ctls.Healthz)
+	ng.GET("/readyz", ctls.Readyz)
+	if c := config.GetConfigs().Metrics; !c.Disabled {
+		path := c.Path
+		if path == "" {
+			path = "/metrics"
+		}
+		ng.GET(path, middlewares.MetricsHandler())
+	}
 	ng.GET("/user/register/", ctls.UserRegister)
 	ng.POST("/user/register/", ctls.UserRegister)
+	ng.GET("/user/verify-email/", ctls.UserVerifyEmail)
+	ng.GET("/user/password-reset/", ctls.UserPasswordReset)
+	ng.POST("/user/password-reset/", ctls.UserPasswordReset)
 	ng.POST("/api/v1/jwt-token/", jwtAuth.LoginHandler)
 	ng.POST("/api/v1/jwt-token-refresh/", jwtAuth.RefreshHandler)
-	v1 := ng.Group("/api/v1", jwtAuth.MiddlewareFunc(),middlewares.AuthTokenMiddlewareFunc())
+	ng.GET("/.well-known/jwks.json", jwtAuth.JWKSHandler)
+	if config.GetConfigs().OIDC.Enabled {
+		oidcCtl := ctls.NewOIDCController(jwtAuth.TokenGenerator)
+		ng.GET("/oidc/login/", oidcCtl.Login)
+		ng.GET("/oidc/callback/", oidcCtl.Callback)
+		ng.POST("/oidc/2fa/", oidcCtl.SecondFactor)
+	}
+	v1 := ng.Group("/api/v1", jwtAuth.MiddlewareFunc(),middlewares.AuthTokenMiddlewareFunc(), rateLimit)
 	{
 		v1.Any("/users/", ctls.NewUserController().Init().Dispatch)
 		v1.Any("/users/:id/", ctls.NewUserDetailController().Init().Dispatch)
@@ -30,9 +53,22 @@ func Urls(ng *gin.Engine) {
 		v1.Any("/dir/:bucketname/*dirpath", ctls.NewDirController().Init().Dispatch)
 		v1.Any("/metadata/:bucketname/*path", ctls.NewMetadataController().Init().Dispatch)
 		v1.Any("/move/:bucketname/*objpath", ctls.NewMoveController().Init().Dispatch)
+		v1.Any("/copy/:bucketname/*objpath", ctls.NewCopyController().Init().Dispatch)
 		v1.Any("/auth-token/", ctls.NewTokenController().Init().Dispatch)
+		v1.Any("/2fa/", ctls.NewTwoFactorController().Init().Dispatch)
+		v1.Any("/verify-email/", ctls.NewVerifyEmailController().Init().Dispatch)
+		v1.Any("/password-reset/", ctls.NewPasswordResetController().Init().Dispatch)
+		v1.Any("/password-reset/confirm/", ctls.NewPasswordResetConfirmController().Init().Dispatch)
+		v1.Any("/lockouts/", ctls.NewLockoutController().Init().Dispatch)
+		v1.Any("/audit-events/", ctls.NewAuditEventController().Init().Dispatch)
+		v1.Any("/webhooks/", ctls.NewWebhookController().Init().Dispatch)
+		v1.Any("/webhooks/:id/", ctls.NewWebhookDetailController().Init().Dispatch)
+		v1.Any("/webhooks/:id/deliveries/", ctls.NewWebhookDeliveryController().Init().Dispatch)
+		v1.Any("/diagnostics/", ctls.NewDiagnosticsController().Init().Dispatch)
+		v1.Any("/gc/", ctls.NewGCController().Init().Dispatch)
+		v1.Any("/dedup/", ctls.NewDedupController().Init().Dispatch)
 	}
-	obs := ng.Group("obs", jwtAuth.MiddlewareFunc())
+	obs := ng.Group("obs", jwtAuth.MiddlewareFunc(), rateLimit)
 	{
 		obs.GET("/:bucketname/*objpath", ctls.NewDownloadController().Init().Dispatch)
 	}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2PasswordHasher Secure password hashing using the argon2id algorithm
+// encoded format is the same as django: argon2$argon2id$v=19$m=65536,t=3,p=4$salt$hash
+type Argon2PasswordHasher struct {
+	algorithm   string
+	time        uint32
+	memory      uint32 // KiB
+	parallelism uint8
+	keyLen      uint32
+}
+
+// NewArgon2PasswordHasher return *Argon2PasswordHasher, zero value param means default
+func NewArgon2PasswordHasher(time, memory uint32, parallelism uint8) *Argon2PasswordHasher {
+
+	if time == 0 {
+		time = 3
+	}
+	if memory == 0 {
+		memory = 64 * 1024
+	}
+	if parallelism == 0 {
+		parallelism = 4
+	}
+	return &Argon2PasswordHasher{
+		algorithm:   "argon2",
+		time:        time,
+		memory:      memory,
+		parallelism: parallelism,
+		keyLen:      32,
+	}
+}
+
+// Algorithm return "argon2"
+func (hasher Argon2PasswordHasher) Algorithm() string {
+
+	return hasher.algorithm
+}
+
+// Hash return encoded string with a new salt
+func (hasher Argon2PasswordHasher) Hash(password string) string {
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	key := argon2.IDKey([]byte(password), salt, hasher.time, hasher.memory, hasher.parallelism, hasher.keyLen)
+	return fmt.Sprintf("%s$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", hasher.algorithm, argon2.Version,
+		hasher.memory, hasher.time, hasher.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// argon2Params params decoded from encoded string
+type argon2Params struct {
+	variant     string
+	version     int
+	time        uint32
+	memory      uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+// decode parse encoded string
+func (hasher Argon2PasswordHasher) decode(encoded string) (*argon2Params, error) {
+
+	a := strings.Split(encoded, "$")
+	if len(a) != 6 || a[0] != hasher.algorithm {
+		return nil, fmt.Errorf("invalid argon2 encoded password")
+	}
+
+	p := &argon2Params{variant: a[1]}
+	if _, err := fmt.Sscanf(a[2], "v=%d", &p.version); err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Sscanf(a[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.parallelism); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(a[4]); err != nil {
+		return nil, err
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(a[5]); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Verify Check if the given password is correct
+func (hasher Argon2PasswordHasher) Verify(password, encoded string) bool {
+
+	p, err := hasher.decode(encoded)
+	if err != nil || p.version != argon2.Version || len(p.key) == 0 {
+		return false
+	}
+
+	var key []byte
+	switch p.variant {
+	case "argon2id":
+		key = argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.parallelism, uint32(len(p.key)))
+	case "argon2i":
+		key = argon2.Key([]byte(password), p.salt, p.time, p.memory, p.parallelism, uint32(len(p.key)))
+	default:
+		return false
+	}
+	return constantTimeCompare(string(key), string(p.key))
+}
+
+// MustUpdate return true if variant is not argon2id or any cost parameter is lower than the current,
+// passwords hashed with higher cost are kept after the config is lowered
+func (hasher Argon2PasswordHasher) MustUpdate(encoded string) bool {
+
+	p, err := hasher.decode(encoded)
+	if err != nil {
+		return false
+	}
+	return p.variant != "argon2id" || p.version != argon2.Version || p.time < hasher.time ||
+		p.memory < hasher.memory || p.parallelism < hasher.parallelism
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BCryptPasswordHasher Secure password hashing using the bcrypt algorithm
+// encoded format is the same as django: bcrypt$$2a$12$...
+// bcrypt only use the first 72 bytes of password, so BCryptSHA256PasswordHasher is recommended
+type BCryptPasswordHasher struct {
+	algorithm string
+	cost      int
+	digest    bool // hash password with sha256 first
+}
+
+// NewBCryptPasswordHasher return *BCryptPasswordHasher, zero cost means default 12
+func NewBCryptPasswordHasher(cost int) *BCryptPasswordHasher {
+
+	if cost <= 0 {
+		cost = 12
+	}
+	return &BCryptPasswordHasher{
+		algorithm: "bcrypt",
+		cost:      cost,
+	}
+}
+
+// NewBCryptSHA256PasswordHasher return a bcrypt hasher that hash password with sha256 first,
+// encoded format is the same as django: bcrypt_sha256$$2a$12$...
+func NewBCryptSHA256PasswordHasher(cost int) *BCryptPasswordHasher {
+
+	hasher := NewBCryptPasswordHasher(cost)
+	hasher.algorithm = "bcrypt_sha256"
+	hasher.digest = true
+	return hasher
+}
+
+// Algorithm return "bcrypt" or "bcrypt_sha256"
+func (hasher BCryptPasswordHasher) Algorithm() string {
+
+	return hasher.algorithm
+}
+
+func (hasher BCryptPasswordHasher) password(password string) []byte {
+
+	if hasher.digest {
+		sum := sha256.Sum256([]byte(password))
+		return []byte(hex.EncodeToString(sum[:]))
+	}
+	return []byte(password)
+}
+
+// data return bcrypt hash in encoded string
+func (hasher BCryptPasswordHasher) data(encoded string) (string, bool) {
+
+	prefix := hasher.algorithm + "$"
+	if !strings.HasPrefix(encoded, prefix) {
+		return "", false
+	}
+	return encoded[len(prefix):], true
+}
+
+// Hash return encoded string with a new salt
+func (hasher BCryptPasswordHasher) Hash(password string) string {
+
+	data, err := bcrypt.GenerateFromPassword(hasher.password(password), hasher.cost)
+	if err != nil {
+		return ""
+	}
+	return hasher.algorithm + "$" + string(data)
+}
+
+// Verify Check if the given password is correct
+func (hasher BCryptPasswordHasher) Verify(password, encoded string) bool {
+
+	data, ok := hasher.data(encoded)
+	if !ok {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(data), hasher.password(password)) == nil
+}
+
+// MustUpdate return true if cost of encoded is lower than the current cost
+func (hasher BCryptPasswordHasher) MustUpdate(encoded string) bool {
+
+	data, ok := hasher.data(encoded)
+	if !ok {
+		return false
+	}
+	cost, err := bcrypt.Cost([]byte(data))
+	if err != nil {
+		return false
+	}
+	return cost < hasher.cost
+}
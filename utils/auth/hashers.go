@@ -2,14 +2,13 @@ package auth
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
 	"hash"
-	"math/rand"
 	"strconv"
 	"strings"
-	"time"
 
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -21,8 +20,93 @@ const (
 	UnuablePasswordPrefixLength = 40
 )
 
-// Return True if this password wasn't generated by make_password("")
-func isPasswordUsable(encoded string) bool {
+// PasswordHasher hash and verify password, the encoded string is prefixed by "{algorithm}$"
+type PasswordHasher interface {
+	// Algorithm return the algorithm name, the prefix of encoded string
+	Algorithm() string
+	// Hash return encoded string of password with a new random salt and current cost parameters
+	Hash(password string) string
+	// Verify check if the given password is correct
+	Verify(password, encoded string) bool
+	// MustUpdate return true if the encoded string use weaker cost parameters than current
+	MustUpdate(encoded string) bool
+}
+
+var (
+	hashers         = map[string]PasswordHasher{}
+	preferredHasher PasswordHasher
+)
+
+func init() {
+
+	ConfigureHashers("", HasherOptions{})
+}
+
+// HasherOptions cost parameters of hashers, zero value means default
+type HasherOptions struct {
+	PBKDF2Iterations  int
+	Argon2Time        uint32
+	Argon2Memory      uint32 // KiB
+	Argon2Parallelism uint8
+	BcryptCost        int
+}
+
+// ConfigureHashers register all hashers with options, and set the preferred hasher used to hash new password
+// preferred: pbkdf2_sha256(default), argon2, bcrypt_sha256
+func ConfigureHashers(preferred string, opts HasherOptions) error {
+
+	pbkdf2Hasher := NewPBKDF2PasswordHasher()
+	if opts.PBKDF2Iterations > 0 {
+		pbkdf2Hasher.iterations = opts.PBKDF2Iterations
+	}
+	all := []PasswordHasher{
+		pbkdf2Hasher,
+		NewArgon2PasswordHasher(opts.Argon2Time, opts.Argon2Memory, opts.Argon2Parallelism),
+		NewBCryptSHA256PasswordHasher(opts.BcryptCost),
+		NewBCryptPasswordHasher(opts.BcryptCost),
+	}
+
+	m := map[string]PasswordHasher{}
+	for _, h := range all {
+		m[h.Algorithm()] = h
+	}
+	if preferred == "" {
+		preferred = pbkdf2Hasher.Algorithm()
+	}
+	h, ok := m[preferred]
+	if !ok {
+		return fmt.Errorf("unknown password hasher '%s'", preferred)
+	}
+
+	hashers = m
+	preferredHasher = h
+	return nil
+}
+
+// GetHasher return hasher by algorithm, nil if not found
+func GetHasher(algorithm string) PasswordHasher {
+
+	return hashers[algorithm]
+}
+
+// identifyHasher return the hasher used to encode, by the algorithm prefix
+func identifyHasher(encoded string) PasswordHasher {
+
+	i := strings.Index(encoded, "$")
+	if i <= 0 {
+		return nil
+	}
+	return GetHasher(encoded[:i])
+}
+
+// IsPasswordUsable return false if encoded is made by MakePassword(""), or is empty, no password matches it
+func IsPasswordUsable(encoded string) bool {
+
+	return !isPasswordUnusable(encoded)
+}
+
+// Return True if this password was generated by make_password(""), or is empty
+func isPasswordUnusable(encoded string) bool {
 
 	return encoded == "" || strings.HasPrefix(encoded, UnuablePasswordPrefix)
 }
@@ -30,12 +114,14 @@ func isPasswordUsable(encoded string) bool {
 func getRandomString(length int) string {
 
 	allowedChars := []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
-	s := []byte{}
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	for i := 0; i < length; i++ {
-		s = append(s, allowedChars[r.Intn(len(allowedChars))])
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
 	}
-	return string(s)
+	for i := range b {
+		b[i] = allowedChars[int(b[i])%len(allowedChars)]
+	}
+	return string(b)
 }
 
 func constantTimeCompare(val1, val2 string) bool {
@@ -43,29 +129,49 @@ func constantTimeCompare(val1, val2 string) bool {
 	return hmac.Equal([]byte(val1), []byte(val2))
 }
 
-// MakePassword return the password encrypted by hash
+// MakePassword return the password encrypted by the preferred hasher
 func MakePassword(rawPassword string) string {
 
 	if rawPassword == "" {
 		return UnuablePasswordPrefix + getRandomString(UnuablePasswordPrefixLength)
 	}
 
-	hasher := NewPBKDF2PasswordHasher()
-	salt := hasher.Salt()
-	return hasher.Encode(rawPassword, salt, 0)
+	return preferredHasher.Hash(rawPassword)
 }
 
-// CheckPassword Return a boolean of whether the raw password matches the three part encoded digest
+// CheckPassword Return a boolean of whether the raw password matches the encoded digest,
+// the hasher is identified by the algorithm prefix of encoded
 func CheckPassword(rawPassword, encoded string) bool {
 
-	if isPasswordUsable(encoded) {
+	if isPasswordUnusable(encoded) {
 		return false
 	}
 
-	hasher := NewPBKDF2PasswordHasher()
+	hasher := identifyHasher(encoded)
+	if hasher == nil {
+		return false
+	}
 	return hasher.Verify(rawPassword, encoded)
 }
 
+// MustUpdate return true if the encoded password is not encoded by the preferred hasher or
+// use weaker cost parameters, it should be rehashed after the password is checked
+func MustUpdate(encoded string) bool {
+
+	if isPasswordUnusable(encoded) {
+		return false
+	}
+
+	hasher := identifyHasher(encoded)
+	if hasher == nil {
+		return false
+	}
+	if hasher.Algorithm() != preferredHasher.Algorithm() {
+		return true
+	}
+	return hasher.MustUpdate(encoded)
+}
+
 // PBKDF2PasswordHasher Secure password hashing using the PBKDF2 algorithm
 // PBKDF2 + HMAC + SHA256
 type PBKDF2PasswordHasher struct {
@@ -84,6 +190,18 @@ func NewPBKDF2PasswordHasher() *PBKDF2PasswordHasher {
 	}
 }
 
+// Algorithm return "pbkdf2_sha256"
+func (hasher PBKDF2PasswordHasher) Algorithm() string {
+
+	return hasher.algorithm
+}
+
+// Hash return encoded string with a new salt
+func (hasher PBKDF2PasswordHasher) Hash(password string) string {
+
+	return hasher.Encode(password, hasher.Salt(), 0)
+}
+
 // Salt Generate a cryptographically secure nonce salt in ASCII
 func (hasher PBKDF2PasswordHasher) Salt() string {
 
@@ -145,12 +263,16 @@ func (hasher PBKDF2PasswordHasher) Verify(password, encoded string) bool {
 //             (_('hash'), mask_hash(hash)),
 //         ])
 
-//     def must_update(self, encoded):
-//         algorithm, iterations, salt, hash = encoded.split('$', 3)
-//         return int(iterations) != self.iterations
+// MustUpdate return true if iterations of encoded is less than the current iterations
+func (hasher PBKDF2PasswordHasher) MustUpdate(encoded string) bool {
 
-//     def harden_runtime(self, password, encoded):
-//         algorithm, iterations, salt, hash = encoded.split('$', 3)
-//         extra_iterations = self.iterations - int(iterations)
-//         if extra_iterations > 0:
-//             self.encode(password, salt, extra_iterations)
+	a := strings.SplitN(encoded, "$", 4)
+	if len(a) != 4 {
+		return false
+	}
+	iterations, err := strconv.Atoi(a[1])
+	if err != nil {
+		return false
+	}
+	return iterations < hasher.iterations
+}
@@ -0,0 +1,97 @@
+package auth_test
+
+import (
+	"harbor/utils/auth"
+	"strings"
+	"testing"
+)
+
+// cheap cost parameters for test
+var testOptions = auth.HasherOptions{
+	PBKDF2Iterations:  1000,
+	Argon2Time:        1,
+	Argon2Memory:      1024,
+	Argon2Parallelism: 1,
+	BcryptCost:        4,
+}
+
+func TestHashers(t *testing.T) {
+
+	defer auth.ConfigureHashers("", auth.HasherOptions{})
+
+	for _, algorithm := range []string{"pbkdf2_sha256", "argon2", "bcrypt_sha256", "bcrypt"} {
+		if err := auth.ConfigureHashers(algorithm, testOptions); err != nil {
+			t.Fatal(err)
+		}
+		encoded := auth.MakePassword("lètmein")
+		if !strings.HasPrefix(encoded, algorithm+"$") {
+			t.Errorf("%s: bad encoded %s", algorithm, encoded)
+		}
+		if !auth.CheckPassword("lètmein", encoded) {
+			t.Errorf("%s: password should be correct", algorithm)
+		}
+		if auth.CheckPassword("letmein", encoded) {
+			t.Errorf("%s: password should be wrong", algorithm)
+		}
+		if auth.MustUpdate(encoded) {
+			t.Errorf("%s: password hashed by preferred hasher should not be updated", algorithm)
+		}
+	}
+
+	if err := auth.ConfigureHashers("md5", testOptions); err == nil {
+		t.Errorf("unknown hasher should be rejected")
+	}
+	if auth.CheckPassword("", auth.MakePassword("")) {
+		t.Errorf("unusable password should never be correct")
+	}
+}
+
+func TestHasherMustUpdate(t *testing.T) {
+
+	defer auth.ConfigureHashers("", auth.HasherOptions{})
+
+	auth.ConfigureHashers("pbkdf2_sha256", testOptions)
+	old := auth.MakePassword("secret")
+
+	// preferred hasher changed, old hash still works but must be updated
+	auth.ConfigureHashers("argon2", testOptions)
+	if !auth.CheckPassword("secret", old) || !auth.MustUpdate(old) {
+		t.Errorf("pbkdf2 password should be checked and updated when argon2 is preferred")
+	}
+
+	// cost parameters changed
+	encoded := auth.MakePassword("secret")
+	stronger := testOptions
+	stronger.Argon2Time = 2
+	auth.ConfigureHashers("argon2", stronger)
+	if !auth.CheckPassword("secret", encoded) || !auth.MustUpdate(encoded) {
+		t.Errorf("argon2 password should be updated when time cost changed")
+	}
+
+	// stronger hash is not downgraded after cost is lowered
+	encoded = auth.MakePassword("secret")
+	auth.ConfigureHashers("argon2", testOptions)
+	if auth.MustUpdate(encoded) {
+		t.Errorf("argon2 password should not be updated when time cost lowered")
+	}
+
+	auth.ConfigureHashers("bcrypt_sha256", testOptions)
+	encoded = auth.MakePassword("secret")
+	stronger.BcryptCost = 5
+	auth.ConfigureHashers("bcrypt_sha256", stronger)
+	if !auth.MustUpdate(encoded) {
+		t.Errorf("bcrypt password should be updated when cost changed")
+	}
+}
+
+func TestDjangoCompatible(t *testing.T) {
+
+	// django make_password("lètmein", "seasalt", "pbkdf2_sha256") with 1000 iterations
+	encoded := "pbkdf2_sha256$1000$seasalt$JgZryXe2Ga8ysg6XbzkLpTdyPQrHqsinbL9BnnhgX4A="
+	if !auth.CheckPassword("lètmein", encoded) {
+		t.Errorf("django pbkdf2 password should be correct")
+	}
+	if !auth.MustUpdate(encoded) {
+		t.Errorf("pbkdf2 password with other iterations should be updated")
+	}
+}
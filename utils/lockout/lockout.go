@@ -0,0 +1,161 @@
+// Package lockout track failed login attempts by key (username, ip),
+// keys failed too many times are locked with exponential backoff
+package lockout
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Policy lockout policy of a kind of key
+type Policy struct {
+	FreeAttempts int           // failures allowed before lockout
+	BaseDelay    time.Duration // lockout duration after the first failure over FreeAttempts, doubled for each failure after
+	MaxDelay     time.Duration // max lockout duration
+	ResetAfter   time.Duration // failures are forgotten if no failure in this duration
+}
+
+// delay return lockout duration after failures
+func (p Policy) delay(failures int) time.Duration {
+
+	n := failures - p.FreeAttempts
+	if n <= 0 {
+		return 0
+	}
+	d := p.BaseDelay
+	for i := 1; i < n && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// Record failed attempts of a key
+type Record struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// Tracker track failed attempts, safe for concurrent use
+type Tracker struct {
+	policy  Policy
+	mu      sync.Mutex
+	records map[string]*Record
+	now     func() time.Time
+	fails   int // failures since last prune
+}
+
+// NewTracker return a tracker with policy
+func NewTracker(policy Policy) *Tracker {
+
+	if policy.ResetAfter <= 0 {
+		policy.ResetAfter = time.Hour
+	}
+	return &Tracker{
+		policy:  policy,
+		records: map[string]*Record{},
+		now:     time.Now,
+	}
+}
+
+// SetClock replace the clock, used in tests
+func (t *Tracker) SetClock(now func() time.Time) {
+	t.now = now
+}
+
+// get return the record of key, nil if not exists or expired; lock must be held
+func (t *Tracker) get(key string, now time.Time) *Record {
+
+	r, ok := t.records[key]
+	if !ok {
+		return nil
+	}
+	if now.Sub(r.LastFailure) > t.policy.ResetAfter && !now.Before(r.LockedUntil) {
+		delete(t.records, key)
+		return nil
+	}
+	return r
+}
+
+// RetryAfter return the remaining lockout duration of key, 0 if not locked
+func (t *Tracker) RetryAfter(key string) time.Duration {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	r := t.get(key, now)
+	if r == nil || !now.Before(r.LockedUntil) {
+		return 0
+	}
+	return r.LockedUntil.Sub(now)
+}
+
+// Fail record a failed attempt of key, return the lockout duration
+func (t *Tracker) Fail(key string) time.Duration {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.fails++
+	if t.fails >= 1000 {
+		t.prune(now)
+	}
+
+	r := t.get(key, now)
+	if r == nil {
+		r = &Record{Key: key}
+		t.records[key] = r
+	}
+	r.Failures++
+	r.LastFailure = now
+	d := t.policy.delay(r.Failures)
+	if d > 0 {
+		r.LockedUntil = now.Add(d)
+	}
+	return d
+}
+
+// Reset forget failed attempts of key, return false if key has no record
+func (t *Tracker) Reset(key string) bool {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.records[key]
+	delete(t.records, key)
+	return ok
+}
+
+// Locked return records of keys locked now, sorted by key
+func (t *Tracker) Locked() []Record {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.prune(now)
+	records := []Record{}
+	for _, r := range t.records {
+		if now.Before(r.LockedUntil) {
+			records = append(records, *r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	return records
+}
+
+// prune remove expired records; lock must be held
+func (t *Tracker) prune(now time.Time) {
+
+	t.fails = 0
+	for key := range t.records {
+		t.get(key, now)
+	}
+}
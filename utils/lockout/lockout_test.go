@@ -0,0 +1,66 @@
+package lockout_test
+
+import (
+	"harbor/utils/lockout"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	tr := lockout.NewTracker(lockout.Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Second,
+		ResetAfter:   time.Minute,
+	})
+	tr.SetClock(func() time.Time { return now })
+
+	for i := 0; i < 3; i++ {
+		if d := tr.Fail("user:a"); d != 0 {
+			t.Fatalf("failure %d locked %v, want free", i+1, d)
+		}
+	}
+	// exponential backoff capped by MaxDelay
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		if d := tr.Fail("user:a"); d != want {
+			t.Fatalf("lockout %v, want %v", d, want)
+		}
+	}
+	if d := tr.RetryAfter("user:a"); d != 5*time.Second {
+		t.Fatalf("RetryAfter = %v, want 5s", d)
+	}
+	if d := tr.RetryAfter("user:b"); d != 0 {
+		t.Fatalf("RetryAfter of other key = %v, want 0", d)
+	}
+	if locked := tr.Locked(); len(locked) != 1 || locked[0].Key != "user:a" || locked[0].Failures != 7 {
+		t.Fatalf("Locked() = %+v", locked)
+	}
+
+	now = now.Add(6 * time.Second)
+	if d := tr.RetryAfter("user:a"); d != 0 {
+		t.Fatalf("RetryAfter after lockout = %v, want 0", d)
+	}
+	// failures are remembered until ResetAfter
+	if d := tr.Fail("user:a"); d != 5*time.Second {
+		t.Fatalf("lockout %v, want 5s", d)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if d := tr.Fail("user:a"); d != 0 {
+		t.Fatalf("lockout after reset %v, want 0", d)
+	}
+
+	tr.Fail("user:a")
+	tr.Fail("user:a")
+	if d := tr.Fail("user:a"); d != time.Second {
+		t.Fatalf("lockout %v, want 1s", d)
+	}
+	if !tr.Reset("user:a") {
+		t.Fatal("Reset return false")
+	}
+	if d := tr.RetryAfter("user:a"); d != 0 {
+		t.Fatalf("RetryAfter after Reset = %v, want 0", d)
+	}
+}
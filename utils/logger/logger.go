@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var std = logrus.New()
+
+// Std return the process wide logger
+func Std() *logrus.Logger {
+
+	return std
+}
+
+// Configure set level, format("text" or "json") and output file of the process wide logger,
+// output is stderr if file is empty
+func Configure(level, format, file string) error {
+
+	lvl := logrus.InfoLevel
+	if level != "" {
+		l, err := logrus.ParseLevel(level)
+		if err != nil {
+			return err
+		}
+		lvl = l
+	}
+
+	var formatter logrus.Formatter
+	switch strings.ToLower(format) {
+	case "", "text":
+		formatter = &logrus.TextFormatter{FullTimestamp: true, TimestampFormat: time.RFC3339}
+	case "json":
+		formatter = &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}
+	default:
+		return fmt.Errorf("invalid log format '%s', should be text or json", format)
+	}
+
+	var out io.Writer = os.Stderr
+	if file != "" {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+
+	std.SetLevel(lvl)
+	std.SetFormatter(formatter)
+	std.SetOutput(out)
+	return nil
+}
+
+// Or return l, or a entry of the process wide logger if l is nil
+func Or(l *logrus.Entry) *logrus.Entry {
+
+	if l != nil {
+		return l
+	}
+	return logrus.NewEntry(std)
+}
+
+// Operation log a operation of component, at debug level if succeeded or error level if failed
+func Operation(l *logrus.Entry, component, operation string, start time.Time, err error, fields logrus.Fields) {
+
+	l = Or(l)
+	if err == nil && !l.Logger.IsLevelEnabled(logrus.DebugLevel) {
+		return
+	}
+	e := l.WithFields(fields).WithFields(logrus.Fields{
+		"component":   component,
+		"operation":   operation,
+		"duration_ms": float64(time.Since(start)) / float64(time.Millisecond),
+	})
+	if err != nil {
+		e.WithError(err).Errorf("%s %s failed", component, operation)
+		return
+	}
+	e.Debugf("%s %s", component, operation)
+}
@@ -0,0 +1,207 @@
+// Package mail build and send email, senders: smtp, file, log
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message email message
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+	HTML    bool // body is html, otherwise plain text
+}
+
+// Bytes return message in RFC 5322 format, body is base64 encoded utf-8
+func (m *Message) Bytes() []byte {
+
+	buf := bytes.Buffer{}
+	writeHeader := func(k, v string) {
+		buf.WriteString(k + ": " + v + "\r\n")
+	}
+
+	b := make([]byte, 12)
+	rand.Read(b)
+	domain := "localhost"
+	if a, err := mail.ParseAddress(m.From); err == nil {
+		if i := strings.LastIndex(a.Address, "@"); i >= 0 {
+			domain = a.Address[i+1:]
+		}
+	}
+
+	contentType := "text/plain"
+	if m.HTML {
+		contentType = "text/html"
+	}
+	writeHeader("From", m.From)
+	writeHeader("To", strings.Join(m.To, ", "))
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	writeHeader("Message-ID", "<"+hex.EncodeToString(b)+"@"+domain+">")
+	writeHeader("MIME-Version", "1.0")
+	writeHeader("Content-Type", contentType+"; charset=UTF-8")
+	writeHeader("Content-Transfer-Encoding", "base64")
+	buf.WriteString("\r\n")
+
+	body := base64.StdEncoding.EncodeToString([]byte(m.Body))
+	for len(body) > 76 {
+		buf.WriteString(body[:76] + "\r\n")
+		body = body[76:]
+	}
+	buf.WriteString(body + "\r\n")
+	return buf.Bytes()
+}
+
+// Sender send email
+type Sender interface {
+	Send(m *Message) error
+}
+
+// SMTPSender send email by smtp server
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Security string // "starttls"(default), "tls" or "none"
+	Timeout  time.Duration
+}
+
+// Send email
+func (s *SMTPSender) Send(m *Message) error {
+
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+	if len(m.To) == 0 {
+		return errors.New("mail: no recipient")
+	}
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	tlsConfig := &tls.Config{ServerName: s.Host}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: timeout}
+	if s.Security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.Security == "" || s.Security == "starttls" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range m.To {
+		a, err := mail.ParseAddress(to)
+		if err != nil {
+			return err
+		}
+		if err := c.Rcpt(a.Address); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// FileSender write each email to a file in Dir, used in development and tests
+type FileSender struct {
+	Dir string
+	mu  sync.Mutex
+	n   int
+}
+
+// Send write email to file "{Dir}/{timestamp}-{n}.eml"
+func (s *FileSender) Send(m *Message) error {
+
+	s.mu.Lock()
+	s.n++
+	name := fmt.Sprintf("%s-%d.eml", time.Now().Format("20060102-150405"), s.n)
+	s.mu.Unlock()
+
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.Dir, name), m.Bytes(), 0600)
+}
+
+// LogSender write email to Writer(default os.Stdout) in plain text, used in development
+type LogSender struct {
+	Writer   io.Writer
+	OmitBody bool // write headers only, the body may have links with tokens
+	mu       sync.Mutex
+}
+
+// Send write email
+func (s *LogSender) Send(m *Message) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := s.Writer
+	if w == nil {
+		w = os.Stdout
+	}
+	body := m.Body
+	if s.OmitBody {
+		body = "(body omitted, email.backend is not set)"
+	}
+	_, err := fmt.Fprintf(w, "From: %s\nTo: %s\nSubject: %s\n\n%s\n%s\n",
+		m.From, strings.Join(m.To, ", "), m.Subject, body, strings.Repeat("-", 72))
+	return err
+}
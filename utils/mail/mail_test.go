@@ -0,0 +1,89 @@
+package mail_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"harbor/utils/mail"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSender(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &mail.FileSender{Dir: filepath.Join(dir, "out")}
+	m := &mail.Message{
+		From:    "EVHarbor <noreply@example.com>",
+		To:      []string{"user@example.com"},
+		Subject: "邮箱验证",
+		Body:    "<a href=\"http://localhost/\">验证</a>",
+		HTML:    true,
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.Send(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("want 2 mails, got %d", len(files))
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.Dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.SplitN(string(data), "\r\n\r\n", 2)
+	if len(parts) != 2 {
+		t.Fatal("mail has no body")
+	}
+	header := parts[0]
+	for _, want := range []string{"To: user@example.com", "Subject: =?utf-8?q?", "Content-Type: text/html; charset=UTF-8", "@example.com>"} {
+		if !strings.Contains(header, want) {
+			t.Errorf("header missing %q:\n%s", want, header)
+		}
+	}
+	body, err := base64.StdEncoding.DecodeString(strings.Replace(parts[1], "\r\n", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != m.Body {
+		t.Errorf("body = %q, want %q", body, m.Body)
+	}
+}
+
+func TestLogSender(t *testing.T) {
+
+	buf := bytes.Buffer{}
+	s := &mail.LogSender{Writer: &buf}
+	if err := s.Send(&mail.Message{From: "a@example.com", To: []string{"b@example.com"}, Subject: "hi", Body: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Subject: hi") || !strings.Contains(buf.String(), "hello") {
+		t.Errorf("unexpected output: %s", buf.String())
+	}
+}
+
+func TestLogSenderOmitBody(t *testing.T) {
+
+	buf := bytes.Buffer{}
+	s := &mail.LogSender{Writer: &buf, OmitBody: true}
+	if err := s.Send(&mail.Message{To: []string{"b@example.com"}, Subject: "reset", Body: "https://example.com/reset?token=secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Subject: reset") || strings.Contains(buf.String(), "secret") {
+		t.Errorf("body should be omitted: %s", buf.String())
+	}
+}
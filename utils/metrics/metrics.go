@@ -0,0 +1,178 @@
+// Package metrics prometheus metrics of harbor
+package metrics
+
+import (
+	"harbor/config"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "harbor"
+
+// HandlerKey context key of handler name, set by controllers
+const HandlerKey = "metrics_handler"
+
+var (
+	// Registry registry of all harbor metrics, served by Handler
+	Registry = prometheus.NewRegistry()
+
+	// HTTPRequests requests by handler, method and status code
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by handler, method and status code.",
+	}, []string{"handler", "method", "code"})
+
+	// HTTPRequestDuration request latency by handler and method
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by handler and method, including streaming the response.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"handler", "method"})
+
+	// TransferBytes bytes of object data uploaded and downloaded
+	TransferBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "transfer",
+		Name:      "bytes_total",
+		Help:      "Bytes of object data uploaded and downloaded, by direction and bucket.",
+	}, []string{"direction", "bucket"})
+
+	// ActiveDownloads streaming downloads in progress
+	ActiveDownloads = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "transfer",
+		Name:      "active_downloads",
+		Help:      "Number of streaming downloads in progress.",
+	})
+
+	// RateLimitEvents requests rejected or transfers throttled by rate limits
+	RateLimitEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rate_limit",
+		Name:      "exceeded_total",
+		Help:      "Requests rejected (kind=request) and uploads or downloads slowed down (kind=upload, download) by rate limits.",
+	}, []string{"kind"})
+
+	// StorageOperationDuration storage backend operation latency
+	StorageOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "operation_duration_seconds",
+		Help:      "Storage backend operation latency by backend and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "operation"})
+
+	// StorageOperationErrors storage backend operation errors
+	StorageOperationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "operation_errors_total",
+		Help:      "Storage backend operation errors by backend and operation.",
+	}, []string{"backend", "operation"})
+
+	// DBQueryDuration database query latency
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Database query latency by database alias and operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"database", "operation"})
+
+	// DBQueryErrors database query errors
+	DBQueryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "query_errors_total",
+		Help:      "Database query errors by database alias and operation, not found is not an error.",
+	}, []string{"database", "operation"})
+
+	// GCKeys storage keys processed by garbage collector
+	GCKeys = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gc",
+		Name:      "keys_total",
+		Help:      "Storage keys processed by garbage collector by result: deleted, skipped or failed.",
+	}, []string{"result"})
+
+	// GCReclaimedBytes bytes of storage data deleted by garbage collector
+	GCReclaimedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "gc",
+		Name:      "reclaimed_bytes_total",
+		Help:      "Bytes of storage data deleted by garbage collector.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		TransferBytes,
+		ActiveDownloads,
+		RateLimitEvents,
+		StorageOperationDuration,
+		StorageOperationErrors,
+		DBQueryDuration,
+		DBQueryErrors,
+		GCKeys,
+		GCReclaimedBytes,
+	)
+}
+
+// Handler return http handler of metrics in prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+var (
+	bucketLabels     map[string]bool
+	bucketLabelsOnce sync.Once
+)
+
+// BucketLabel return bucket label value, only buckets listed in config "metrics.bucket_labels"
+// are labeled by name to keep cardinality bounded, others are "other"
+func BucketLabel(name string) string {
+
+	bucketLabelsOnce.Do(func() {
+		bucketLabels = map[string]bool{}
+		for _, b := range config.GetConfigs().Metrics.BucketLabels {
+			bucketLabels[b] = true
+		}
+	})
+	if bucketLabels[name] {
+		return name
+	}
+	return "other"
+}
+
+// ObserveStorage observe storage operation started at start, err is checked when it is called,
+// use it with defer and named error result
+func ObserveStorage(backend, operation string, start time.Time, err *error) {
+
+	StorageOperationDuration.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+	if err != nil && *err != nil {
+		StorageOperationErrors.WithLabelValues(backend, operation).Inc()
+	}
+}
+
+// HandlerName return handler label value from gin handler func name,
+// e.g. "harbor/controllers.UserRegister" -> "controllers.UserRegister"
+func HandlerName(name string) string {
+
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
@@ -0,0 +1,42 @@
+package metrics_test
+
+import (
+	"harbor/utils/metrics"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerName(t *testing.T) {
+
+	cases := map[string]string{
+		"harbor/controllers.UserRegister":                                   "controllers.UserRegister",
+		"harbor/middlewares/jwt.(*GinJWTMiddleware).LoginHandler-fm":        "jwt.(*GinJWTMiddleware).LoginHandler",
+		"github.com/gin-gonic/gin.(*RouterGroup).createStaticHandler.func1": "gin.(*RouterGroup).createStaticHandler.func1",
+	}
+	for name, want := range cases {
+		if got := metrics.HandlerName(name); got != want {
+			t.Errorf("HandlerName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+
+	metrics.HTTPRequests.WithLabelValues("ObjController", "GET", "200").Inc()
+	metrics.TransferBytes.WithLabelValues("download", metrics.BucketLabel("not-listed")).Add(10)
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	for _, want := range []string{
+		`harbor_http_requests_total{code="200",handler="ObjController",method="GET"} 1`,
+		`harbor_transfer_bytes_total{bucket="other",direction="download"} 10`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
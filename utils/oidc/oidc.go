@@ -0,0 +1,417 @@
+// Package oidc OpenID Connect relying party, authorization code flow with PKCE
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// ErrIssuerMismatch indicates the issuer in discovery document or id token is not the configured issuer
+	ErrIssuerMismatch = errors.New("oidc: issuer mismatch")
+
+	// ErrAudienceMismatch indicates the id token is not issued to this client
+	ErrAudienceMismatch = errors.New("oidc: audience mismatch")
+
+	// ErrNonceMismatch indicates the nonce in id token is not the one sent in authorization request
+	ErrNonceMismatch = errors.New("oidc: nonce mismatch")
+
+	// ErrNoIDToken indicates the token response does not contain id_token
+	ErrNoIDToken = errors.New("oidc: token response has no id_token")
+
+	// ErrUnknownKey indicates the key used to sign id token is not found in provider's JWKS
+	ErrUnknownKey = errors.New("oidc: unknown signing key")
+)
+
+// Provider OpenID provider metadata, from "{issuer}/.well-known/openid-configuration"
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// TokenResponse token endpoint response
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	IDToken      string `json:"id_token"`
+}
+
+// errorResponse token endpoint error response
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Client OpenID Connect client(relying party)
+type Client struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	HTTPClient *http.Client
+
+	mu       sync.Mutex
+	provider *Provider
+	keys     map[string]interface{} // kid -> public key
+}
+
+// NewClient return a client, provider metadata is discovered at first use
+func NewClient(issuer, clientID, clientSecret, redirectURL string, scopes []string) *Client {
+
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	return &Client{
+		Issuer:       strings.TrimRight(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// getJSON GET url and decode json response body to v
+func (c *Client) getJSON(u string, v interface{}) error {
+
+	resp, err := c.HTTPClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Provider return provider metadata, discover it if not yet
+func (c *Client) Provider() (*Provider, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.provider != nil {
+		return c.provider, nil
+	}
+
+	p := &Provider{}
+	if err := c.getJSON(c.Issuer+"/.well-known/openid-configuration", p); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(p.Issuer, "/") != c.Issuer {
+		return nil, ErrIssuerMismatch
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("oidc: invalid provider metadata")
+	}
+	c.provider = p
+	return p, nil
+}
+
+// AuthCodeURL return the url of authorization endpoint to redirect user to
+func (c *Client) AuthCodeURL(state, nonce, codeChallenge string) (string, error) {
+
+	p, err := c.Provider()
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", c.ClientID)
+	v.Set("redirect_uri", c.RedirectURL)
+	v.Set("scope", strings.Join(c.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", codeChallenge)
+	v.Set("code_challenge_method", "S256")
+
+	u := p.AuthorizationEndpoint
+	if strings.Contains(u, "?") {
+		return u + "&" + v.Encode(), nil
+	}
+	return u + "?" + v.Encode(), nil
+}
+
+// Exchange exchange authorization code for tokens
+func (c *Client) Exchange(code, codeVerifier string) (*TokenResponse, error) {
+
+	p, err := c.Provider()
+	if err != nil {
+		return nil, err
+	}
+
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", c.RedirectURL)
+	v.Set("code_verifier", codeVerifier)
+	req, err := http.NewRequest("POST", p.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := errorResponse{}
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("oidc: token endpoint: %s %s", e.Error, e.ErrorDescription)
+		}
+		return nil, fmt.Errorf("oidc: token endpoint: %s", resp.Status)
+	}
+
+	token := &TokenResponse{}
+	if err := json.Unmarshal(body, token); err != nil {
+		return nil, err
+	}
+	if token.IDToken == "" {
+		return nil, ErrNoIDToken
+	}
+	return token, nil
+}
+
+// VerifyIDToken verify signature and claims of id token, return the claims
+func (c *Client) VerifyIDToken(rawIDToken, nonce string) (jwt.MapClaims, error) {
+
+	claims := jwt.MapClaims{}
+	// jwt-go check "exp", "iat" and "nbf"
+	if _, err := jwt.ParseWithClaims(rawIDToken, claims, c.keyFunc); err != nil {
+		return nil, err
+	}
+
+	iss, _ := claims["iss"].(string)
+	if strings.TrimRight(iss, "/") != c.Issuer {
+		return nil, ErrIssuerMismatch
+	}
+	if err := c.verifyAudience(claims); err != nil {
+		return nil, err
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("oidc: id token has no exp")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, ErrNonceMismatch
+	}
+	return claims, nil
+}
+
+// verifyAudience "aud" may be a string or an array of strings, it must contain client id;
+// for multiple audiences, authorized party "azp" must be this client
+func (c *Client) verifyAudience(claims jwt.MapClaims) error {
+
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud == c.ClientID {
+			return nil
+		}
+	case []interface{}:
+		found := false
+		for _, a := range aud {
+			if s, _ := a.(string); s == c.ClientID {
+				found = true
+			}
+		}
+		if !found {
+			return ErrAudienceMismatch
+		}
+		if len(aud) == 1 {
+			return nil
+		}
+		if azp, _ := claims["azp"].(string); azp == c.ClientID {
+			return nil
+		}
+	}
+	return ErrAudienceMismatch
+}
+
+// keyFunc return public key to verify id token by "kid" header
+func (c *Client) keyFunc(t *jwt.Token) (interface{}, error) {
+
+	switch t.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+	default:
+		return nil, fmt.Errorf("oidc: unexpected signing method %v", t.Header["alg"])
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	if key, ok := c.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	// the provider may have rotated keys, refetch
+	if err := c.fetchKeys(); err != nil {
+		return nil, err
+	}
+	if key, ok := c.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// lookupKey return cached key by kid, the only key is used if token has no kid
+func (c *Client) lookupKey(kid string) (interface{}, bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if kid == "" && len(c.keys) == 1 {
+		for _, k := range c.keys {
+			return k, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// jsonWebKey public key in JWK format
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// fetchKeys fetch provider's JWKS
+func (c *Client) fetchKeys() error {
+
+	p, err := c.Provider()
+	if err != nil {
+		return err
+	}
+
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := c.getJSON(p.JWKSURI, &set); err != nil {
+		return err
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue // unsupported key type
+		}
+		keys[k.KeyID] = key
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+	return nil
+}
+
+// publicKey return *rsa.PublicKey or *ecdsa.PublicKey
+func (k jsonWebKey) publicKey() (interface{}, error) {
+
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("oidc: unsupported curve %s", k.Curve)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("oidc: unsupported key type %s", k.KeyType)
+}
+
+// RandomString return a url safe random string, used as state, nonce or code verifier
+func RandomString(n int) string {
+
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// NewPKCE return a PKCE code verifier and its S256 code challenge(RFC 7636)
+func NewPKCE() (verifier, challenge string) {
+
+	verifier = RandomString(32)
+	return verifier, CodeChallengeS256(verifier)
+}
+
+// CodeChallengeS256 return BASE64URL(SHA256(verifier))
+func CodeChallengeS256(verifier string) string {
+
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
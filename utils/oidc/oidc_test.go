@@ -0,0 +1,187 @@
+package oidc_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"harbor/utils/oidc"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// mockProvider a minimal OpenID provider, issues id token for code "good-code"
+type mockProvider struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	clientID  string
+	challenge string // code challenge of the authorization request
+	nonce     string
+	claims    jwt.MapClaims // extra claims of id token
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, clientID: "harbor"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != p.clientID || secret != "secret" {
+			w.WriteHeader(401)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		if r.PostFormValue("code") != "good-code" ||
+			oidc.CodeChallengeS256(r.PostFormValue("code_verifier")) != p.challenge {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "at",
+			"token_type":   "Bearer",
+			"id_token":     p.idToken(t, nil),
+		})
+	})
+	p.server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *mockProvider) idToken(t *testing.T, override jwt.MapClaims) string {
+
+	claims := jwt.MapClaims{
+		"iss":            p.server.URL,
+		"sub":            "1234",
+		"aud":            p.clientID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          p.nonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"groups":         []string{"harbor-admins"},
+	}
+	for k, v := range override {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	s, err := token.SignedString(p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+
+	p := newMockProvider(t)
+	defer p.server.Close()
+
+	client := oidc.NewClient(p.server.URL, "harbor", "secret", "http://harbor/oidc/callback/", nil)
+	verifier, challenge := oidc.NewPKCE()
+	p.nonce = "n-0S6_WzA2Mj"
+
+	authURL, err := client.AuthCodeURL("xyz", p.nonce, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("code_challenge_method") != "S256" || q.Get("state") != "xyz" ||
+		q.Get("scope") != "openid email profile" {
+		t.Fatalf("bad authorization url %s", authURL)
+	}
+	p.challenge = q.Get("code_challenge")
+
+	if _, err := client.Exchange("good-code", "wrong-verifier"); err == nil {
+		t.Errorf("exchange should fail with wrong code verifier")
+	}
+	token, err := client.Exchange("good-code", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := client.VerifyIDToken(token.IDToken, p.nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["email"] != "alice@example.com" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+	if _, err := client.VerifyIDToken(token.IDToken, "other-nonce"); err != oidc.ErrNonceMismatch {
+		t.Errorf("want nonce mismatch, got %v", err)
+	}
+}
+
+func TestVerifyIDTokenClaims(t *testing.T) {
+
+	p := newMockProvider(t)
+	defer p.server.Close()
+	client := oidc.NewClient(p.server.URL, "harbor", "secret", "http://harbor/oidc/callback/", nil)
+
+	cases := []struct {
+		name     string
+		override jwt.MapClaims
+		ok       bool
+	}{
+		{"valid", nil, true},
+		{"audience array", jwt.MapClaims{"aud": []string{"harbor"}}, true},
+		{"multiple audiences without azp", jwt.MapClaims{"aud": []string{"harbor", "other"}}, false},
+		{"multiple audiences with azp", jwt.MapClaims{"aud": []string{"harbor", "other"}, "azp": "harbor"}, true},
+		{"other audience", jwt.MapClaims{"aud": "other"}, false},
+		{"other issuer", jwt.MapClaims{"iss": "https://evil.example.com"}, false},
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}, false},
+	}
+	for _, c := range cases {
+		_, err := client.VerifyIDToken(p.idToken(t, c.override), "")
+		if (err == nil) != c.ok {
+			t.Errorf("%s: want ok=%v, got err %v", c.name, c.ok, err)
+		}
+	}
+
+	// token signed by unknown key
+	other, _ := rsa.GenerateKey(rand.Reader, 1024)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": p.server.URL, "aud": "harbor"})
+	token.Header["kid"] = "k1"
+	s, _ := token.SignedString(other)
+	if _, err := client.VerifyIDToken(s, ""); err == nil {
+		t.Errorf("token signed by unknown key should be rejected")
+	}
+
+	// alg none or HS256 must be rejected
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": p.server.URL, "aud": "harbor"})
+	s, _ = hs.SignedString([]byte("secret"))
+	if _, err := client.VerifyIDToken(s, ""); err == nil {
+		t.Errorf("HS256 id token should be rejected")
+	}
+}
@@ -328,12 +328,12 @@ func (p *DirListLimitOffsetPagination) PaginateDBQuery(out interface{}, db *gorm
 
 	//当数据很多时，目录和对象分开考虑
 	var dirsCount uint64
-	if err := db.Where("fod = ?", "false").Count(&dirsCount).Error; err != nil {
+	if err := db.Where("fod = ?", false).Count(&dirsCount).Error; err != nil {
 		return errors.New("Error when query database:" + err.Error())
 	}
 	// 分页数据只有目录
 	if (offset + limit) <= dirsCount {
-		if err := db.Where("fod = ?", "false").Offset(offset).Limit(limit).Find(out).Error; err != nil {
+		if err := db.Where("fod = ?", false).Offset(offset).Limit(limit).Find(out).Error; err != nil {
 			return errors.New("Error when query database:" + err.Error())
 		}
 		return nil
@@ -344,14 +344,14 @@ func (p *DirListLimitOffsetPagination) PaginateDBQuery(out interface{}, db *gorm
 		objsOffset := offset - dirsCount
 		// 偏移量objsOffset较小时
 		if objsOffset <= 10000 {
-			if err := db.Where("fod = ?", "true").Offset(objsOffset).Limit(limit).Find(out).Error; err != nil {
+			if err := db.Where("fod = ?", true).Offset(objsOffset).Limit(limit).Find(out).Error; err != nil {
 				return errors.New("Error when query database:" + err.Error())
 			}
 			return nil
 		}
 
 		// 偏移量objsOffset较大时
-		if err := db.Where("fod = ?", "true").Select("id").Offset(objsOffset).Limit(1).Find(out).Error; err != nil {
+		if err := db.Where("fod = ?", true).Select("id").Offset(objsOffset).Limit(1).Find(out).Error; err != nil {
 			return errors.New("Error when query database:" + err.Error())
 		}
 
@@ -370,54 +370,22 @@ func (p *DirListLimitOffsetPagination) PaginateDBQuery(out interface{}, db *gorm
 
 	// 分页数据包含目录和对象
 	// 目录
-	if err := db.Where("fod = ?", "false").Offset(offset).Limit(limit).Find(out).Error; err != nil {
+	if err := db.Where("fod = ?", false).Offset(offset).Limit(limit).Find(out).Error; err != nil {
 		return errors.New("Error when query database:" + err.Error())
 	}
 	refValue := indirect(reflect.ValueOf(out))
-	retOut := reflect.MakeSlice(refValue.Type(), 0, 0)
-	if err := copySlice(retOut, refValue); err != nil {
-		return err
+	if refValue.Kind() != reflect.Slice {
+		return errors.New("input must be slice")
 	}
-	dl := retOut.Len()
-	ol := limit - uint64(dl)
-	// 对象
-	if err := db.Where("fod = ?", "false").Offset(offset).Limit(ol).Find(out).Error; err != nil {
+	dirs := reflect.AppendSlice(reflect.MakeSlice(refValue.Type(), 0, int(limit)), refValue)
+	// 对象从第一个开始，补足本页剩余的数量
+	ol := limit - uint64(dirs.Len())
+	if err := db.Where("fod = ?", true).Offset(0).Limit(ol).Find(out).Error; err != nil {
 		return errors.New("Error when query database:" + err.Error())
 	}
-
 	refValue = indirect(reflect.ValueOf(out))
-	if err := copySlice(retOut, refValue); err != nil {
-		return err
-	}
-	out = retOut.Interface()
-
-	return nil
-}
+	refValue.Set(reflect.AppendSlice(dirs, refValue))
 
-func copySlice(retOut, refValue reflect.Value) error {
-
-	var (
-		isPtr      bool
-		resultType reflect.Type
-	)
-
-	if kind := refValue.Kind(); kind != reflect.Slice {
-		return errors.New("input must be slice")
-	}
-	resultType = refValue.Type().Elem()
-	if resultType.Kind() == reflect.Ptr {
-		isPtr = true
-		resultType = resultType.Elem()
-	}
-	// elem = reflect.New(resultType).Elem()
-	for i := 0; i < refValue.Len(); i++ {
-		elem := refValue.Index(i)
-		if isPtr {
-			retOut.Set(reflect.Append(retOut, elem.Addr()))
-		} else {
-			retOut.Set(reflect.Append(retOut, elem))
-		}
-	}
 	return nil
 }
 
@@ -0,0 +1,301 @@
+// Package ratelimit token bucket rate limiter of requests and bytes
+package ratelimit
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Bucket token bucket, tokens are added at rate per second up to burst;
+// safe for concurrent use
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewBucket return a full bucket, nil if rate <= 0 which means unlimited
+func NewBucket(rate float64, burst int64) *Bucket {
+
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int64(rate)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &Bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// SetClock replace the clock, used in tests
+func (b *Bucket) SetClock(now func() time.Time) {
+
+	b.now = now
+	b.last = now()
+}
+
+// refill add tokens since last time; lock must be held
+func (b *Bucket) refill() {
+
+	now := b.now()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// Allow take one token if available, otherwise return false and the duration to wait for a token
+func (b *Bucket) Allow() (bool, time.Duration) {
+
+	if b == nil {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Reserve take n tokens, the bucket may go into debt;
+// return the duration to wait before the tokens are actually available
+func (b *Bucket) Reserve(n int64) time.Duration {
+
+	if b == nil || n <= 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Burst return the max tokens of bucket
+func (b *Bucket) Burst() int64 {
+
+	if b == nil {
+		return 0
+	}
+	return int64(b.burst)
+}
+
+// Buckets a group of buckets, all of them are taken
+type Buckets []*Bucket
+
+// Limited return true if any bucket is not nil
+func (bs Buckets) Limited() bool {
+
+	for _, b := range bs {
+		if b != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow take one token of each bucket; if any bucket is empty, no token is taken,
+// return false and the max duration to wait
+func (bs Buckets) Allow() (bool, time.Duration) {
+
+	for i, b := range bs {
+		if ok, d := b.Allow(); !ok {
+			// give back tokens taken
+			for _, taken := range bs[:i] {
+				taken.giveBack(1)
+			}
+			return false, d
+		}
+	}
+	return true, 0
+}
+
+// giveBack return n tokens to bucket
+func (b *Bucket) giveBack(n int64) {
+
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.tokens += float64(n)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.mu.Unlock()
+}
+
+// Wait take n tokens of each bucket, sleep until the tokens are available;
+// return true if it had to wait
+func (bs Buckets) Wait(n int64) bool {
+
+	var wait time.Duration
+	for _, b := range bs {
+		if d := b.Reserve(n); d > wait {
+			wait = d
+		}
+	}
+	if wait <= 0 {
+		return false
+	}
+	time.Sleep(wait)
+	return true
+}
+
+// chunkSize return size of each throttled write/read, not larger than the smallest burst
+func (bs Buckets) chunkSize() int {
+
+	size := int64(32 * 1024)
+	for _, b := range bs {
+		if b != nil && b.Burst() < size {
+			size = b.Burst()
+		}
+	}
+	if size < 1 {
+		size = 1
+	}
+	return int(size)
+}
+
+// writer throttled writer
+type writer struct {
+	w       io.Writer
+	buckets Buckets
+	onWait  func()
+}
+
+// NewWriter return a writer limited by buckets; onWait is called each time it has to wait, can be nil
+func NewWriter(w io.Writer, buckets Buckets, onWait func()) io.Writer {
+
+	if !buckets.Limited() {
+		return w
+	}
+	return &writer{w: w, buckets: buckets, onWait: onWait}
+}
+
+func (tw *writer) Write(p []byte) (int, error) {
+
+	size := tw.buckets.chunkSize()
+	written := 0
+	for len(p) > 0 {
+		n := size
+		if n > len(p) {
+			n = len(p)
+		}
+		if tw.buckets.Wait(int64(n)) && tw.onWait != nil {
+			tw.onWait()
+		}
+		m, err := tw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// reader throttled reader
+type reader struct {
+	r       io.Reader
+	buckets Buckets
+	onWait  func()
+}
+
+// NewReader return a reader limited by buckets; onWait is called each time it has to wait, can be nil
+func NewReader(r io.Reader, buckets Buckets, onWait func()) io.Reader {
+
+	if !buckets.Limited() {
+		return r
+	}
+	return &reader{r: r, buckets: buckets, onWait: onWait}
+}
+
+func (tr *reader) Read(p []byte) (int, error) {
+
+	if size := tr.buckets.chunkSize(); len(p) > size {
+		p = p[:size]
+	}
+	n, err := tr.r.Read(p)
+	if n > 0 && tr.buckets.Wait(int64(n)) && tr.onWait != nil {
+		tr.onWait()
+	}
+	return n, err
+}
+
+// Limits request, upload and download buckets of a key
+type Limits struct {
+	Requests *Bucket
+	Upload   *Bucket
+	Download *Bucket
+}
+
+type limiterEntry struct {
+	limits   *Limits
+	lastUsed time.Time
+}
+
+// Limiter limits by key, keys not used in idle duration are removed
+type Limiter struct {
+	mu    sync.Mutex
+	keys  map[string]*limiterEntry
+	idle  time.Duration
+	calls int
+}
+
+// NewLimiter return a limiter, idle default 10 minutes
+func NewLimiter(idle time.Duration) *Limiter {
+
+	if idle <= 0 {
+		idle = 10 * time.Minute
+	}
+	return &Limiter{keys: map[string]*limiterEntry{}, idle: idle}
+}
+
+// Get return limits of key, newLimits is called to create limits if key not exists
+func (l *Limiter) Get(key string, newLimits func() *Limits) *Limits {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.calls++
+	if l.calls >= 1000 {
+		l.calls = 0
+		for k, e := range l.keys {
+			if now.Sub(e.lastUsed) > l.idle {
+				delete(l.keys, k)
+			}
+		}
+	}
+
+	e, ok := l.keys[key]
+	if !ok {
+		e = &limiterEntry{limits: newLimits()}
+		l.keys[key] = e
+	}
+	e.lastUsed = now
+	return e.limits
+}
@@ -0,0 +1,85 @@
+package ratelimit_test
+
+import (
+	"bytes"
+	"harbor/utils/ratelimit"
+	"testing"
+	"time"
+)
+
+func TestBucketAllow(t *testing.T) {
+
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	b := ratelimit.NewBucket(2, 3)
+	b.SetClock(func() time.Time { return now })
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.Allow(); !ok {
+			t.Fatalf("request %d rejected, want burst 3", i+1)
+		}
+	}
+	ok, wait := b.Allow()
+	if ok {
+		t.Fatal("request over burst allowed")
+	}
+	if wait != 500*time.Millisecond {
+		t.Fatalf("wait = %v, want 500ms", wait)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := b.Allow(); !ok {
+		t.Fatal("request rejected after refill")
+	}
+
+	if ratelimit.NewBucket(0, 10) != nil {
+		t.Fatal("bucket of rate 0 should be nil, unlimited")
+	}
+	var unlimited *ratelimit.Bucket
+	if ok, _ := unlimited.Allow(); !ok {
+		t.Fatal("nil bucket should allow")
+	}
+}
+
+func TestBucketsAllow(t *testing.T) {
+
+	a := ratelimit.NewBucket(1, 2)
+	b := ratelimit.NewBucket(1, 1)
+	bs := ratelimit.Buckets{a, b}
+	if ok, _ := bs.Allow(); !ok {
+		t.Fatal("first request rejected")
+	}
+	if ok, _ := bs.Allow(); ok {
+		t.Fatal("second request allowed, bucket b is empty")
+	}
+	// the token of a taken by the rejected request is given back
+	if ok, _ := a.Allow(); !ok {
+		t.Fatal("token of bucket a was not given back")
+	}
+}
+
+func TestWriter(t *testing.T) {
+
+	// 100KB per second, burst 100KB: the first 100KB is written at once, the next 50KB waits about 0.5s
+	b := ratelimit.NewBucket(100*1024, 100*1024)
+	waits := 0
+	buf := bytes.Buffer{}
+	w := ratelimit.NewWriter(&buf, ratelimit.Buckets{nil, b}, func() { waits++ })
+
+	start := time.Now()
+	data := make([]byte, 150*1024)
+	n, err := w.Write(data)
+	if err != nil || n != len(data) || buf.Len() != len(data) {
+		t.Fatalf("Write() = %d, %v; buffered %d", n, err, buf.Len())
+	}
+	elapsed := time.Since(start)
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("write took %v, want about 500ms", elapsed)
+	}
+	if waits == 0 {
+		t.Fatal("onWait not called")
+	}
+
+	if w := ratelimit.NewWriter(&buf, ratelimit.Buckets{nil}, nil); w != &buf {
+		t.Fatal("writer without limits should not be wrapped")
+	}
+}
@@ -0,0 +1,165 @@
+// Package server http server with configurable timeouts, TLS certificate reloading and graceful shutdown
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"harbor/config"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaults of config "server"
+const (
+	DefaultAddress           = ":9999"
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+	DefaultShutdownTimeout   = 5 * time.Minute
+)
+
+// CertReloader hold a TLS certificate which can be reloaded from files
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewCertReloader return a CertReloader with certificate loaded
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload load certificate from files, the certificate in use is kept if error
+func (r *CertReloader) Reload() error {
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate used as tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Server http server
+type Server struct {
+	srv             *http.Server
+	certs           *CertReloader
+	shutdownTimeout time.Duration
+}
+
+// New return a server serving handler, config zero values are replaced with defaults
+func New(c config.ServerConfig, handler http.Handler) (*Server, error) {
+
+	if c.Address == "" {
+		c.Address = DefaultAddress
+	}
+	if c.ReadHeaderTimeout <= 0 {
+		c.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = DefaultIdleTimeout
+	}
+	if c.ShutdownTimeout <= 0 {
+		c.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return nil, errors.New("both tls_cert_file and tls_key_file must be set to serve https")
+	}
+
+	s := &Server{
+		srv: &http.Server{
+			Addr:              c.Address,
+			Handler:           handler,
+			ReadHeaderTimeout: c.ReadHeaderTimeout,
+			ReadTimeout:       c.ReadTimeout,
+			WriteTimeout:      c.WriteTimeout,
+			IdleTimeout:       c.IdleTimeout,
+		},
+		shutdownTimeout: c.ShutdownTimeout,
+	}
+	if c.TLSCertFile != "" {
+		certs, err := NewCertReloader(config.GetConfigs().AbsPath(c.TLSCertFile), config.GetConfigs().AbsPath(c.TLSKeyFile))
+		if err != nil {
+			return nil, err
+		}
+		s.certs = certs
+		s.srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+	}
+	return s, nil
+}
+
+// Addr return listen address
+func (s *Server) Addr() string {
+
+	return s.srv.Addr
+}
+
+// TLS return true if serving https
+func (s *Server) TLS() bool {
+
+	return s.certs != nil
+}
+
+// ListenAndServe serve until Shutdown is called, http.ErrServerClosed is returned after Shutdown
+func (s *Server) ListenAndServe() error {
+
+	if s.certs != nil {
+		return s.srv.ListenAndServeTLS("", "")
+	}
+	return s.srv.ListenAndServe()
+}
+
+// Serve serve on listener until Shutdown is called
+func (s *Server) Serve(l net.Listener) error {
+
+	if s.certs != nil {
+		return s.srv.ServeTLS(l, "", "")
+	}
+	return s.srv.Serve(l)
+}
+
+// ReloadCert reload TLS certificate from files, nothing is done if not serving https
+func (s *Server) ReloadCert() error {
+
+	if s.certs == nil {
+		return nil
+	}
+	return s.certs.Reload()
+}
+
+// Shutdown stop accepting connections and wait for in-flight requests(uploads and downloads)
+// until shutdown timeout, connections still active are closed when timeout
+func (s *Server) Shutdown() error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	err := s.srv.Shutdown(ctx)
+	if err != nil {
+		s.srv.Close()
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"harbor/config"
+	"harbor/utils/server"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCert(t *testing.T, dir, cn string) (string, string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func certCN(t *testing.T, r *server.CertReloader) string {
+
+	cert, _ := r.GetCertificate(nil)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "harbor-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCert(t, dir, "old")
+	r, err := server.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn := certCN(t, r); cn != "old" {
+		t.Errorf("got certificate %q", cn)
+	}
+
+	writeCert(t, dir, "new")
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cn := certCN(t, r); cn != "new" {
+		t.Errorf("certificate should be reloaded, got %q", cn)
+	}
+
+	ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	if err := r.Reload(); err == nil {
+		t.Errorf("reload of broken key should fail")
+	}
+	if cn := certCN(t, r); cn != "new" {
+		t.Errorf("certificate in use should be kept, got %q", cn)
+	}
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	s, err := server.New(config.ServerConfig{ShutdownTimeout: 5 * time.Second}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	got := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/")
+		if err != nil {
+			got <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		got <- result{string(b), err}
+	}()
+
+	<-started
+	if err := s.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != http.ErrServerClosed {
+		t.Errorf("serve should return ErrServerClosed, got %v", err)
+	}
+	if r := <-got; r.err != nil || r.body != "done" {
+		t.Errorf("in-flight request should complete, got %q %v", r.body, r.err)
+	}
+}
+
+func TestNewRequiresCertAndKey(t *testing.T) {
+
+	if _, err := server.New(config.ServerConfig{TLSCertFile: "cert.pem"}, http.NotFoundHandler()); err == nil {
+		t.Errorf("cert without key should fail")
+	}
+}
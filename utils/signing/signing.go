@@ -0,0 +1,86 @@
+// Package signing sign and verify values with a secret key, like django.core.signing
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrBadSignature indicates that the signature does not match
+	ErrBadSignature = errors.New("bad signature")
+
+	// ErrSignatureExpired indicates that the signature is expired
+	ErrSignatureExpired = errors.New("signature expired")
+)
+
+// Signer sign value with secret key and salt
+// different salt used for different purpose, so a signed value of one purpose can not be used for another
+type Signer struct {
+	key  []byte
+	salt string
+}
+
+// NewSigner return a signer
+func NewSigner(secretKey, salt string) *Signer {
+
+	return &Signer{
+		key:  []byte(secretKey),
+		salt: salt,
+	}
+}
+
+func (s Signer) signature(value string) string {
+
+	mac := hmac.New(sha256.New, append([]byte(s.salt+"signer"), s.key...))
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign return "{base64 value}:{expire unix time}:{signature}"
+// expire zero means never expire
+func (s Signer) Sign(value []byte, expire time.Time) string {
+
+	var exp int64
+	if !expire.IsZero() {
+		exp = expire.Unix()
+	}
+	v := base64.RawURLEncoding.EncodeToString(value) + ":" + strconv.FormatInt(exp, 10)
+	return v + ":" + s.signature(v)
+}
+
+// Unsign verify signed string return by Sign, return the value if signature is valid and not expired
+func (s Signer) Unsign(signed string, now time.Time) ([]byte, error) {
+
+	i := strings.LastIndex(signed, ":")
+	if i < 0 {
+		return nil, ErrBadSignature
+	}
+	v, sig := signed[:i], signed[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(s.signature(v))) {
+		return nil, ErrBadSignature
+	}
+
+	a := strings.SplitN(v, ":", 2)
+	if len(a) != 2 {
+		return nil, ErrBadSignature
+	}
+	exp, err := strconv.ParseInt(a[1], 10, 64)
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+	if exp > 0 && now.Unix() > exp {
+		return nil, ErrSignatureExpired
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(a[0])
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+	return value, nil
+}
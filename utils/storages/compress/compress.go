@@ -0,0 +1,431 @@
+// Package compress transparent compression of object data in independently compressed blocks.
+//
+// Data is split into blocks of BlockSize bytes, each block is compressed by gzip, or kept as is if it is not
+// smaller after compression, and stored at index*BlockSize of the data, so that any range of data is read or
+// written by the blocks it covers and writes of different blocks never overlap. The stored data is sparse,
+// only the compressed bytes of each block are written, unless it is written by parts like s3, then blocks
+// of a part are written at a time with zeros between them.
+//
+// Stored length and codec of each block are kept in an index of IndexEntrySize bytes per block, stored apart
+// from the data by key IndexKey. Blocks never written (holes left by writing beyond the end) have a zero entry
+// and read as zeros. All blocks are full except the last one, a block shorter than its length is filled with zeros.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"harbor/utils/storages/radosio"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"sync"
+)
+
+const (
+	// BlockSize size of uncompressed data of a block
+	BlockSize = 256 * 1024
+	// IndexEntrySize size of index entry of a block
+	IndexEntrySize = 8
+	// IndexSuffix suffix of key of index
+	IndexSuffix = ".idx"
+)
+
+// codecs of blocks in index entries, zero entry is a block not written
+const (
+	codecRaw  = 1
+	codecGzip = 2
+)
+
+// Gzip name of compression by gzip, the only one supported
+const Gzip = "gzip"
+
+// ErrCorrupted returned if a stored block can not be decompressed
+var ErrCorrupted = errors.New("compressed data is corrupted")
+
+// IndexKey return key of index of compressed data of key
+func IndexKey(key string) string {
+
+	return key + IndexSuffix
+}
+
+// IndexSize return size of index of data of size bytes
+func IndexSize(size uint64) uint64 {
+
+	return (size + BlockSize - 1) / BlockSize * IndexEntrySize
+}
+
+// Supported return true if compression of name is supported
+func Supported(name string) bool {
+
+	return name == Gzip
+}
+
+// RawIO read and write stored data, implemented by *radosio.CephHarborObject
+type RawIO interface {
+	Read(offset uint64, size uint) ([]byte, error)
+	Write(data []byte, offset uint64) error
+	Delete() error
+	Close() error
+}
+
+// partWriter is implemented by stored data written by parts, e.g. *s3.Object, a partial write of a part reads
+// and writes the whole part, so blocks of a part are written together
+type partWriter interface {
+	PartSize() uint64
+}
+
+// partSize return size of parts of stored data, 0 if it is not written by parts
+func (o *Object) partSize() uint64 {
+
+	if p, ok := o.raw.(partWriter); ok {
+		return p.PartSize()
+	}
+	return 0
+}
+
+// entry index entry of a block
+type entry struct {
+	length uint32 // stored bytes
+	codec  uint8
+}
+
+func (e entry) encode(b []byte) {
+
+	binary.BigEndian.PutUint32(b[0:4], e.length)
+	b[4] = e.codec
+	b[5], b[6], b[7] = 0, 0, 0
+}
+
+func decodeEntry(b []byte) entry {
+
+	return entry{length: binary.BigEndian.Uint32(b[0:4]), codec: b[4]}
+}
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+		return w
+	},
+}
+
+// encode return stored bytes of block data and its index entry
+func encode(plain []byte) ([]byte, entry, error) {
+
+	var buf bytes.Buffer
+	w := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(plain); err != nil {
+		return nil, entry{}, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, entry{}, err
+	}
+	if buf.Len() >= len(plain) {
+		return plain, entry{length: uint32(len(plain)), codec: codecRaw}, nil
+	}
+	return buf.Bytes(), entry{length: uint32(buf.Len()), codec: codecGzip}, nil
+}
+
+// decode return block data of n bytes from stored bytes
+func decode(stored []byte, e entry, n uint64) ([]byte, error) {
+
+	var plain []byte
+	switch e.codec {
+	case 0:
+		return make([]byte, n), nil
+	case codecRaw:
+		plain = stored
+	case codecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(stored))
+		if err != nil {
+			return nil, ErrCorrupted
+		}
+		if plain, err = ioutil.ReadAll(io.LimitReader(r, BlockSize)); err != nil {
+			return nil, ErrCorrupted
+		}
+	default:
+		return nil, fmt.Errorf("unknown codec %d of compressed block", e.codec)
+	}
+	if uint64(len(plain)) >= n {
+		return plain[:n], nil
+	}
+	return append(plain, make([]byte, n-uint64(len(plain)))...), nil
+}
+
+// Object read and write uncompressed data of an object whose stored data is compressed
+type Object struct {
+	raw   RawIO
+	index RawIO
+	size  uint64 // size of uncompressed data
+	delta int64  // change of size of stored data by writes
+}
+
+// NewObject return Object of stored data raw with its index, size is the size of uncompressed data
+func NewObject(raw, index RawIO, size uint64) *Object {
+
+	return &Object{raw: raw, index: index, size: size}
+}
+
+// GetObjSize return size of uncompressed data
+func (o *Object) GetObjSize() uint64 {
+
+	return o.size
+}
+
+// StoredSizeDelta return the change of size of stored data by writes of o, index is not included
+func (o *Object) StoredSizeDelta() int64 {
+
+	return o.delta
+}
+
+// blockLen return size of uncompressed data of block i in data of size bytes
+func blockLen(i, size uint64) uint64 {
+
+	start := i * BlockSize
+	if start >= size {
+		return 0
+	}
+	if size-start > BlockSize {
+		return BlockSize
+	}
+	return size - start
+}
+
+// readEntries return index entries of blocks first to last
+func (o *Object) readEntries(first, last uint64) ([]entry, error) {
+
+	n := last - first + 1
+	b, err := o.index.Read(first*IndexEntrySize, uint(n*IndexEntrySize))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(b)) < n*IndexEntrySize {
+		// not written
+		b = append(b, make([]byte, n*IndexEntrySize-uint64(len(b)))...)
+	}
+	entries := make([]entry, n)
+	for j := range entries {
+		entries[j] = decodeEntry(b[j*IndexEntrySize:])
+	}
+	return entries, nil
+}
+
+// readBlock return uncompressed data of block i with index entry e
+func (o *Object) readBlock(i uint64, e entry) ([]byte, error) {
+
+	n := blockLen(i, o.size)
+	if e.codec == 0 || n == 0 {
+		return make([]byte, n), nil
+	}
+	stored, err := o.raw.Read(i*BlockSize, uint(e.length))
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) < int(e.length) {
+		return nil, fmt.Errorf("block %d: %s", i, ErrCorrupted)
+	}
+	plain, err := decode(stored, e, n)
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %s", i, err)
+	}
+	return plain, nil
+}
+
+// Read read uncompressed data of size bytes start at offset, []byte{} at end of object
+func (o *Object) Read(offset uint64, size uint) ([]byte, error) {
+
+	if offset >= o.size || size == 0 {
+		return []byte{}, nil
+	}
+	end := offset + uint64(size)
+	if end > o.size {
+		end = o.size
+	}
+	first, last := offset/BlockSize, (end-1)/BlockSize
+	entries, err := o.readEntries(first, last)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, end-offset)
+	for j, e := range entries {
+		i := first + uint64(j)
+		plain, err := o.readBlock(i, e)
+		if err != nil {
+			return nil, err
+		}
+		from, to := i*BlockSize, i*BlockSize+uint64(len(plain))
+		if offset > from {
+			from = offset
+		}
+		if end < to {
+			to = end
+		}
+		buf = append(buf, plain[from-i*BlockSize:to-i*BlockSize]...)
+	}
+	return buf, nil
+}
+
+// Write write uncompressed data start at offset, blocks partially covered by data are read and compressed again;
+// blocks in the same part of stored data written by parts are written at a time, gaps between them are zeros
+func (o *Object) Write(data []byte, offset uint64) error {
+
+	if len(data) == 0 {
+		return nil
+	}
+	end := offset + uint64(len(data))
+	size := o.size
+	if end > size {
+		size = end
+	}
+	first, last := offset/BlockSize, (end-1)/BlockSize
+	entries, err := o.readEntries(first, last)
+	if err != nil {
+		return err
+	}
+
+	index := make([]byte, len(entries)*IndexEntrySize)
+	var delta int64
+	ps := o.partSize()
+	var buf []byte
+	var bufStart uint64
+	for j, old := range entries {
+		i := first + uint64(j)
+		blockStart := i * BlockSize
+		plain := make([]byte, blockLen(i, size))
+		if (offset > blockStart || end < blockStart+uint64(len(plain))) && old.codec != 0 {
+			prev, err := o.readBlock(i, old)
+			if err != nil {
+				return err
+			}
+			copy(plain, prev)
+		}
+		from, to := blockStart, blockStart+uint64(len(plain))
+		if offset > from {
+			from = offset
+		}
+		if end < to {
+			to = end
+		}
+		copy(plain[from-blockStart:], data[from-offset:to-offset])
+
+		stored, e, err := encode(plain)
+		if err != nil {
+			return err
+		}
+		if len(buf) > 0 && (ps == 0 || bufStart/ps != blockStart/ps) {
+			if err := o.raw.Write(buf, bufStart); err != nil {
+				return err
+			}
+			buf = nil
+		}
+		if len(buf) == 0 {
+			bufStart = blockStart
+		}
+		buf = append(buf, make([]byte, blockStart-bufStart-uint64(len(buf)))...)
+		buf = append(buf, stored...)
+		e.encode(index[j*IndexEntrySize:])
+		delta += int64(e.length) - int64(old.length)
+	}
+	if err := o.raw.Write(buf, bufStart); err != nil {
+		return err
+	}
+	// blocks are written before their entries, a failed write leaves the old entries
+	if err := o.index.Write(index, first*IndexEntrySize); err != nil {
+		return err
+	}
+	o.size = size
+	o.delta += delta
+	return nil
+}
+
+// WriteFile write a file-like start at offset
+func (o *Object) WriteFile(offset int64, file *multipart.FileHeader) error {
+
+	inputFile, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	// blocks are compressed once if the file is written from the start of a block,
+	// and parts of stored data written by parts are written once if they are made of whole blocks
+	size := uint64(20 * BlockSize)
+	if ps := o.partSize(); ps > 0 && ps%BlockSize == 0 {
+		size = ps
+	}
+	chunk := make([]byte, size)
+	if r := uint64(offset) % size; r != 0 {
+		chunk = chunk[:size-r]
+	}
+	for written := int64(0); written < file.Size; {
+		n, err := io.ReadFull(inputFile, chunk)
+		if n > 0 {
+			if err := o.Write(chunk[:n], uint64(offset+written)); err != nil {
+				return err
+			}
+			written += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		chunk = chunk[:cap(chunk)]
+	}
+	return nil
+}
+
+// StepWriteFunc return function writing uncompressed data from offset to end(included) by steps, used by gin Stream()
+func (o *Object) StepWriteFunc(offset, end uint64) (radosio.StepWriteFunc, error) {
+
+	const step = 20 * BlockSize // 5MB
+
+	if end > o.size {
+		return nil, errors.New("invalid input param, the reading range is beyond the size of the object")
+	}
+	return func(w io.Writer) bool {
+		n := uint64(step)
+		if offset+n > end+1 {
+			n = end + 1 - offset
+		}
+		data, err := o.Read(offset, uint(n))
+		if err != nil || len(data) == 0 {
+			o.Close()
+			return false
+		}
+		if _, err := w.Write(data); err != nil {
+			o.Close()
+			return false
+		}
+		offset += uint64(len(data))
+		if offset > end {
+			o.Close()
+			return false
+		}
+		return true
+	}, nil
+}
+
+// Delete delete stored data and its index
+func (o *Object) Delete() error {
+
+	if err := o.raw.Delete(); err != nil {
+		return err
+	}
+	return o.index.Delete()
+}
+
+// Close close stored data and its index
+func (o *Object) Close() error {
+
+	err := o.raw.Close()
+	if err2 := o.index.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,203 @@
+package compress_test
+
+import (
+	"bytes"
+	"harbor/utils/storages/compress"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// memRaw in-memory stored data, read as zeros beyond the end like rados
+type memRaw struct {
+	data    []byte
+	written uint64 // bytes written
+}
+
+func (r *memRaw) Read(offset uint64, size uint) ([]byte, error) {
+
+	buf := make([]byte, size)
+	if offset < uint64(len(r.data)) {
+		copy(buf, r.data[offset:])
+	}
+	return buf, nil
+}
+
+func (r *memRaw) Write(data []byte, offset uint64) error {
+
+	if end := int(offset) + len(data); end > len(r.data) {
+		r.data = append(r.data, make([]byte, end-len(r.data))...)
+	}
+	copy(r.data[offset:], data)
+	r.written += uint64(len(data))
+	return nil
+}
+
+func (r *memRaw) Delete() error {
+
+	r.data = nil
+	return nil
+}
+
+func (r *memRaw) Close() error {
+	return nil
+}
+
+// partRaw stored data written by parts, counting writes
+type partRaw struct {
+	memRaw
+	writes int
+}
+
+func (r *partRaw) Write(data []byte, offset uint64) error {
+
+	r.writes++
+	return r.memRaw.Write(data, offset)
+}
+
+func (r *partRaw) PartSize() uint64 {
+	return 4 * compress.BlockSize
+}
+
+// text return n bytes of compressible text
+func text(n int) []byte {
+
+	var buf bytes.Buffer
+	for buf.Len() < n {
+		buf.WriteString(strings.Repeat("harbor,", rand.Intn(5)+1))
+		buf.WriteString("1234\n")
+	}
+	return buf.Bytes()[:n]
+}
+
+func TestObject(t *testing.T) {
+
+	raw, index := &memRaw{}, &memRaw{}
+	var want []byte
+
+	size := uint64(0)
+	var stored int64
+	for i, w := range []struct {
+		offset, n int
+		random    bool
+	}{
+		{0, 100, false},                                         // partial block
+		{50, 10, false},                                         // inside
+		{600000, 1000, false},                                   // beyond the end, leaving a hole
+		{300000, compress.BlockSize, true},                      // incompressible across blocks
+		{compress.BlockSize - 1, 3, false},                      // across block boundary
+		{4 * compress.BlockSize, 2 * compress.BlockSize, false}, // aligned
+	} {
+		data := text(w.n)
+		if w.random {
+			rand.Read(data)
+		}
+		if end := w.offset + w.n; end > len(want) {
+			want = append(want, make([]byte, end-len(want))...)
+		}
+		copy(want[w.offset:], data)
+
+		o := compress.NewObject(raw, index, size)
+		if err := o.Write(data, uint64(w.offset)); err != nil {
+			t.Fatalf("write %d: %s", i, err)
+		}
+		size = o.GetObjSize()
+		if size != uint64(len(want)) {
+			t.Fatalf("write %d: got size %d, want %d", i, size, len(want))
+		}
+		if uint64(len(raw.data)) > size {
+			t.Fatalf("write %d: stored data %d is beyond the end %d", i, len(raw.data), size)
+		}
+		stored += o.StoredSizeDelta()
+	}
+	if stored <= 0 || stored >= int64(len(want))/2 {
+		t.Errorf("got stored size %d of %d bytes mostly compressible", stored, len(want))
+	}
+
+	o := compress.NewObject(raw, index, size)
+	got, err := o.Read(0, uint(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("read data is not equal to written")
+	}
+	for _, r := range []struct{ offset, n uint64 }{
+		{0, 1}, {compress.BlockSize - 5, 10}, {500000, 200000}, {size - 1, 10}, {size, 10},
+	} {
+		got, err := o.Read(r.offset, uint(r.n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		end := r.offset + r.n
+		if end > size {
+			end = size
+		}
+		if !bytes.Equal(got, want[r.offset:end]) {
+			t.Errorf("read %d bytes at %d: not equal", r.n, r.offset)
+		}
+	}
+
+	var buf bytes.Buffer
+	step, err := o.StepWriteFunc(1000, size-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for step(&buf) {
+	}
+	if !bytes.Equal(buf.Bytes(), want[1000:]) {
+		t.Error("step read data is not equal")
+	}
+
+	// rewriting a whole block does not read it
+	written := raw.written
+	if err := o.Write(text(compress.BlockSize), compress.BlockSize); err != nil {
+		t.Fatal(err)
+	}
+	if raw.written-written >= compress.BlockSize/2 {
+		t.Errorf("compressible block is written in %d bytes", raw.written-written)
+	}
+
+	// corrupted block
+	for i := 0; i < 64; i++ {
+		raw.data[10+i] ^= 0xff
+	}
+	if _, err := o.Read(0, 100); err == nil {
+		t.Error("corrupted block should not be read")
+	}
+}
+
+func TestPartWrite(t *testing.T) {
+
+	raw, index := &partRaw{}, &memRaw{}
+	want := text(8*compress.BlockSize + 100)
+	o := compress.NewObject(raw, index, 0)
+	if err := o.Write(want[:100], 0); err != nil {
+		t.Fatal(err)
+	}
+	raw.writes = 0
+	if err := o.Write(want[50:], 50); err != nil {
+		t.Fatal(err)
+	}
+	if raw.writes != 3 {
+		t.Errorf("blocks of 3 parts are written by %d writes", raw.writes)
+	}
+	got, err := compress.NewObject(raw, index, o.GetObjSize()).Read(0, uint(len(want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("read data is not equal to written")
+	}
+}
+
+func TestIndexSize(t *testing.T) {
+
+	for _, c := range []struct{ size, want uint64 }{
+		{0, 0}, {1, 8}, {compress.BlockSize, 8}, {compress.BlockSize + 1, 16},
+	} {
+		if got := compress.IndexSize(c.size); got != c.want {
+			t.Errorf("IndexSize(%d) = %d, want %d", c.size, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+// Package encrypt encryption at rest of object data in a chunked AEAD format.
+//
+// Data is split into segments of SegmentSize bytes, each segment is sealed by AES-256-GCM with the data key
+// of the object, a random nonce and its index as additional data, and stored as nonce|ciphertext|tag at
+// index*(SegmentSize+Overhead), so that any range of data is read or written by the segments it covers.
+// All segments are full except the last one. Segments skipped by writing beyond the end are sealed as zeros,
+// so that every segment of data is authenticated, and stored zeros are not accepted as data.
+//
+// Data keys are random per object and stored wrapped by a master key from config, or by a customer-provided key.
+package encrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"harbor/config"
+	"harbor/utils/storages/radosio"
+	"io"
+	"mime/multipart"
+)
+
+const (
+	// SegmentSize size of plaintext of a segment
+	SegmentSize = 64 * 1024
+	// NonceSize size of nonce of a segment
+	NonceSize = 12
+	// Overhead stored bytes of a segment besides its plaintext, nonce and tag
+	Overhead = NonceSize + 16
+	// KeySize size of data keys and master keys, AES-256
+	KeySize = 32
+
+	storedSegmentSize = SegmentSize + Overhead
+)
+
+var (
+	// ErrAuth returned if stored data can not be decrypted, it is corrupted or the data key is wrong
+	ErrAuth = errors.New("encrypted data authentication failed")
+	// ErrKeyMismatch returned if a wrapped data key can not be unwrapped by the key
+	ErrKeyMismatch = errors.New("the key does not match the one data is encrypted with")
+)
+
+// StoredSize return size of stored data of size bytes plaintext
+func StoredSize(size uint64) uint64 {
+
+	segments := (size + SegmentSize - 1) / SegmentSize
+	return size + segments*Overhead
+}
+
+// PlainSize return size of plaintext of stored data of storedSize bytes, inverse of StoredSize
+func PlainSize(storedSize uint64) uint64 {
+
+	overhead := (storedSize + storedSegmentSize - 1) / storedSegmentSize * Overhead
+	if overhead > storedSize {
+		return 0
+	}
+	return storedSize - overhead
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key should be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewDataKey return a random data key
+func NewDataKey() ([]byte, error) {
+
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WrapKey encrypt dataKey by key, return it in base64
+func WrapKey(key, dataKey []byte) (string, error) {
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, dataKey, nil)), nil
+}
+
+// UnwrapKey decrypt data key wrapped by WrapKey, return ErrKeyMismatch if it is not wrapped by key
+func UnwrapKey(key []byte, wrapped string) ([]byte, error) {
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(b) < aead.NonceSize() {
+		return nil, errors.New("wrapped data key is invalid")
+	}
+	dataKey, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrKeyMismatch
+	}
+	return dataKey, nil
+}
+
+// Keyring master keys by id, the active key wraps new data keys
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+// NewKeyring return keyring of master keys, the first one is active
+func NewKeyring(keys []config.EncryptionKeyConfig) (*Keyring, error) {
+
+	k := &Keyring{keys: map[string][]byte{}}
+	for i, c := range keys {
+		key, err := base64.StdEncoding.DecodeString(c.Key)
+		if err != nil || len(key) != KeySize {
+			return nil, fmt.Errorf("encryption key '%s' should be %d bytes encoded in base64", c.KeyID, KeySize)
+		}
+		if i == 0 {
+			k.active = c.KeyID
+		}
+		k.keys[c.KeyID] = key
+	}
+	return k, nil
+}
+
+// ConfiguredKeyring return keyring of master keys in config "encryption"
+func ConfiguredKeyring() (*Keyring, error) {
+
+	return NewKeyring(config.GetConfigs().Encryption.Keys)
+}
+
+// Enabled return true if there is any master key
+func (k *Keyring) Enabled() bool {
+
+	return k.active != ""
+}
+
+// ActiveID return id of the active key, "" if there is no key
+func (k *Keyring) ActiveID() string {
+
+	return k.active
+}
+
+// Has return true if key of keyID is in keyring
+func (k *Keyring) Has(keyID string) bool {
+
+	_, ok := k.keys[keyID]
+	return ok
+}
+
+// Wrap wrap dataKey by the active key, return id of the key and the wrapped data key
+func (k *Keyring) Wrap(dataKey []byte) (keyID, wrapped string, err error) {
+
+	if !k.Enabled() {
+		return "", "", errors.New("no encryption key is configured")
+	}
+	wrapped, err = WrapKey(k.keys[k.active], dataKey)
+	return k.active, wrapped, err
+}
+
+// Unwrap unwrap data key wrapped by key of keyID
+func (k *Keyring) Unwrap(keyID, wrapped string) ([]byte, error) {
+
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("encryption key '%s' is not configured", keyID)
+	}
+	return UnwrapKey(key, wrapped)
+}
+
+// RawIO read and write stored data, implemented by *radosio.CephHarborObject
+type RawIO interface {
+	Read(offset uint64, size uint) ([]byte, error)
+	Write(data []byte, offset uint64) error
+	Delete() error
+	Close() error
+}
+
+// Object read and write plaintext of an object whose stored data is encrypted
+type Object struct {
+	raw  RawIO
+	aead cipher.AEAD
+	size uint64 // size of plaintext stored
+}
+
+// NewObject return Object of stored data raw encrypted by dataKey,
+// size is the size of plaintext stored, segments beyond it are not read
+func NewObject(raw RawIO, dataKey []byte, size uint64) (*Object, error) {
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Object{raw: raw, aead: aead, size: size}, nil
+}
+
+// GetObjSize return size of plaintext
+func (o *Object) GetObjSize() uint64 {
+
+	return o.size
+}
+
+// segmentLen return size of plaintext of segment i in data of size bytes
+func segmentLen(i, size uint64) uint64 {
+
+	start := i * SegmentSize
+	if start >= size {
+		return 0
+	}
+	if size-start > SegmentSize {
+		return SegmentSize
+	}
+	return size - start
+}
+
+func additionalData(i uint64) []byte {
+
+	ad := make([]byte, 8)
+	binary.BigEndian.PutUint64(ad, i)
+	return ad
+}
+
+func (o *Object) seal(i uint64, plain []byte) ([]byte, error) {
+
+	sealed := make([]byte, NonceSize, NonceSize+len(plain)+o.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, sealed); err != nil {
+		return nil, err
+	}
+	return o.aead.Seal(sealed, sealed, plain, additionalData(i)), nil
+}
+
+func (o *Object) open(i uint64, stored []byte) ([]byte, error) {
+
+	if len(stored) < Overhead {
+		return nil, ErrAuth
+	}
+	plain, err := o.aead.Open(nil, stored[:NonceSize], stored[NonceSize:], additionalData(i))
+	if err != nil {
+		return nil, ErrAuth
+	}
+	return plain, nil
+}
+
+// readSegments return plaintext of segments first to last of the stored data
+func (o *Object) readSegments(first, last uint64) ([]byte, error) {
+
+	lens := make([]uint64, 0, last-first+1)
+	var storedLen uint64
+	for i := first; i <= last; i++ {
+		n := segmentLen(i, o.size)
+		lens = append(lens, n)
+		storedLen += n + Overhead
+	}
+	stored, err := o.raw.Read(first*storedSegmentSize, uint(storedLen))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(stored)) < storedLen {
+		// not written, zeros fail authentication
+		stored = append(stored, make([]byte, storedLen-uint64(len(stored)))...)
+	}
+
+	var buf bytes.Buffer
+	for j, n := range lens {
+		plain, err := o.open(first+uint64(j), stored[:n+Overhead])
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %s", first+uint64(j), err)
+		}
+		buf.Write(plain)
+		stored = stored[n+Overhead:]
+	}
+	return buf.Bytes(), nil
+}
+
+// Read read plaintext of size bytes start at offset, []byte{} at end of object
+func (o *Object) Read(offset uint64, size uint) ([]byte, error) {
+
+	if offset >= o.size || size == 0 {
+		return []byte{}, nil
+	}
+	end := offset + uint64(size)
+	if end > o.size {
+		end = o.size
+	}
+	first, last := offset/SegmentSize, (end-1)/SegmentSize
+	plain, err := o.readSegments(first, last)
+	if err != nil {
+		return nil, err
+	}
+	start := offset - first*SegmentSize
+	return plain[start : start+end-offset], nil
+}
+
+// Write write plaintext data start at offset, segments partially covered by data are read and sealed again
+func (o *Object) Write(data []byte, offset uint64) error {
+
+	if len(data) == 0 {
+		return nil
+	}
+	end := offset + uint64(len(data))
+	size := o.size
+	if end > size {
+		size = end
+	}
+	first, last := offset/SegmentSize, (end-1)/SegmentSize
+
+	// the last segment is partial, it is filled up with zeros as segments after it are written
+	if tail := o.size / SegmentSize; o.size%SegmentSize != 0 && tail < first {
+		plain, err := o.readSegments(tail, tail)
+		if err != nil {
+			return err
+		}
+		sealed, err := o.seal(tail, append(plain, make([]byte, SegmentSize-len(plain))...))
+		if err != nil {
+			return err
+		}
+		if err := o.raw.Write(sealed, tail*storedSegmentSize); err != nil {
+			return err
+		}
+	}
+	// segments skipped are sealed as zeros, by batches of segments
+	const batch = 160
+	zeros := make([]byte, SegmentSize)
+	for i := (o.size + SegmentSize - 1) / SegmentSize; i < first; {
+		buf := make([]byte, 0, batch*storedSegmentSize)
+		j := i
+		for ; j < first && j < i+batch; j++ {
+			sealed, err := o.seal(j, zeros)
+			if err != nil {
+				return err
+			}
+			buf = append(buf, sealed...)
+		}
+		if err := o.raw.Write(buf, i*storedSegmentSize); err != nil {
+			return err
+		}
+		i = j
+	}
+
+	buf := make([]byte, 0, (last-first+1)*storedSegmentSize)
+	for i := first; i <= last; i++ {
+		segStart := i * SegmentSize
+		plain := make([]byte, segmentLen(i, size))
+		if (offset > segStart || end < segStart+uint64(len(plain))) && segmentLen(i, o.size) > 0 {
+			old, err := o.readSegments(i, i)
+			if err != nil {
+				return err
+			}
+			copy(plain, old)
+		}
+		from, to := segStart, segStart+uint64(len(plain))
+		if offset > from {
+			from = offset
+		}
+		if end < to {
+			to = end
+		}
+		copy(plain[from-segStart:], data[from-offset:to-offset])
+		sealed, err := o.seal(i, plain)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, sealed...)
+	}
+	if err := o.raw.Write(buf, first*storedSegmentSize); err != nil {
+		return err
+	}
+	o.size = size
+	return nil
+}
+
+// WriteFile write a file-like start at offset
+func (o *Object) WriteFile(offset int64, file *multipart.FileHeader) error {
+
+	inputFile, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	// multiple of SegmentSize, so that segments are not sealed twice
+	chunk := make([]byte, 160*SegmentSize)
+	for written := int64(0); written < file.Size; {
+		n, err := io.ReadFull(inputFile, chunk)
+		if n > 0 {
+			if err := o.Write(chunk[:n], uint64(offset+written)); err != nil {
+				return err
+			}
+			written += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StepWriteFunc return function writing plaintext from offset to end(included) by steps, used by gin Stream()
+func (o *Object) StepWriteFunc(offset, end uint64) (radosio.StepWriteFunc, error) {
+
+	const step = 80 * SegmentSize // 5MB
+
+	if end > o.size {
+		return nil, errors.New("invalid input param, the reading range is beyond the size of the object")
+	}
+	return func(w io.Writer) bool {
+		n := uint64(step)
+		if offset+n > end+1 {
+			n = end + 1 - offset
+		}
+		data, err := o.Read(offset, uint(n))
+		if err != nil || len(data) == 0 {
+			o.Close()
+			return false
+		}
+		if _, err := w.Write(data); err != nil {
+			o.Close()
+			return false
+		}
+		offset += uint64(len(data))
+		if offset > end {
+			o.Close()
+			return false
+		}
+		return true
+	}, nil
+}
+
+// Delete delete stored data
+func (o *Object) Delete() error {
+
+	return o.raw.Delete()
+}
+
+// Close close stored data
+func (o *Object) Close() error {
+
+	return o.raw.Close()
+}
@@ -0,0 +1,175 @@
+package encrypt_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"harbor/config"
+	"harbor/utils/storages/encrypt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// memRaw in-memory stored data, read as zeros beyond the end like rados
+type memRaw struct {
+	data []byte
+}
+
+func (r *memRaw) Read(offset uint64, size uint) ([]byte, error) {
+
+	buf := make([]byte, size)
+	if offset < uint64(len(r.data)) {
+		copy(buf, r.data[offset:])
+	}
+	return buf, nil
+}
+
+func (r *memRaw) Write(data []byte, offset uint64) error {
+
+	if end := int(offset) + len(data); end > len(r.data) {
+		r.data = append(r.data, make([]byte, end-len(r.data))...)
+	}
+	copy(r.data[offset:], data)
+	return nil
+}
+
+func (r *memRaw) Delete() error {
+
+	r.data = nil
+	return nil
+}
+
+func (r *memRaw) Close() error {
+	return nil
+}
+
+func TestObject(t *testing.T) {
+
+	key, _ := encrypt.NewDataKey()
+	raw := &memRaw{}
+	var want []byte
+
+	size := uint64(0)
+	for i, w := range []struct{ offset, n int }{
+		{0, 100},                     // partial segment
+		{50, 10},                     // inside
+		{200000, 1000},               // beyond the end, leaving a hole
+		{70000, encrypt.SegmentSize}, // fill the hole across segments
+		{encrypt.SegmentSize - 1, 3}, // across segment boundary
+		{3 * encrypt.SegmentSize, 2 * encrypt.SegmentSize}, // aligned
+	} {
+		data := make([]byte, w.n)
+		rand.Read(data)
+		if end := w.offset + w.n; end > len(want) {
+			want = append(want, make([]byte, end-len(want))...)
+		}
+		copy(want[w.offset:], data)
+
+		o, err := encrypt.NewObject(raw, key, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := o.Write(data, uint64(w.offset)); err != nil {
+			t.Fatalf("write %d: %s", i, err)
+		}
+		if i == 2 && bytes.Count(raw.data[encrypt.SegmentSize+encrypt.Overhead:][:encrypt.SegmentSize], []byte{0}) == encrypt.SegmentSize {
+			t.Fatalf("write %d: segments of the hole should be sealed", i)
+		}
+		size = o.GetObjSize()
+		if size != uint64(len(want)) {
+			t.Fatalf("write %d: got size %d, want %d", i, size, len(want))
+		}
+		if got := encrypt.StoredSize(size); got != uint64(len(raw.data)) {
+			t.Fatalf("write %d: got stored size %d, want %d", i, len(raw.data), got)
+		}
+	}
+	if encrypt.PlainSize(uint64(len(raw.data))) != size {
+		t.Errorf("PlainSize should be inverse of StoredSize")
+	}
+
+	o, _ := encrypt.NewObject(raw, key, size)
+	for _, r := range [][2]int{{0, len(want)}, {0, 1}, {99, 200}, {encrypt.SegmentSize - 5, 10}, {150000, 60000}, {len(want) - 3, 100}} {
+		got, err := o.Read(uint64(r[0]), uint(r[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		end := r[0] + r[1]
+		if end > len(want) {
+			end = len(want)
+		}
+		if !bytes.Equal(got, want[r[0]:end]) {
+			t.Errorf("read %d+%d: data mismatch", r[0], r[1])
+		}
+	}
+	if got, _ := o.Read(size, 10); len(got) != 0 {
+		t.Errorf("read at end should return no data")
+	}
+
+	var buf bytes.Buffer
+	step, err := o.StepWriteFunc(10, size-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for step(&buf) {
+	}
+	if !bytes.Equal(buf.Bytes(), want[10:]) {
+		t.Errorf("stepped read data mismatch")
+	}
+
+	// tampered
+	raw.data[encrypt.NonceSize+1] ^= 1
+	if _, err := o.Read(0, 10); err == nil || !strings.Contains(err.Error(), encrypt.ErrAuth.Error()) {
+		t.Errorf("got error %v, want %s", err, encrypt.ErrAuth)
+	}
+	// zeros are not accepted as data
+	stored := raw.data[encrypt.SegmentSize+encrypt.Overhead:][:encrypt.SegmentSize+encrypt.Overhead]
+	copy(stored, make([]byte, len(stored)))
+	if _, err := o.Read(encrypt.SegmentSize, 10); err == nil || !strings.Contains(err.Error(), encrypt.ErrAuth.Error()) {
+		t.Errorf("got error %v, want %s", err, encrypt.ErrAuth)
+	}
+	other, _ := encrypt.NewDataKey()
+	o, _ = encrypt.NewObject(raw, other, size)
+	if _, err := o.Read(encrypt.SegmentSize, 10); err == nil {
+		t.Errorf("read by wrong key should fail")
+	}
+}
+
+func TestKeyring(t *testing.T) {
+
+	k1, k2 := make([]byte, 32), make([]byte, 32)
+	rand.Read(k1)
+	rand.Read(k2)
+	old, err := encrypt.NewKeyring([]config.EncryptionKeyConfig{{KeyID: "k1", Key: base64.StdEncoding.EncodeToString(k1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, _ := encrypt.NewDataKey()
+	kid, wrapped, err := old.Wrap(dataKey)
+	if err != nil || kid != "k1" {
+		t.Fatalf("got %s, %v", kid, err)
+	}
+
+	rotated, err := encrypt.NewKeyring([]config.EncryptionKeyConfig{
+		{KeyID: "k2", Key: base64.StdEncoding.EncodeToString(k2)},
+		{KeyID: "k1", Key: base64.StdEncoding.EncodeToString(k1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.ActiveID() != "k2" {
+		t.Errorf("the first key should be active")
+	}
+	got, err := rotated.Unwrap(kid, wrapped)
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("unwrap by old key failed: %v", err)
+	}
+	if _, err := rotated.Unwrap("k3", wrapped); err == nil {
+		t.Errorf("unwrap by unknown key should fail")
+	}
+	if _, err := encrypt.UnwrapKey(k2, wrapped); err != encrypt.ErrKeyMismatch {
+		t.Errorf("got error %v, want %s", err, encrypt.ErrKeyMismatch)
+	}
+	if _, err := encrypt.NewKeyring([]config.EncryptionKeyConfig{{KeyID: "short", Key: "c2hvcnQ="}}); err == nil {
+		t.Errorf("short key should be invalid")
+	}
+}
@@ -0,0 +1,28 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package filesystem
+
+import (
+	"syscall"
+)
+
+// GetDiskUsage return usage of the file system containing path
+func GetDiskUsage(path string) (DiskUsage, error) {
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return DiskUsage{}, err
+	}
+	bsize := uint64(st.Bsize)
+	u := DiskUsage{
+		Path:       path,
+		Total:      uint64(st.Blocks) * bsize,
+		Free:       uint64(st.Bfree) * bsize,
+		Available:  uint64(st.Bavail) * bsize,
+		Inodes:     uint64(st.Files),
+		InodesFree: uint64(st.Ffree),
+	}
+	u.Used = u.Total - u.Free
+	return u, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package filesystem
+
+import (
+	"errors"
+)
+
+// GetDiskUsage return usage of the file system containing path
+func GetDiskUsage(path string) (DiskUsage, error) {
+
+	return DiskUsage{}, errors.New("disk usage is not supported on this platform")
+}
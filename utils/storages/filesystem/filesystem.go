@@ -2,16 +2,47 @@ package filesystem
 
 import (
 	"errors"
+	"harbor/utils/logger"
+	"harbor/utils/metrics"
 	"io"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 // FileStorage manage write or read file on local file system
 type FileStorage struct {
 	Filename   string
 	UploadPath string
+	Log        *logrus.Entry // request-scoped logger
+}
+
+// WithLogger set request-scoped logger and return the storage
+func (fs *FileStorage) WithLogger(l *logrus.Entry) *FileStorage {
+
+	fs.Log = l
+	return fs
+}
+
+// observe record metrics and log of operation
+func (fs FileStorage) observe(operation string, start time.Time, err *error) {
+
+	metrics.ObserveStorage("filesystem", operation, start, err)
+	logger.Operation(fs.Log, "filesystem", operation, start, *err, logrus.Fields{"file": fs.Filename})
+}
+
+// DiskUsage usage of a file system in bytes
+type DiskUsage struct {
+	Path       string `json:"path"`
+	Total      uint64 `json:"total"`
+	Used       uint64 `json:"used"`
+	Free       uint64 `json:"free"`
+	Available  uint64 `json:"available"` // free space for unprivileged users
+	Inodes     uint64 `json:"inodes"`
+	InodesFree uint64 `json:"inodes_free"`
 }
 
 // GetFilename return file path name
@@ -21,7 +52,9 @@ func (fs FileStorage) GetFilename() string {
 }
 
 // WriteFile write a file-like to a file
-func (fs FileStorage) WriteFile(offset int64, file *multipart.FileHeader) error {
+func (fs FileStorage) WriteFile(offset int64, file *multipart.FileHeader) (err error) {
+
+	defer fs.observe("write", time.Now(), &err)
 
 	inputFile, err := file.Open()
 	if err != nil {
@@ -75,7 +108,9 @@ func (fs FileStorage) OpenOrCreateFile(filename string) (*os.File, error) {
 }
 
 // Write write bytes to a file
-func (fs FileStorage) Write(offset int64, data []byte) error {
+func (fs FileStorage) Write(offset int64, data []byte) (err error) {
+
+	defer fs.observe("write", time.Now(), &err)
 
 	fileName := fs.GetFilename()
 	saveFile, err := fs.OpenOrCreateFile(fileName)
@@ -108,6 +143,8 @@ func (fs FileStorage) writeChunk(file *os.File, offset int64, data []byte) error
 // Read read bytes from a file
 func (fs FileStorage) Read(offset int64, size int32) (data []byte, err error) {
 
+	defer fs.observe("read", time.Now(), &err)
+
 	fileName := fs.GetFilename()
 	file, err := os.Open(fileName)
 	if err != nil {
@@ -153,11 +190,12 @@ func (fs FileStorage) FileSize() int64 {
 }
 
 // Delete remove a file
-func (fs FileStorage) Delete() error {
+func (fs FileStorage) Delete() (err error) {
+
+	defer fs.observe("delete", time.Now(), &err)
 
 	fileName := fs.GetFilename()
-	err := os.Remove(fileName)
-	if err != nil && !os.IsNotExist(err) {
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	return nil
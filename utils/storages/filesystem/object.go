@@ -0,0 +1,126 @@
+package filesystem
+
+import (
+	"errors"
+	"harbor/utils/storages/radosio"
+	"io"
+	"mime/multipart"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Object read and write data of an object as a file of FileStorage
+type Object struct {
+	fs   *FileStorage
+	size uint64
+}
+
+// Object return Object of data of the file with size
+func (fs *FileStorage) Object(size uint64) *Object {
+
+	return &Object{fs: fs, size: size}
+}
+
+// WithLogger set request-scoped logger and return the object
+func (o *Object) WithLogger(l *logrus.Entry) *Object {
+
+	o.fs.WithLogger(l)
+	return o
+}
+
+// GetObjSize return size of object
+func (o *Object) GetObjSize() uint64 {
+
+	return o.size
+}
+
+// Read read size bytes at offset, the data is truncated at the end of object;
+// data not written in the file is zeros
+func (o *Object) Read(offset uint64, size uint) (data []byte, err error) {
+
+	if offset >= o.size || size == 0 {
+		return []byte{}, nil
+	}
+	defer o.fs.observe("read", time.Now(), &err)
+
+	end := offset + uint64(size)
+	if end > o.size {
+		end = o.size
+	}
+	data = make([]byte, end-offset)
+	file, err := os.Open(o.fs.GetFilename())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return data, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.ReadAt(data, int64(offset)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Write write data at offset
+func (o *Object) Write(data []byte, offset uint64) error {
+
+	if err := o.fs.Write(int64(offset), data); err != nil {
+		return err
+	}
+	if end := offset + uint64(len(data)); end > o.size {
+		o.size = end
+	}
+	return nil
+}
+
+// WriteFile write a file-like at offset
+func (o *Object) WriteFile(offset int64, file *multipart.FileHeader) error {
+
+	if err := o.fs.WriteFile(offset, file); err != nil {
+		return err
+	}
+	if end := uint64(offset + file.Size); end > o.size {
+		o.size = end
+	}
+	return nil
+}
+
+// StepWriteFunc return function writing data from offset to end(included) to w by steps
+func (o *Object) StepWriteFunc(offset, end uint64) (radosio.StepWriteFunc, error) {
+
+	step := uint64(5 * 1024 * 1024)
+
+	if end > o.size {
+		return nil, errors.New("invalid input param, the reading range is beyond the size of the object")
+	}
+	return func(w io.Writer) bool {
+		n := step
+		if offset+n > end+1 {
+			n = end + 1 - offset
+		}
+		data, err := o.Read(offset, uint(n))
+		if err != nil || len(data) == 0 {
+			return false
+		}
+		if _, err := w.Write(data); err != nil {
+			return false
+		}
+		offset += uint64(len(data))
+		return offset <= end
+	}, nil
+}
+
+// Delete remove the file
+func (o *Object) Delete() error {
+
+	return o.fs.Delete()
+}
+
+// Close do nothing, the file is opened by each operation
+func (o *Object) Close() error {
+
+	return nil
+}
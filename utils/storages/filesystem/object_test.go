@@ -0,0 +1,59 @@
+package filesystem_test
+
+import (
+	"bytes"
+	"harbor/utils/storages/filesystem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestObject(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "harbor-filesystem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := &filesystem.FileStorage{Filename: "1_1", UploadPath: dir}
+	o := fs.Object(100)
+	if data, err := o.Read(0, 10); err != nil || !bytes.Equal(data, make([]byte, 10)) {
+		t.Errorf("data not written should be zeros, got %v %v", data, err)
+	}
+
+	if err := o.Write([]byte("hello"), 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Write([]byte("world"), 200); err != nil {
+		t.Fatal(err)
+	}
+	if o.GetObjSize() != 205 {
+		t.Errorf("got size %d, want 205", o.GetObjSize())
+	}
+	want := make([]byte, 205)
+	copy(want[10:], "hello")
+	copy(want[200:], "world")
+	if data, err := o.Read(8, 1000); err != nil || !bytes.Equal(data, want[8:]) {
+		t.Errorf("read is not equal, %v", err)
+	}
+
+	var buf bytes.Buffer
+	step, err := o.StepWriteFunc(5, 204)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for step(&buf) {
+	}
+	if !bytes.Equal(buf.Bytes(), want[5:]) {
+		t.Error("step read data is not equal")
+	}
+
+	if err := o.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1_1")); !os.IsNotExist(err) {
+		t.Errorf("file should be removed, %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package storages
+
+import (
+	"fmt"
+	"harbor/utils/storages/filesystem"
+	"harbor/utils/storages/multidisk"
+	"harbor/utils/storages/radosio"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// backends of config "storage.backend"
+const (
+	BackendCeph       = "ceph"
+	BackendFilesystem = "filesystem"
+	BackendMultiDisk  = "multidisk"
+	BackendS3         = "s3"
+)
+
+// Backend return name of the configured storage backend
+func Backend() string {
+
+	if b := strings.ToLower(configs.Storage.Backend); b != "" {
+		return b
+	}
+	return BackendCeph
+}
+
+// CheckHealth return error if the storage backend is unavailable,
+// for ceph the cluster stats is got, for filesystem a file is written to the upload dir,
+// for multidisk a file is written to each dir, and enough dirs for writes should be available,
+// for s3 the bucket is requested
+func CheckHealth() error {
+
+	switch b := Backend(); b {
+	case BackendCeph:
+		api, err := NewCephHarborObject("", 0).GetRados()
+		if err != nil {
+			return err
+		}
+		// connect before calling methods of value receiver, so that the connection is shared and closed
+		if _, err := api.GetConn(); err != nil {
+			return err
+		}
+		defer api.Close()
+		_, err = api.GetClusterStats()
+		return err
+	case BackendFilesystem:
+		dir := getUploadPath()
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		f, err := ioutil.TempFile(dir, ".health-")
+		if err != nil {
+			return err
+		}
+		name := f.Name()
+		_, err = f.Write([]byte("ok"))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		os.Remove(name)
+		return err
+	case BackendMultiDisk:
+		s, err := MultiDisk()
+		if err != nil {
+			return err
+		}
+		available := 0
+		var problems []string
+		for _, st := range s.CheckDirs() {
+			if st.Available {
+				available++
+			} else {
+				problems = append(problems, st.Error)
+			}
+		}
+		if available < s.WriteQuorum() {
+			return fmt.Errorf("%d of %d dirs are available, %d are needed for writes: %s",
+				available, len(problems)+available, s.WriteQuorum(), strings.Join(problems, "; "))
+		}
+		return nil
+	case BackendS3:
+		s, err := S3()
+		if err != nil {
+			return err
+		}
+		return s.CheckHealth()
+	default:
+		return fmt.Errorf("unknown storage backend '%s'", b)
+	}
+}
+
+// ClusterStats ceph cluster usage
+type ClusterStats struct {
+	KB         uint64 `json:"kb"`
+	KBUsed     uint64 `json:"kb_used"`
+	KBAvail    uint64 `json:"kb_avail"`
+	NumObjects uint64 `json:"num_objects"`
+}
+
+// CephDiagnostics ceph cluster diagnostics
+type CephDiagnostics struct {
+	ClusterName string          `json:"cluster_name"`
+	PoolName    string          `json:"pool_name"`
+	Stats       *ClusterStats   `json:"stats,omitempty"`
+	StatsError  string          `json:"stats_error,omitempty"`
+	IOStat      *radosio.IOStat `json:"iostat,omitempty"`
+	IOStatError string          `json:"iostat_error,omitempty"`
+}
+
+// Diagnostics storage backend diagnostics
+type Diagnostics struct {
+	Backend   string                `json:"backend"`
+	Ceph      *CephDiagnostics      `json:"ceph,omitempty"`
+	Disk      *filesystem.DiskUsage `json:"disk,omitempty"`
+	DiskError string                `json:"disk_error,omitempty"`
+	MultiDisk *MultiDiskDiagnostics `json:"multidisk,omitempty"`
+	S3        *S3Diagnostics        `json:"s3,omitempty"`
+}
+
+// MultiDiskDiagnostics multidisk storage diagnostics
+type MultiDiskDiagnostics struct {
+	Mode        string                `json:"mode,omitempty"`
+	Shards      int                   `json:"shards,omitempty"`       // shards of a stripe
+	WriteQuorum int                   `json:"write_quorum,omitempty"` // shards must be written
+	Dirs        []multidisk.DirStatus `json:"dirs,omitempty"`
+	Error       string                `json:"error,omitempty"`
+}
+
+// S3Diagnostics s3 storage diagnostics
+type S3Diagnostics struct {
+	Endpoint string `json:"endpoint"`
+	Bucket   string `json:"bucket"`
+	Prefix   string `json:"prefix,omitempty"`
+	PartSize uint64 `json:"part_size,omitempty"` // bytes of data in each s3 object
+	Error    string `json:"error,omitempty"`
+}
+
+// GetDiagnostics return usage and io status of the storage backend, errors of items are set in the result
+func GetDiagnostics() *Diagnostics {
+
+	d := &Diagnostics{Backend: Backend()}
+	switch d.Backend {
+	case BackendCeph:
+		c := configs.CephRados
+		cd := &CephDiagnostics{ClusterName: c.ClusterName, PoolName: c.PoolName}
+		d.Ceph = cd
+		api, err := NewCephHarborObject("", 0).GetRados()
+		if err == nil {
+			_, err = api.GetConn()
+		}
+		if err != nil {
+			cd.StatsError = err.Error()
+			cd.IOStatError = err.Error()
+			break
+		}
+		defer api.Close()
+		if st, err := api.GetClusterStats(); err != nil {
+			cd.StatsError = err.Error()
+		} else {
+			cd.Stats = &ClusterStats{KB: st.Kb, KBUsed: st.Kb_used, KBAvail: st.Kb_avail, NumObjects: st.Num_objects}
+		}
+		if io, err := api.GetIOStat(); err != nil {
+			cd.IOStatError = err.Error()
+		} else {
+			cd.IOStat = &io
+		}
+	case BackendFilesystem:
+		if u, err := filesystem.GetDiskUsage(getUploadPath()); err != nil {
+			d.DiskError = err.Error()
+		} else {
+			d.Disk = &u
+		}
+	case BackendMultiDisk:
+		md := &MultiDiskDiagnostics{}
+		d.MultiDisk = md
+		s, err := MultiDisk()
+		if err != nil {
+			md.Error = err.Error()
+			break
+		}
+		md.Mode, md.Shards, md.WriteQuorum = s.Mode(), s.Width(), s.WriteQuorum()
+		md.Dirs = s.CheckDirs()
+	case BackendS3:
+		c := configs.Storage.S3
+		sd := &S3Diagnostics{Endpoint: c.Endpoint, Bucket: c.Bucket, Prefix: c.Prefix}
+		d.S3 = sd
+		s, err := S3()
+		if err == nil {
+			sd.PartSize = s.PartSize()
+			err = s.CheckHealth()
+		}
+		if err != nil {
+			sd.Error = err.Error()
+		}
+	}
+	return d
+}
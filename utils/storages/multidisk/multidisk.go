@@ -0,0 +1,644 @@
+// Package multidisk store data of objects across several local directories, usually mount points of different disks,
+// so that the data can still be read when some of them fail. Data of an object is split into stripes, each stripe is
+// encoded into shards by Reed-Solomon erasure coding or copied to replicas, and each shard of the object is stored in
+// file "{dir}/{key}" of a different directory. Shards of a stripe are stored in records with a checksum and
+// a generation, damaged or stale shards are reconstructed from others when read, and rewritten by Repair.
+package multidisk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"harbor/utils/storages/filesystem"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// modes of storing stripes
+const (
+	ModeErasure = "erasure"
+	ModeReplica = "replica"
+)
+
+// ShardSize bytes of data of a stripe stored in each shard
+const ShardSize = 64 * 1024
+
+// record of a shard of a stripe: magic(4) generation(4) length(4) crc32c(4) data(ShardSize),
+// length is bytes of object data in the stripe, crc is of generation, length and data
+const (
+	headerSize = 16
+	recordSize = headerSize + ShardSize
+)
+
+var (
+	magic    = []byte("HBSD")
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Options of storage, zero values are replaced by defaults
+type Options struct {
+	Dirs         []string // directories of shards, order should not be changed as shards are placed by it
+	Mode         string   // ModeErasure(default) or ModeReplica
+	DataShards   int      // erasure only, default number of dirs - ParityShards
+	ParityShards int      // erasure only, default 2, or 1 for less than 4 dirs
+	Replicas     int      // replica only, default number of dirs
+}
+
+// Storage data of objects stored across directories
+type Storage struct {
+	dirs   []string
+	data   int                 // data shards of a stripe, 1 for replicas
+	parity int                 // shards can be lost
+	enc    reedsolomon.Encoder // nil for replicas
+}
+
+// New return a storage of opts
+func New(opts Options) (*Storage, error) {
+
+	n := len(opts.Dirs)
+	if n < 2 {
+		return nil, errors.New("at least 2 dirs are required")
+	}
+	s := &Storage{dirs: opts.Dirs}
+	switch strings.ToLower(opts.Mode) {
+	case "", ModeErasure:
+		s.parity = opts.ParityShards
+		if s.parity == 0 {
+			s.parity = 2
+			if n < 4 {
+				s.parity = 1
+			}
+		}
+		s.data = opts.DataShards
+		if s.data == 0 {
+			s.data = n - s.parity
+		}
+		if s.data < 1 || s.parity < 1 || s.data+s.parity > n {
+			return nil, fmt.Errorf("%d data shards and %d parity shards do not fit in %d dirs", s.data, s.parity, n)
+		}
+		enc, err := reedsolomon.New(s.data, s.parity)
+		if err != nil {
+			return nil, err
+		}
+		s.enc = enc
+	case ModeReplica:
+		replicas := opts.Replicas
+		if replicas == 0 {
+			replicas = n
+		}
+		if replicas < 2 || replicas > n {
+			return nil, fmt.Errorf("%d replicas do not fit in %d dirs", replicas, n)
+		}
+		s.data, s.parity = 1, replicas-1
+	default:
+		return nil, fmt.Errorf("unknown mode '%s'", opts.Mode)
+	}
+	return s, nil
+}
+
+// Mode return ModeErasure or ModeReplica
+func (s *Storage) Mode() string {
+
+	if s.enc == nil {
+		return ModeReplica
+	}
+	return ModeErasure
+}
+
+// Width return number of shards of a stripe
+func (s *Storage) Width() int {
+
+	return s.data + s.parity
+}
+
+// StripeSize return bytes of object data in a stripe
+func (s *Storage) StripeSize() uint64 {
+
+	return uint64(s.data) * ShardSize
+}
+
+// WriteQuorum return number of shards must be written for a write to succeed, so that data written can be read back;
+// writes succeed while parity shards are lost, shards not written are restored by Repair
+func (s *Storage) WriteQuorum() int {
+
+	return s.data
+}
+
+// shardPaths return file paths of shards of key in order, shards are placed in dirs from one chosen by hash of key,
+// so that load is spread when there are more dirs than shards
+func (s *Storage) shardPaths(key string) []string {
+
+	n := len(s.dirs)
+	start := int(crc32.ChecksumIEEE([]byte(key)) % uint32(n))
+	paths := make([]string, s.Width())
+	for i := range paths {
+		paths[i] = filepath.Join(s.dirs[(start+i)%n], key)
+	}
+	return paths
+}
+
+// dirAvailable return error if dir of shard path is not an existing directory
+func dirAvailable(path string) error {
+
+	dir := filepath.Dir(path)
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// states of a shard record
+const (
+	shardMissing = iota // shard can not be read, e.g. its dir is missing
+	shardEmpty          // record is not written
+	shardCorrupt        // record is written partially or its checksum mismatches
+	shardOK
+)
+
+// record of a shard of a stripe read from file
+type record struct {
+	state  int
+	gen    uint32
+	length uint32
+	data   []byte
+}
+
+func putRecord(buf []byte, gen, length uint32, shard []byte) {
+
+	copy(buf, magic)
+	binary.BigEndian.PutUint32(buf[4:], gen)
+	binary.BigEndian.PutUint32(buf[8:], length)
+	copy(buf[headerSize:recordSize], shard)
+	crc := crc32.Update(crc32.Checksum(buf[4:12], crcTable), crcTable, buf[headerSize:recordSize])
+	binary.BigEndian.PutUint32(buf[12:], crc)
+}
+
+func parseRecord(buf []byte) record {
+
+	if len(buf) == 0 || len(buf) >= 4 && binary.BigEndian.Uint32(buf) == 0 {
+		return record{state: shardEmpty}
+	}
+	if len(buf) < recordSize || string(buf[:4]) != string(magic) {
+		return record{state: shardCorrupt}
+	}
+	crc := crc32.Update(crc32.Checksum(buf[4:12], crcTable), crcTable, buf[headerSize:recordSize])
+	if crc != binary.BigEndian.Uint32(buf[12:]) {
+		return record{state: shardCorrupt}
+	}
+	return record{
+		state:  shardOK,
+		gen:    binary.BigEndian.Uint32(buf[4:]),
+		length: binary.BigEndian.Uint32(buf[8:]),
+		data:   buf[headerSize:recordSize],
+	}
+}
+
+// stripeRecords return records of shards of the i-th stripe in records read by readRecords
+func stripeRecords(recs [][]record, i int) []record {
+
+	r := make([]record, len(recs))
+	for j := range recs {
+		r[j] = recs[j][i]
+	}
+	return r
+}
+
+// readRecords return records of n stripes from stripe first of each shard of paths, indexed by shard
+func readRecords(paths []string, first uint64, n int, headerOnly bool) [][]record {
+
+	recs := make([][]record, len(paths))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			recs[i] = readShard(path, first, n, headerOnly)
+		}(i, path)
+	}
+	wg.Wait()
+	return recs
+}
+
+// readShard return records of n stripes from stripe first in file path, a missing file is empty if its dir exists
+func readShard(path string, first uint64, n int, headerOnly bool) []record {
+
+	recs := make([]record, n)
+	f, err := os.Open(path)
+	if err != nil {
+		state := shardMissing
+		if os.IsNotExist(err) && dirAvailable(path) == nil {
+			state = shardEmpty
+		}
+		for i := range recs {
+			recs[i].state = state
+		}
+		return recs
+	}
+	defer f.Close()
+
+	if headerOnly {
+		buf := make([]byte, headerSize)
+		for i := range recs {
+			m, err := f.ReadAt(buf, int64(first+uint64(i))*recordSize)
+			switch {
+			case m == headerSize && string(buf[:4]) == string(magic):
+				recs[i] = record{state: shardOK, gen: binary.BigEndian.Uint32(buf[4:]), length: binary.BigEndian.Uint32(buf[8:])}
+			case err != nil && err != io.EOF:
+				recs[i].state = shardMissing
+			default:
+				recs[i].state = shardEmpty
+			}
+		}
+		return recs
+	}
+
+	buf := make([]byte, n*recordSize)
+	m, err := f.ReadAt(buf, int64(first)*recordSize)
+	if err != nil && err != io.EOF {
+		for i := range recs {
+			recs[i].state = shardMissing
+		}
+		return recs
+	}
+	buf = buf[:m]
+	for i := range recs {
+		start := i * recordSize
+		if start > len(buf) {
+			start = len(buf)
+		}
+		end := start + recordSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+		recs[i] = parseRecord(buf[start:end])
+	}
+	return recs
+}
+
+// stripe decoded from records of its shards
+type stripe struct {
+	hole   bool     // no shard is written, data is zeros
+	gen    uint32   // generation of the latest write
+	length uint32   // bytes of object data
+	shards [][]byte // all shards of the stripe, nil for a hole
+	bad    []int    // shards missing, corrupted or stale
+}
+
+// stripeData return object data in stripe st
+func (s *Storage) stripeData(st *stripe) []byte {
+
+	data := make([]byte, s.StripeSize())
+	if !st.hole {
+		for i := 0; i < s.data; i++ {
+			copy(data[i*ShardSize:], st.shards[i])
+		}
+	}
+	return data
+}
+
+// decode return stripe of records of its shards, shards of the latest generation are used,
+// error is returned if there are not enough of them
+func (s *Storage) decode(recs []record) (*stripe, error) {
+
+	st := &stripe{}
+	ok, corrupt, empty := 0, 0, 0
+	for _, r := range recs {
+		switch r.state {
+		case shardOK:
+			if ok == 0 || r.gen > st.gen {
+				st.gen, st.length, ok = r.gen, r.length, 0
+			}
+			if r.gen == st.gen {
+				ok++
+			}
+		case shardCorrupt:
+			corrupt++
+		case shardEmpty:
+			empty++
+		}
+	}
+	// a write leaves at most parity shards not written, more empty shards are a hole never written
+	if ok == 0 && corrupt == 0 && empty > s.parity {
+		st.hole = true
+		return st, nil
+	}
+	if ok < s.data {
+		return nil, fmt.Errorf("%d of %d shards are available, %d are needed", ok, len(recs), s.data)
+	}
+
+	st.shards = make([][]byte, len(recs))
+	for i, r := range recs {
+		if r.state == shardOK && r.gen == st.gen {
+			st.shards[i] = r.data
+		} else {
+			st.bad = append(st.bad, i)
+		}
+	}
+	if len(st.bad) == 0 {
+		return st, nil
+	}
+	if s.enc == nil {
+		var good []byte
+		for _, shard := range st.shards {
+			if shard != nil {
+				good = shard
+				break
+			}
+		}
+		for _, i := range st.bad {
+			st.shards[i] = good
+		}
+		return st, nil
+	}
+	if err := s.enc.Reconstruct(st.shards); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// encode return shards of data of a stripe
+func (s *Storage) encode(data []byte) ([][]byte, error) {
+
+	shards := make([][]byte, s.Width())
+	if s.enc == nil {
+		for i := range shards {
+			shards[i] = data
+		}
+		return shards, nil
+	}
+	for i := range shards {
+		if i < s.data {
+			shards[i] = data[i*ShardSize : (i+1)*ShardSize]
+		} else {
+			shards[i] = make([]byte, ShardSize)
+		}
+	}
+	if err := s.enc.Encode(shards); err != nil {
+		return nil, err
+	}
+	return shards, nil
+}
+
+// writeShards write buffers of records to shard files at offset of stripe first concurrently, buffers of nil are skipped;
+// files are created but their dirs are not, return number of shards written and the first error
+func writeShards(paths []string, bufs [][]byte, first uint64) (int, error) {
+
+	errs := make([]error, len(paths))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		if bufs[i] == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			errs[i] = writeShard(path, bufs[i], first)
+		}(i, path)
+	}
+	wg.Wait()
+	n := 0
+	var firstErr error
+	for i, err := range errs {
+		if bufs[i] == nil {
+			continue
+		}
+		if err == nil {
+			n++
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return n, firstErr
+}
+
+func writeShard(path string, buf []byte, first uint64) error {
+
+	if err := dirAvailable(path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt(buf, int64(first)*recordSize)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// shardsSize return the largest size of shard files of key, exists is false if there is no shard file
+func (s *Storage) shardsSize(key string) (size int64, exists bool, err error) {
+
+	for _, path := range s.shardPaths(key) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, false, err
+		}
+		exists = true
+		if fi.Size() > size {
+			size = fi.Size()
+		}
+	}
+	return size, exists, nil
+}
+
+// Stat return size of data of key, it is the end of data written in the last stripe, or the end of the last stripe
+// if it can not be decoded, e.g. some shard files are removed; exists is false if there is no shard file of key
+func (s *Storage) Stat(key string) (size uint64, exists bool, err error) {
+
+	fileSize, exists, err := s.shardsSize(key)
+	if err != nil || !exists || fileSize == 0 {
+		return 0, exists, err
+	}
+	last := uint64((fileSize - 1) / recordSize)
+	st, err := s.decode(stripeRecords(readRecords(s.shardPaths(key), last, 1, false), 0))
+	if err != nil {
+		return (last + 1) * s.StripeSize(), true, nil
+	}
+	return last*s.StripeSize() + uint64(st.length), true, nil
+}
+
+// Remove delete shard files of key, it is not an error if they do not exist;
+// error is returned if a dir is unavailable, so that removing is tried again before the dir comes back
+func (s *Storage) Remove(key string) error {
+
+	var firstErr error
+	for _, path := range s.shardPaths(key) {
+		err := os.Remove(path)
+		if err == nil {
+			continue
+		}
+		if os.IsNotExist(err) {
+			if err = dirAvailable(path); err == nil {
+				continue
+			}
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Keys call fn with each key having shard files in available dirs in order, stop and return the error if fn returns an error
+func (s *Storage) Keys(fn func(key string) error) error {
+
+	keys := map[string]bool{}
+	for _, dir := range s.dirs {
+		names, err := readDirNames(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, name := range names {
+			if !strings.HasPrefix(name, ".") {
+				keys[name] = true
+			}
+		}
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	for _, key := range sorted {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readDirNames(dir string) ([]string, error) {
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+// DirStatus status of a directory of shards
+type DirStatus struct {
+	Path      string                `json:"path"`
+	Available bool                  `json:"available"` // a file can be written in it
+	Error     string                `json:"error,omitempty"`
+	Usage     *filesystem.DiskUsage `json:"usage,omitempty"`
+}
+
+// CheckDirs return status of dirs, a file is written to each dir to check it
+func (s *Storage) CheckDirs() []DirStatus {
+
+	statuses := make([]DirStatus, len(s.dirs))
+	for i, dir := range s.dirs {
+		st := &statuses[i]
+		st.Path = dir
+		err := dirAvailable(filepath.Join(dir, ".health"))
+		if err == nil {
+			err = writeProbe(dir)
+		}
+		if err != nil {
+			st.Error = err.Error()
+			continue
+		}
+		st.Available = true
+		if u, err := filesystem.GetDiskUsage(dir); err == nil {
+			st.Usage = &u
+		}
+	}
+	return statuses
+}
+
+func writeProbe(dir string) error {
+
+	f, err := ioutil.TempFile(dir, ".health-")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_, err = f.Write([]byte("ok"))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	os.Remove(name)
+	return err
+}
+
+// RepairResult result of checking and repairing shards of a key
+type RepairResult struct {
+	Key      string `json:"key"`
+	Stripes  int    `json:"stripes"`
+	Damaged  int    `json:"damaged"`  // stripes with shards missing, corrupted or stale, but can be reconstructed
+	Repaired int    `json:"repaired"` // damaged stripes whose bad shards are rewritten
+	Lost     int    `json:"lost"`     // stripes without enough shards to be reconstructed
+	Error    string `json:"error,omitempty"`
+}
+
+// Repair check shards of all stripes of key, and rewrite bad shards of damaged stripes
+// by reconstructed data if fix is true; shards in unavailable dirs can not be repaired
+func (s *Storage) Repair(key string, fix bool) (*RepairResult, error) {
+
+	const batch = 64
+
+	r := &RepairResult{Key: key}
+	fileSize, exists, err := s.shardsSize(key)
+	if err != nil || !exists {
+		return r, err
+	}
+	stripes := uint64((fileSize + recordSize - 1) / recordSize)
+	r.Stripes = int(stripes)
+	paths := s.shardPaths(key)
+	for first := uint64(0); first < stripes; first += batch {
+		n := int(stripes - first)
+		if n > batch {
+			n = batch
+		}
+		recs := readRecords(paths, first, n, false)
+		for i := 0; i < n; i++ {
+			st, err := s.decode(stripeRecords(recs, i))
+			if err != nil {
+				r.Lost++
+				r.Error = fmt.Sprintf("stripe %d: %s", first+uint64(i), err)
+				continue
+			}
+			if len(st.bad) == 0 {
+				continue
+			}
+			r.Damaged++
+			if !fix {
+				continue
+			}
+			bufs := make([][]byte, len(paths))
+			for _, j := range st.bad {
+				bufs[j] = make([]byte, recordSize)
+				putRecord(bufs[j], st.gen, st.length, st.shards[j])
+			}
+			if written, err := writeShards(paths, bufs, first+uint64(i)); written == len(st.bad) {
+				r.Repaired++
+			} else {
+				r.Error = fmt.Sprintf("stripe %d: %s", first+uint64(i), err)
+			}
+		}
+	}
+	return r, nil
+}
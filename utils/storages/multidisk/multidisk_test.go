@@ -0,0 +1,245 @@
+package multidisk_test
+
+import (
+	"bytes"
+	"harbor/utils/storages/multidisk"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tempDirs return root temp dir and n dirs in it
+func tempDirs(t *testing.T, n int) (string, []string) {
+
+	root, err := ioutil.TempDir("", "harbor-multidisk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := make([]string, n)
+	for i := range dirs {
+		dirs[i] = filepath.Join(root, string(rune('a'+i)))
+		if err := os.Mkdir(dirs[i], 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, dirs
+}
+
+// write data at offsets to object key, return the expected content
+func write(t *testing.T, s *multidisk.Storage, key string, want []byte, writes [][2]int) []byte {
+
+	for i, w := range writes {
+		data := make([]byte, w[1])
+		rand.Read(data)
+		size := uint64(len(want))
+		if end := w[0] + w[1]; end > len(want) {
+			want = append(want, make([]byte, end-len(want))...)
+		}
+		copy(want[w[0]:], data)
+		if err := s.Object(key, size).Write(data, uint64(w[0])); err != nil {
+			t.Fatalf("write %d: %s", i, err)
+		}
+	}
+	return want
+}
+
+func checkRead(t *testing.T, s *multidisk.Storage, key string, want []byte) {
+
+	t.Helper()
+	o := s.Object(key, uint64(len(want)))
+	got, err := o.Read(0, uint(len(want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("read data is not equal to written")
+	}
+	size, exists, err := s.Stat(key)
+	if err != nil || !exists || size != uint64(len(want)) {
+		t.Errorf("got stat %d %v %v, want size %d", size, exists, err, len(want))
+	}
+}
+
+// shardFiles return shard files of key in dirs
+func shardFiles(dirs []string, key string) []string {
+
+	var files []string
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, key)); err == nil {
+			files = append(files, filepath.Join(dir, key))
+		}
+	}
+	return files
+}
+
+func TestErasure(t *testing.T) {
+
+	root, dirs := tempDirs(t, 7)
+	defer os.RemoveAll(root)
+	s, err := multidisk.New(multidisk.Options{Dirs: dirs, DataShards: 4, ParityShards: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := int(s.StripeSize())
+	want := write(t, s, "1_1", nil, [][2]int{
+		{0, 100},         // partial stripe
+		{50, 10},         // inside
+		{3*ss + 7, 1000}, // beyond the end, leaving a hole
+		{ss - 1, ss + 2}, // across stripes
+		{5 * ss, 2 * ss}, // aligned
+		{7*ss - 3, 6},    // extend
+	})
+	checkRead(t, s, "1_1", want)
+	if files := shardFiles(dirs, "1_1"); len(files) != 6 {
+		t.Fatalf("got %d shard files, want 6", len(files))
+	}
+
+	o := s.Object("1_1", uint64(len(want)))
+	for _, r := range [][2]int{{0, 1}, {ss - 5, 10}, {2 * ss, ss}, {len(want) - 1, 10}} {
+		got, err := o.Read(uint64(r[0]), uint(r[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		end := r[0] + r[1]
+		if end > len(want) {
+			end = len(want)
+		}
+		if !bytes.Equal(got, want[r[0]:end]) {
+			t.Errorf("read %d bytes at %d: not equal", r[1], r[0])
+		}
+	}
+	var buf bytes.Buffer
+	step, err := o.StepWriteFunc(1000, uint64(len(want)-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for step(&buf) {
+	}
+	if !bytes.Equal(buf.Bytes(), want[1000:]) {
+		t.Error("step read data is not equal")
+	}
+
+	// two shards lost, one corrupted
+	files := shardFiles(dirs, "1_1")
+	for _, f := range files[:2] {
+		os.Rename(filepath.Dir(f), filepath.Dir(f)+".gone")
+	}
+	checkRead(t, s, "1_1", want)
+	data, _ := ioutil.ReadFile(files[2])
+	data[100] ^= 0xff
+	ioutil.WriteFile(files[2], data, 0644)
+	if _, err := s.Object("1_1", uint64(len(want))).Read(0, 10); err == nil {
+		t.Fatal("stripe with three bad shards should not be read")
+	}
+	if err := s.Object("1_1", uint64(len(want))).Write([]byte("x"), 0); err == nil {
+		t.Error("write should fail if the stripe can not be read")
+	}
+	os.Rename(filepath.Dir(files[0])+".gone", filepath.Dir(files[0]))
+
+	// stale shard of the dir missing during a write is not read
+	want = write(t, s, "1_1", want, [][2]int{{10, 2 * ss}})
+	os.Rename(filepath.Dir(files[1])+".gone", filepath.Dir(files[1]))
+	checkRead(t, s, "1_1", want)
+
+	r, err := s.Repair("1_1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Damaged == 0 || r.Repaired != 0 || r.Lost != 0 {
+		t.Fatalf("got check result %+v", r)
+	}
+	if r, err = s.Repair("1_1", true); err != nil || r.Repaired != r.Damaged {
+		t.Fatalf("got repair result %+v %v", r, err)
+	}
+	if r, err = s.Repair("1_1", false); err != nil || r.Damaged != 0 || r.Lost != 0 || r.Stripes != 8 {
+		t.Fatalf("got result %+v %v after repair", r, err)
+	}
+	// any two shards can be lost after repair
+	for _, f := range files[4:] {
+		os.Remove(f)
+	}
+	checkRead(t, s, "1_1", want)
+}
+
+func TestLostWrite(t *testing.T) {
+
+	root, dirs := tempDirs(t, 4)
+	defer os.RemoveAll(root)
+	s, err := multidisk.New(multidisk.Options{Dirs: dirs, DataShards: 2, ParityShards: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// written while two dirs are offline, then the other two dirs are lost
+	for _, dir := range dirs[:2] {
+		os.Rename(dir, dir+".gone")
+	}
+	want := write(t, s, "1_3", nil, [][2]int{{0, 1000}})
+	for _, dir := range dirs[:2] {
+		os.Rename(dir+".gone", dir)
+	}
+	for _, dir := range dirs[2:] {
+		os.Rename(dir, dir+".gone")
+	}
+	if _, err := s.Object("1_3", uint64(len(want))).Read(0, 10); err == nil {
+		t.Error("stripe written only to the lost dirs should not be read as a hole")
+	}
+}
+
+func TestReplica(t *testing.T) {
+
+	root, dirs := tempDirs(t, 3)
+	defer os.RemoveAll(root)
+	s, err := multidisk.New(multidisk.Options{Dirs: dirs, Mode: multidisk.ModeReplica, Replicas: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := write(t, s, "1_2", nil, [][2]int{{0, 3*multidisk.ShardSize + 5}})
+	files := shardFiles(dirs, "1_2")
+	if len(files) != 2 {
+		t.Fatalf("got %d replicas, want 2", len(files))
+	}
+	os.Remove(files[0])
+	checkRead(t, s, "1_2", want)
+	if r, err := s.Repair("1_2", true); err != nil || r.Damaged != 4 || r.Repaired != 4 {
+		t.Fatalf("got repair result %+v %v", r, err)
+	}
+	os.Remove(files[1])
+	checkRead(t, s, "1_2", want)
+
+	var keys []string
+	s.Keys(func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if len(keys) != 1 || keys[0] != "1_2" {
+		t.Errorf("got keys %v", keys)
+	}
+	if err := s.Remove("1_2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists, err := s.Stat("1_2"); exists || err != nil {
+		t.Errorf("removed key exists %v %v", exists, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+
+	root, dirs := tempDirs(t, 3)
+	defer os.RemoveAll(root)
+	for _, opts := range []multidisk.Options{
+		{Dirs: dirs[:1]},
+		{Dirs: dirs, DataShards: 3},
+		{Dirs: dirs, Mode: multidisk.ModeReplica, Replicas: 4},
+		{Dirs: dirs, Mode: "raid"},
+	} {
+		if _, err := multidisk.New(opts); err == nil {
+			t.Errorf("options %+v should be invalid", opts)
+		}
+	}
+	s, err := multidisk.New(multidisk.Options{Dirs: dirs})
+	if err != nil || s.Width() != 3 || s.WriteQuorum() != 2 {
+		t.Errorf("default erasure of 3 dirs should have 2 data shards and 1 parity shard, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package multidisk
+
+import (
+	"errors"
+	"fmt"
+	"harbor/utils/logger"
+	"harbor/utils/metrics"
+	"harbor/utils/storages/radosio"
+	"io"
+	"mime/multipart"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Object read and write data of an object key in storage
+type Object struct {
+	s     *Storage
+	key   string
+	size  uint64
+	paths []string
+	log   *logrus.Entry
+}
+
+// Object return Object of data of key with size
+func (s *Storage) Object(key string, size uint64) *Object {
+
+	return &Object{s: s, key: key, size: size, paths: s.shardPaths(key)}
+}
+
+// WithLogger set request-scoped logger and return the object
+func (o *Object) WithLogger(l *logrus.Entry) *Object {
+
+	o.log = l
+	return o
+}
+
+// observe record metrics and log of operation
+func (o *Object) observe(operation string, start time.Time, err *error) {
+
+	metrics.ObserveStorage("multidisk", operation, start, err)
+	logger.Operation(o.log, "multidisk", operation, start, *err, logrus.Fields{"key": o.key})
+}
+
+// warnDamaged log stripes read with bad shards, they are reconstructed and should be repaired
+func (o *Object) warnDamaged(first uint64, damaged int) {
+
+	if damaged > 0 {
+		logger.Or(o.log).WithFields(logrus.Fields{"key": o.key, "stripe": first, "damaged": damaged}).
+			Warn("stripes have shards missing or damaged, run \"multidisk repair\"")
+	}
+}
+
+// GetObjSize return size of object
+func (o *Object) GetObjSize() uint64 {
+
+	return o.size
+}
+
+// Read read size bytes at offset, the data is truncated at the end of object
+func (o *Object) Read(offset uint64, size uint) (data []byte, err error) {
+
+	if offset >= o.size || size == 0 {
+		return []byte{}, nil
+	}
+	defer o.observe("read", time.Now(), &err)
+
+	end := offset + uint64(size)
+	if end > o.size {
+		end = o.size
+	}
+	ss := o.s.StripeSize()
+	first := offset / ss
+	n := int((end-1)/ss - first + 1)
+	recs := readRecords(o.paths, first, n, false)
+	data = make([]byte, 0, end-offset)
+	damaged := 0
+	for i := 0; i < n; i++ {
+		index := first + uint64(i)
+		st, err := o.s.decode(stripeRecords(recs, i))
+		if err != nil {
+			return nil, fmt.Errorf("read stripe %d of %s: %s", index, o.key, err)
+		}
+		if len(st.bad) > 0 {
+			damaged++
+		}
+		stripeData := o.s.stripeData(st)
+		start, stop := index*ss, (index+1)*ss
+		if offset > start {
+			stripeData = stripeData[offset-start:]
+		}
+		if end < stop {
+			stripeData = stripeData[:uint64(len(stripeData))-(stop-end)]
+		}
+		data = append(data, stripeData...)
+	}
+	o.warnDamaged(first, damaged)
+	return data, nil
+}
+
+// Write write data at offset, stripes partially written are read and encoded again;
+// it fails if less than WriteQuorum shards are written
+func (o *Object) Write(data []byte, offset uint64) (err error) {
+
+	if len(data) == 0 {
+		return nil
+	}
+	defer o.observe("write", time.Now(), &err)
+
+	ss := o.s.StripeSize()
+	end := offset + uint64(len(data))
+	first := offset / ss
+	n := int((end-1)/ss - first + 1)
+	headers := readRecords(o.paths, first, n, true)
+
+	bufs := make([][]byte, len(o.paths))
+	for j := range bufs {
+		bufs[j] = make([]byte, n*recordSize)
+	}
+	for i := 0; i < n; i++ {
+		index := first + uint64(i)
+		start, stop := index*ss, (index+1)*ss
+		var stripeData []byte
+		var gen, length uint32
+		if (offset > start || end < stop) && start < o.size {
+			// partially written, stripes beyond the end are zeros
+			st, err := o.s.decode(stripeRecords(readRecords(o.paths, index, 1, false), 0))
+			if err != nil {
+				return fmt.Errorf("read stripe %d of %s: %s", index, o.key, err)
+			}
+			stripeData, gen, length = o.s.stripeData(st), st.gen, st.length
+		} else {
+			stripeData = make([]byte, ss)
+			for _, r := range stripeRecords(headers, i) {
+				if r.state == shardOK && r.gen > gen {
+					gen = r.gen
+				}
+			}
+		}
+		from, to := uint64(0), ss
+		if offset > start {
+			from = offset - start
+		}
+		if end < stop {
+			to = end - start
+		}
+		copy(stripeData[from:to], data[start+from-offset:])
+		if uint32(to) > length {
+			length = uint32(to)
+		}
+
+		shards, err := o.s.encode(stripeData)
+		if err != nil {
+			return err
+		}
+		for j, shard := range shards {
+			putRecord(bufs[j][i*recordSize:], gen+1, length, shard)
+		}
+	}
+
+	written, err := writeShards(o.paths, bufs, first)
+	if written < o.s.WriteQuorum() {
+		return fmt.Errorf("write %s: %d of %d shards are written, %d are needed: %v", o.key, written, len(o.paths), o.s.WriteQuorum(), err)
+	}
+	if err != nil {
+		logger.Or(o.log).WithError(err).WithField("key", o.key).Warn("shards are not written, run \"multidisk repair\"")
+	}
+	if end > o.size {
+		o.size = end
+	}
+	return nil
+}
+
+// WriteFile write a file-like at offset
+func (o *Object) WriteFile(offset int64, file *multipart.FileHeader) error {
+
+	inputFile, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	// stripes are encoded once if the file is written from the start of a stripe
+	ss := int64(o.s.StripeSize())
+	chunk := make([]byte, (10*1024*1024/ss+1)*ss)
+	if r := offset % ss; r != 0 {
+		chunk = chunk[:ss-r]
+	}
+	for written := int64(0); written < file.Size; {
+		n, err := io.ReadFull(inputFile, chunk)
+		if n > 0 {
+			if err := o.Write(chunk[:n], uint64(offset+written)); err != nil {
+				return err
+			}
+			written += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		chunk = chunk[:cap(chunk)]
+	}
+	return nil
+}
+
+// StepWriteFunc return function writing data from offset to end(included) to w by steps
+func (o *Object) StepWriteFunc(offset, end uint64) (radosio.StepWriteFunc, error) {
+
+	step := (5*1024*1024/o.s.StripeSize() + 1) * o.s.StripeSize()
+
+	if end > o.size {
+		return nil, errors.New("invalid input param, the reading range is beyond the size of the object")
+	}
+	return func(w io.Writer) bool {
+		n := step - offset%o.s.StripeSize()
+		if offset+n > end+1 {
+			n = end + 1 - offset
+		}
+		data, err := o.Read(offset, uint(n))
+		if err != nil || len(data) == 0 {
+			return false
+		}
+		if _, err := w.Write(data); err != nil {
+			return false
+		}
+		offset += uint64(len(data))
+		return offset <= end
+	}, nil
+}
+
+// Delete delete shard files of object
+func (o *Object) Delete() (err error) {
+
+	defer o.observe("delete", time.Now(), &err)
+	return o.s.Remove(o.key)
+}
+
+// Close do nothing, files are closed after each operation
+func (o *Object) Close() error {
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package radosio
+
+import (
+	"sync"
+
+	"github.com/ceph/go-ceph/rados"
+)
+
+// openConns connections to ceph cluster which are not closed, they are shut down by CloseAll at exit
+var openConns = struct {
+	sync.Mutex
+	m map[*rados.Conn]struct{}
+}{m: map[*rados.Conn]struct{}{}}
+
+func trackConn(conn *rados.Conn) {
+
+	openConns.Lock()
+	openConns.m[conn] = struct{}{}
+	openConns.Unlock()
+}
+
+// untrackConn return false if the connection has been shut down
+func untrackConn(conn *rados.Conn) bool {
+
+	openConns.Lock()
+	defer openConns.Unlock()
+	if _, ok := openConns.m[conn]; !ok {
+		return false
+	}
+	delete(openConns.m, conn)
+	return true
+}
+
+// OpenConns return number of connections which are not closed
+func OpenConns() int {
+
+	openConns.Lock()
+	defer openConns.Unlock()
+	return len(openConns.m)
+}
+
+// CloseAll shut down all connections to ceph cluster which are not closed
+func CloseAll() {
+
+	openConns.Lock()
+	conns := openConns.m
+	openConns.m = map[*rados.Conn]struct{}{}
+	openConns.Unlock()
+
+	for conn := range conns {
+		conn.Shutdown()
+	}
+}
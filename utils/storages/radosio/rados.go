@@ -4,12 +4,18 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"harbor/utils/logger"
+	"harbor/utils/metrics"
 	"io"
 	"math"
 	"mime/multipart"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ceph/go-ceph/rados"
+	"github.com/sirupsen/logrus"
 )
 
 // SizePerRadosObj 每个rados object 最大2Gb
@@ -173,6 +179,7 @@ type RadosAPI struct {
 	confFile    string
 	keyringFile string
 	conn        *rados.Conn
+	log         *logrus.Entry
 }
 
 // NewRadosAPI return *RadosAPI
@@ -197,6 +204,19 @@ func NewRadosAPI(clusterName, userName, poolName, confFile, keyringFile string)
 
 }
 
+// SetLogger set request-scoped logger, operations are logged with its fields
+func (r *RadosAPI) SetLogger(l *logrus.Entry) {
+
+	r.log = l
+}
+
+// observe record metrics and log of operation on object
+func (r RadosAPI) observe(operation, objID string, start time.Time, err *error) {
+
+	metrics.ObserveStorage("rados", operation, start, err)
+	logger.Operation(r.log, "rados", operation, start, *err, logrus.Fields{"pool": r.poolName, "object": objID})
+}
+
 // newConn return a connect to ceph cluster
 func (r RadosAPI) newConn() (*rados.Conn, error) {
 
@@ -214,6 +234,7 @@ func (r RadosAPI) newConn() (*rados.Conn, error) {
 	if err != nil {
 		return nil, errors.New("connect to ceph cluster error")
 	}
+	trackConn(conn)
 
 	return conn, nil
 }
@@ -222,7 +243,10 @@ func (r RadosAPI) newConn() (*rados.Conn, error) {
 func (r *RadosAPI) Close() error {
 
 	if r.conn != nil {
-		r.conn.Shutdown()
+		if untrackConn(r.conn) {
+			r.conn.Shutdown()
+		}
+		r.conn = nil
 	}
 	return nil
 }
@@ -246,7 +270,9 @@ func (r *RadosAPI) GetConn() (*rados.Conn, error) {
 // :param objID: 对象id
 // :param offset: 数据写入偏移量
 // :param data: 数据，bytes
-func (r RadosAPI) Write(objID string, offset uint64, data []byte) error {
+func (r RadosAPI) Write(objID string, offset uint64, data []byte) (err error) {
+
+	defer r.observe("write", objID, time.Now(), &err)
 
 	tasks, err := writePartTasks(objID, int64(offset), int64(len(data)))
 	if err != nil {
@@ -304,7 +330,9 @@ func (r RadosAPI) radosRead(ioctx *rados.IOContext, radosID string, offset, read
 // :return
 //		nil,error
 //		[]byte, nil
-func (r RadosAPI) Read(objID string, offset, readSize uint64) ([]byte, error) {
+func (r RadosAPI) Read(objID string, offset, readSize uint64) (data []byte, err error) {
+
+	defer r.observe("read", objID, time.Now(), &err)
 
 	if offset < 0 || readSize <= 0 {
 		return []byte{}, nil
@@ -349,7 +377,9 @@ func (r RadosAPI) Read(objID string, offset, readSize uint64) ([]byte, error) {
 // Delete a HarborObject
 // :param objID: 对象id
 // :param objSize: 对象大小
-func (r RadosAPI) Delete(objID string, objSize uint64) error {
+func (r RadosAPI) Delete(objID string, objSize uint64) (err error) {
+
+	defer r.observe("delete", objID, time.Now(), &err)
 
 	conn, err := r.GetConn()
 	if err != nil {
@@ -374,6 +404,53 @@ func (r RadosAPI) Delete(objID string, objSize uint64) error {
 	return nil
 }
 
+// Stat return total size of parts of a HarborObject, the parts are stated in order until one does not exist
+// :param objID: 对象id
+// :return: size, false if part0 does not exist, error
+func (r RadosAPI) Stat(objID string) (size uint64, exists bool, err error) {
+
+	defer r.observe("stat", objID, time.Now(), &err)
+
+	conn, err := r.GetConn()
+	if err != nil {
+		return 0, false, err
+	}
+	ioctx, err := conn.OpenIOContext(r.poolName)
+	if err != nil {
+		return 0, false, errors.New("error when openIOContext:" + err.Error())
+	}
+	defer ioctx.Destroy()
+
+	for i := uint(0); ; i++ {
+		st, err := ioctx.Stat(buildPartID(objID, i))
+		if err == rados.RadosErrorNotFound {
+			return size, i > 0, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		size += st.Size
+	}
+}
+
+// ListObjects call listFn with id of each rados object in pool, parts of HarborObject are included
+func (r RadosAPI) ListObjects(listFn func(oid string)) (err error) {
+
+	defer r.observe("list", "", time.Now(), &err)
+
+	conn, err := r.GetConn()
+	if err != nil {
+		return err
+	}
+	ioctx, err := conn.OpenIOContext(r.poolName)
+	if err != nil {
+		return errors.New("error when openIOContext:" + err.Error())
+	}
+	defer ioctx.Destroy()
+
+	return ioctx.ListObjects(listFn)
+}
+
 // GetClusterStats return ceph cluster stats information
 func (r RadosAPI) GetClusterStats() (rados.ClusterStat, error) {
 
@@ -395,90 +472,93 @@ func (r RadosAPI) MonCommand(args []byte) (buffer []byte, info string, err error
 	return conn.MonCommand(args)
 }
 
-//     def mgr_command(self, prefix, format='json', **kwargs):
-//         '''
-//         :return: io status string
-//         :raises: class:`RadosError`
-//         '''
-//         cluster = self.get_cluster()
-//         kwargs['prefix'] = prefix
-//         kwargs['format'] = format
-//         try:
-//             ret, buf, err = cluster.mgr_command(json.dumps(kwargs), '', timeout=5)
-//         except rados.Error as e:
-//             raise RadosError(str(e), errno=e.errno)
-//         else:
-//             if ret != 0:
-//                 raise RadosError(err)
-//             else:
-//                 return err
-
-//     def get_ceph_io_status(self):
-//         '''
-//         :return: {
-//                 'bw_rd': 0.0,   # Kb/s ,float
-//                 'bw_wr': 0.0,   # Kb/s ,float
-//                 'bw': 0.0       # Kb/s ,float
-//                 'op_rd': 0,     # op/s, int
-//                 'op_wr': 0,     # op/s, int
-//                 'op': 0,        # op/s, int
-//             }
-//         :raises: class:`RadosError`
-//         '''
-//         try:
-//             s = self.mgr_command(prefix='iostat')
-//         except RadosError as e:
-//             raise e
-//         return self.parse_io_str(io_str=s)
-
-//     def parse_io_str(self, io_str:str):
-//         '''
-//         :param io_str:  '  | 1623 KiB/s |   20 KiB/s | 1643 KiB/s |          2 |          1 |          4 |  '
-//         :return: {
-//                 'bw_rd': 0.0,   # Kb/s ,float
-//                 'bw_wr': 0.0,   # Kb/s ,float
-//                 'bw': 0.0       # Kb/s ,float
-//                 'op_rd': 0,     # op/s, int
-//                 'op_wr': 0,     # op/s, int
-//                 'op': 0,        # op/s, int
-//             }
-//         '''
-//         def to_kb(value, unit):
-//             u = unit[0]
-//             if u == 'b':
-//                 value = value / 1024
-//             elif u == 'k':
-//                 value = value
-//             elif u == 'm':
-//                 value = value * 1024
-//             elif u == 'g':
-//                 value = value * 1024 * 1024
-
-//             return value
-
-//         keys = ['bw_rd', 'bw_wr', 'bw', 'op_rd', 'op_wr', 'op']
-//         data = {}
-//         items = io_str.strip(' |').split('|')
-//         for i, item in enumerate(items):
-//             item = item.strip()
-//             item = item.lower()
-//             units = item.split(' ')
-//             try:
-//                 val = float(units[0])
-//             except:
-//                 data[keys[i]] = 0
-//                 continue
-
-//             # iobw
-//             if item.endswith('b/s'):
-//                 val = to_kb(val, units[-1])
-//             # iops
-//             else:
-//                 val = int(val)
+// MgrCommand sends a command to ceph mgr, return output buffer and status string
+func (r RadosAPI) MgrCommand(args []byte) (buffer []byte, info string, err error) {
+
+	conn, err := r.GetConn()
+	if err != nil {
+		return []byte{}, "", err
+	}
+	return conn.MgrCommand([][]byte{args})
+}
+
+// IOStat io status of ceph cluster
+type IOStat struct {
+	BwRd float64 `json:"bw_rd"` // KiB/s
+	BwWr float64 `json:"bw_wr"` // KiB/s
+	Bw   float64 `json:"bw"`    // KiB/s
+	OpRd int64   `json:"op_rd"` // op/s
+	OpWr int64   `json:"op_wr"` // op/s
+	Op   int64   `json:"op"`    // op/s
+}
+
+// GetIOStat return io status by mgr command "iostat"
+func (r RadosAPI) GetIOStat() (IOStat, error) {
+
+	_, info, err := r.MgrCommand([]byte(`{"prefix": "iostat", "format": "json"}`))
+	if err != nil {
+		return IOStat{}, err
+	}
+	return ParseIOStat(info), nil
+}
+
+// toKiB convert value of unit(e.g. "B/s", "KiB/s", "MiB/s", "GiB/s") to KiB
+func toKiB(value float64, unit string) float64 {
+
+	if unit == "" {
+		return value
+	}
+	switch unit[0] {
+	case 'b':
+		return value / 1024
+	case 'm':
+		return value * 1024
+	case 'g':
+		return value * 1024 * 1024
+	case 't':
+		return value * 1024 * 1024 * 1024
+	}
+	return value
+}
 
-//             data[keys[i]] = val
+// ParseIOStat parse iostat output line, e.g.
+// "  | 1623 KiB/s |   20 KiB/s | 1643 KiB/s |          2 |          1 |          4 |  ",
+// the columns are read bandwidth, write bandwidth, total bandwidth, read ops, write ops and total ops;
+// invalid columns are 0; only the last line is parsed if s has several lines, e.g. with table header
+func ParseIOStat(s string) IOStat {
 
-//         return data
+	var vals [6]float64
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	}
+	items := strings.Split(strings.Trim(s, " |"), "|")
+	for i, item := range items {
+		if i >= len(vals) {
+			break
+		}
+		units := strings.Fields(strings.ToLower(item))
+		if len(units) == 0 {
+			continue
+		}
+		val, err := strconv.ParseFloat(units[0], 64)
+		if err != nil {
+			continue
+		}
+		if strings.HasSuffix(units[len(units)-1], "b/s") {
+			val = toKiB(val, units[len(units)-1])
+		}
+		vals[i] = val
+	}
+	return IOStat{
+		BwRd: vals[0],
+		BwWr: vals[1],
+		Bw:   vals[2],
+		OpRd: int64(vals[3]),
+		OpWr: int64(vals[4]),
+		Op:   int64(vals[5]),
+	}
+}
 
 // CephHarborObject 对象操作接口封装
 type CephHarborObject struct {
@@ -490,6 +570,24 @@ type CephHarborObject struct {
 	objID       string
 	objSize     uint64
 	radosAPI    *RadosAPI
+	log         *logrus.Entry
+}
+
+// WithLogger set request-scoped logger and return the object
+func (cho *CephHarborObject) WithLogger(l *logrus.Entry) *CephHarborObject {
+
+	cho.log = l
+	if cho.radosAPI != nil {
+		cho.radosAPI.SetLogger(l)
+	}
+	return cho
+}
+
+// WithRados set rados api of the object and return it, objects with the same api share its connection
+func (cho *CephHarborObject) WithRados(api *RadosAPI) *CephHarborObject {
+
+	cho.radosAPI = api
+	return cho
 }
 
 // SetCephConfig set ceph settings
@@ -510,6 +608,7 @@ func (cho *CephHarborObject) GetRados() (*RadosAPI, error) {
 		if err != nil {
 			return nil, err
 		}
+		api.SetLogger(cho.log)
 		cho.radosAPI = api
 	}
 
@@ -689,26 +788,15 @@ func (cho CephHarborObject) GetClusterStats() (rados.ClusterStat, error) {
 	return api.GetClusterStats()
 }
 
-//     def get_ceph_io_status(self):
-//         '''
-//         :return:
-//             success: True, {
-//                     'bw_rd': 0.0,   # Kb/s ,float
-//                     'bw_wr': 0.0,   # Kb/s ,float
-//                     'bw': 0.0       # Kb/s ,float
-//                     'op_rd': 0,     # op/s, int
-//                     'op_wr': 0,     # op/s, int
-//                     'op': 0,        # op/s, int
-//                 }
-//             error: False, err:tsr
-//         '''
-//         try:
-//             rados = self.get_rados_api()
-//             status = rados.get_ceph_io_status()
-//         except RadosError as e:
-//             return False, str(e)
+// GetIOStat return io status of ceph cluster
+func (cho CephHarborObject) GetIOStat() (IOStat, error) {
 
-//         return True, status
+	api, err := cho.GetRados()
+	if err != nil {
+		return IOStat{}, err
+	}
+	return api.GetIOStat()
+}
 
 // StepWriteFunc defines the handler used by gin Stream() as return value.
 type StepWriteFunc func(io.Writer) bool
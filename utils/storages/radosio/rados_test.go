@@ -0,0 +1,22 @@
+package radosio_test
+
+import (
+	"harbor/utils/storages/radosio"
+	"testing"
+)
+
+func TestParseIOStat(t *testing.T) {
+
+	s := radosio.ParseIOStat("  | 1623 KiB/s |   20 MiB/s | 512 B/s |          2 |          1 |          4 |  ")
+	want := radosio.IOStat{BwRd: 1623, BwWr: 20 * 1024, Bw: 0.5, OpRd: 2, OpWr: 1, Op: 4}
+	if s != want {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+
+	header := "+-------+-------+\n|  Read |  Write |  Total | Read IOPS | Write IOPS | Total IOPS |\n| 1 GiB/s | x | 3 KiB/s | 5 | | 6 |\n"
+	s = radosio.ParseIOStat(header)
+	want = radosio.IOStat{BwRd: 1024 * 1024, Bw: 3, OpRd: 5, Op: 6}
+	if s != want {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+}
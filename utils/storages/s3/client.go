@@ -0,0 +1,386 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client of S3-compatible service, requests are signed by AWS signature version 4
+type Client struct {
+	endpoint    *url.URL
+	region      string
+	bucket      string
+	accessKey   string
+	secretKey   string
+	virtualHost bool
+	http        *http.Client
+}
+
+// NewClient return client of bucket by opts
+func NewClient(opts Options) (*Client, error) {
+
+	opts.setDefaults()
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("endpoint '%s' should be http(s)://host[:port]", opts.Endpoint)
+	}
+	if opts.Bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	return &Client{
+		endpoint:    u,
+		region:      opts.Region,
+		bucket:      opts.Bucket,
+		accessKey:   opts.AccessKey,
+		secretKey:   opts.SecretKey,
+		virtualHost: opts.VirtualHost,
+		http:        &http.Client{Timeout: opts.Timeout},
+	}, nil
+}
+
+// Error error response of S3 service
+type Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+
+	if e.Message == "" {
+		return fmt.Sprintf("s3: %d %s", e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsNotFound return true if err is an error response of status 404
+func IsNotFound(err error) bool {
+
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// objectURL return url of object key in bucket, url of bucket if key is empty
+func (c *Client) objectURL(key string, query url.Values) *url.URL {
+
+	u := *c.endpoint
+	p := strings.TrimSuffix(u.Path, "/")
+	if c.virtualHost {
+		u.Host = c.bucket + "." + u.Host
+		p += "/" + key
+	} else {
+		p += "/" + c.bucket
+		if key != "" {
+			p += "/" + key
+		}
+	}
+	u.Path = p
+	u.RawPath = uriEncode(p, false)
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// do send request of object key with body, return response of status 2xx, or *Error
+func (c *Client) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+
+	req, err := http.NewRequest(method, c.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.ContentLength = int64(len(body))
+	if c.accessKey != "" {
+		sum := sha256.Sum256(body)
+		SignRequest(req, hex.EncodeToString(sum[:]), c.accessKey, c.secretKey, c.region, time.Now())
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	e := &Error{}
+	if b, err := ioutil.ReadAll(resp.Body); err == nil && len(b) > 0 {
+		xml.Unmarshal(b, e)
+	}
+	e.StatusCode = resp.StatusCode
+	if e.Code == "" {
+		e.Code = http.StatusText(resp.StatusCode)
+	}
+	return nil, e
+}
+
+// GetObject return length bytes at offset of object key by range request, the whole object if length is 0;
+// data is shorter if the object ends before, and empty if it ends before offset
+func (c *Client) GetObject(key string, offset, length uint64) ([]byte, error) {
+
+	header := http.Header{}
+	if offset > 0 || length > 0 {
+		r := fmt.Sprintf("bytes=%d-", offset)
+		if length > 0 {
+			r += strconv.FormatUint(offset+length-1, 10)
+		}
+		header.Set("Range", r)
+	}
+	resp, err := c.do("GET", key, nil, header, nil)
+	if err != nil {
+		if e, ok := err.(*Error); ok && e.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			return []byte{}, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// the whole object is returned if range is not supported
+	if resp.StatusCode == http.StatusOK && header.Get("Range") != "" {
+		if offset >= uint64(len(data)) {
+			return []byte{}, nil
+		}
+		data = data[offset:]
+		if length > 0 && uint64(len(data)) > length {
+			data = data[:length]
+		}
+	}
+	return data, nil
+}
+
+// PutObject write object key of data
+func (c *Client) PutObject(key string, data []byte) error {
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.do("PUT", key, nil, header, data)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// DeleteObject delete object key, it is not an error if the object does not exist
+func (c *Client) DeleteObject(key string) error {
+
+	resp, err := c.do("DELETE", key, nil, nil, nil)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// HeadBucket return error if the bucket can not be accessed
+func (c *Client) HeadBucket() error {
+
+	resp, err := c.do("HEAD", "", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// ObjectInfo object listed in bucket
+type ObjectInfo struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+type listResult struct {
+	IsTruncated           bool         `xml:"IsTruncated"`
+	NextContinuationToken string       `xml:"NextContinuationToken"`
+	Contents              []ObjectInfo `xml:"Contents"`
+	CommonPrefixes        []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+// ListObjects call fn with each page of objects of keys with prefix, and common prefixes of keys ending at delimiter
+// if it is not empty; stop and return the error if fn returns an error
+func (c *Client) ListObjects(prefix, delimiter string, fn func(objects []ObjectInfo, prefixes []string) error) error {
+
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := c.do("GET", "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		var r listResult
+		err = xml.NewDecoder(resp.Body).Decode(&r)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3: parse list result: %s", err)
+		}
+		prefixes := make([]string, len(r.CommonPrefixes))
+		for i, p := range r.CommonPrefixes {
+			prefixes[i] = p.Prefix
+		}
+		if err := fn(r.Contents, prefixes); err != nil {
+			return err
+		}
+		if !r.IsTruncated || r.NextContinuationToken == "" {
+			return nil
+		}
+		token = r.NextContinuationToken
+	}
+}
+
+// signing by AWS signature version 4
+
+const (
+	signAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat = "20060102T150405Z"
+)
+
+// uriEncode encode s as required by signature version 4, "/" is kept if encodeSlash is false
+func uriEncode(s string, encodeSlash bool) string {
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' ||
+			ch == '_' || ch == '-' || ch == '~' || ch == '.' || ch == '/' && !encodeSlash {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signedHeaderNames return lower case names of headers of req to sign, host and x-amz-* headers are included
+func signedHeaderNames(req *http.Request) []string {
+
+	names := []string{"host"}
+	for k := range req.Header {
+		name := strings.ToLower(k)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-type" || name == "content-md5" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// signature return signature of req with signed headers at amzDate
+func signature(req *http.Request, signed []string, payloadHash, secretKey, region, amzDate string) string {
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	var headers strings.Builder
+	for _, name := range signed {
+		value := host
+		if name != "host" {
+			value = strings.Join(req.Header[http.CanonicalHeaderKey(name)], ",")
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			params = append(params, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	canonical := strings.Join([]string{req.Method, uri, strings.Join(params, "&"), headers.String(),
+		strings.Join(signed, ";"), payloadHash}, "\n")
+
+	date := amzDate[:8]
+	scope := date + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	stringToSign := signAlgorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// SignRequest sign req by AWS signature version 4 at time t, payloadHash is sha256 in hex of the body
+func SignRequest(req *http.Request, payloadHash, accessKey, secretKey, region string, t time.Time) {
+
+	amzDate := t.UTC().Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signed := signedHeaderNames(req)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s/%s/s3/aws4_request, SignedHeaders=%s, Signature=%s",
+		signAlgorithm, accessKey, amzDate[:8], region, strings.Join(signed, ";"),
+		signature(req, signed, payloadHash, secretKey, region, amzDate)))
+}
+
+// VerifyRequest return error if req is not signed by SignRequest with the credentials, used by S3 stand-ins;
+// the payload hash is not checked against the body
+func VerifyRequest(req *http.Request, accessKey, secretKey, region string) error {
+
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, signAlgorithm+" ") {
+		return errors.New("missing signature")
+	}
+	fields := map[string]string{}
+	for _, f := range strings.Split(strings.TrimPrefix(auth, signAlgorithm+" "), ",") {
+		if kv := strings.SplitN(strings.TrimSpace(f), "=", 2); len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	amzDate := req.Header.Get("X-Amz-Date")
+	if len(amzDate) != len(amzDateFormat) {
+		return errors.New("invalid x-amz-date")
+	}
+	if want := fmt.Sprintf("%s/%s/%s/s3/aws4_request", accessKey, amzDate[:8], region); fields["Credential"] != want {
+		return errors.New("invalid credential")
+	}
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	want := signature(req, signed, req.Header.Get("X-Amz-Content-Sha256"), secretKey, region, amzDate)
+	if !hmac.Equal([]byte(want), []byte(fields["Signature"])) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"harbor/utils/logger"
+	"harbor/utils/metrics"
+	"harbor/utils/storages/radosio"
+	"io"
+	"mime/multipart"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Object read and write data of an object key in storage
+type Object struct {
+	s    *Storage
+	key  string
+	size uint64
+	log  *logrus.Entry
+}
+
+// Object return Object of data of key with size
+func (s *Storage) Object(key string, size uint64) *Object {
+
+	return &Object{s: s, key: key, size: size}
+}
+
+// WithLogger set request-scoped logger and return the object
+func (o *Object) WithLogger(l *logrus.Entry) *Object {
+
+	o.log = l
+	return o
+}
+
+// observe record metrics and log of operation
+func (o *Object) observe(operation string, start time.Time, err *error) {
+
+	metrics.ObserveStorage("s3", operation, start, err)
+	logger.Operation(o.log, "s3", operation, start, *err, logrus.Fields{"key": o.key})
+}
+
+// GetObjSize return size of object
+func (o *Object) GetObjSize() uint64 {
+
+	return o.size
+}
+
+// PartSize return bytes of data stored in each part
+func (o *Object) PartSize() uint64 {
+
+	return o.s.partSize
+}
+
+// Read read size bytes at offset by range requests of parts, the data is truncated at the end of object
+func (o *Object) Read(offset uint64, size uint) (data []byte, err error) {
+
+	if offset >= o.size || size == 0 {
+		return []byte{}, nil
+	}
+	defer o.observe("read", time.Now(), &err)
+
+	end := offset + uint64(size)
+	if end > o.size {
+		end = o.size
+	}
+	ps := o.s.partSize
+	data = make([]byte, 0, end-offset)
+	for n := offset / ps; n*ps < end; n++ {
+		from, to := uint64(0), ps
+		if offset > n*ps {
+			from = offset - n*ps
+		}
+		if end < (n+1)*ps {
+			to = end - n*ps
+		}
+		part, err := o.s.client.GetObject(o.s.partKey(o.key, n), from, to-from)
+		if err != nil && !IsNotFound(err) {
+			return nil, fmt.Errorf("read part %d of %s: %s", n, o.key, err)
+		}
+		if uint64(len(part)) > to-from {
+			part = part[:to-from]
+		}
+		// holes and the end of a part shorter than the range are zeros
+		data = append(data, part...)
+		data = append(data, make([]byte, to-from-uint64(len(part)))...)
+	}
+	return data, nil
+}
+
+// Write write data at offset, parts partially written are read and put again with data merged
+func (o *Object) Write(data []byte, offset uint64) (err error) {
+
+	if len(data) == 0 {
+		return nil
+	}
+	defer o.observe("write", time.Now(), &err)
+
+	ps := o.s.partSize
+	end := offset + uint64(len(data))
+	for n := offset / ps; n*ps < end; n++ {
+		start := n * ps
+		from, to := uint64(0), ps
+		if offset > start {
+			from = offset - start
+		}
+		if end < start+ps {
+			to = end - start
+		}
+		chunk := data[start+from-offset : start+to-offset]
+		key := o.s.partKey(o.key, n)
+		if from > 0 || to < ps {
+			old, err := o.s.client.GetObject(key, 0, 0)
+			if err != nil && !IsNotFound(err) {
+				return fmt.Errorf("read part %d of %s: %s", n, o.key, err)
+			}
+			buf := make([]byte, to)
+			if uint64(len(old)) > to {
+				buf = make([]byte, len(old))
+			}
+			copy(buf, old)
+			copy(buf[from:], chunk)
+			chunk = buf
+		}
+		if err := o.s.client.PutObject(key, chunk); err != nil {
+			return fmt.Errorf("write part %d of %s: %s", n, o.key, err)
+		}
+	}
+	if end > o.size {
+		o.size = end
+	}
+	return nil
+}
+
+// WriteFile write a file-like at offset
+func (o *Object) WriteFile(offset int64, file *multipart.FileHeader) error {
+
+	inputFile, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	// parts are put once if the file is written from the start of a part
+	ps := int64(o.s.partSize)
+	chunk := make([]byte, ps)
+	if r := offset % ps; r != 0 {
+		chunk = chunk[:ps-r]
+	}
+	for written := int64(0); written < file.Size; {
+		n, err := io.ReadFull(inputFile, chunk)
+		if n > 0 {
+			if err := o.Write(chunk[:n], uint64(offset+written)); err != nil {
+				return err
+			}
+			written += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		chunk = chunk[:cap(chunk)]
+	}
+	return nil
+}
+
+// StepWriteFunc return function writing data from offset to end(included) to w by steps
+func (o *Object) StepWriteFunc(offset, end uint64) (radosio.StepWriteFunc, error) {
+
+	step := uint64(5 * 1024 * 1024)
+
+	if end > o.size {
+		return nil, errors.New("invalid input param, the reading range is beyond the size of the object")
+	}
+	return func(w io.Writer) bool {
+		n := step
+		if offset+n > end+1 {
+			n = end + 1 - offset
+		}
+		data, err := o.Read(offset, uint(n))
+		if err != nil || len(data) == 0 {
+			return false
+		}
+		if _, err := w.Write(data); err != nil {
+			return false
+		}
+		offset += uint64(len(data))
+		return offset <= end
+	}, nil
+}
+
+// Delete delete parts of object
+func (o *Object) Delete() (err error) {
+
+	defer o.observe("delete", time.Now(), &err)
+	return o.s.Remove(o.key)
+}
+
+// Close do nothing, no connection is kept by object
+func (o *Object) Close() error {
+
+	return nil
+}
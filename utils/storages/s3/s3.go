@@ -0,0 +1,159 @@
+// Package s3 store data of objects in a bucket of an S3-compatible service. Objects of S3 can not be modified,
+// so data of an object is stored in staged parts of fixed size, part N of key is object "{prefix}{key}/{N}" of
+// the bucket; a write at an offset puts the parts it covers, reading and merging parts partially written,
+// and a read gets the ranges of parts by range requests. Parts not written are holes of zeros.
+package s3
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPartSize default bytes of data of an object stored in each part
+const DefaultPartSize = 8 * 1024 * 1024
+
+// Options of storage, zero values are replaced by defaults
+type Options struct {
+	Endpoint    string        // e.g. https://s3.us-east-1.amazonaws.com, http://127.0.0.1:9000
+	Region      string        // default "us-east-1"
+	Bucket      string        // should exist
+	AccessKey   string        // requests are not signed if empty
+	SecretKey   string        //
+	VirtualHost bool          // address bucket by host "{bucket}.{endpoint host}", default by path "{endpoint}/{bucket}"
+	Prefix      string        // prefix of keys of parts in bucket, e.g. "harbor/"
+	PartSize    uint64        // default DefaultPartSize, should not be changed after data is stored
+	Timeout     time.Duration // timeout of a request, default 2m
+}
+
+func (opts *Options) setDefaults() {
+
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.PartSize == 0 {
+		opts.PartSize = DefaultPartSize
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 2 * time.Minute
+	}
+}
+
+// Storage data of objects stored in a bucket
+type Storage struct {
+	client   *Client
+	prefix   string
+	partSize uint64
+}
+
+// New return a storage of opts
+func New(opts Options) (*Storage, error) {
+
+	opts.setDefaults()
+	c, err := NewClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{client: c, prefix: opts.Prefix, partSize: opts.PartSize}, nil
+}
+
+// Client return client of the bucket
+func (s *Storage) Client() *Client {
+
+	return s.client
+}
+
+// PartSize return bytes of data stored in each part
+func (s *Storage) PartSize() uint64 {
+
+	return s.partSize
+}
+
+// partKey return key of part n of key in bucket
+func (s *Storage) partKey(key string, n uint64) string {
+
+	return s.prefix + key + "/" + strconv.FormatUint(n, 10)
+}
+
+// parts call fn with number and size of each part of key
+func (s *Storage) parts(key string, fn func(n uint64, size int64) error) error {
+
+	prefix := s.prefix + key + "/"
+	return s.client.ListObjects(prefix, "/", func(objects []ObjectInfo, _ []string) error {
+		for _, obj := range objects {
+			n, err := strconv.ParseUint(strings.TrimPrefix(obj.Key, prefix), 10, 64)
+			if err != nil {
+				continue
+			}
+			if err := fn(n, obj.Size); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Stat return size of data of key by its last part, exists is false if no part is stored
+func (s *Storage) Stat(key string) (size uint64, exists bool, err error) {
+
+	err = s.parts(key, func(n uint64, partSize int64) error {
+		if end := n*s.partSize + uint64(partSize); !exists || end > size {
+			size = end
+		}
+		exists = true
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return size, exists, nil
+}
+
+// Remove delete parts of key, it is not an error if key does not exist
+func (s *Storage) Remove(key string) error {
+
+	var keys []string
+	err := s.parts(key, func(n uint64, _ int64) error {
+		keys = append(keys, s.partKey(key, n))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := s.client.DeleteObject(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Keys call fn with each key having parts in bucket
+func (s *Storage) Keys(fn func(key string) error) error {
+
+	return s.client.ListObjects(s.prefix, "/", func(_ []ObjectInfo, prefixes []string) error {
+		for _, p := range prefixes {
+			key := strings.TrimSuffix(strings.TrimPrefix(p, s.prefix), "/")
+			if key == "" {
+				continue
+			}
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CheckHealth return error if the bucket can not be accessed
+func (s *Storage) CheckHealth() error {
+
+	if err := s.client.HeadBucket(); err != nil {
+		if IsNotFound(err) {
+			return errors.New("bucket does not exist")
+		}
+		return err
+	}
+	return nil
+}
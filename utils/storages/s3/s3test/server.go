@@ -0,0 +1,238 @@
+// Package s3test provide an in-process S3-compatible server for tests. It keeps objects in memory, verifies
+// signatures, and supports the requests sent by package s3 to path-style buckets: head bucket, list objects v2,
+// and get with range, put and delete of objects.
+package s3test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"harbor/utils/storages/s3"
+)
+
+// credentials accepted by server
+const (
+	Region    = "us-east-1"
+	AccessKey = "AKIDHARBORTEST"
+	SecretKey = "harbor-test-secret"
+)
+
+// Server S3 stand-in
+type Server struct {
+	*httptest.Server
+	MaxKeys int // keys in a page of list objects if max-keys is not requested, default 1000
+
+	mu       sync.Mutex
+	buckets  map[string]map[string][]byte
+	requests map[string]int
+}
+
+// NewServer start a server with empty buckets, it should be closed by Close
+func NewServer(buckets ...string) *Server {
+
+	s := &Server{buckets: map[string]map[string][]byte{}, requests: map[string]int{}}
+	for _, b := range buckets {
+		s.buckets[b] = map[string][]byte{}
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Options return options of storage of bucket in server
+func (s *Server) Options(bucket string) s3.Options {
+
+	return s3.Options{Endpoint: s.URL, Region: Region, Bucket: bucket, AccessKey: AccessKey, SecretKey: SecretKey}
+}
+
+// Objects return copy of objects in bucket
+func (s *Server) Objects(bucket string) map[string][]byte {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects := map[string][]byte{}
+	for k, v := range s.buckets[bucket] {
+		objects[k] = append([]byte(nil), v...)
+	}
+	return objects
+}
+
+// Requests return number of requests served by method, "GET" with range is counted as "GET range"
+func (s *Server) Requests() map[string]int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := map[string]int{}
+	for k, v := range s.requests {
+		requests[k] = v
+	}
+	return requests
+}
+
+type errorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(errorResponse{Code: code, Message: message})
+}
+
+// ServeHTTP serve requests of S3 API
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if err := s3.VerifyRequest(r, AccessKey, SecretKey, Region); err != nil {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	sum := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	method := r.Method
+	if r.Header.Get("Range") != "" {
+		method += " range"
+	}
+	s.requests[method]++
+
+	path := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, ok := s.buckets[path[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "")
+		return
+	}
+	if len(path) == 1 || path[1] == "" {
+		switch {
+		case r.Method == "HEAD":
+		case r.Method == "GET" && r.URL.Query().Get("list-type") == "2":
+			s.list(w, r, path[0], bucket)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "")
+		}
+		return
+	}
+
+	key := path[1]
+	switch r.Method {
+	case "GET", "HEAD":
+		data, ok := bucket[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "")
+			return
+		}
+		status := http.StatusOK
+		if rng := r.Header.Get("Range"); rng != "" {
+			var start, end int
+			if n, _ := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); n == 0 {
+				writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid range "+rng)
+				return
+			} else if n == 1 || end >= len(data) {
+				end = len(data) - 1
+			}
+			if start >= len(data) {
+				writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "")
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			data, status = data[start:end+1], http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == "GET" {
+			w.Write(data)
+		}
+	case "PUT":
+		bucket[key] = body
+	case "DELETE":
+		delete(bucket, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "")
+	}
+}
+
+type listResult struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	KeyCount              int              `xml:"KeyCount"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	Contents              []s3.ObjectInfo  `xml:"Contents"`
+	CommonPrefixes        []commonPrefixes `xml:"CommonPrefixes"`
+}
+
+type commonPrefixes struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// list list keys after continuation token in order, by pages of max-keys keys and common prefixes
+func (s *Server) list(w http.ResponseWriter, r *http.Request, name string, bucket map[string][]byte) {
+
+	query := r.URL.Query()
+	prefix, delimiter, token := query.Get("prefix"), query.Get("delimiter"), query.Get("continuation-token")
+	maxKeys := s.MaxKeys
+	if maxKeys == 0 {
+		maxKeys = 1000
+	}
+	if v := query.Get("max-keys"); v != "" {
+		maxKeys, _ = strconv.Atoi(v)
+	}
+	keys := make([]string, 0, len(bucket))
+	for k := range bucket {
+		if strings.HasPrefix(k, prefix) && k > token {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	result := listResult{Name: name, Prefix: prefix}
+	for _, k := range keys {
+		p := ""
+		if i := strings.Index(k[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			p = k[:len(prefix)+i+len(delimiter)]
+		}
+		if n := len(result.CommonPrefixes); p != "" && n > 0 && result.CommonPrefixes[n-1].Prefix == p {
+			// keys of the last common prefix are skipped
+			result.NextContinuationToken = k
+			continue
+		}
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if p != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefixes{Prefix: p})
+		} else {
+			result.Contents = append(result.Contents, s3.ObjectInfo{Key: k, Size: int64(len(bucket[k]))})
+		}
+		result.KeyCount++
+		result.NextContinuationToken = k
+	}
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
@@ -1,11 +1,23 @@
 package storages
 
 import (
+	"errors"
+	"fmt"
 	"harbor/config"
+	"harbor/utils/storages/compress"
+	"harbor/utils/storages/encrypt"
 	"harbor/utils/storages/filesystem"
+	"harbor/utils/storages/multidisk"
 	"harbor/utils/storages/radosio"
+	"harbor/utils/storages/s3"
+	"io"
+	"mime/multipart"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
 )
 
 var configs = config.GetConfigs()
@@ -22,13 +34,234 @@ func NewCephHarborObject(objID string, objSize uint64) *radosio.CephHarborObject
 	return r
 }
 
+var (
+	multiDiskOnce sync.Once
+	multiDisk     *multidisk.Storage
+	multiDiskErr  error
+)
+
+// MultiDisk return storage of multidisk backend of config "storage.multidisk"
+func MultiDisk() (*multidisk.Storage, error) {
+
+	multiDiskOnce.Do(func() {
+		c := configs.Storage.MultiDisk
+		dirs := make([]string, len(c.Dirs))
+		for i, dir := range c.Dirs {
+			dirs[i] = configs.AbsPath(dir)
+		}
+		multiDisk, multiDiskErr = multidisk.New(multidisk.Options{
+			Dirs:         dirs,
+			Mode:         c.Mode,
+			DataShards:   c.DataShards,
+			ParityShards: c.ParityShards,
+			Replicas:     c.Replicas,
+		})
+	})
+	return multiDisk, multiDiskErr
+}
+
+var (
+	s3Once    sync.Once
+	s3Storage *s3.Storage
+	s3Err     error
+)
+
+// S3 return storage of s3 backend of config "storage.s3"
+func S3() (*s3.Storage, error) {
+
+	s3Once.Do(func() {
+		c := configs.Storage.S3
+		s3Storage, s3Err = s3.New(s3.Options{
+			Endpoint:    c.Endpoint,
+			Region:      c.Region,
+			Bucket:      c.Bucket,
+			AccessKey:   c.AccessKey,
+			SecretKey:   c.SecretKey,
+			VirtualHost: c.VirtualHost,
+			Prefix:      c.Prefix,
+			PartSize:    c.PartSize,
+			Timeout:     c.Timeout,
+		})
+	})
+	return s3Storage, s3Err
+}
+
+// keyStorage storage of data by key, implemented by *multidisk.Storage and *s3.Storage
+type keyStorage interface {
+	Remove(key string) error
+	Stat(key string) (size uint64, exists bool, err error)
+	Keys(fn func(key string) error) error
+}
+
+// keyObjectFunc return function creating ObjectIO of key with size for filesystem, multidisk or s3 backend,
+// nil for ceph
+func keyObjectFunc(log *logrus.Entry) (func(key string, size uint64) ObjectIO, error) {
+
+	switch Backend() {
+	case BackendFilesystem:
+		return func(key string, size uint64) ObjectIO { return NewFileStorage(key).Object(size).WithLogger(log) }, nil
+	case BackendMultiDisk:
+		s, err := MultiDisk()
+		if err != nil {
+			return nil, err
+		}
+		return func(key string, size uint64) ObjectIO { return s.Object(key, size).WithLogger(log) }, nil
+	case BackendS3:
+		s, err := S3()
+		if err != nil {
+			return nil, err
+		}
+		return func(key string, size uint64) ObjectIO { return s.Object(key, size).WithLogger(log) }, nil
+	}
+	return nil, nil
+}
+
+// ObjectIO read and write data of an object, implemented by *radosio.CephHarborObject, *filesystem.Object,
+// *multidisk.Object, *s3.Object, *encrypt.Object and *compress.Object
+type ObjectIO interface {
+	GetObjSize() uint64
+	Read(offset uint64, size uint) ([]byte, error)
+	Write(data []byte, offset uint64) error
+	WriteFile(offset int64, file *multipart.FileHeader) error
+	StepWriteFunc(offset, end uint64) (radosio.StepWriteFunc, error)
+	Delete() error
+	Close() error
+}
+
+// NewObjectIO return ObjectIO of data of object key with size, the data is encrypted by dataKey if it is not nil,
+// or compressed by compression if it is not empty; encrypted data is not compressed
+func NewObjectIO(objID string, objSize uint64, dataKey []byte, compression string, log *logrus.Entry) (ObjectIO, error) {
+
+	if dataKey != nil && compression != "" {
+		return nil, errors.New("encrypted data can not be compressed")
+	}
+	if compression != "" && !compress.Supported(compression) {
+		return nil, fmt.Errorf("unknown compression '%s'", compression)
+	}
+	newObject, err := keyObjectFunc(log)
+	if err != nil {
+		return nil, err
+	}
+	if newObject != nil {
+		switch {
+		case dataKey != nil:
+			return encrypt.NewObject(newObject(objID, encrypt.StoredSize(objSize)), dataKey, objSize)
+		case compression != "":
+			index := newObject(compress.IndexKey(objID), compress.IndexSize(objSize))
+			return compress.NewObject(newObject(objID, objSize), index, objSize), nil
+		}
+		return newObject(objID, objSize), nil
+	}
+	if dataKey != nil {
+		raw := NewCephHarborObject(objID, encrypt.StoredSize(objSize)).WithLogger(log)
+		return encrypt.NewObject(raw, dataKey, objSize)
+	}
+	if compression != "" {
+		// data and index share the connection
+		raw := NewCephHarborObject(objID, objSize).WithLogger(log)
+		api, err := raw.GetRados()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := api.GetConn(); err != nil {
+			return nil, err
+		}
+		index := NewCephHarborObject(compress.IndexKey(objID), compress.IndexSize(objSize)).WithLogger(log).WithRados(api)
+		return compress.NewObject(raw, index, objSize), nil
+	}
+	return NewCephHarborObject(objID, objSize).WithLogger(log), nil
+}
+
+// StoredSizeDelta return the change of size of stored data by writes of oio,
+// which change size of its object from oldSize to newSize
+func StoredSizeDelta(oio ObjectIO, oldSize, newSize uint64) int64 {
+
+	switch o := oio.(type) {
+	case *compress.Object:
+		return o.StoredSizeDelta()
+	case *encrypt.Object:
+		return int64(encrypt.StoredSize(newSize)) - int64(encrypt.StoredSize(oldSize))
+	}
+	return int64(newSize) - int64(oldSize)
+}
+
+// DeleteObjectData delete stored data of object key with size of stored data, and index of compressed data
+func DeleteObjectData(objID string, storedSize uint64, compressed bool, log *logrus.Entry) error {
+
+	newObject, err := keyObjectFunc(log)
+	if err != nil {
+		return err
+	}
+	if newObject != nil {
+		if err := newObject(objID, storedSize).Delete(); err != nil {
+			return err
+		}
+		if compressed {
+			return newObject(compress.IndexKey(objID), 0).Delete()
+		}
+		return nil
+	}
+	if err := NewCephHarborObject(objID, storedSize).WithLogger(log).Delete(); err != nil {
+		return err
+	}
+	if compressed {
+		return NewCephHarborObject(compress.IndexKey(objID), 0).WithLogger(log).Delete()
+	}
+	return nil
+}
+
+// CopyObjectData copy size bytes of data from src to dst by steps, the data is also written to w if it is not nil,
+// dst can be nil to only read the data to w; data not written in src is copied as zeros
+func CopyObjectData(dst, src ObjectIO, size uint64, w io.Writer) error {
+
+	const step = 5 * 1024 * 1024
+
+	// connect first, so that the connection is shared by the steps and closed by Close
+	for _, oio := range []ObjectIO{dst, src} {
+		if cho, ok := oio.(*radosio.CephHarborObject); ok {
+			api, err := cho.GetRados()
+			if err != nil {
+				return err
+			}
+			if _, err := api.GetConn(); err != nil {
+				return err
+			}
+		}
+	}
+	for offset := uint64(0); offset < size; {
+		n := uint64(step)
+		if offset+n > size {
+			n = size - offset
+		}
+		data, err := src.Read(offset, uint(n))
+		if err != nil {
+			return err
+		}
+		if uint64(len(data)) < n {
+			data = append(data, make([]byte, n-uint64(len(data)))...)
+		}
+		if dst != nil {
+			if err := dst.Write(data, offset); err != nil {
+				return err
+			}
+		}
+		if w != nil {
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		offset += n
+	}
+	return nil
+}
+
 // NewFileStorage return a filestorage
 func NewFileStorage(filename string) *filesystem.FileStorage {
 
 	// 目录路径不存在存在则创建
 	dirPath := filepath.Clean(getUploadPath())
 	if exist, _ := DirExists(dirPath); !exist {
-		os.MkdirAll(dirPath, os.ModeDir)
+		os.MkdirAll(dirPath, 0755)
 	}
 	return &filesystem.FileStorage{
 		Filename:   filename,
@@ -58,3 +291,146 @@ func DirExists(path string) (bool, error) {
 	}
 	return false, err
 }
+
+// Store access data of objects in the configured storage backend by object key,
+// the connection to ceph cluster is shared by all operations until Close
+type Store struct {
+	api *radosio.RadosAPI
+	ks  keyStorage // multidisk or s3
+}
+
+// NewStore return a Store of the configured storage backend
+func NewStore() (*Store, error) {
+
+	switch b := Backend(); b {
+	case BackendCeph:
+		api, err := NewCephHarborObject("", 0).GetRados()
+		if err != nil {
+			return nil, err
+		}
+		// connect before calling methods of value receiver, so that the connection is shared and closed
+		if _, err := api.GetConn(); err != nil {
+			return nil, err
+		}
+		return &Store{api: api}, nil
+	case BackendFilesystem:
+		return &Store{}, nil
+	case BackendMultiDisk:
+		md, err := MultiDisk()
+		if err != nil {
+			return nil, err
+		}
+		return &Store{ks: md}, nil
+	case BackendS3:
+		st, err := S3()
+		if err != nil {
+			return nil, err
+		}
+		return &Store{ks: st}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend '%s'", b)
+	}
+}
+
+// Remove delete data of object key with size, and index of the data if it is compressed,
+// it is not an error if the data does not exist
+func (s *Store) Remove(key string, size uint64) error {
+
+	if s.api != nil {
+		if err := s.api.Delete(key, size); err != nil {
+			return err
+		}
+		if strings.HasSuffix(key, compress.IndexSuffix) {
+			return nil
+		}
+		return s.api.Delete(compress.IndexKey(key), 0)
+	}
+	if s.ks != nil {
+		if err := s.ks.Remove(key); err != nil {
+			return err
+		}
+		if strings.HasSuffix(key, compress.IndexSuffix) {
+			return nil
+		}
+		return s.ks.Remove(compress.IndexKey(key))
+	}
+	if err := NewFileStorage(key).Delete(); err != nil {
+		return err
+	}
+	if strings.HasSuffix(key, compress.IndexSuffix) {
+		return nil
+	}
+	return NewFileStorage(compress.IndexKey(key)).Delete()
+}
+
+// Stat return size of data of object key, exists is false if there is no data
+func (s *Store) Stat(key string) (size uint64, exists bool, err error) {
+
+	if s.api != nil {
+		return s.api.Stat(key)
+	}
+	if s.ks != nil {
+		return s.ks.Stat(key)
+	}
+	fi, err := os.Stat(NewFileStorage(key).GetFilename())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return uint64(fi.Size()), true, nil
+}
+
+// Keys call fn with each key in the storage backend, stop and return the error if fn returns an error;
+// keys of other data in the ceph pool, upload dir, multidisk dirs or s3 bucket are also given, e.g. ids of parts "{key}_{N}"
+func (s *Store) Keys(fn func(key string) error) error {
+
+	if s.api != nil {
+		var fnErr error
+		err := s.api.ListObjects(func(oid string) {
+			if fnErr == nil {
+				fnErr = fn(oid)
+			}
+		})
+		if fnErr != nil {
+			return fnErr
+		}
+		return err
+	}
+	if s.ks != nil {
+		return s.ks.Keys(fn)
+	}
+
+	dir, err := os.Open(getUploadPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer dir.Close()
+	for {
+		names, err := dir.Readdirnames(1000)
+		for _, name := range names {
+			if err := fn(name); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Close the connection to ceph cluster
+func (s *Store) Close() error {
+
+	if s.api != nil {
+		return s.api.Close()
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Package totp time-based one-time password(RFC 6238), compatible with Google Authenticator
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits number of digits of code
+	Digits = 6
+	// Period seconds a code is valid
+	Period = 30
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret return a random base32 encoded secret of 160 bits
+func GenerateSecret() string {
+
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b32.EncodeToString(b)
+}
+
+// decodeSecret decode base32 secret, ignore spaces, padding and case
+func decodeSecret(secret string) ([]byte, error) {
+
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	return b32.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// hotp HMAC-based one-time password(RFC 4226)
+func hotp(key []byte, counter uint64) string {
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, bin%mod)
+}
+
+// Counter return time step counter of t
+func Counter(t time.Time) int64 {
+
+	return t.Unix() / Period
+}
+
+// Code return code at time t
+func Code(secret string, t time.Time) (string, error) {
+
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(Counter(t))), nil
+}
+
+// Validate check code at time t, allow skew time steps before and after for clock drift,
+// return the time step counter of matched code, it should be saved to prevent replay
+func Validate(secret, code string, t time.Time, skew int) (int64, bool) {
+
+	key, err := decodeSecret(secret)
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+
+	counter := Counter(t)
+	for i := -skew; i <= skew; i++ {
+		c := counter + int64(i)
+		if c < 0 {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(c))), []byte(code)) == 1 {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// ProvisioningURI return otpauth uri, authenticator apps add account by scanning its QR code
+func ProvisioningURI(secret, issuer, account string) string {
+
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", Digits))
+	v.Set("period", fmt.Sprintf("%d", Period))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
@@ -0,0 +1,55 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"harbor/utils/totp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// test vectors of RFC 6238 appendix B, SHA1, the last 6 digits
+func TestCode(t *testing.T) {
+
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for ts, want := range vectors {
+		code, err := totp.Code(secret, time.Unix(ts, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != want {
+			t.Errorf("time %d: want %s, got %s", ts, want, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	secret := totp.GenerateSecret()
+	now := time.Now()
+	code, _ := totp.Code(secret, now.Add(-totp.Period*time.Second))
+
+	counter, ok := totp.Validate(secret, code, now, 1)
+	if !ok || counter != totp.Counter(now)-1 {
+		t.Errorf("code of previous time step should be accepted with skew 1")
+	}
+	if _, ok := totp.Validate(secret, code, now, 0); ok {
+		t.Errorf("code of previous time step should be rejected without skew")
+	}
+	if _, ok := totp.Validate(secret, "12345", now, 1); ok {
+		t.Errorf("code with wrong length should be rejected")
+	}
+
+	uri := totp.ProvisioningURI(secret, "EVHarbor", "alice@example.com")
+	if !strings.HasPrefix(uri, "otpauth://totp/EVHarbor:alice@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("bad provisioning uri %s", uri)
+	}
+}
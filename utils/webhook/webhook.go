@@ -0,0 +1,369 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// event types
+const (
+	// EventObjectCreated sent when a object is created or reset by its first uploaded chunk, more chunks may follow
+	EventObjectCreated = "object.created"
+	// EventObjectWritten sent for each uploaded chunk with the object size after writing,
+	// the size of the last event is the final size of an upload
+	EventObjectWritten = "object.written"
+	EventObjectDeleted = "object.deleted"
+	EventObjectMoved   = "object.moved"
+	EventObjectShared  = "object.shared"
+	EventBucketDeleted = "bucket.deleted"
+)
+
+// EventTypes all event types can be subscribed
+var EventTypes = []string{
+	EventObjectCreated,
+	EventObjectWritten,
+	EventObjectDeleted,
+	EventObjectMoved,
+	EventObjectShared,
+	EventBucketDeleted,
+}
+
+// IsEventType return true if s is a event type
+func IsEventType(s string) bool {
+
+	for _, t := range EventTypes {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// request headers of delivery
+const (
+	EventHeader     = "X-Harbor-Event"
+	DeliveryHeader  = "X-Harbor-Delivery"
+	TimestampHeader = "X-Harbor-Timestamp"
+	SignatureHeader = "X-Harbor-Signature"
+)
+
+// Event payload of delivery
+type Event struct {
+	ID     string                 `json:"id"`
+	Type   string                 `json:"type"`
+	Time   time.Time              `json:"time"`
+	Bucket string                 `json:"bucket"`
+	Path   string                 `json:"path,omitempty"`
+	Actor  string                 `json:"actor,omitempty"`
+	Detail map[string]interface{} `json:"detail,omitempty"`
+}
+
+// Sign return signature of body, "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body));
+// receivers should compute it with the timestamp header and reject old timestamps to prevent replay
+func Sign(secret string, timestamp int64, body []byte) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify return true if signature is valid
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// RetryPolicy retry policy of failed delivery
+type RetryPolicy struct {
+	MaxAttempts int           // attempts before giving up
+	BaseDelay   time.Duration // delay after first failure, doubled for each failure after
+	MaxDelay    time.Duration
+}
+
+// Delay return delay before next attempt after attempts failed
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+
+	d := p.BaseDelay
+	for i := 1; i < attempts && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// Delivery a event to send to a endpoint
+type Delivery struct {
+	ID        uint64 // delivery record id
+	URL       string
+	Secret    string
+	EventType string
+	Body      []byte
+	Attempts  int // attempts done
+}
+
+// Result result of a attempt
+type Result struct {
+	StatusCode int
+	Err        error
+	Duration   time.Duration
+	NextRetry  time.Time // zero if no more retry
+	Dead       bool      // true if gave up after max attempts
+}
+
+// Send post delivery to the endpoint once, error if response status is not 2xx
+func Send(client *http.Client, d *Delivery) (int, error) {
+
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "EVHarbor-Webhook/1.0")
+	req.Header.Set(EventHeader, d.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(d.ID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(SignatureHeader, Sign(d.Secret, ts, d.Body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// ErrPrivateAddress endpoint address is not allowed
+var ErrPrivateAddress = errors.New("webhook endpoint address is in a private or loopback network")
+
+var privateNets = func() []*net.IPNet {
+
+	var nets []*net.IPNet
+	for _, s := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7", "fe80::/10",
+	} {
+		_, n, _ := net.ParseCIDR(s)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// IsPrivateIP return true if ip is loopback, private, link-local or unspecified
+func IsPrivateIP(ip net.IP) bool {
+
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL validate endpoint url, only http and https are allowed;
+// host of literal ip in private networks is rejected if not allowPrivate
+func CheckURL(rawurl string, allowPrivate bool) error {
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("webhook url scheme must be http or https")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("webhook url host is required")
+	}
+	if u.User != nil {
+		return errors.New("webhook url should not contain user info")
+	}
+	if ip := net.ParseIP(host); ip != nil && !allowPrivate && IsPrivateIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// NewClient return http client for deliveries, connections to private networks are refused if not allowPrivate,
+// which is checked after dns resolving, and redirects are not followed;
+// proxy of environment is used only if allowPrivate, or the address checked would be the proxy's
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+
+	dialer := &net.Dialer{Timeout: timeout}
+	proxy := http.ProxyFromEnvironment
+	if !allowPrivate {
+		proxy = nil
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || IsPrivateIP(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               proxy,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Dispatcher send deliveries asynchronously by workers and retry failed ones with backoff
+type Dispatcher struct {
+	client   *http.Client
+	policy   RetryPolicy
+	queue    chan *Delivery
+	onResult func(*Delivery, Result)
+
+	mu      sync.Mutex
+	stopped bool
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewDispatcher return a dispatcher, onResult is called after each attempt in worker goroutine
+func NewDispatcher(client *http.Client, policy RetryPolicy, queueSize int, onResult func(*Delivery, Result)) *Dispatcher {
+
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	return &Dispatcher{
+		client:   client,
+		policy:   policy,
+		queue:    make(chan *Delivery, queueSize),
+		onResult: onResult,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start start workers
+func (d *Dispatcher) Start(workers int) {
+
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+}
+
+// Stop stop workers after the attempts in progress are done, queued and scheduled deliveries are dropped
+func (d *Dispatcher) Stop() {
+
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return
+	}
+	d.stopped = true
+	close(d.stop)
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// Enqueue queue the delivery, it is retried later if the queue is full
+func (d *Dispatcher) Enqueue(dl *Delivery) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return
+	}
+	select {
+	case d.queue <- dl:
+	default:
+		d.scheduleLocked(dl, d.policy.Delay(1))
+	}
+}
+
+// Schedule queue the delivery after delay
+func (d *Dispatcher) Schedule(dl *Delivery, delay time.Duration) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.scheduleLocked(dl, delay)
+}
+
+func (d *Dispatcher) scheduleLocked(dl *Delivery, delay time.Duration) {
+
+	if d.stopped {
+		return
+	}
+	time.AfterFunc(delay, func() { d.Enqueue(dl) })
+}
+
+func (d *Dispatcher) work() {
+
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case dl := <-d.queue:
+			d.attempt(dl)
+		}
+	}
+}
+
+func (d *Dispatcher) attempt(dl *Delivery) {
+
+	start := time.Now()
+	code, err := Send(d.client, dl)
+	dl.Attempts++
+	r := Result{StatusCode: code, Err: err, Duration: time.Since(start)}
+	var delay time.Duration
+	if err != nil {
+		if dl.Attempts >= d.policy.MaxAttempts {
+			r.Dead = true
+		} else {
+			delay = d.policy.Delay(dl.Attempts)
+			r.NextRetry = time.Now().Add(delay)
+		}
+	}
+	if d.onResult != nil {
+		d.onResult(dl, r)
+	}
+	if !r.NextRetry.IsZero() {
+		d.Schedule(dl, delay)
+	}
+}
@@ -0,0 +1,120 @@
+package webhook_test
+
+import (
+	"harbor/utils/webhook"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+
+	body := []byte(`{"type":"object.created"}`)
+	sig := webhook.Sign("secret", 1570000000, body)
+	if !webhook.Verify("secret", 1570000000, body, sig) {
+		t.Errorf("signature should be verified")
+	}
+	if webhook.Verify("secret", 1570000001, body, sig) || webhook.Verify("other", 1570000000, body, sig) {
+		t.Errorf("signature should depend on timestamp and secret")
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+
+	p := webhook.RetryPolicy{MaxAttempts: 8, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if d := p.Delay(i + 1); d != w {
+			t.Errorf("delay after %d failures should be %s, got %s", i+1, w, d)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+
+	for _, s := range []string{"ftp://example.com/", "http:///a", "http://u:p@example.com/", "http://127.0.0.1/", "http://[::1]:80/", "http://10.1.2.3/"} {
+		if webhook.CheckURL(s, false) == nil {
+			t.Errorf("url %q should be rejected", s)
+		}
+	}
+	if err := webhook.CheckURL("http://127.0.0.1:8000/hook", true); err != nil {
+		t.Errorf("private url should be allowed, %s", err)
+	}
+	if err := webhook.CheckURL("https://example.com/hook", false); err != nil {
+		t.Error(err)
+	}
+	if !webhook.IsPrivateIP(net.ParseIP("192.168.1.1")) || webhook.IsPrivateIP(net.ParseIP("8.8.8.8")) {
+		t.Errorf("IsPrivateIP is wrong")
+	}
+}
+
+func TestClientRefusePrivate(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	_, err := webhook.Send(webhook.NewClient(time.Second, false), &webhook.Delivery{URL: srv.URL})
+	if err == nil {
+		t.Errorf("connection to loopback should be refused")
+	}
+	// a proxy would be the only address checked
+	if tr := webhook.NewClient(time.Second, false).Transport.(*http.Transport); tr.Proxy != nil {
+		t.Errorf("proxy should not be used if private networks are refused")
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+
+	var mu sync.Mutex
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get(webhook.TimestampHeader), 10, 64)
+		if !webhook.Verify("s3cret", ts, body, r.Header.Get(webhook.SignatureHeader)) {
+			w.WriteHeader(400)
+			return
+		}
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		if r.Header.Get(webhook.DeliveryHeader) == "1" && n < 3 {
+			w.WriteHeader(503)
+		}
+	}))
+	defer srv.Close()
+
+	results := make(chan webhook.Result, 10)
+	policy := webhook.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	d := webhook.NewDispatcher(webhook.NewClient(time.Second, true), policy, 10, func(dl *webhook.Delivery, r webhook.Result) {
+		results <- r
+	})
+	d.Start(2)
+	defer d.Stop()
+
+	// succeeds at the third attempt
+	d.Enqueue(&webhook.Delivery{ID: 1, URL: srv.URL, Secret: "s3cret", EventType: webhook.EventObjectCreated, Body: []byte("{}")})
+	for i := 0; i < 3; i++ {
+		r := <-results
+		if i < 2 && (r.Err == nil || r.StatusCode != 503 || r.NextRetry.IsZero()) {
+			t.Fatalf("attempt %d should fail and be retried, %+v", i+1, r)
+		}
+		if i == 2 && (r.Err != nil || r.StatusCode != 200) {
+			t.Fatalf("third attempt should succeed, %+v", r)
+		}
+	}
+
+	// signature is wrong, gives up after max attempts
+	d.Enqueue(&webhook.Delivery{ID: 2, URL: srv.URL, Secret: "wrong", Body: []byte("{}")})
+	for i := 0; i < 3; i++ {
+		r := <-results
+		if r.Dead != (i == 2) {
+			t.Fatalf("delivery should be dead after 3 attempts, attempt %d %+v", i+1, r)
+		}
+	}
+}